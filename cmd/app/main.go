@@ -23,20 +23,26 @@ func main() {
 	// get psql store
 	psqlStore := service.NewPsqlStore(cfg.DbDsn)
 
-	// get minio store
-	minioConn := &db.MinioConn{
-		Endpoint: cfg.MinioEndpoint,
-		User:     cfg.MinioUser,
-		Password: cfg.MinioPassword,
-		Ssl:      cfg.MinioSSL,
+	// get file store, minio or a local directory
+	fileStore, err := service.NewFileStore(cfg.FileStoreBackend, &service.FileStoreConfig{
+		Minio: &db.MinioConn{
+			Endpoint: cfg.MinioEndpoint,
+			User:     cfg.MinioUser,
+			Password: cfg.MinioPassword,
+			Ssl:      cfg.MinioSSL,
+		},
+		Bucket: cfg.MinioBucket,
+		Root:   cfg.FileStoreRoot,
+	})
+	if err != nil {
+		log.Fatalf("Error while setting up file store: %v", err)
 	}
-	minioStore := service.NewMinioStore(minioConn, cfg.MinioBucket)
 
 	// get redis stores
 	redisStore := service.NewRedisStore(cfg.RedisDsn)
 
 	// get rabbitmq client
-	rabbitMqQueue := service.NewTaskQueue(cfg.RabbitMqDsn, cfg.RabbitMqQueueName)
+	rabbitMqQueue := service.NewTaskQueue(cfg.RabbitMqDsn, cfg.RabbitMqQueueName, cfg.RabbitMqMaxRetries, cfg.RabbitMqRetryDelay)
 
 	// craft the server address using env vars
 	host := cfg.Host
@@ -47,9 +53,26 @@ func main() {
 	server := server.New(&server.ServerConfig{
 		Addr:       addr,
 		DataStore:  psqlStore,
-		FileStore:  minioStore,
+		FileStore:  fileStore,
 		CacheStore: redisStore,
 		Queue:      rabbitMqQueue,
+
+		RateLimitStore: redisStore,
+
+		CredentialKey: []byte(cfg.HashPassword),
+		CredentialTTL: cfg.AuthCacheTTL,
+
+		SigningKey:       []byte(cfg.HashPassword),
+		SignatureMaxSkew: cfg.AuthSignatureMaxSkew,
+
+		TokenKeys:  cfg.JWTSigningKeys,
+		TokenKeyID: cfg.JWTSigningKeyID,
+		TokenTTL:   cfg.JWTTTL,
+		RefreshTTL: cfg.JWTRefreshTTL,
+
+		IdempotencyTTL:   cfg.IdempotencyTTL,
+		UploadSessionTTL: cfg.UploadSessionTTL,
+		PresignTTL:       cfg.PresignTTL,
 	})
 	server.Run()
 }
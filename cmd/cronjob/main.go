@@ -21,25 +21,44 @@ func main() {
 	// get psql store
 	psqlStore := cronjob.NewPsqlCrobJobStore(cfg.DbDsn)
 
-	// get minio store
-	minioConn := &db.MinioConn{
-		Endpoint: cfg.MinioEndpoint,
-		User:     cfg.MinioUser,
-		Password: cfg.MinioPassword,
-		Ssl:      cfg.MinioSSL,
+	// get file store, minio or a local directory
+	fileStore, err := service.NewFileStore(cfg.FileStoreBackend, &service.FileStoreConfig{
+		Minio: &db.MinioConn{
+			Endpoint: cfg.MinioEndpoint,
+			User:     cfg.MinioUser,
+			Password: cfg.MinioPassword,
+			Ssl:      cfg.MinioSSL,
+		},
+		Bucket: cfg.MinioBucket,
+		Root:   cfg.FileStoreRoot,
+	})
+	if err != nil {
+		log.Fatalf("Error while setting up file store: %v", err)
 	}
-	minioStore := service.NewMinioStore(minioConn, cfg.MinioBucket)
+
+	// get rabbitmq queue, the stale jobs are put back on it
+	queue := service.NewTaskQueue(cfg.RabbitMqDsn, cfg.RabbitMqQueueName, cfg.RabbitMqMaxRetries, cfg.RabbitMqRetryDelay)
+	maxRetryDelay := service.RetryDelay(cfg.RabbitMqMaxRetries, cfg.RabbitMqRetryDelay)
 
 	// get cronjob service
 	service := cronjob.NewService()
 
 	// start the cronjob
-	c := cronjob.New(&cronjob.CronJobConfig{
+	c, err := cronjob.New(&cronjob.CronJobConfig{
 		DataStore:      psqlStore,
-		FileStore:      minioStore,
+		FileStore:      fileStore,
 		ServiceManager: service,
 		Cron:           cron.New(),
+
+		Queue:            queue,
+		FaceMatchTimeout: cfg.JobFaceMatchTimeout,
+		OCRTimeout:       cfg.JobOCRTimeout,
+		MaxJobAttempts:   cfg.JobMaxAttempts,
+		MaxRetryDelay:    maxRetryDelay,
 	})
+	if err != nil {
+		log.Fatalf("Error while setting up cronjob: %v", err)
+	}
 
 	// add the schedules
 	currentTime := time.Now()
@@ -59,6 +78,30 @@ func main() {
 		return
 	}
 
+	_, err = c.Cron.AddFunc(cfg.JobReaperSchedule, c.ReapStaleJobs)
+	if err != nil {
+		log.Println("Error scheduling stale job reaper:", err.Error())
+		return
+	}
+
+	_, err = c.Cron.AddFunc("0 * * * *", c.PurgeIdempotencyKeys) // every hour
+	if err != nil {
+		log.Println("Error scheduling idempotency key purge:", err.Error())
+		return
+	}
+
+	_, err = c.Cron.AddFunc("30 * * * *", c.PurgeUploadSessions) // every hour
+	if err != nil {
+		log.Println("Error scheduling upload session purge:", err.Error())
+		return
+	}
+
+	_, err = c.Cron.AddFunc("45 * * * *", c.PurgePresignedUploads) // every hour
+	if err != nil {
+		log.Println("Error scheduling presigned upload purge:", err.Error())
+		return
+	}
+
 	// start the job
 	c.Cron.Start()
 
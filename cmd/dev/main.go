@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/config"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/cronjob"
+	_ "github.com/justsushant/one2n-go-bootcamp/go-ekyc/docs"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/server"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/webhook"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/worker"
+	"github.com/robfig/cron/v3"
+)
+
+// dev mode runs the server, worker, webhook dispatcher and cron job in one process,
+// with in-memory stores and queue in place of postgres, minio, redis and rabbitmq.
+// Nothing survives a restart.
+func main() {
+	// load configs
+	cfg, err := config.InitDev()
+	if err != nil {
+		log.Fatalf("Error while config init: %v", err)
+	}
+
+	// cancelled on SIGTERM or SIGINT, so in-flight jobs can be drained before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	// in-memory stores and queue, shared by all the components
+	memoryStore := service.NewMemoryStore()
+	fileStore := service.NewMemoryFileStore()
+	cacheStore := service.NewMemoryCacheStore()
+	queue := service.NewMemoryQueue(cfg.QueueSize, cfg.MaxRetries, cfg.RetryDelay)
+
+	// start the webhook dispatcher
+	dispatcher := webhook.New(&webhook.DispatcherConfig{
+		DataStore:    memoryStore,
+		HTTPClient:   &http.Client{Timeout: cfg.WebhookTimeout},
+		MaxAttempts:  cfg.WebhookMaxAttempts,
+		BaseBackoff:  cfg.WebhookBaseBackoff,
+		PollInterval: cfg.WebhookPollInterval,
+	})
+	go dispatcher.Run(ctx)
+
+	// face match and ocr engines
+	faceMatcher, err := worker.NewFaceMatcher(cfg.FaceMatchEngine, &worker.HTTPEngineConfig{
+		URL:              cfg.FaceMatchEngineURL,
+		Timeout:          cfg.EngineTimeout,
+		MaxRetries:       &cfg.EngineMaxRetries,
+		RetryDelay:       cfg.EngineRetryDelay,
+		BreakerThreshold: cfg.EngineBreakerThreshold,
+		BreakerCooldown:  cfg.EngineBreakerCooldown,
+	})
+	if err != nil {
+		log.Fatalf("Error while setting up the face match engine: %v", err)
+	}
+	ocr, err := worker.NewOCRPerformer(cfg.OCREngine, &worker.HTTPEngineConfig{
+		URL:              cfg.OCREngineURL,
+		Timeout:          cfg.EngineTimeout,
+		MaxRetries:       &cfg.EngineMaxRetries,
+		RetryDelay:       cfg.EngineRetryDelay,
+		BreakerThreshold: cfg.EngineBreakerThreshold,
+		BreakerCooldown:  cfg.EngineBreakerCooldown,
+	})
+	if err != nil {
+		log.Fatalf("Error while setting up the ocr engine: %v", err)
+	}
+
+	// create the worker, it's started last and holds the process until shutdown
+	w := worker.New(&worker.WorkerConfig{
+		Queue:       queue,
+		DataStore:   memoryStore,
+		FileStore:   fileStore,
+		FaceMatcher: faceMatcher,
+		OCR:         ocr,
+
+		Concurrency:     cfg.WorkerConcurrency,
+		ShutdownTimeout: cfg.WorkerShutdownTimeout,
+	})
+
+	// start the cronjob, reports are saved to the in-memory file store
+	c, err := cronjob.New(&cronjob.CronJobConfig{
+		DataStore:      memoryStore,
+		FileStore:      fileStore,
+		ServiceManager: cronjob.NewService(),
+		Cron:           cron.New(),
+
+		Queue:            queue,
+		FaceMatchTimeout: cfg.JobFaceMatchTimeout,
+		OCRTimeout:       cfg.JobOCRTimeout,
+		MaxJobAttempts:   cfg.JobMaxAttempts,
+		MaxRetryDelay:    service.RetryDelay(cfg.MaxRetries, cfg.RetryDelay),
+	})
+	if err != nil {
+		log.Fatalf("Error while setting up cronjob: %v", err)
+	}
+	_, err = c.Cron.AddFunc("0 1 * * *", func() { // every day at 1AM
+		c.CalcDailyReport(time.Now())
+	})
+	if err != nil {
+		log.Fatalf("Error scheduling the job: %v", err)
+	}
+	_, err = c.Cron.AddFunc("0 1 1 * *", func() { // first day of every month at 1AM
+		c.CalcMonthlyReport(time.Now())
+	})
+	if err != nil {
+		log.Fatalf("Error scheduling monthly job: %v", err)
+	}
+	_, err = c.Cron.AddFunc(cfg.JobReaperSchedule, c.ReapStaleJobs)
+	if err != nil {
+		log.Fatalf("Error scheduling stale job reaper: %v", err)
+	}
+	_, err = c.Cron.AddFunc("0 * * * *", c.PurgeIdempotencyKeys) // every hour
+	if err != nil {
+		log.Fatalf("Error scheduling idempotency key purge: %v", err)
+	}
+	_, err = c.Cron.AddFunc("30 * * * *", c.PurgeUploadSessions) // every hour
+	if err != nil {
+		log.Fatalf("Error scheduling upload session purge: %v", err)
+	}
+	_, err = c.Cron.AddFunc("45 * * * *", c.PurgePresignedUploads) // every hour
+	if err != nil {
+		log.Fatalf("Error scheduling presigned upload purge: %v", err)
+	}
+	c.Cron.Start()
+
+	// the cached credentials, signing secrets and tokens don't outlive the process, so a random key is enough
+	serverKey := make([]byte, 32)
+	if _, err := rand.Read(serverKey); err != nil {
+		log.Fatalf("Error while generating the server key: %v", err)
+	}
+
+	// init and start the server
+	log.Println("Running in dev mode, all data is kept in memory")
+	server := server.New(&server.ServerConfig{
+		Addr:       cfg.Host + ":" + cfg.Port,
+		DataStore:  memoryStore,
+		FileStore:  fileStore,
+		CacheStore: cacheStore,
+		Queue:      queue,
+
+		RateLimitStore: service.NewMemoryRateLimitStore(),
+
+		CredentialKey: serverKey,
+		CredentialTTL: cfg.AuthCacheTTL,
+
+		SigningKey:       serverKey,
+		SignatureMaxSkew: cfg.AuthSignatureMaxSkew,
+
+		TokenKeys:  map[string]string{"dev": hex.EncodeToString(serverKey)},
+		TokenKeyID: "dev",
+		TokenTTL:   cfg.JWTTTL,
+		RefreshTTL: cfg.JWTRefreshTTL,
+
+		IdempotencyTTL:   cfg.IdempotencyTTL,
+		UploadSessionTTL: cfg.UploadSessionTTL,
+		PresignTTL:       cfg.PresignTTL,
+	})
+	go server.Run()
+
+	w.ProcessMessages(ctx)
+	log.Println("Dev mode stopped")
+}
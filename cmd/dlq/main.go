@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/config"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+)
+
+var (
+	mode  string
+	limit int
+)
+
+func main() {
+	cfg, err := config.Init()
+	if err != nil {
+		log.Fatalf("Error while config init: %v", err)
+	}
+
+	// set the flags
+	flag.StringVar(&mode, "m", "list", "dead letter queue [list|replay]")
+	flag.IntVar(&limit, "n", 10, "number of messages to list or replay")
+	flag.Parse()
+
+	rabbitMqQueue := service.NewTaskQueue(cfg.RabbitMqDsn, cfg.RabbitMqQueueName, cfg.RabbitMqMaxRetries, cfg.RabbitMqRetryDelay)
+
+	// acting on the supplied mode
+	switch strings.ToLower(mode) {
+	case "list":
+		deadLetters, err := rabbitMqQueue.InspectDeadLetters(limit)
+		if err != nil {
+			log.Fatalf("Error occured while reading dead letters: %v\n", err)
+		}
+		for i, d := range deadLetters {
+			log.Printf("%d. attempts: %d, dead lettered at: %s, last error: %s\n", i+1, d.Attempts, d.DeadLetteredAt, d.LastError)
+			log.Printf("   %s\n", d.Body)
+		}
+		log.Printf("Listed %d dead letters\n", len(deadLetters))
+	case "replay":
+		count, err := rabbitMqQueue.ReplayDeadLetters(limit)
+		if err != nil {
+			log.Fatalf("Error occured while replaying dead letters after %d messages: %v\n", count, err)
+		}
+		log.Printf("Replayed %d dead letters onto the job queue\n", count)
+	default:
+		log.Fatalf("Invalid mode %q, expected list or replay\n", mode)
+	}
+}
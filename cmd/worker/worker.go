@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os/signal"
+	"syscall"
 
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/config"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/db"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/webhook"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/worker"
 )
 
@@ -16,32 +20,76 @@ func main() {
 		log.Fatalf("Error while config init: %v", err)
 	}
 
+	// cancelled on SIGTERM or SIGINT, so in-flight jobs can be drained before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
 	// get psql store
 	psqlStore := worker.NewPsqlWorkerStore(cfg.DbDsn)
 
-	// get minio store
-	minioConn := &db.MinioConn{
-		Endpoint: cfg.MinioEndpoint,
-		User:     cfg.MinioUser,
-		Password: cfg.MinioPassword,
-		Ssl:      cfg.MinioSSL,
+	// get file store, minio or a local directory
+	fileStore, err := service.NewFileStore(cfg.FileStoreBackend, &service.FileStoreConfig{
+		Minio: &db.MinioConn{
+			Endpoint: cfg.MinioEndpoint,
+			User:     cfg.MinioUser,
+			Password: cfg.MinioPassword,
+			Ssl:      cfg.MinioSSL,
+		},
+		Bucket: cfg.MinioBucket,
+		Root:   cfg.FileStoreRoot,
+	})
+	if err != nil {
+		log.Fatalf("Error while setting up file store: %v", err)
 	}
-	minioStore := service.NewMinioStore(minioConn, cfg.MinioBucket)
 
 	// get rabbitmq client
-	rabbitMqQueue := service.NewTaskQueue(cfg.RabbitMqDsn, cfg.RabbitMqQueueName)
+	rabbitMqQueue := service.NewTaskQueue(cfg.RabbitMqDsn, cfg.RabbitMqQueueName, cfg.RabbitMqMaxRetries, cfg.RabbitMqRetryDelay)
 
-	// face match and ocr service
-	faceMatchService := worker.NewFaceMatchService()
-	ocrService := worker.NewOCRService()
+	// start the webhook dispatcher, it posts the results of finished jobs to the clients
+	dispatcher := webhook.New(&webhook.DispatcherConfig{
+		DataStore:    service.NewPsqlStore(cfg.DbDsn),
+		HTTPClient:   webhook.NewHTTPClient(cfg.WebhookTimeout),
+		MaxAttempts:  cfg.WebhookMaxAttempts,
+		BaseBackoff:  cfg.WebhookBaseBackoff,
+		PollInterval: cfg.WebhookPollInterval,
+	})
+	go dispatcher.Run(ctx)
+
+	// face match and ocr engines
+	faceMatchService, err := worker.NewFaceMatcher(cfg.FaceMatchEngine, &worker.HTTPEngineConfig{
+		URL:              cfg.FaceMatchEngineURL,
+		Timeout:          cfg.EngineTimeout,
+		MaxRetries:       &cfg.EngineMaxRetries,
+		RetryDelay:       cfg.EngineRetryDelay,
+		BreakerThreshold: cfg.EngineBreakerThreshold,
+		BreakerCooldown:  cfg.EngineBreakerCooldown,
+	})
+	if err != nil {
+		log.Fatalf("Error while setting up the face match engine: %v", err)
+	}
+	ocrService, err := worker.NewOCRPerformer(cfg.OCREngine, &worker.HTTPEngineConfig{
+		URL:              cfg.OCREngineURL,
+		Timeout:          cfg.EngineTimeout,
+		MaxRetries:       &cfg.EngineMaxRetries,
+		RetryDelay:       cfg.EngineRetryDelay,
+		BreakerThreshold: cfg.EngineBreakerThreshold,
+		BreakerCooldown:  cfg.EngineBreakerCooldown,
+	})
+	if err != nil {
+		log.Fatalf("Error while setting up the ocr engine: %v", err)
+	}
 
 	// start the worker and process the messages
 	worker := worker.New(&worker.WorkerConfig{
 		Queue:       rabbitMqQueue,
 		DataStore:   psqlStore,
-		FileStore:   minioStore,
+		FileStore:   fileStore,
 		FaceMatcher: faceMatchService,
 		OCR:         ocrService,
+
+		Concurrency:     cfg.WorkerConcurrency,
+		ShutdownTimeout: cfg.WorkerShutdownTimeout,
 	})
-	worker.ProcessMessages()
+	worker.ProcessMessages(ctx)
+	log.Println("Worker stopped")
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"log"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 )
@@ -16,14 +17,99 @@ type Config struct {
 	PostgresSSL       string `env:"POSTGRES_SSL,required"`
 	PostgresDB        string `env:"POSTGRES_DB,required"`
 	HashPassword      string `env:"HASH_PASSWORD,required"`
-	MinioUser         string `env:"MINIO_USER,required"`
-	MinioPassword     string `env:"MINIO_PASSWORD,required"`
-	MinioEndpoint     string `env:"MINIO_ENDPOINT,required"`
-	MinioSSL          bool   `env:"MINIO_SSL,required"`
-	MinioBucket       string `env:"MINIO_BUCKET_NAME,required"`
 	RedisDsn          string `env:"REDIS_DSN,required"`
 	RabbitMqDsn       string `env:"RABBITMQ_DSN,required"`
 	RabbitMqQueueName string `env:"RABBITMQ_QUEUE_NAME,required"`
+
+	// minio or local, the minio settings are only needed by the minio backend and FILE_STORE_ROOT by the local one
+	FileStoreBackend string `env:"FILE_STORE_BACKEND" envDefault:"minio"`
+	FileStoreRoot    string `env:"FILE_STORE_ROOT" envDefault:"data/files"`
+	MinioUser        string `env:"MINIO_USER"`
+	MinioPassword    string `env:"MINIO_PASSWORD"`
+	MinioEndpoint    string `env:"MINIO_ENDPOINT"`
+	MinioSSL         bool   `env:"MINIO_SSL"`
+	MinioBucket      string `env:"MINIO_BUCKET_NAME"`
+
+	RabbitMqMaxRetries int           `env:"RABBITMQ_MAX_RETRIES" envDefault:"5"`
+	RabbitMqRetryDelay time.Duration `env:"RABBITMQ_RETRY_DELAY" envDefault:"5s"`
+
+	WorkerConcurrency     int           `env:"WORKER_CONCURRENCY" envDefault:"4"`
+	WorkerShutdownTimeout time.Duration `env:"WORKER_SHUTDOWN_TIMEOUT" envDefault:"30s"`
+
+	JobReaperSchedule   string        `env:"JOB_REAPER_SCHEDULE" envDefault:"@every 1m"`
+	JobFaceMatchTimeout time.Duration `env:"JOB_FACE_MATCH_TIMEOUT" envDefault:"30m"`
+	JobOCRTimeout       time.Duration `env:"JOB_OCR_TIMEOUT" envDefault:"30m"`
+	JobMaxAttempts      int           `env:"JOB_MAX_ATTEMPTS" envDefault:"3"`
+
+	FaceMatchEngine        string        `env:"FACE_MATCH_ENGINE" envDefault:"dummy"`
+	FaceMatchEngineURL     string        `env:"FACE_MATCH_ENGINE_URL"`
+	OCREngine              string        `env:"OCR_ENGINE" envDefault:"dummy"`
+	OCREngineURL           string        `env:"OCR_ENGINE_URL"`
+	EngineTimeout          time.Duration `env:"ENGINE_TIMEOUT" envDefault:"10s"`
+	EngineMaxRetries       int           `env:"ENGINE_MAX_RETRIES" envDefault:"2"`
+	EngineRetryDelay       time.Duration `env:"ENGINE_RETRY_DELAY" envDefault:"500ms"`
+	EngineBreakerThreshold int           `env:"ENGINE_BREAKER_THRESHOLD" envDefault:"5"`
+	EngineBreakerCooldown  time.Duration `env:"ENGINE_BREAKER_COOLDOWN" envDefault:"30s"`
+
+	WebhookMaxAttempts  int           `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"8"`
+	WebhookBaseBackoff  time.Duration `env:"WEBHOOK_BASE_BACKOFF" envDefault:"30s"`
+	WebhookPollInterval time.Duration `env:"WEBHOOK_POLL_INTERVAL" envDefault:"5s"`
+	WebhookTimeout      time.Duration `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+
+	AuthCacheTTL         time.Duration `env:"AUTH_CACHE_TTL" envDefault:"1m"`
+	AuthSignatureMaxSkew time.Duration `env:"AUTH_SIGNATURE_MAX_SKEW" envDefault:"5m"`
+
+	// kid:key pairs, tokens are signed with the key of JWT_SIGNING_KEY_ID and verified with any of them
+	JWTSigningKeys  map[string]string `env:"JWT_SIGNING_KEYS"`
+	JWTSigningKeyID string            `env:"JWT_SIGNING_KEY_ID"`
+	JWTTTL          time.Duration     `env:"JWT_TTL" envDefault:"15m"`
+	JWTRefreshTTL   time.Duration     `env:"JWT_REFRESH_TTL" envDefault:"720h"`
+
+	IdempotencyTTL   time.Duration `env:"IDEMPOTENCY_TTL" envDefault:"24h"`
+	UploadSessionTTL time.Duration `env:"UPLOAD_SESSION_TTL" envDefault:"24h"`
+	PresignTTL       time.Duration `env:"PRESIGN_TTL" envDefault:"15m"`
+}
+
+// DevConfig is the config of the single binary dev mode, which keeps everything in memory
+type DevConfig struct {
+	Host       string        `env:"HOST"`
+	Port       string        `env:"PORT" envDefault:"8080"`
+	QueueSize  int           `env:"DEV_QUEUE_SIZE" envDefault:"1000"`
+	MaxRetries int           `env:"RABBITMQ_MAX_RETRIES" envDefault:"5"`
+	RetryDelay time.Duration `env:"RABBITMQ_RETRY_DELAY" envDefault:"5s"`
+
+	WorkerConcurrency     int           `env:"WORKER_CONCURRENCY" envDefault:"4"`
+	WorkerShutdownTimeout time.Duration `env:"WORKER_SHUTDOWN_TIMEOUT" envDefault:"30s"`
+
+	JobReaperSchedule   string        `env:"JOB_REAPER_SCHEDULE" envDefault:"@every 1m"`
+	JobFaceMatchTimeout time.Duration `env:"JOB_FACE_MATCH_TIMEOUT" envDefault:"30m"`
+	JobOCRTimeout       time.Duration `env:"JOB_OCR_TIMEOUT" envDefault:"30m"`
+	JobMaxAttempts      int           `env:"JOB_MAX_ATTEMPTS" envDefault:"3"`
+
+	FaceMatchEngine        string        `env:"FACE_MATCH_ENGINE" envDefault:"dummy"`
+	FaceMatchEngineURL     string        `env:"FACE_MATCH_ENGINE_URL"`
+	OCREngine              string        `env:"OCR_ENGINE" envDefault:"dummy"`
+	OCREngineURL           string        `env:"OCR_ENGINE_URL"`
+	EngineTimeout          time.Duration `env:"ENGINE_TIMEOUT" envDefault:"10s"`
+	EngineMaxRetries       int           `env:"ENGINE_MAX_RETRIES" envDefault:"2"`
+	EngineRetryDelay       time.Duration `env:"ENGINE_RETRY_DELAY" envDefault:"500ms"`
+	EngineBreakerThreshold int           `env:"ENGINE_BREAKER_THRESHOLD" envDefault:"5"`
+	EngineBreakerCooldown  time.Duration `env:"ENGINE_BREAKER_COOLDOWN" envDefault:"30s"`
+
+	WebhookMaxAttempts  int           `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"8"`
+	WebhookBaseBackoff  time.Duration `env:"WEBHOOK_BASE_BACKOFF" envDefault:"30s"`
+	WebhookPollInterval time.Duration `env:"WEBHOOK_POLL_INTERVAL" envDefault:"5s"`
+	WebhookTimeout      time.Duration `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+
+	AuthCacheTTL         time.Duration `env:"AUTH_CACHE_TTL" envDefault:"1m"`
+	AuthSignatureMaxSkew time.Duration `env:"AUTH_SIGNATURE_MAX_SKEW" envDefault:"5m"`
+
+	JWTTTL        time.Duration `env:"JWT_TTL" envDefault:"15m"`
+	JWTRefreshTTL time.Duration `env:"JWT_REFRESH_TTL" envDefault:"720h"`
+
+	IdempotencyTTL   time.Duration `env:"IDEMPOTENCY_TTL" envDefault:"24h"`
+	UploadSessionTTL time.Duration `env:"UPLOAD_SESSION_TTL" envDefault:"24h"`
+	PresignTTL       time.Duration `env:"PRESIGN_TTL" envDefault:"15m"`
 }
 
 func Init() (*Config, error) {
@@ -43,3 +129,14 @@ func Init() (*Config, error) {
 
 	return &cfg, nil
 }
+
+func InitDev() (*DevConfig, error) {
+	var cfg DevConfig
+	err := env.Parse(&cfg)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &cfg, nil
+}
@@ -2,21 +2,34 @@ package cronjob
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 	"github.com/robfig/cron/v3"
 )
 
+const (
+	// longer than service.MAX_RETRY_DELAY, so jobs waiting for a retry aren't taken for stale ones
+	DEFAULT_FACE_MATCH_TIMEOUT = 30 * time.Minute
+	DEFAULT_OCR_TIMEOUT        = 30 * time.Minute
+	DEFAULT_MAX_JOB_ATTEMPTS   = 3
+)
+
 type CronJob struct {
-	service   CronJobServiceManager
-	db        store.CronJobDataStore
-	fileStore store.FileStore
-	Cron      *cron.Cron
+	service          CronJobServiceManager
+	db               store.CronJobDataStore
+	fileStore        store.FileStore
+	queue            service.TaskQueue
+	faceMatchTimeout time.Duration
+	ocrTimeout       time.Duration
+	maxJobAttempts   int
+	Cron             *cron.Cron
 }
 
 type CronJobConfig struct {
@@ -24,16 +37,53 @@ type CronJobConfig struct {
 	DataStore      store.CronJobDataStore
 	FileStore      store.FileStore
 	Cron           *cron.Cron
+
+	// queue the stale jobs are put back on
+	Queue service.TaskQueue
+
+	// how long a job may sit on the queue or in a worker before it's considered stale
+	FaceMatchTimeout time.Duration
+	OCRTimeout       time.Duration
+
+	// times a job is put on the queue before a stale one is failed instead
+	MaxJobAttempts int
+
+	// longest a job waits in a retry queue of the queue, the timeouts must be longer so the jobs waiting
+	// for a retry aren't requeued as stale ones and processed twice
+	MaxRetryDelay time.Duration
 }
 
-// instantiate a CronJob struct
-func New(cronjobConfig *CronJobConfig) *CronJob {
-	return &CronJob{
-		service:   cronjobConfig.ServiceManager,
-		db:        cronjobConfig.DataStore,
-		fileStore: cronjobConfig.FileStore,
-		Cron:      cronjobConfig.Cron,
+// instantiate a CronJob struct, zero values in config fall back to the defaults
+func New(cronjobConfig *CronJobConfig) (*CronJob, error) {
+	c := &CronJob{
+		service:          cronjobConfig.ServiceManager,
+		db:               cronjobConfig.DataStore,
+		fileStore:        cronjobConfig.FileStore,
+		queue:            cronjobConfig.Queue,
+		faceMatchTimeout: cronjobConfig.FaceMatchTimeout,
+		ocrTimeout:       cronjobConfig.OCRTimeout,
+		maxJobAttempts:   cronjobConfig.MaxJobAttempts,
+		Cron:             cronjobConfig.Cron,
 	}
+
+	if c.faceMatchTimeout <= 0 {
+		c.faceMatchTimeout = DEFAULT_FACE_MATCH_TIMEOUT
+	}
+	if c.ocrTimeout <= 0 {
+		c.ocrTimeout = DEFAULT_OCR_TIMEOUT
+	}
+	if c.maxJobAttempts <= 0 {
+		c.maxJobAttempts = DEFAULT_MAX_JOB_ATTEMPTS
+	}
+
+	if c.faceMatchTimeout <= cronjobConfig.MaxRetryDelay {
+		return nil, fmt.Errorf("face match job timeout %s must be longer than the longest retry delay %s", c.faceMatchTimeout, cronjobConfig.MaxRetryDelay)
+	}
+	if c.ocrTimeout <= cronjobConfig.MaxRetryDelay {
+		return nil, fmt.Errorf("ocr job timeout %s must be longer than the longest retry delay %s", c.ocrTimeout, cronjobConfig.MaxRetryDelay)
+	}
+
+	return c, nil
 }
 
 func (c *CronJob) CalcDailyReport(currentTime time.Time) {
@@ -113,6 +163,64 @@ func (c *CronJob) CalcMonthlyReport(currentTime time.Time) {
 	log.Printf("Monthly report cronjob executed successfully at %s", time.Now().String())
 }
 
+// PurgeIdempotencyKeys deletes the expired idempotency keys, which are never replayed again
+func (c *CronJob) PurgeIdempotencyKeys() {
+	count, err := c.db.DeleteExpiredIdempotencyKeys()
+	if err != nil {
+		log.Printf("Error while purging expired idempotency keys: %s\n", err.Error())
+		return
+	}
+
+	log.Printf("Idempotency key purge executed at %s, %d deleted", time.Now().String(), count)
+}
+
+// PurgeUploadSessions deletes the chunked uploads past their expiry, and aborts the multipart uploads of the ones
+// which weren't completed so the chunks don't linger in the file store. Chunks already assembled into the file
+// of a session which couldn't be saved are deleted along with it.
+func (c *CronJob) PurgeUploadSessions() {
+	sessions, err := c.db.DeleteExpiredUploadSessions()
+	if err != nil {
+		log.Printf("Error while purging expired upload sessions: %s\n", err.Error())
+		return
+	}
+
+	for _, session := range sessions {
+		if session.UploadID != "" {
+			continue
+		}
+		_, err := c.fileStore.StatFile(session.FilePath)
+		if err == nil {
+			err = c.fileStore.DeleteFile(session.FilePath)
+		} else if errors.Is(err, store.ErrFileNotFound) {
+			err = c.fileStore.AbortMultipartUpload(session.FilePath, session.StoreUploadID)
+		}
+		if err != nil {
+			log.Printf("Error while dropping the chunks of upload session %s: %s\n", session.ID, err.Error())
+		}
+	}
+
+	log.Printf("Upload session purge executed at %s, %d deleted", time.Now().String(), len(sessions))
+}
+
+// PurgePresignedUploads deletes the presigned uploads past their confirm window, along with the files staged
+// for them, which were either never confirmed or put again after it
+func (c *CronJob) PurgePresignedUploads() {
+	uploads, err := c.db.DeleteExpiredPresignedUploads()
+	if err != nil {
+		log.Printf("Error while purging expired presigned uploads: %s\n", err.Error())
+		return
+	}
+
+	for _, upload := range uploads {
+		err := c.fileStore.DeleteFile(upload.FilePath)
+		if err != nil && !errors.Is(err, store.ErrFileNotFound) {
+			log.Printf("Error while deleting the staged file of presigned upload %s: %s\n", upload.ID, err.Error())
+		}
+	}
+
+	log.Printf("Presigned upload purge executed at %s, %d deleted", time.Now().String(), len(uploads))
+}
+
 func (c *CronJob) getDailyReportPath(date string) string {
 	return fmt.Sprintf("reports/daily/%s", strings.ReplaceAll(date, "-", ""))
 }
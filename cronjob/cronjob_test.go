@@ -1,14 +1,25 @@
 package cronjob
 
 import (
+	"database/sql"
+	"io"
+	"reflect"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 )
 
 type mockCronJobStore struct {
-	counter int
+	counter   int
+	staleJobs []*types.StaleJob
+	requeued  []string
+	failed    map[string]string
+	purges    int
+	sessions  []*types.UploadSession
+	presigned []*types.PresignedUpload
 }
 
 func (mst *mockCronJobStore) GetReportData(date string) ([]*types.ClientReport, error) {
@@ -84,6 +95,50 @@ func (mst *mockCronJobStore) GetMonthlyReport(currentMonth, currentYear int) ([]
 	}, nil
 }
 
+func (mst *mockCronJobStore) GetStaleJobs(jobType string, timeout time.Duration) ([]*types.StaleJob, error) {
+	var jobs []*types.StaleJob
+	for _, job := range mst.staleJobs {
+		if string(job.Type) == jobType {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (mst *mockCronJobStore) RequeueStaleJob(jobType, jobID string, attempts int) error {
+	if jobID == "moved" {
+		return sql.ErrNoRows
+	}
+	mst.requeued = append(mst.requeued, jobID)
+	return nil
+}
+
+func (mst *mockCronJobStore) FailStaleJob(jobType, jobID string, attempts int, reason string) error {
+	if jobID == "moved" {
+		return sql.ErrNoRows
+	}
+	if mst.failed == nil {
+		mst.failed = map[string]string{}
+	}
+	mst.failed[jobID] = reason
+	return nil
+}
+
+func (mst *mockCronJobStore) DeleteExpiredIdempotencyKeys() (int64, error) {
+	mst.purges++
+	return 2, nil
+}
+
+func (mst *mockCronJobStore) DeleteExpiredUploadSessions() ([]*types.UploadSession, error) {
+	mst.purges++
+	return mst.sessions, nil
+}
+
+func (mst *mockCronJobStore) DeleteExpiredPresignedUploads() ([]*types.PresignedUpload, error) {
+	mst.purges++
+	return mst.presigned, nil
+}
+
 type mockCronJobService struct {
 	counter int
 }
@@ -95,6 +150,9 @@ func (mse *mockCronJobService) PrepareCSV(data interface{}) ([]byte, error) {
 
 type mockCronJobFileStore struct {
 	counter int
+	files   []string
+	aborted []string
+	deleted []string
 }
 
 func (mfs *mockCronJobFileStore) SaveFile(file *types.FileUpload) error {
@@ -106,6 +164,43 @@ func (mfs *mockCronJobFileStore) GetFile(filePath string) ([]byte, error) {
 	return nil, nil
 }
 
+func (mfs *mockCronJobFileStore) DeleteFile(filePath string) error {
+	mfs.deleted = append(mfs.deleted, filePath)
+	return nil
+}
+
+func (mfs *mockCronJobFileStore) StartMultipartUpload(filePath, contentType string) (string, error) {
+	return "", nil
+}
+
+func (mfs *mockCronJobFileStore) SaveFilePart(filePath, uploadID string, partNumber int, content io.Reader, size int64) (string, error) {
+	return "", nil
+}
+
+func (mfs *mockCronJobFileStore) CompleteMultipartUpload(filePath, uploadID string, parts []*types.UploadChunk) error {
+	return nil
+}
+
+func (mfs *mockCronJobFileStore) AbortMultipartUpload(filePath, uploadID string) error {
+	mfs.aborted = append(mfs.aborted, uploadID)
+	return nil
+}
+
+func (mfs *mockCronJobFileStore) PresignPutURL(filePath, contentType string, expiresIn time.Duration) (string, error) {
+	return "", nil
+}
+
+func (mfs *mockCronJobFileStore) PresignGetURL(filePath string, expiresIn time.Duration) (string, error) {
+	return "", nil
+}
+
+func (mfs *mockCronJobFileStore) StatFile(filePath string) (*types.FileInfo, error) {
+	if !slices.Contains(mfs.files, filePath) {
+		return nil, store.ErrFileNotFound
+	}
+	return &types.FileInfo{}, nil
+}
+
 func TestCalcDailyReport(t *testing.T) {
 	// call the method
 	mockService := &mockCronJobService{}
@@ -173,3 +268,58 @@ func TestCalcMonthlyReport(t *testing.T) {
 		})
 	}
 }
+
+func TestPurgeIdempotencyKeys(t *testing.T) {
+	mockDataStore := &mockCronJobStore{}
+	cj := &CronJob{db: mockDataStore}
+	cj.PurgeIdempotencyKeys()
+
+	if mockDataStore.purges != 1 {
+		t.Errorf("Expected mock data store purges to be %d but got %d", 1, mockDataStore.purges)
+	}
+}
+
+func TestPurgeUploadSessions(t *testing.T) {
+	mockDataStore := &mockCronJobStore{
+		sessions: []*types.UploadSession{
+			{ID: "session1", FilePath: "1/sessions/session1.png", StoreUploadID: "upload1"},
+			{ID: "session2", FilePath: "2/sessions/session2.jpeg", StoreUploadID: "upload2"},
+			{ID: "session3", FilePath: "1/sessions/session3.png", StoreUploadID: "upload3"},
+			{ID: "session4", FilePath: "1/sessions/session4.png", StoreUploadID: "upload4", UploadID: "image4"},
+		},
+	}
+	// session3 was assembled but not saved, session4 was completed
+	mockFileStore := &mockCronJobFileStore{files: []string{"1/sessions/session3.png"}}
+	cj := &CronJob{db: mockDataStore, fileStore: mockFileStore}
+	cj.PurgeUploadSessions()
+
+	if mockDataStore.purges != 1 {
+		t.Errorf("Expected mock data store purges to be %d but got %d", 1, mockDataStore.purges)
+	}
+	if !reflect.DeepEqual(mockFileStore.aborted, []string{"upload1", "upload2"}) {
+		t.Errorf("Expected the multipart uploads %v to be aborted but got %v", []string{"upload1", "upload2"}, mockFileStore.aborted)
+	}
+	if !reflect.DeepEqual(mockFileStore.deleted, []string{"1/sessions/session3.png"}) {
+		t.Errorf("Expected the assembled files %v to be deleted but got %v", []string{"1/sessions/session3.png"}, mockFileStore.deleted)
+	}
+}
+
+func TestPurgePresignedUploads(t *testing.T) {
+	mockDataStore := &mockCronJobStore{
+		presigned: []*types.PresignedUpload{
+			{ID: "upload1", ClientID: 1, FilePath: "incoming/1/upload1.png"},
+			{ID: "upload2", ClientID: 2, FilePath: "incoming/2/upload2.jpeg"},
+		},
+	}
+	mockFileStore := &mockCronJobFileStore{}
+	cj := &CronJob{db: mockDataStore, fileStore: mockFileStore}
+	cj.PurgePresignedUploads()
+
+	if mockDataStore.purges != 1 {
+		t.Errorf("Expected mock data store purges to be %d but got %d", 1, mockDataStore.purges)
+	}
+	expected := []string{"incoming/1/upload1.png", "incoming/2/upload2.jpeg"}
+	if !reflect.DeepEqual(mockFileStore.deleted, expected) {
+		t.Errorf("Expected the staged files %v to be deleted but got %v", expected, mockFileStore.deleted)
+	}
+}
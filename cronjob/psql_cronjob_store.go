@@ -3,6 +3,7 @@ package cronjob
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/db"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
@@ -36,7 +37,8 @@ func (s PsqlCrobJobStore) GetReportData(date string) ([]*types.ClientReport, err
         JOIN plan p ON c.plan_id = p.id
         LEFT JOIN face_match f ON f.client_id = c.id AND CAST(f.created_at AS DATE) = $1
         LEFT JOIN ocr o ON o.client_id = c.id AND CAST(o.created_at AS DATE) = $1
-        LEFT JOIN upload u ON u.client_id = c.id
+        LEFT JOIN upload u ON u.client_id = c.id AND u.deleted_at IS NULL -- deleted uploads aren't stored anymore
+        WHERE c.sandbox = FALSE -- sandbox clients aren't billed
         GROUP BY 
             c.id, c.name, p.name, p.per_call_cost, p.upload_cost_per_mb, CAST(f.created_at AS DATE);
     `
@@ -65,7 +67,8 @@ func (s PsqlCrobJobStore) GetMonthlyReport(currentMonth, currentYear int) ([][]*
 	// fetch all client IDs
 	clientQuery := `
 		SELECT id 
-		FROM client;
+		FROM client
+		WHERE sandbox = FALSE; -- sandbox clients aren't billed
 	`
 
 	clientRows, err := s.db.Query(clientQuery)
@@ -104,7 +107,7 @@ func (s PsqlCrobJobStore) GetMonthlyReport(currentMonth, currentYear int) ([][]*
 			LEFT JOIN plan p ON c.plan_id = p.id
 			LEFT JOIN face_match fm ON c.id = fm.client_id AND DATE_PART('month', fm.created_at) = $1 AND DATE_PART('year', fm.created_at) = $2
 			LEFT JOIN ocr o ON c.id = o.client_id AND DATE_PART('month', o.created_at) = $1 AND DATE_PART('year', o.created_at) = $2
-			LEFT JOIN upload u ON c.id = u.client_id AND DATE_PART('month', u.created_at) = $1 AND DATE_PART('year', u.created_at) = $2
+			LEFT JOIN upload u ON c.id = u.client_id AND DATE_PART('month', u.created_at) = $1 AND DATE_PART('year', u.created_at) = $2 AND u.deleted_at IS NULL
 			WHERE c.id = $3
 			GROUP BY DATE(fm.created_at), p.per_call_cost, p.upload_cost_per_mb
 		)
@@ -157,3 +160,200 @@ func (s PsqlCrobJobStore) GetMonthlyReport(currentMonth, currentYear int) ([][]*
 
 	return finalReports, nil
 }
+
+func (s PsqlCrobJobStore) GetStaleJobs(jobType string, timeout time.Duration) ([]*types.StaleJob, error) {
+	// jobs are stale when they sit on the queue or in a worker for longer than the timeout
+	var query string
+	switch jobType {
+	case types.FACE_MATCH_WORK_TYPE:
+		query = `
+			SELECT j.job_id, j.status, j.attempts, c.sandbox, u1.uuid, u2.uuid
+			FROM face_match j
+			JOIN client c ON c.id = j.client_id
+			JOIN upload u1 ON u1.id = j.upload_id1
+			JOIN upload u2 ON u2.id = j.upload_id2
+			WHERE (j.status = 'created' AND j.enqueued_at < NOW() - make_interval(secs => $1))
+				OR (j.status = 'processing' AND j.processed_at < NOW() - make_interval(secs => $1))
+		`
+	case types.OCR_WORK_TYPE:
+		query = `
+			SELECT j.job_id, j.status, j.attempts, c.sandbox, u.uuid
+			FROM ocr j
+			JOIN client c ON c.id = j.client_id
+			JOIN upload u ON u.id = j.upload_id
+			WHERE (j.status = 'created' AND j.enqueued_at < NOW() - make_interval(secs => $1))
+				OR (j.status = 'processing' AND j.processed_at < NOW() - make_interval(secs => $1))
+		`
+	default:
+		return nil, fmt.Errorf("unknown job type %q", jobType)
+	}
+
+	rows, err := s.db.Query(query, timeout.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*types.StaleJob
+	for rows.Next() {
+		job := &types.StaleJob{Type: types.WorkType(jobType)}
+		if jobType == types.FACE_MATCH_WORK_TYPE {
+			job.ImageIDs = make([]string, 2)
+			err = rows.Scan(&job.JobID, &job.Status, &job.Attempts, &job.Sandbox, &job.ImageIDs[0], &job.ImageIDs[1])
+		} else {
+			job.ImageIDs = make([]string, 1)
+			err = rows.Scan(&job.JobID, &job.Status, &job.Attempts, &job.Sandbox, &job.ImageIDs[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// RequeueStaleJob moves the job back to 'created' and counts another attempt.
+// It returns sql.ErrNoRows if the job has moved on since it was found stale.
+func (s PsqlCrobJobStore) RequeueStaleJob(jobType, jobID string, attempts int) error {
+	table, err := jobTable(jobType)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.Exec(
+		fmt.Sprintf(`UPDATE %s SET status = 'created', attempts = attempts + 1, enqueued_at = CURRENT_TIMESTAMP, processed_at = NULL
+			WHERE job_id = $1 AND attempts = $2 AND status IN ('created', 'processing')`, table),
+		jobID, attempts,
+	)
+	if err != nil {
+		return err
+	}
+
+	return checkUpdated(res)
+}
+
+// FailStaleJob marks the job failed and queues its webhook deliveries.
+// It returns sql.ErrNoRows if the job has moved on since it was found stale.
+func (s PsqlCrobJobStore) FailStaleJob(jobType, jobID string, attempts int, reason string) error {
+	table, err := jobTable(jobType)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		fmt.Sprintf(`UPDATE %s SET status = 'failed', failed_reason = $3, failed_at = CURRENT_TIMESTAMP
+			WHERE job_id = $1 AND attempts = $2 AND status IN ('created', 'processing')`, table),
+		jobID, attempts, reason,
+	)
+	if err != nil {
+		return err
+	}
+	err = checkUpdated(res)
+	if err != nil {
+		return err
+	}
+
+	// one delivery for every active webhook of the client who owns the job
+	_, err = tx.Exec(
+		fmt.Sprintf(`INSERT INTO webhook_delivery (webhook_id, client_id, job_type, job_id)
+			SELECT w.id, w.client_id, $1::TEXT, $2::TEXT
+			FROM webhook w
+			JOIN %s j ON j.client_id = w.client_id AND j.job_id = $2::TEXT
+			WHERE w.deleted_at IS NULL`, table),
+		jobType, jobID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteExpiredIdempotencyKeys deletes the idempotency keys past their expiry, and returns how many it did
+func (s PsqlCrobJobStore) DeleteExpiredIdempotencyKeys() (int64, error) {
+	res, err := s.db.Exec("DELETE FROM idempotency_key WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// DeleteExpiredUploadSessions deletes the chunked uploads past their expiry along with their chunks,
+// and returns them so their multipart uploads can be aborted
+func (s PsqlCrobJobStore) DeleteExpiredUploadSessions() ([]*types.UploadSession, error) {
+	rows, err := s.db.Query("DELETE FROM upload_session WHERE expires_at <= NOW() RETURNING id, client_id, file_path, store_upload_id, upload_uuid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*types.UploadSession
+	for rows.Next() {
+		var session types.UploadSession
+		var uploadID sql.NullString
+		if err := rows.Scan(&session.ID, &session.ClientID, &session.FilePath, &session.StoreUploadID, &uploadID); err != nil {
+			return nil, err
+		}
+		session.UploadID = uploadID.String
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// DeleteExpiredPresignedUploads deletes the presigned uploads past their expiry,
+// and returns them so the files staged for them can be deleted
+func (s PsqlCrobJobStore) DeleteExpiredPresignedUploads() ([]*types.PresignedUpload, error) {
+	rows, err := s.db.Query("DELETE FROM presigned_upload WHERE expires_at <= NOW() RETURNING id, client_id, file_path")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []*types.PresignedUpload
+	for rows.Next() {
+		var upload types.PresignedUpload
+		if err := rows.Scan(&upload.ID, &upload.ClientID, &upload.FilePath); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, &upload)
+	}
+
+	return uploads, rows.Err()
+}
+
+// jobTable returns the table holding the jobs of the given type
+func jobTable(jobType string) (string, error) {
+	switch jobType {
+	case types.FACE_MATCH_WORK_TYPE:
+		return "face_match", nil
+	case types.OCR_WORK_TYPE:
+		return "ocr", nil
+	default:
+		return "", fmt.Errorf("unknown job type %q", jobType)
+	}
+}
+
+func checkUpdated(res sql.Result) error {
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
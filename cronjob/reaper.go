@@ -0,0 +1,123 @@
+package cronjob
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+// ReapStaleJobs finds the jobs stuck in 'created' or 'processing' beyond their timeout, like after a worker crash.
+// They're put back on the queue, or failed once they've used up their attempts.
+func (c *CronJob) ReapStaleJobs() {
+	timeouts := []struct {
+		jobType string
+		timeout time.Duration
+	}{
+		{jobType: types.FACE_MATCH_WORK_TYPE, timeout: c.faceMatchTimeout},
+		{jobType: types.OCR_WORK_TYPE, timeout: c.ocrTimeout},
+	}
+
+	requeued, failed := 0, 0
+	for _, t := range timeouts {
+		jobs, err := c.db.GetStaleJobs(t.jobType, t.timeout)
+		if err != nil {
+			log.Printf("Error while fetching stale %s jobs: %s\n", t.jobType, err.Error())
+			continue
+		}
+
+		for _, job := range jobs {
+			if job.Attempts >= c.maxJobAttempts {
+				if c.failStaleJob(job) {
+					failed++
+				}
+				continue
+			}
+
+			if c.requeueStaleJob(job) {
+				requeued++
+			}
+		}
+	}
+
+	log.Printf("Stale job reaper executed at %s, %d requeued, %d failed", time.Now().String(), requeued, failed)
+}
+
+func (c *CronJob) failStaleJob(job *types.StaleJob) bool {
+	reason := fmt.Sprintf("timed out after %d attempts", job.Attempts)
+	err := c.db.FailStaleJob(string(job.Type), job.JobID, job.Attempts, reason)
+	if errors.Is(err, sql.ErrNoRows) {
+		// a worker got to it in the meantime
+		return false
+	}
+	if err != nil {
+		log.Printf("Error while failing stale job (%s): %s\n", job.JobID, err.Error())
+		return false
+	}
+
+	return true
+}
+
+func (c *CronJob) requeueStaleJob(job *types.StaleJob) bool {
+	payload, err := queuePayload(job)
+	if err != nil {
+		log.Printf("Error while preparing queue payload of stale job (%s): %s\n", job.JobID, err.Error())
+		return false
+	}
+
+	// the job is claimed first, so a job found stale by two reapers at once is only requeued once
+	err = c.db.RequeueStaleJob(string(job.Type), job.JobID, job.Attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Error while requeueing stale job (%s): %s\n", job.JobID, err.Error())
+		return false
+	}
+
+	// if the push fails, the job is found stale again after its timeout
+	err = c.queue.PushJobOnQueue(payload)
+	if err != nil {
+		log.Printf("Error while pushing stale job (%s) on queue: %s\n", job.JobID, err.Error())
+		return false
+	}
+
+	return true
+}
+
+// queuePayload rebuilds the message the job was first queued with
+func queuePayload(job *types.StaleJob) ([]byte, error) {
+	switch job.Type {
+	case types.FACE_MATCH_WORK_TYPE:
+		if len(job.ImageIDs) != 2 {
+			return nil, fmt.Errorf("expected 2 uploads but got %d", len(job.ImageIDs))
+		}
+		return json.Marshal(types.FaceMatchQueuePayload{
+			Type: types.FACE_MATCH_WORK_TYPE,
+			Msg: types.FaceMatchInternalPayload{
+				JobID:   job.JobID,
+				Image1:  job.ImageIDs[0],
+				Image2:  job.ImageIDs[1],
+				Sandbox: job.Sandbox,
+			},
+		})
+	case types.OCR_WORK_TYPE:
+		if len(job.ImageIDs) != 1 {
+			return nil, fmt.Errorf("expected 1 upload but got %d", len(job.ImageIDs))
+		}
+		return json.Marshal(types.OCRQueuePayload{
+			Type: types.OCR_WORK_TYPE,
+			Msg: types.OCRInternalPayload{
+				JobID:   job.JobID,
+				Image:   job.ImageIDs[0],
+				Sandbox: job.Sandbox,
+			},
+		})
+	default:
+		return nil, fmt.Errorf("unknown job type %q", job.Type)
+	}
+}
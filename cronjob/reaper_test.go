@@ -0,0 +1,100 @@
+package cronjob
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+type mockCronJobQueue struct {
+	service.TaskQueue
+	payloads [][]byte
+}
+
+func (m *mockCronJobQueue) PushJobOnQueue(payload []byte) error {
+	m.payloads = append(m.payloads, payload)
+	return nil
+}
+
+func TestReapStaleJobs(t *testing.T) {
+	mockDataStore := &mockCronJobStore{
+		staleJobs: []*types.StaleJob{
+			{Type: types.FACE_MATCH_WORK_TYPE, JobID: "job1", Status: types.JOB_STATUS_PROCESSING, Attempts: 1, ImageIDs: []string{"img1", "img2"}},
+			{Type: types.OCR_WORK_TYPE, JobID: "job2", Status: types.JOB_STATUS_CREATED, Attempts: 2, ImageIDs: []string{"img3"}},
+			{Type: types.OCR_WORK_TYPE, JobID: "job3", Status: types.JOB_STATUS_PROCESSING, Attempts: 3, ImageIDs: []string{"img4"}},
+			{Type: types.FACE_MATCH_WORK_TYPE, JobID: "moved", Status: types.JOB_STATUS_PROCESSING, Attempts: 1, ImageIDs: []string{"img1", "img2"}},
+		},
+	}
+	mockQueue := &mockCronJobQueue{}
+	cj, err := New(&CronJobConfig{
+		DataStore:      mockDataStore,
+		Queue:          mockQueue,
+		MaxJobAttempts: 3,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cj.ReapStaleJobs()
+
+	// jobs with attempts left are requeued, unless they moved on in the meantime
+	expRequeued := []string{"job1", "job2"}
+	if !reflect.DeepEqual(mockDataStore.requeued, expRequeued) {
+		t.Errorf("Expected requeued jobs %v but got %v", expRequeued, mockDataStore.requeued)
+	}
+	if len(mockQueue.payloads) != 2 {
+		t.Fatalf("Expected 2 jobs pushed on queue but got %d", len(mockQueue.payloads))
+	}
+
+	var faceMatch types.FaceMatchQueuePayload
+	json.Unmarshal(mockQueue.payloads[0], &faceMatch)
+	expFaceMatch := types.FaceMatchQueuePayload{
+		Type: types.FACE_MATCH_WORK_TYPE,
+		Msg:  types.FaceMatchInternalPayload{JobID: "job1", Image1: "img1", Image2: "img2"},
+	}
+	if faceMatch != expFaceMatch {
+		t.Errorf("Expected face match payload %+v but got %+v", expFaceMatch, faceMatch)
+	}
+
+	var ocr types.OCRQueuePayload
+	json.Unmarshal(mockQueue.payloads[1], &ocr)
+	expOCR := types.OCRQueuePayload{
+		Type: types.OCR_WORK_TYPE,
+		Msg:  types.OCRInternalPayload{JobID: "job2", Image: "img3"},
+	}
+	if ocr != expOCR {
+		t.Errorf("Expected ocr payload %+v but got %+v", expOCR, ocr)
+	}
+
+	// jobs out of attempts are failed
+	expFailed := map[string]string{"job3": "timed out after 3 attempts"}
+	if !reflect.DeepEqual(mockDataStore.failed, expFailed) {
+		t.Errorf("Expected failed jobs %v but got %v", expFailed, mockDataStore.failed)
+	}
+}
+
+func TestStaleJobTimeouts(t *testing.T) {
+	// the default timeouts outlast the longest retry delay
+	if _, err := New(&CronJobConfig{MaxRetryDelay: service.MAX_RETRY_DELAY}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// jobs waiting for a retry would be taken for stale ones
+	tt := []struct {
+		name   string
+		config *CronJobConfig
+	}{
+		{name: "face match timeout", config: &CronJobConfig{FaceMatchTimeout: 10 * time.Minute, MaxRetryDelay: service.MAX_RETRY_DELAY}},
+		{name: "ocr timeout", config: &CronJobConfig{OCRTimeout: service.MAX_RETRY_DELAY, MaxRetryDelay: service.MAX_RETRY_DELAY}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := New(tc.config); err == nil {
+				t.Errorf("Expected an error for a timeout within the longest retry delay")
+			}
+		})
+	}
+}
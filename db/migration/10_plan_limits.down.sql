@@ -0,0 +1,2 @@
+-- Drop the `plan_limit` table created in the up migration
+DROP TABLE IF EXISTS plan_limit;
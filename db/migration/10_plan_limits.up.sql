@@ -0,0 +1,30 @@
+-- Create the `plan_limit` table to store the rate limits and quotas of every plan, per endpoint
+CREATE TABLE IF NOT EXISTS plan_limit (
+    id SERIAL PRIMARY KEY,                                -- Primary key for the limit
+    plan_id INTEGER NOT NULL,                             -- Foreign key referencing the `plan` table
+    endpoint VARCHAR(50) NOT NULL,                        -- Endpoint the limit applies to (e.g., 'face-match'), '*' for the ones without their own limit
+    rate_per_minute INTEGER,                              -- Calls a minute the token bucket is refilled with, NULL for no rate limit
+    burst INTEGER NOT NULL DEFAULT 1,                     -- Size of the token bucket, the calls that can be made at once
+    daily_quota INTEGER,                                  -- Calls allowed a day, NULL for no quota
+    monthly_quota INTEGER,                                -- Calls allowed a month, NULL for no quota
+    UNIQUE (plan_id, endpoint),
+    FOREIGN KEY (plan_id) REFERENCES plan(id)             -- Enforce plan_id must exist in `plan`
+);
+
+-- Insert the default limits of the default plans
+INSERT INTO plan_limit (plan_id, endpoint, rate_per_minute, burst, daily_quota, monthly_quota)
+SELECT p.id, l.endpoint, l.rate_per_minute, l.burst, l.daily_quota, l.monthly_quota
+FROM plan p
+JOIN (
+    VALUES
+        ('basic', '*', 60, 20, NULL, NULL),
+        ('basic', 'face-match', 10, 5, 100, 2000),
+        ('basic', 'ocr', 10, 5, 100, 2000),
+        ('advanced', '*', 120, 40, NULL, NULL),
+        ('advanced', 'face-match', 30, 10, 1000, 20000),
+        ('advanced', 'ocr', 30, 10, 1000, 20000),
+        ('enterprise', '*', 600, 100, NULL, NULL),
+        ('enterprise', 'face-match', 120, 30, NULL, NULL),
+        ('enterprise', 'ocr', 120, 30, NULL, NULL)
+) AS l (plan_name, endpoint, rate_per_minute, burst, daily_quota, monthly_quota) ON l.plan_name = p.name
+ON CONFLICT (plan_id, endpoint) DO NOTHING;
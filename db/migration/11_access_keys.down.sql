@@ -0,0 +1,16 @@
+-- Put a key back on every client, the oldest working one, and drop the `access_key` table
+ALTER TABLE client
+ADD COLUMN access_key VARCHAR(20),
+ADD COLUMN secret_key_hash VARCHAR(200);
+
+UPDATE client c
+SET access_key = k.access_key, secret_key_hash = k.secret_key_hash
+FROM (
+    SELECT DISTINCT ON (client_id) client_id, access_key, secret_key_hash
+    FROM access_key
+    WHERE revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+    ORDER BY client_id, id
+) k
+WHERE k.client_id = c.id;
+
+DROP TABLE IF EXISTS access_key;
@@ -0,0 +1,23 @@
+-- Create the `access_key` table, so clients can hold several keys and rotate or revoke them
+CREATE TABLE IF NOT EXISTS access_key (
+    id SERIAL PRIMARY KEY,                                -- Primary key for the key
+    client_id INTEGER NOT NULL,                           -- Foreign key referencing the `client` table
+    access_key VARCHAR(20) NOT NULL UNIQUE,               -- Access key of the pair, sandbox keys have a `test_` prefix
+    secret_key_hash VARCHAR(200) NOT NULL,                -- Hashed value of the secret key of the pair
+    label VARCHAR(50) NOT NULL DEFAULT '',                -- Label given by the client to tell its keys apart
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,       -- Timestamp of creation
+    expires_at TIMESTAMP,                                 -- Timestamp after which the key stops working, NULL for never
+    revoked_at TIMESTAMP,                                 -- Timestamp indicating when the key was revoked
+    FOREIGN KEY (client_id) REFERENCES client(id)         -- Enforce client_id must exist in `client`
+);
+CREATE INDEX IF NOT EXISTS idx_access_key_client ON access_key (client_id);
+
+-- Move the key of every client to the new table
+INSERT INTO access_key (client_id, access_key, secret_key_hash, label, created_at)
+SELECT id, access_key, secret_key_hash, 'default', created_at
+FROM client
+WHERE access_key IS NOT NULL AND secret_key_hash IS NOT NULL;
+
+ALTER TABLE client
+DROP COLUMN access_key,
+DROP COLUMN secret_key_hash;
@@ -0,0 +1,4 @@
+-- Keys with signed requests are left with the secret key headers
+ALTER TABLE access_key
+DROP COLUMN auth_scheme,
+DROP COLUMN signing_secret;
@@ -0,0 +1,4 @@
+-- Let every key pick how requests are authenticated, with the plain secret key headers or signed requests
+ALTER TABLE access_key
+ADD COLUMN auth_scheme VARCHAR(20) NOT NULL DEFAULT 'header',  -- 'header' sends the secret key as is, 'signature' signs requests with it
+ADD COLUMN signing_secret VARCHAR(200);                        -- Secret key of 'signature' keys, encrypted with the server key
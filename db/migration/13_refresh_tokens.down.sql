@@ -0,0 +1,8 @@
+-- Drop the refresh tokens issued so far, the column goes back to being unused
+DROP INDEX IF EXISTS idx_client_refresh_token;
+
+UPDATE client SET refresh_token = NULL;
+ALTER TABLE client
+ALTER COLUMN refresh_token TYPE VARCHAR(50),
+DROP COLUMN refresh_token_key_id,
+DROP COLUMN refresh_token_expires_at;
@@ -0,0 +1,8 @@
+-- Keep the hash of the refresh token of the client, along with the key it was issued for and its expiry
+ALTER TABLE client
+ALTER COLUMN refresh_token TYPE VARCHAR(64),   -- Hex SHA-256 of the refresh token, NULL when none was issued
+ADD COLUMN refresh_token_key_id INTEGER,       -- Access key the refresh token was issued for, it stops working with the key
+ADD COLUMN refresh_token_expires_at TIMESTAMP; -- Timestamp after which the refresh token stops working
+
+UPDATE client SET refresh_token = NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_client_refresh_token ON client (refresh_token);
@@ -0,0 +1,3 @@
+-- Every key is allowed everything again
+ALTER TABLE access_key
+DROP COLUMN scopes;
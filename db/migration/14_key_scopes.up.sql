@@ -0,0 +1,3 @@
+-- Let every key be limited to some operations, the existing keys keep being allowed everything
+ALTER TABLE access_key
+ADD COLUMN scopes TEXT[] NOT NULL DEFAULT ARRAY['upload', 'face_match', 'ocr', 'results:read', 'reports:read']; -- Operations the key is allowed
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_idempotency_key_expires_at;
+DROP TABLE IF EXISTS idempotency_key;
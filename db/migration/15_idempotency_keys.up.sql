@@ -0,0 +1,15 @@
+-- Create the `idempotency_key` table to keep the first response of the requests sent with an Idempotency-Key header
+CREATE TABLE IF NOT EXISTS idempotency_key (
+    client_id INTEGER NOT NULL,                           -- Foreign key referencing the `client` table
+    idempotency_key VARCHAR(255) NOT NULL,                -- Key picked by the client, unique per client
+    request_hash VARCHAR(64) NOT NULL,                    -- Hex SHA-256 of the method, path and body of the first request
+    status_code INTEGER,                                  -- Http status code of the response, NULL while the request is in progress
+    response_body TEXT,                                   -- Body of the response, replayed to the retries
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,       -- Timestamp of creation
+    expires_at TIMESTAMP NOT NULL,                        -- Timestamp after which the key can be used again
+    PRIMARY KEY (client_id, idempotency_key),
+    FOREIGN KEY (client_id) REFERENCES client(id)         -- Enforce client_id must exist in `client`
+);
+
+-- Index used by the cron job to purge the expired keys
+CREATE INDEX IF NOT EXISTS idx_idempotency_key_expires_at ON idempotency_key (expires_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_upload_client_sha256;
+
+ALTER TABLE upload
+DROP COLUMN sha256;
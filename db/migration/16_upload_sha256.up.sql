@@ -0,0 +1,6 @@
+-- Keep the hash of every upload, so a client uploading the same file again gets the existing upload back
+ALTER TABLE upload
+ADD COLUMN sha256 VARCHAR(64); -- Hex SHA-256 of the uploaded file, NULL for the uploads made before it was kept
+
+-- Index used to find the earlier upload of the same file by the client
+CREATE INDEX IF NOT EXISTS idx_upload_client_sha256 ON upload (client_id, sha256);
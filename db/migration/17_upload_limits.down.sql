@@ -0,0 +1,7 @@
+ALTER TABLE upload
+DROP COLUMN format,
+DROP COLUMN height,
+DROP COLUMN width;
+
+ALTER TABLE plan
+DROP COLUMN max_upload_bytes;
@@ -0,0 +1,13 @@
+-- Limit the size of the files every plan can upload
+ALTER TABLE plan
+ADD COLUMN max_upload_bytes BIGINT NOT NULL DEFAULT 10485760; -- Largest file a client of the plan can upload, in bytes
+
+UPDATE plan SET max_upload_bytes = 5242880 WHERE name = 'basic';
+UPDATE plan SET max_upload_bytes = 10485760 WHERE name = 'advanced';
+UPDATE plan SET max_upload_bytes = 26214400 WHERE name = 'enterprise';
+
+-- Keep what was found decoding every upload
+ALTER TABLE upload
+ADD COLUMN width INTEGER, -- Width of the image in pixels, NULL for the uploads made before it was kept
+ADD COLUMN height INTEGER, -- Height of the image in pixels
+ADD COLUMN format VARCHAR(10); -- Format of the image sniffed from its content, png or jpeg
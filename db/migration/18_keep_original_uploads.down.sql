@@ -0,0 +1,2 @@
+ALTER TABLE client
+DROP COLUMN keep_original_uploads;
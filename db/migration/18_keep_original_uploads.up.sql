@@ -0,0 +1,3 @@
+-- Let clients opt out of having the metadata of their uploads stripped
+ALTER TABLE client
+ADD COLUMN keep_original_uploads BOOLEAN NOT NULL DEFAULT FALSE; -- Whether uploads are stored as sent, instead of re-encoded without their metadata
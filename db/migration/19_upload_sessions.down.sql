@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS upload_session_chunk;
+
+DROP INDEX IF EXISTS idx_upload_session_expires_at;
+
+DROP TABLE IF EXISTS upload_session;
@@ -0,0 +1,27 @@
+-- Create the `upload_session` table, keeping the state of chunked uploads until they're completed
+CREATE TABLE IF NOT EXISTS upload_session (
+    id VARCHAR(36) PRIMARY KEY, -- Id of the session, a uuid
+    client_id INTEGER NOT NULL, -- Foreign key referencing the `client` table
+    type FILE_UPLOAD_TYPE NOT NULL, -- Type of the upload, referencing the ENUM
+    file_name VARCHAR(255) NOT NULL, -- Name of the file as uploaded by the client
+    file_size BIGINT NOT NULL, -- Size of the whole file in bytes
+    chunk_size BIGINT NOT NULL, -- Size of every chunk in bytes, but the last one
+    file_path VARCHAR(100) NOT NULL, -- Path the chunks are assembled at in the file store
+    store_upload_id VARCHAR(255) NOT NULL, -- Id of the multipart upload in the file store
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp of creation
+    expires_at TIMESTAMP NOT NULL, -- Timestamp after which the session is dropped, with its chunks
+    FOREIGN KEY (client_id) REFERENCES client(id) -- Enforce client_id must exist in `client`
+);
+
+-- Index used to purge the expired sessions
+CREATE INDEX IF NOT EXISTS idx_upload_session_expires_at ON upload_session (expires_at);
+
+-- Create the `upload_session_chunk` table, with the chunks received by every session
+CREATE TABLE IF NOT EXISTS upload_session_chunk (
+    session_id VARCHAR(36) NOT NULL, -- Foreign key referencing the `upload_session` table
+    chunk_number INTEGER NOT NULL, -- Number of the chunk, starting at 1
+    size BIGINT NOT NULL, -- Size of the chunk in bytes
+    etag VARCHAR(255) NOT NULL, -- ETag of the part the chunk was stored as in the file store
+    PRIMARY KEY (session_id, chunk_number),
+    FOREIGN KEY (session_id) REFERENCES upload_session(id) ON DELETE CASCADE -- Chunks go away with their session
+);
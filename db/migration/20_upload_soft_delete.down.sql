@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_upload_client_created_at;
+
+ALTER TABLE upload
+DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,6 @@
+-- Let clients delete their uploads, the row is kept for the jobs and reports referencing it
+ALTER TABLE upload
+ADD COLUMN deleted_at TIMESTAMP; -- Timestamp of deletion, NULL while the upload exists
+
+-- Index used to list the uploads of the client, newest first
+CREATE INDEX IF NOT EXISTS idx_upload_client_created_at ON upload (client_id, created_at DESC, id DESC) WHERE deleted_at IS NULL;
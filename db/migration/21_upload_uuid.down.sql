@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_upload_uuid;
+
+ALTER TABLE upload
+DROP COLUMN IF EXISTS uuid;
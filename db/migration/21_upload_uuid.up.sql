@@ -0,0 +1,12 @@
+-- Keep the id clients know every upload by in a column of its own, so uploads are looked up by it exactly
+ALTER TABLE upload
+ADD COLUMN uuid VARCHAR(36); -- Id of the upload, the name of its file without the extension
+
+-- File paths look like clientID/uuid.extension
+UPDATE upload SET uuid = regexp_replace(file_path, '^.*/|\.[^./]*$', '', 'g');
+
+ALTER TABLE upload
+ALTER COLUMN uuid SET NOT NULL;
+
+-- Index used to look the uploads up by their id
+CREATE UNIQUE INDEX IF NOT EXISTS idx_upload_uuid ON upload (uuid);
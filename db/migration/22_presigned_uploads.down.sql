@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_presigned_upload_expires_at;
+
+DROP TABLE IF EXISTS presigned_upload;
@@ -0,0 +1,13 @@
+-- Create the `presigned_upload` table, keeping the presigned uploads handed out until they expire,
+-- so only their client can confirm them and the files put for them are purged afterwards
+CREATE TABLE IF NOT EXISTS presigned_upload (
+    id VARCHAR(36) PRIMARY KEY, -- Id the upload will have, a uuid
+    client_id INTEGER NOT NULL, -- Foreign key referencing the `client` table
+    file_path VARCHAR(100) NOT NULL, -- Staging path the file is put at in the file store
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp of creation
+    expires_at TIMESTAMP NOT NULL, -- Timestamp after which the upload can't be confirmed, and its staged file is purged
+    FOREIGN KEY (client_id) REFERENCES client(id) -- Enforce client_id must exist in `client`
+);
+
+-- Index used to purge the expired presigned uploads
+CREATE INDEX IF NOT EXISTS idx_presigned_upload_expires_at ON presigned_upload (expires_at);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_upload_dedup;
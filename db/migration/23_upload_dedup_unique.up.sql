@@ -0,0 +1,10 @@
+-- Uploads made at the same time could both be stored, the later duplicates are deleted so the index can be built
+UPDATE upload u SET deleted_at = NOW()
+WHERE u.deleted_at IS NULL AND EXISTS (
+    SELECT 1 FROM upload e
+    WHERE e.client_id = u.client_id AND e.type = u.type AND e.sha256 = u.sha256 AND e.file_name = u.file_name
+        AND e.deleted_at IS NULL AND e.id < u.id
+);
+
+-- Index keeping a single live upload of a file by a client, as the same type and under the same name
+CREATE UNIQUE INDEX IF NOT EXISTS idx_upload_dedup ON upload (client_id, type, sha256, file_name) WHERE deleted_at IS NULL;
@@ -0,0 +1,21 @@
+-- Move the refresh tokens back to the client, keeping the latest one issued to each
+ALTER TABLE client
+ADD COLUMN refresh_token VARCHAR(64),
+ADD COLUMN refresh_token_key_id INTEGER,
+ADD COLUMN refresh_token_expires_at TIMESTAMP;
+
+UPDATE client c
+SET refresh_token = k.refresh_token, refresh_token_key_id = k.id, refresh_token_expires_at = k.refresh_token_expires_at
+FROM (
+    SELECT DISTINCT ON (client_id) id, client_id, refresh_token, refresh_token_expires_at
+    FROM access_key
+    WHERE refresh_token IS NOT NULL
+    ORDER BY client_id, refresh_token_expires_at DESC
+) k
+WHERE k.client_id = c.id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_client_refresh_token ON client (refresh_token);
+
+DROP INDEX IF EXISTS idx_access_key_refresh_token;
+ALTER TABLE access_key
+DROP COLUMN refresh_token,
+DROP COLUMN refresh_token_expires_at;
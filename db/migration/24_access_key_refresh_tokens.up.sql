@@ -0,0 +1,17 @@
+-- Keep a refresh token per access key, so tokens issued for one key don't replace the ones issued for another
+ALTER TABLE access_key
+ADD COLUMN refresh_token VARCHAR(64),          -- Hex SHA-256 of the refresh token, NULL when none was issued
+ADD COLUMN refresh_token_expires_at TIMESTAMP; -- Timestamp after which the refresh token stops working
+CREATE UNIQUE INDEX IF NOT EXISTS idx_access_key_refresh_token ON access_key (refresh_token);
+
+-- Move the refresh token of every client to the key it was issued for
+UPDATE access_key k
+SET refresh_token = c.refresh_token, refresh_token_expires_at = c.refresh_token_expires_at
+FROM client c
+WHERE c.refresh_token IS NOT NULL AND k.id = c.refresh_token_key_id AND k.client_id = c.id;
+
+DROP INDEX IF EXISTS idx_client_refresh_token;
+ALTER TABLE client
+DROP COLUMN refresh_token,
+DROP COLUMN refresh_token_key_id,
+DROP COLUMN refresh_token_expires_at;
@@ -0,0 +1,2 @@
+ALTER TABLE upload_session
+DROP COLUMN upload_uuid;
@@ -0,0 +1,3 @@
+-- Keep completed upload sessions until they expire, with the upload they produced, so completing them again returns it
+ALTER TABLE upload_session
+ADD COLUMN upload_uuid VARCHAR(36); -- Uuid of the upload the session was completed as, NULL until it's completed
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_upload_dedup;
+
+ALTER TABLE upload
+DROP COLUMN dedup_name;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_upload_dedup ON upload (client_id, type, sha256, file_name) WHERE deleted_at IS NULL;
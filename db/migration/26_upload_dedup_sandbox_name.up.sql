@@ -0,0 +1,17 @@
+-- Only sandbox clients get another upload for the same file under another name, since their scenarios are picked by it.
+-- The file name is kept in the key of sandbox uploads and left empty for the others.
+ALTER TABLE upload
+ADD COLUMN dedup_name VARCHAR(255) NOT NULL DEFAULT ''; -- Name the upload is deduplicated under, empty but for sandbox clients
+
+-- Uploads made before keep their name in the key, but for the first live upload of every file by the other clients
+UPDATE upload SET dedup_name = COALESCE(file_name, '');
+UPDATE upload u SET dedup_name = ''
+FROM client c
+WHERE c.id = u.client_id AND NOT c.sandbox AND u.deleted_at IS NULL AND NOT EXISTS (
+    SELECT 1 FROM upload e
+    WHERE e.client_id = u.client_id AND e.type = u.type AND e.sha256 = u.sha256 AND e.deleted_at IS NULL AND e.id < u.id
+);
+
+-- Index keeping a single live upload of a file by a client as the same type, and under the same name for sandbox clients
+DROP INDEX IF EXISTS idx_upload_dedup;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_upload_dedup ON upload (client_id, type, sha256, dedup_name) WHERE deleted_at IS NULL;
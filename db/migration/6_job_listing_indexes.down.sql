@@ -0,0 +1,3 @@
+-- Remove the job listing indexes added in the up migration
+DROP INDEX IF EXISTS idx_face_match_client_created_at;
+DROP INDEX IF EXISTS idx_ocr_client_created_at;
@@ -0,0 +1,3 @@
+-- Add indexes to support listing a client's jobs ordered by creation time
+CREATE INDEX IF NOT EXISTS idx_face_match_client_created_at ON face_match (client_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_ocr_client_created_at ON ocr (client_id, created_at DESC, id DESC);
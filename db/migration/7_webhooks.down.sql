@@ -0,0 +1,16 @@
+-- Drop the webhook tables in reverse order of dependencies
+DROP TABLE IF EXISTS webhook_delivery;
+DROP TABLE IF EXISTS webhook;
+
+-- Drop the `DELIVERY_STATUS_TYPE` ENUM type if it exists
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_type WHERE typname = 'delivery_status_type') THEN
+        DROP TYPE DELIVERY_STATUS_TYPE;
+    END IF;
+END
+$$;
+
+-- Remove the webhook secret from the `client` table
+ALTER TABLE client
+DROP COLUMN IF EXISTS webhook_secret;
@@ -0,0 +1,43 @@
+-- Add a per-client secret used to sign webhook payloads
+ALTER TABLE client
+ADD COLUMN webhook_secret VARCHAR(64);                    -- Secret used to derive the HMAC-SHA256 signature of webhook payloads
+
+-- Create the `webhook` table to store the callback urls registered by clients
+CREATE TABLE IF NOT EXISTS webhook (
+    id SERIAL PRIMARY KEY,                                -- Primary key for the webhook
+    client_id INTEGER NOT NULL,                           -- Foreign key referencing the `client` table
+    url VARCHAR(500) NOT NULL,                            -- Url the job results are posted to
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,       -- Timestamp of creation
+    deleted_at TIMESTAMP,                                 -- Timestamp indicating when the webhook was removed
+    FOREIGN KEY (client_id) REFERENCES client(id)         -- Enforce client_id must exist in `client`
+);
+
+-- Create a new ENUM type `DELIVERY_STATUS_TYPE` if it does not already exist
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'delivery_status_type') THEN
+        CREATE TYPE DELIVERY_STATUS_TYPE AS ENUM ('pending', 'succeeded', 'failed');
+    END IF;
+END
+$$;
+
+-- Create the `webhook_delivery` table to log every delivery of a job result to a webhook
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+    id SERIAL PRIMARY KEY,                                -- Primary key for the delivery
+    webhook_id INTEGER NOT NULL,                          -- Foreign key referencing the `webhook` table
+    client_id INTEGER NOT NULL,                           -- Foreign key referencing the `client` table
+    job_type VARCHAR(20) NOT NULL,                        -- Type of the job (e.g., 'face_match', 'ocr')
+    job_id VARCHAR(100) NOT NULL,                         -- Identifier of the job whose result is delivered
+    status DELIVERY_STATUS_TYPE NOT NULL DEFAULT 'pending', -- Current status of the delivery
+    attempts INTEGER NOT NULL DEFAULT 0,                  -- Number of delivery attempts made so far
+    last_status_code INTEGER,                             -- Http status code returned on the last attempt
+    last_error VARCHAR(500),                              -- Error seen on the last attempt, if applicable
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,  -- Timestamp after which the delivery is attempted again
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,       -- Timestamp of creation
+    delivered_at TIMESTAMP,                               -- Timestamp indicating when the delivery succeeded
+    FOREIGN KEY (webhook_id) REFERENCES webhook(id),      -- Enforce webhook_id must exist in `webhook`
+    FOREIGN KEY (client_id) REFERENCES client(id)         -- Enforce client_id must exist in `client`
+);
+
+-- Index used by the dispatcher to pick up the deliveries which are due
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_due ON webhook_delivery (status, next_attempt_at);
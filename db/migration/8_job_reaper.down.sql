@@ -0,0 +1,11 @@
+-- Remove the reaper indexes and columns added in the up migration
+DROP INDEX IF EXISTS idx_face_match_status;
+DROP INDEX IF EXISTS idx_ocr_status;
+
+ALTER TABLE face_match
+DROP COLUMN IF EXISTS attempts,
+DROP COLUMN IF EXISTS enqueued_at;
+
+ALTER TABLE ocr
+DROP COLUMN IF EXISTS attempts,
+DROP COLUMN IF EXISTS enqueued_at;
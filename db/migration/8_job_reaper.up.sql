@@ -0,0 +1,12 @@
+-- Track how many times a job was put on the queue and when it last was, so stale jobs can be reaped
+ALTER TABLE face_match
+ADD COLUMN attempts INTEGER NOT NULL DEFAULT 1,               -- Number of times the job was put on the queue
+ADD COLUMN enqueued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;   -- Timestamp indicating when the job was last put on the queue
+
+ALTER TABLE ocr
+ADD COLUMN attempts INTEGER NOT NULL DEFAULT 1,               -- Number of times the job was put on the queue
+ADD COLUMN enqueued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;   -- Timestamp indicating when the job was last put on the queue
+
+-- Indexes used by the reaper to find the jobs stuck in 'created' or 'processing'
+CREATE INDEX IF NOT EXISTS idx_face_match_status ON face_match (status);
+CREATE INDEX IF NOT EXISTS idx_ocr_status ON ocr (status);
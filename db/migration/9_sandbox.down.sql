@@ -0,0 +1,11 @@
+-- Remove the sandbox columns added in the up migration
+ALTER TABLE upload
+DROP COLUMN IF EXISTS file_name;
+
+ALTER TABLE client
+DROP COLUMN IF EXISTS sandbox;
+
+-- Sandbox access keys don't fit anymore, truncating them leaves them unusable
+ALTER TABLE client
+ALTER COLUMN access_key TYPE VARCHAR(10)
+USING LEFT(access_key, 10);
@@ -0,0 +1,11 @@
+-- Add sandbox clients, whose jobs get deterministic results and are left out of the reports
+ALTER TABLE client
+ADD COLUMN sandbox BOOLEAN NOT NULL DEFAULT FALSE;        -- Whether the client was signed up for the sandbox
+
+-- Make room for the `test_` prefix of sandbox access keys
+ALTER TABLE client
+ALTER COLUMN access_key TYPE VARCHAR(20);
+
+-- Keep the original name of uploads, sandbox scenarios are picked from it
+ALTER TABLE upload
+ADD COLUMN file_name VARCHAR(255);                        -- Name of the file as uploaded by the client
@@ -3,6 +3,7 @@ module github.com/justsushant/one2n-go-bootcamp/go-ekyc
 go 1.23.2
 
 require (
+	github.com/docker/docker v27.4.0-rc.4+incompatible
 	github.com/gin-gonic/gin v1.10.0
 	github.com/golang-migrate/migrate/v4 v4.18.1
 	github.com/lib/pq v1.10.9
@@ -28,7 +29,6 @@ require (
 	github.com/creack/pty v1.1.21 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/distribution/reference v0.6.0 // indirect
-	github.com/docker/docker v27.4.0-rc.4+incompatible // indirect
 	github.com/docker/go-connections v0.5.0 // indirect
 	github.com/docker/go-units v0.5.0 // indirect
 	github.com/felixge/httpsnoop v1.0.4 // indirect
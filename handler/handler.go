@@ -2,13 +2,16 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"path/filepath"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/middleware"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 )
 
@@ -24,13 +27,40 @@ func NewHandler(service service.ServiceManager) Handler {
 
 func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
 	router.POST("/signup", h.SignupHandler)
+	router.POST("/token/refresh", h.TokenRefreshHandler)
 }
 
-func (h *Handler) RegisterProtectedRoutes(router *gin.RouterGroup) {
-	router.POST("/upload", h.FileUploadHandler)
-	router.POST("/face-match", h.FaceMatchHandler)
-	router.POST("/ocr", h.OCRHandler)
-	router.GET("/result/:jobType/:jobID", h.ResultHandler)
+// RegisterProtectedRoutes registers the routes behind the auth middleware, each allowed to the keys with its scope.
+// Keys and webhooks can only be managed by keys with all the scopes.
+// The routes creating uploads and jobs go through idempotent, so they're safe to retry.
+func (h *Handler) RegisterProtectedRoutes(router *gin.RouterGroup, idempotent gin.HandlerFunc) {
+	fullAccess := middleware.RequireScope(service.ALL_SCOPES...)
+
+	router.POST("/upload", middleware.RequireScope(service.SCOPE_UPLOAD), idempotent, h.FileUploadHandler)
+	router.POST("/upload/sessions", middleware.RequireScope(service.SCOPE_UPLOAD), h.UploadSessionCreateHandler)
+	router.GET("/upload/sessions/:sessionID", middleware.RequireScope(service.SCOPE_UPLOAD), h.UploadSessionGetHandler)
+	router.PUT("/upload/sessions/:sessionID/chunks/:chunkNumber", middleware.RequireScope(service.SCOPE_UPLOAD), h.UploadChunkHandler)
+	router.POST("/upload/sessions/:sessionID/complete", middleware.RequireScope(service.SCOPE_UPLOAD), idempotent, h.UploadSessionCompleteHandler)
+	router.DELETE("/upload/sessions/:sessionID", middleware.RequireScope(service.SCOPE_UPLOAD), h.UploadSessionAbortHandler)
+	router.POST("/upload/presigned", middleware.RequireScope(service.SCOPE_UPLOAD), h.PresignedUploadHandler)
+	router.POST("/upload/presigned/:uploadID/confirm", middleware.RequireScope(service.SCOPE_UPLOAD), idempotent, h.PresignedUploadConfirmHandler)
+	router.GET("/upload/:uploadID/download", middleware.RequireScope(service.SCOPE_UPLOAD), h.PresignedDownloadHandler)
+	router.GET("/uploads", middleware.RequireScope(service.SCOPE_UPLOAD), h.UploadListHandler)
+	router.GET("/uploads/:uploadID", middleware.RequireScope(service.SCOPE_UPLOAD), h.UploadGetHandler)
+	router.DELETE("/uploads/:uploadID", middleware.RequireScope(service.SCOPE_UPLOAD), h.UploadDeleteHandler)
+	router.POST("/face-match", middleware.RequireScope(service.SCOPE_FACE_MATCH), idempotent, h.FaceMatchHandler)
+	router.POST("/ocr", middleware.RequireScope(service.SCOPE_OCR), idempotent, h.OCRHandler)
+	router.GET("/result/:jobType/:jobID", middleware.RequireScope(service.SCOPE_RESULTS_READ), h.ResultHandler)
+	router.GET("/jobs", middleware.RequireScope(service.SCOPE_RESULTS_READ), h.JobListHandler)
+	router.POST("/keys", fullAccess, h.AccessKeyCreateHandler)
+	router.GET("/keys", fullAccess, h.AccessKeyListHandler)
+	router.DELETE("/keys/:keyID", fullAccess, h.AccessKeyRevokeHandler)
+	router.POST("/token", h.TokenHandler)
+	router.POST("/webhooks", fullAccess, h.WebhookRegisterHandler)
+	router.GET("/webhooks", fullAccess, h.WebhookListHandler)
+	router.DELETE("/webhooks/:webhookID", fullAccess, h.WebhookDeleteHandler)
+	router.GET("/webhooks/deliveries", fullAccess, h.WebhookDeliveryListHandler)
+	router.POST("/webhooks/deliveries/:deliveryID/redeliver", fullAccess, h.WebhookRedeliverHandler)
 }
 
 // @Summary Signup
@@ -41,6 +71,7 @@ func (h *Handler) RegisterProtectedRoutes(router *gin.RouterGroup) {
 // @Param name body string true "Name of client"
 // @Param email body string true "Email of client"
 // @Param plan body string true "Name of plan"
+// @Param sandbox body bool false "Sign up for the sandbox, with test_ keys and deterministic results"
 // @Success 200 {object} types.SignupResponse "Access & secret keys"
 // @Failure 400 {object} types.ErrorResponse "invalid email"
 // @Failure 400 {object} types.ErrorResponse "invalid plan, supported plans are basic, advanced, or enterprise"
@@ -78,13 +109,6 @@ func (h *Handler) FileUploadHandler(c *gin.Context) {
 		return
 	}
 
-	// applying validations on file
-	err = h.service.ValidateFile(fileHeader.Filename, fileType)
-	if err != nil {
-		c.JSON(400, gin.H{"errorMessage": err.Error()})
-		return
-	}
-
 	// fetching client_id from request scoped variables
 	clientID, ok := c.Get("client_id")
 	if !ok {
@@ -93,23 +117,348 @@ func (h *Handler) FileUploadHandler(c *gin.Context) {
 
 	objectName := uuid.NewString()
 	uploadMetaData := &types.UploadMetaData{
+		UUID:       objectName,
 		Type:       fileType,
 		ClientID:   clientID.(int),
 		FilePath:   strconv.Itoa(clientID.(int)) + "/" + objectName + filepath.Ext(fileHeader.Filename), // filepath is saved like, clientID/uuid.extension
 		FileSizeKB: fileHeader.Size / 1000,
+		FileName:   fileHeader.Filename,
+
+		// the metadata of the image is stripped unless the client asked to keep it
+		KeepOriginal: c.GetBool("keep_original_uploads"),
 	}
 
-	// save the file to bucket and psql
-	err = h.service.SaveFile(fileHeader, uploadMetaData)
+	// applying validations on file, the image found in it is kept on the metadata
+	err = h.service.ValidateFile(fileHeader, uploadMetaData, c.GetInt("plan_id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrFileTooLarge):
+			c.JSON(413, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, service.ErrInvalidFileType),
+			errors.Is(err, service.ErrInvalidFileFormat),
+			errors.Is(err, service.ErrContentMismatch),
+			errors.Is(err, service.ErrCorruptImage),
+			errors.Is(err, service.ErrInvalidImageSize):
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+		default:
+			c.JSON(500, gin.H{"errorMessage": err.Error()})
+		}
+		return
+	}
+
+	// save the file to bucket and psql, the id of an earlier upload of the same file is returned instead
+	resp, err := h.service.SaveFile(fileHeader, uploadMetaData)
 	if err != nil {
 		c.JSON(500, gin.H{"errorMessage": err.Error()})
 		return
 	}
 
-	// replace this with proper uuid
-	c.JSON(200, gin.H{
-		"id": objectName,
-	})
+	c.JSON(200, resp)
+}
+
+func (h *Handler) UploadSessionCreateHandler(c *gin.Context) {
+	var payload types.UploadSessionPayload
+	err := json.NewDecoder(c.Request.Body).Decode(&payload)
+	if err != nil {
+		c.JSON(400, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	session, err := h.service.CreateUploadSession(clientID.(int), c.GetInt("plan_id"), payload)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrFileTooLarge):
+			c.JSON(413, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, service.ErrInvalidFileType),
+			errors.Is(err, service.ErrInvalidFileFormat),
+			errors.Is(err, service.ErrInvalidFileName),
+			errors.Is(err, service.ErrInvalidFileSize):
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+		default:
+			log.Println("Error while creating upload session: ", err)
+			c.JSON(500, gin.H{"errorMessage": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, session)
+}
+
+func (h *Handler) UploadSessionGetHandler(c *gin.Context) {
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	session, err := h.service.GetUploadSession(clientID.(int), c.Param("sessionID"))
+	if err != nil {
+		if errors.Is(err, service.ErrUploadSessionNotFound) {
+			c.JSON(404, gin.H{"errorMessage": err.Error()})
+			return
+		}
+		log.Println("Error while fetching upload session: ", err)
+		c.JSON(500, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	c.JSON(200, session)
+}
+
+// UploadChunkHandler takes the bytes of the chunk as the request body
+func (h *Handler) UploadChunkHandler(c *gin.Context) {
+	chunkNumber, err := strconv.Atoi(c.Param("chunkNumber"))
+	if err != nil {
+		c.JSON(400, gin.H{"errorMessage": service.ErrInvalidChunkNumber.Error()})
+		return
+	}
+
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	chunk, err := h.service.UploadChunk(clientID.(int), c.Param("sessionID"), chunkNumber, c.Request.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUploadSessionNotFound):
+			c.JSON(404, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, service.ErrUploadSessionCompleted):
+			c.JSON(409, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, service.ErrInvalidChunkNumber),
+			errors.Is(err, service.ErrInvalidChunkSize):
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+		default:
+			log.Println("Error while uploading chunk: ", err)
+			c.JSON(500, gin.H{"errorMessage": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, chunk)
+}
+
+// UploadSessionCompleteHandler responds like FileUploadHandler, with the id of the upload
+func (h *Handler) UploadSessionCompleteHandler(c *gin.Context) {
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	resp, err := h.service.CompleteUploadSession(clientID.(int), c.GetInt("plan_id"), c.Param("sessionID"), c.GetBool("keep_original_uploads"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUploadSessionNotFound):
+			c.JSON(404, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, service.ErrUploadIncomplete):
+			c.JSON(409, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, service.ErrFileTooLarge):
+			c.JSON(413, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, service.ErrContentMismatch),
+			errors.Is(err, service.ErrCorruptImage),
+			errors.Is(err, service.ErrInvalidImageSize):
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+		default:
+			log.Println("Error while completing upload session: ", err)
+			c.JSON(500, gin.H{"errorMessage": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, resp)
+}
+
+func (h *Handler) UploadSessionAbortHandler(c *gin.Context) {
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	err := h.service.AbortUploadSession(clientID.(int), c.Param("sessionID"))
+	if err != nil {
+		if errors.Is(err, service.ErrUploadSessionNotFound) {
+			c.JSON(404, gin.H{"errorMessage": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrUploadSessionCompleted) {
+			c.JSON(409, gin.H{"errorMessage": err.Error()})
+			return
+		}
+		log.Println("Error while aborting upload session: ", err)
+		c.JSON(500, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "upload session aborted"})
+}
+
+func (h *Handler) PresignedUploadHandler(c *gin.Context) {
+	var payload types.PresignedUploadPayload
+	err := json.NewDecoder(c.Request.Body).Decode(&payload)
+	if err != nil {
+		c.JSON(400, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	resp, err := h.service.PresignUpload(clientID.(int), payload)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidFileType),
+			errors.Is(err, service.ErrInvalidFileFormat),
+			errors.Is(err, service.ErrInvalidFileName):
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, store.ErrPresignNotSupported):
+			c.JSON(501, gin.H{"errorMessage": err.Error()})
+		default:
+			log.Println("Error while presigning upload: ", err)
+			c.JSON(500, gin.H{"errorMessage": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, resp)
+}
+
+// PresignedUploadConfirmHandler takes the same body as PresignedUploadHandler, and responds like FileUploadHandler
+func (h *Handler) PresignedUploadConfirmHandler(c *gin.Context) {
+	var payload types.PresignedUploadPayload
+	err := json.NewDecoder(c.Request.Body).Decode(&payload)
+	if err != nil {
+		c.JSON(400, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	resp, err := h.service.ConfirmPresignedUpload(clientID.(int), c.GetInt("plan_id"), c.Param("uploadID"), payload, c.GetBool("keep_original_uploads"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrPresignedUploadNotFound):
+			c.JSON(404, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, service.ErrFileTooLarge):
+			c.JSON(413, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, service.ErrInvalidFileType),
+			errors.Is(err, service.ErrInvalidFileFormat),
+			errors.Is(err, service.ErrInvalidFileName),
+			errors.Is(err, service.ErrContentMismatch),
+			errors.Is(err, service.ErrCorruptImage),
+			errors.Is(err, service.ErrInvalidImageSize):
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+		default:
+			log.Println("Error while confirming presigned upload: ", err)
+			c.JSON(500, gin.H{"errorMessage": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, resp)
+}
+
+func (h *Handler) PresignedDownloadHandler(c *gin.Context) {
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	resp, err := h.service.PresignDownload(clientID.(int), c.Param("uploadID"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUploadNotFound):
+			c.JSON(404, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, store.ErrPresignNotSupported):
+			c.JSON(501, gin.H{"errorMessage": err.Error()})
+		default:
+			log.Println("Error while presigning download: ", err)
+			c.JSON(500, gin.H{"errorMessage": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, resp)
+}
+
+func (h *Handler) UploadListHandler(c *gin.Context) {
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	query := types.UploadListQuery{
+		Type:   c.Query("type"),
+		Cursor: c.Query("cursor"),
+		Limit:  c.Query("limit"),
+	}
+
+	resp, err := h.service.ListUploads(clientID.(int), query)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidFileType),
+			errors.Is(err, service.ErrInvalidCursor),
+			errors.Is(err, service.ErrInvalidLimit):
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+		default:
+			log.Println("Error while listing uploads: ", err)
+			c.JSON(500, gin.H{"errorMessage": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, resp)
+}
+
+func (h *Handler) UploadGetHandler(c *gin.Context) {
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	resp, err := h.service.GetUpload(clientID.(int), c.Param("uploadID"))
+	if err != nil {
+		if errors.Is(err, service.ErrUploadNotFound) {
+			c.JSON(404, gin.H{"errorMessage": err.Error()})
+			return
+		}
+		log.Println("Error while fetching upload: ", err)
+		c.JSON(500, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	c.JSON(200, resp)
+}
+
+func (h *Handler) UploadDeleteHandler(c *gin.Context) {
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	err := h.service.DeleteUpload(clientID.(int), c.Param("uploadID"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUploadNotFound):
+			c.JSON(404, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, store.ErrUploadInUse):
+			c.JSON(409, gin.H{"errorMessage": err.Error()})
+		default:
+			log.Println("Error while deleting upload: ", err)
+			c.JSON(500, gin.H{"errorMessage": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "upload deleted"})
 }
 
 func (h *Handler) FaceMatchHandler(c *gin.Context) {
@@ -126,8 +475,15 @@ func (h *Handler) FaceMatchHandler(c *gin.Context) {
 		// TODO: what to do when ok is false, or clientID is nil
 	}
 
-	// fetch data from cache
-	jobID, ok := h.service.FetchDataFromCache(payload, clientID.(int), types.FACE_MATCH_WORK_TYPE)
+	// jobs of sandbox clients get deterministic results
+	payload.Sandbox = c.GetBool("sandbox")
+
+	// fetch data from cache, requests with an idempotency key are only deduped by their key
+	idempotent := len(c.GetHeader(middleware.IDEMPOTENCY_KEY_HEADER)) != 0
+	jobID, ok := "", false
+	if !idempotent {
+		jobID, ok = h.service.FetchDataFromCache(payload, clientID.(int), types.FACE_MATCH_WORK_TYPE)
+	}
 	if ok {
 		c.JSON(200, gin.H{
 			"id": jobID,
@@ -142,7 +498,9 @@ func (h *Handler) FaceMatchHandler(c *gin.Context) {
 	}
 
 	// set data in cache
-	h.service.SetDataInCache(payload, clientID.(int), types.FACE_MATCH_WORK_TYPE, jobID)
+	if !idempotent {
+		h.service.SetDataInCache(payload, clientID.(int), types.FACE_MATCH_WORK_TYPE, jobID)
+	}
 
 	c.JSON(200, gin.H{
 		"id": jobID,
@@ -162,8 +520,15 @@ func (h *Handler) OCRHandler(c *gin.Context) {
 		// TODO: what to do when ok is false, or clientID is nil
 	}
 
-	// fetch data from cache
-	jobID, ok := h.service.FetchDataFromCache(payload, clientID.(int), types.OCR_WORK_TYPE)
+	// jobs of sandbox clients get deterministic results
+	payload.Sandbox = c.GetBool("sandbox")
+
+	// fetch data from cache, requests with an idempotency key are only deduped by their key
+	idempotent := len(c.GetHeader(middleware.IDEMPOTENCY_KEY_HEADER)) != 0
+	jobID, ok := "", false
+	if !idempotent {
+		jobID, ok = h.service.FetchDataFromCache(payload, clientID.(int), types.OCR_WORK_TYPE)
+	}
 	if ok {
 		c.JSON(200, gin.H{
 			"id": jobID,
@@ -178,7 +543,9 @@ func (h *Handler) OCRHandler(c *gin.Context) {
 	}
 
 	// set data in cache
-	h.service.SetDataInCache(payload, clientID.(int), types.OCR_WORK_TYPE, jobID)
+	if !idempotent {
+		h.service.SetDataInCache(payload, clientID.(int), types.OCR_WORK_TYPE, jobID)
+	}
 
 	c.JSON(200, gin.H{
 		"id": jobID,
@@ -259,3 +626,288 @@ func (h *Handler) ResultHandler(c *gin.Context) {
 		"errorMessage": "Unexpected server error occurred",
 	})
 }
+
+func (h *Handler) JobListHandler(c *gin.Context) {
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	query := types.JobListQuery{
+		Type:   c.Query("type"),
+		Status: c.Query("status"),
+		From:   c.Query("from"),
+		To:     c.Query("to"),
+		Cursor: c.Query("cursor"),
+		Limit:  c.Query("limit"),
+	}
+
+	resp, err := h.service.ListJobs(clientID.(int), query)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidJobType),
+			errors.Is(err, service.ErrInvalidJobStatus),
+			errors.Is(err, service.ErrInvalidTimeRange),
+			errors.Is(err, service.ErrInvalidCursor),
+			errors.Is(err, service.ErrInvalidLimit):
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+		default:
+			log.Println("Error while listing jobs: ", err)
+			c.JSON(500, gin.H{"errorMessage": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, resp)
+}
+
+func (h *Handler) AccessKeyCreateHandler(c *gin.Context) {
+	var payload types.AccessKeyPayload
+	err := json.NewDecoder(c.Request.Body).Decode(&payload)
+	if err != nil {
+		c.JSON(400, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	// keys of sandbox clients are sandbox keys too
+	payload.Sandbox = c.GetBool("sandbox")
+	payload.HeldScopes = c.GetStringSlice("scopes")
+
+	resp, err := h.service.CreateAccessKey(clientID.(int), payload)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidKeyLabel),
+			errors.Is(err, service.ErrInvalidKeyExpiry),
+			errors.Is(err, service.ErrInvalidAuthScheme),
+			errors.Is(err, service.ErrSigningDisabled),
+			errors.Is(err, service.ErrInvalidScope),
+			errors.Is(err, service.ErrScopeNotHeld):
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+		default:
+			log.Println("Error while creating access key: ", err)
+			c.JSON(500, gin.H{"errorMessage": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, resp)
+}
+
+func (h *Handler) AccessKeyListHandler(c *gin.Context) {
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	keys, err := h.service.ListAccessKeys(clientID.(int))
+	if err != nil {
+		log.Println("Error while listing access keys: ", err)
+		c.JSON(500, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	c.JSON(200, types.AccessKeyListResponse{Keys: keys})
+}
+
+func (h *Handler) AccessKeyRevokeHandler(c *gin.Context) {
+	keyID, err := strconv.Atoi(c.Param("keyID"))
+	if err != nil {
+		c.JSON(400, gin.H{"errorMessage": service.ErrAccessKeyNotFound.Error()})
+		return
+	}
+
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	err = h.service.RevokeAccessKey(clientID.(int), keyID)
+	if err != nil {
+		if errors.Is(err, service.ErrAccessKeyNotFound) {
+			c.JSON(404, gin.H{"errorMessage": err.Error()})
+			return
+		}
+		log.Println("Error while revoking access key: ", err)
+		c.JSON(500, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "access key revoked"})
+}
+
+// TokenHandler issues an access token for the key the request was authenticated with, along with a refresh token
+func (h *Handler) TokenHandler(c *gin.Context) {
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	// a token could otherwise be kept alive forever without the key
+	if c.GetBool("token") {
+		c.JSON(400, gin.H{"errorMessage": service.ErrTokenForToken.Error()})
+		return
+	}
+
+	resp, err := h.service.IssueToken(&types.ClientData{
+		Id:      clientID.(int),
+		PlanID:  c.GetInt("plan_id"),
+		KeyID:   c.GetInt("key_id"),
+		Sandbox: c.GetBool("sandbox"),
+		Scopes:  c.GetStringSlice("scopes"),
+
+		KeepOriginalUploads: c.GetBool("keep_original_uploads"),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrTokensDisabled) {
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+			return
+		}
+		log.Println("Error while issuing token: ", err)
+		c.JSON(500, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	c.JSON(200, resp)
+}
+
+// TokenRefreshHandler swaps a refresh token for a new access and refresh token
+func (h *Handler) TokenRefreshHandler(c *gin.Context) {
+	var payload types.RefreshTokenPayload
+	err := json.NewDecoder(c.Request.Body).Decode(&payload)
+	if err != nil {
+		c.JSON(400, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	resp, err := h.service.RefreshToken(payload)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRefresh):
+			c.JSON(401, gin.H{"errorMessage": err.Error()})
+		case errors.Is(err, service.ErrTokensDisabled):
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+		default:
+			log.Println("Error while refreshing token: ", err)
+			c.JSON(500, gin.H{"errorMessage": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, resp)
+}
+
+func (h *Handler) WebhookRegisterHandler(c *gin.Context) {
+	var payload types.WebhookPayload
+	err := json.NewDecoder(c.Request.Body).Decode(&payload)
+	if err != nil {
+		c.JSON(400, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	resp, err := h.service.RegisterWebhook(clientID.(int), payload)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidWebhookURL) || errors.Is(err, service.ErrWebhookHost) {
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+			return
+		}
+		log.Println("Error while registering webhook: ", err)
+		c.JSON(500, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	c.JSON(200, resp)
+}
+
+func (h *Handler) WebhookListHandler(c *gin.Context) {
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	webhooks, err := h.service.ListWebhooks(clientID.(int))
+	if err != nil {
+		log.Println("Error while listing webhooks: ", err)
+		c.JSON(500, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	c.JSON(200, types.WebhookListResponse{Webhooks: webhooks})
+}
+
+func (h *Handler) WebhookDeleteHandler(c *gin.Context) {
+	webhookID, err := strconv.Atoi(c.Param("webhookID"))
+	if err != nil {
+		c.JSON(400, gin.H{"errorMessage": service.ErrWebhookNotFound.Error()})
+		return
+	}
+
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	err = h.service.DeleteWebhook(clientID.(int), webhookID)
+	if err != nil {
+		if errors.Is(err, service.ErrWebhookNotFound) {
+			c.JSON(404, gin.H{"errorMessage": err.Error()})
+			return
+		}
+		log.Println("Error while deleting webhook: ", err)
+		c.JSON(500, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "webhook deleted"})
+}
+
+func (h *Handler) WebhookDeliveryListHandler(c *gin.Context) {
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	deliveries, err := h.service.ListWebhookDeliveries(clientID.(int))
+	if err != nil {
+		log.Println("Error while listing webhook deliveries: ", err)
+		c.JSON(500, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	c.JSON(200, types.WebhookDeliveryListResponse{Deliveries: deliveries})
+}
+
+func (h *Handler) WebhookRedeliverHandler(c *gin.Context) {
+	deliveryID, err := strconv.Atoi(c.Param("deliveryID"))
+	if err != nil {
+		c.JSON(400, gin.H{"errorMessage": service.ErrDeliveryNotFound.Error()})
+		return
+	}
+
+	clientID, ok := c.Get("client_id")
+	if !ok {
+		// TODO: what to do when ok is false, or clientID is nil
+	}
+
+	err = h.service.RedeliverWebhook(clientID.(int), deliveryID)
+	if err != nil {
+		if errors.Is(err, service.ErrDeliveryNotFound) {
+			c.JSON(404, gin.H{"errorMessage": err.Error()})
+			return
+		}
+		log.Println("Error while redelivering webhook: ", err)
+		c.JSON(500, gin.H{"errorMessage": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "delivery scheduled"})
+}
@@ -4,16 +4,25 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/middleware"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // mock of client service for usage in tests
@@ -29,21 +38,31 @@ func (m mockService) SignupClient(payload types.SignupPayload) (*service.KeyPair
 	}
 }
 
-func (m mockService) ValidateFile(fileName, fileType string) error {
-	if fileType != "face" && fileType != "id_card" {
+func (m mockService) ValidateFile(fileHeader *multipart.FileHeader, uploadMetaData *types.UploadMetaData, planID int) error {
+	if uploadMetaData.Type != "face" && uploadMetaData.Type != "id_card" {
 		return service.ErrInvalidFileType
 	}
 
-	ext := filepath.Ext(fileName)
-	if ext != types.VALID_FORMAT_PNG && ext != types.VALID_FORMAT_JPEG {
+	ext := filepath.Ext(fileHeader.Filename)
+	if ext != types.VALID_FORMAT_PNG && ext != types.VALID_FORMAT_JPEG && ext != types.VALID_FORMAT_JPG {
 		return service.ErrInvalidFileFormat
 	}
 
+	switch fileHeader.Filename {
+	case "large.png":
+		return fmt.Errorf("%w, which is 5242 KB", service.ErrFileTooLarge)
+	case "text.png":
+		return service.ErrContentMismatch
+	}
+
 	return nil
 }
 
-func (m mockService) SaveFile(fileHeader *multipart.FileHeader, uploadMetaData *types.UploadMetaData) error {
-	return nil
+func (m mockService) SaveFile(fileHeader *multipart.FileHeader, uploadMetaData *types.UploadMetaData) (*types.FileUploadResponse, error) {
+	if fileHeader.Filename == "duplicate.png" {
+		return &types.FileUploadResponse{Id: "uuid-existing", Deduplicated: true}, nil
+	}
+	return &types.FileUploadResponse{Id: "uuid-new"}, nil
 }
 
 func (m mockService) PerformFaceMatch(payload types.FaceMatchPayload, clientID int) (string, error) {
@@ -69,6 +88,9 @@ func (m mockService) PerformOCR(payload types.OCRPayload, clientID int) (string,
 	return "uuid-ok", nil
 }
 func (m mockService) FetchDataFromCache(payload interface{}, clientID int, jobType string) (string, bool) {
+	if faceMatch, ok := payload.(types.FaceMatchPayload); ok && faceMatch.Image1 == "cached-valid" {
+		return "uuid-cached", true
+	}
 	return "", false
 }
 func (m mockService) SetDataInCache(payload interface{}, clientID int, jobType, jobID string) {}
@@ -139,6 +161,132 @@ func (m mockService) GetJobDetailsByJobID(jobID, jobType string) (*types.JobReco
 	return nil, nil
 }
 
+func (m mockService) ListJobs(clientID int, query types.JobListQuery) (*types.JobListResponse, error) {
+	if query.Type == "invalid" {
+		return nil, service.ErrInvalidJobType
+	}
+
+	return &types.JobListResponse{
+		Jobs: []*types.JobRecord{
+			{
+				Type:      types.OCR_WORK_TYPE,
+				ID:        1,
+				ClientID:  clientID,
+				CreatedAt: "timestamp",
+				JobID:     "jobID1",
+				Status:    types.JOB_STATUS_CREATED,
+			},
+		},
+		NextCursor: "next",
+	}, nil
+}
+
+func (m mockService) CreateAccessKey(clientID int, payload types.AccessKeyPayload) (*types.AccessKeyResponse, error) {
+	if payload.ExpiresInDays < 0 {
+		return nil, service.ErrInvalidKeyExpiry
+	}
+	if payload.AuthScheme != "" && payload.AuthScheme != service.AUTH_SCHEME_HEADER && payload.AuthScheme != service.AUTH_SCHEME_SIGNATURE {
+		return nil, service.ErrInvalidAuthScheme
+	}
+	authScheme := payload.AuthScheme
+	if authScheme == "" {
+		authScheme = service.AUTH_SCHEME_HEADER
+	}
+	scopes := payload.Scopes
+	if len(scopes) == 0 {
+		scopes = payload.HeldScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(payload.HeldScopes, scope) {
+			return nil, service.ErrScopeNotHeld
+		}
+	}
+
+	accessKey := "newAccess1"
+	if payload.Sandbox {
+		accessKey = "test_" + accessKey
+	}
+	return &types.AccessKeyResponse{
+		ID:         2,
+		AccessKey:  accessKey,
+		SecretKey:  "newSecret",
+		Label:      payload.Label,
+		AuthScheme: authScheme,
+		Scopes:     scopes,
+		CreatedAt:  "timestamp",
+		ExpiresAt:  "NULL",
+	}, nil
+}
+
+func (m mockService) ListAccessKeys(clientID int) ([]*types.AccessKey, error) {
+	return []*types.AccessKey{}, nil
+}
+
+func (m mockService) RevokeAccessKey(clientID, keyID int) error {
+	if keyID != 1 {
+		return service.ErrAccessKeyNotFound
+	}
+	return nil
+}
+
+func (m mockService) IssueToken(client *types.ClientData) (*types.TokenResponse, error) {
+	return &types.TokenResponse{
+		AccessToken:      fmt.Sprintf("token%d.%d.%s", client.Id, client.KeyID, strings.Join(client.Scopes, ",")),
+		TokenType:        "Bearer",
+		ExpiresIn:        900,
+		RefreshToken:     "rt_new",
+		RefreshExpiresIn: 2592000,
+	}, nil
+}
+
+func (m mockService) RefreshToken(payload types.RefreshTokenPayload) (*types.TokenResponse, error) {
+	if payload.RefreshToken != "rt_valid" {
+		return nil, service.ErrInvalidRefresh
+	}
+	return &types.TokenResponse{
+		AccessToken:      "token1.1",
+		TokenType:        "Bearer",
+		ExpiresIn:        900,
+		RefreshToken:     "rt_new",
+		RefreshExpiresIn: 2592000,
+	}, nil
+}
+
+func (m mockService) RegisterWebhook(clientID int, payload types.WebhookPayload) (*types.WebhookResponse, error) {
+	if payload.URL == "invalid" {
+		return nil, service.ErrInvalidWebhookURL
+	}
+
+	return &types.WebhookResponse{
+		ID:        1,
+		URL:       payload.URL,
+		CreatedAt: "timestamp",
+		Secret:    "whsec_secret",
+	}, nil
+}
+
+func (m mockService) ListWebhooks(clientID int) ([]*types.Webhook, error) {
+	return []*types.Webhook{}, nil
+}
+
+func (m mockService) DeleteWebhook(clientID, webhookID int) error {
+	if webhookID != 1 {
+		return service.ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (m mockService) ListWebhookDeliveries(clientID int) ([]*types.WebhookDelivery, error) {
+	return []*types.WebhookDelivery{}, nil
+}
+
+func (m mockService) RedeliverWebhook(clientID, deliveryID int) error {
+	if deliveryID != 1 {
+		return service.ErrDeliveryNotFound
+	}
+	return nil
+}
+
 func TestSignupHandler(t *testing.T) {
 	tt := []struct {
 		name          string
@@ -267,6 +415,38 @@ func TestFileUploadHandler(t *testing.T) {
 			expStatusCode: http.StatusBadRequest,
 			expResponse:   `{"errorMessage": "invalid file format, supported formats are png or jpeg"}`,
 		},
+		{
+			name:          "file larger than the plan allows case",
+			fileName:      "large.png",
+			fileType:      "face",
+			content:       "Hello, world!",
+			expStatusCode: http.StatusRequestEntityTooLarge,
+			expResponse:   `{"errorMessage": "file is larger than the upload size limit of the plan, which is 5242 KB"}`,
+		},
+		{
+			name:          "content not matching the extension case",
+			fileName:      "text.png",
+			fileType:      "face",
+			content:       "Hello, world!",
+			expStatusCode: http.StatusBadRequest,
+			expResponse:   `{"errorMessage": "file content doesn't match its extension, png files must hold a png image and jpg or jpeg files a jpeg one"}`,
+		},
+		{
+			name:          "valid case",
+			fileName:      "face.png",
+			fileType:      "face",
+			content:       "Hello, world!",
+			expStatusCode: http.StatusOK,
+			expResponse:   `{"id": "uuid-new", "deduplicated": false}`,
+		},
+		{
+			name:          "file uploaded before case",
+			fileName:      "duplicate.png",
+			fileType:      "face",
+			content:       "Hello, world!",
+			expStatusCode: http.StatusOK,
+			expResponse:   `{"id": "uuid-existing", "deduplicated": true}`,
+		},
 	}
 
 	for _, tc := range tt {
@@ -292,6 +472,7 @@ func TestFileUploadHandler(t *testing.T) {
 			c, _ := gin.CreateTestContext(w)
 			c.Request = httptest.NewRequest("POST", "/image", &buf)
 			c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+			c.Set("client_id", 1)
 
 			// calling the file upload handler
 			handler := NewHandler(&mockService{})
@@ -306,10 +487,11 @@ func TestFileUploadHandler(t *testing.T) {
 
 func TestFaceMatchHandler(t *testing.T) {
 	tt := []struct {
-		name          string
-		payload       types.FaceMatchPayload
-		expStatusCode int
-		expResponse   string
+		name           string
+		payload        types.FaceMatchPayload
+		idempotencyKey string
+		expStatusCode  int
+		expResponse    string
 	}{
 		{
 			name: "invalid img id case",
@@ -338,6 +520,25 @@ func TestFaceMatchHandler(t *testing.T) {
 			expStatusCode: http.StatusOK,
 			expResponse:   `{"id":"uuid-ok"}`,
 		},
+		{
+			name: "cached face match case",
+			payload: types.FaceMatchPayload{
+				Image1: "cached-valid",
+				Image2: "qwerty-valid",
+			},
+			expStatusCode: http.StatusOK,
+			expResponse:   `{"id":"uuid-cached"}`,
+		},
+		{
+			name: "idempotency key skips the cache",
+			payload: types.FaceMatchPayload{
+				Image1: "cached-valid",
+				Image2: "qwerty-valid",
+			},
+			idempotencyKey: "key1",
+			expStatusCode:  http.StatusOK,
+			expResponse:    `{"id":"uuid-ok"}`,
+		},
 	}
 
 	for _, tc := range tt {
@@ -353,6 +554,9 @@ func TestFaceMatchHandler(t *testing.T) {
 			c, _ := gin.CreateTestContext(w)
 			c.Request = httptest.NewRequest("POST", "/face-match-async", bytes.NewBuffer([]byte(body)))
 			c.Request.Header.Set("Content-Type", "application/json")
+			if tc.idempotencyKey != "" {
+				c.Request.Header.Set("Idempotency-Key", tc.idempotencyKey)
+			}
 			c.Set("client_id", 4)
 
 			// calling the signup handler
@@ -508,3 +712,934 @@ func TestResultHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestJobListHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		query         string
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "invalid job type",
+			query:         "?type=invalid",
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "invalid job type, supported types are face_match or ocr"}`,
+		},
+		{
+			name:          "valid case",
+			query:         "?type=ocr&limit=1",
+			expStatusCode: 200,
+			expResponse: `{
+				"jobs": [{
+					"job_type": "ocr", "id": 1, "client_id": 1, "created_at": "timestamp", "job_id": "jobID1", "status": "created",
+					"completed_at": "", "processed_at": "", "failed_at": "", "failed_reason": "", "match_score": 0, "details": null
+				}],
+				"next_cursor": "next"
+			}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/jobs"+tc.query, nil)
+			c.Set("client_id", 1)
+
+			// calling the job list handler
+			handler := NewHandler(&mockService{})
+			handler.JobListHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+func TestAccessKeyCreateHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		payload       types.AccessKeyPayload
+		sandbox       bool
+		scopes        []string
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "invalid expiry",
+			payload:       types.AccessKeyPayload{ExpiresInDays: -1},
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "invalid expiry, expires_in_days must be between 0 and 3650"}`,
+		},
+		{
+			name:          "invalid auth scheme",
+			payload:       types.AccessKeyPayload{AuthScheme: "basic"},
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "invalid auth scheme, supported schemes are header or signature"}`,
+		},
+		{
+			name:          "scope not held by the key",
+			payload:       types.AccessKeyPayload{Scopes: []string{"ocr"}},
+			scopes:        []string{"upload"},
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "a key can't be given scopes the key creating it doesn't have"}`,
+		},
+		{
+			name:          "valid case",
+			payload:       types.AccessKeyPayload{Label: "ci"},
+			scopes:        []string{"upload", "ocr"},
+			expStatusCode: 200,
+			expResponse:   `{"id": 2, "accessKey": "newAccess1", "secretKey": "newSecret", "label": "ci", "auth_scheme": "header", "scopes": ["upload", "ocr"], "created_at": "timestamp", "expires_at": "NULL"}`,
+		},
+		{
+			name:          "key with some of the scopes",
+			payload:       types.AccessKeyPayload{Label: "ci", Scopes: []string{"ocr"}},
+			scopes:        []string{"upload", "ocr"},
+			expStatusCode: 200,
+			expResponse:   `{"id": 2, "accessKey": "newAccess1", "secretKey": "newSecret", "label": "ci", "auth_scheme": "header", "scopes": ["ocr"], "created_at": "timestamp", "expires_at": "NULL"}`,
+		},
+		{
+			name:          "key signing its requests",
+			payload:       types.AccessKeyPayload{Label: "ci", AuthScheme: "signature"},
+			scopes:        []string{"upload"},
+			expStatusCode: 200,
+			expResponse:   `{"id": 2, "accessKey": "newAccess1", "secretKey": "newSecret", "label": "ci", "auth_scheme": "signature", "scopes": ["upload"], "created_at": "timestamp", "expires_at": "NULL"}`,
+		},
+		{
+			name:          "sandbox client gets a sandbox key",
+			payload:       types.AccessKeyPayload{Label: "ci"},
+			sandbox:       true,
+			scopes:        []string{"upload"},
+			expStatusCode: 200,
+			expResponse:   `{"id": 2, "accessKey": "test_newAccess1", "secretKey": "newSecret", "label": "ci", "auth_scheme": "header", "scopes": ["upload"], "created_at": "timestamp", "expires_at": "NULL"}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// marhalling the payload into json
+			body, err := json.Marshal(tc.payload)
+			if err != nil {
+				t.Fatalf("Error while marshalling payload: %v", err)
+			}
+
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/keys", bytes.NewBuffer(body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			c.Set("client_id", 1)
+			c.Set("sandbox", tc.sandbox)
+			c.Set("scopes", tc.scopes)
+
+			// calling the access key create handler
+			handler := NewHandler(&mockService{})
+			handler.AccessKeyCreateHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+func TestAccessKeyRevokeHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		keyID         string
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "non numeric key id",
+			keyID:         "abc",
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "access key not found or already revoked"}`,
+		},
+		{
+			name:          "unknown key id",
+			keyID:         "2",
+			expStatusCode: 404,
+			expResponse:   `{"errorMessage": "access key not found or already revoked"}`,
+		},
+		{
+			name:          "valid case",
+			keyID:         "1",
+			expStatusCode: 200,
+			expResponse:   `{"message": "access key revoked"}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("DELETE", fmt.Sprintf("/keys/%s", tc.keyID), nil)
+			c.Set("client_id", 1)
+			c.Params = []gin.Param{
+				{Key: "keyID", Value: tc.keyID},
+			}
+
+			// calling the revoke handler
+			handler := NewHandler(&mockService{})
+			handler.AccessKeyRevokeHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+func TestTokenHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		token         bool
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "authenticated with a token",
+			token:         true,
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "tokens can't be issued for a token, use the refresh token instead"}`,
+		},
+		{
+			name:          "valid case",
+			expStatusCode: 200,
+			expResponse:   `{"access_token": "token1.3.upload,ocr", "token_type": "Bearer", "expires_in": 900, "refresh_token": "rt_new", "refresh_expires_in": 2592000}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/token", nil)
+			c.Set("client_id", 1)
+			c.Set("key_id", 3)
+			c.Set("scopes", []string{"upload", "ocr"})
+			c.Set("token", tc.token)
+
+			// calling the token handler
+			handler := NewHandler(&mockService{})
+			handler.TokenHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+// TestTokenUpload issues a token with the keys of a client keeping its original uploads, and uploads with it
+func TestTokenUpload(t *testing.T) {
+	dataStore := service.NewMemoryStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	payload := types.SignupPayload{Name: "test", Email: "test@example.com", Plan: "basic", KeepOriginalUploads: true}
+	assert.NoError(t, dataStore.InsertClientData(1, payload, "access", string(hash)))
+	tokens, err := service.NewTokenSigner(map[string]string{"k1": "key1"}, "k1", time.Minute)
+	assert.NoError(t, err)
+	fileStore := service.NewMemoryFileStore()
+	svc := service.NewService(&service.ServiceConfig{
+		DataStore:  dataStore,
+		FileStore:  fileStore,
+		KeyService: service.NewKeyService(),
+		UUID:       &service.UuidService{},
+		Tokens:     tokens,
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.NewAuthMiddleware(&middleware.AuthMiddlewareConfig{
+		DataStore: dataStore,
+		Tokens:    tokens,
+	}).Middleware())
+	handler := NewHandler(svc)
+	router.POST("/api/v1/token", handler.TokenHandler)
+	router.POST("/api/v1/upload", handler.FileUploadHandler)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/v1/token", nil)
+	req.Header.Set("accessKey", "access")
+	req.Header.Set("secretKey", "secret")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var token types.TokenResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &token))
+
+	// an image the server would encode differently, so it's told apart from the normalised one
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	var original bytes.Buffer
+	assert.NoError(t, (&png.Encoder{CompressionLevel: png.NoCompression}).Encode(&original, img))
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("type", types.FACE_TYPE)
+	part, _ := writer.CreateFormFile("file", "selfie.png")
+	part.Write(original.Bytes())
+	writer.Close()
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/api/v1/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp types.FileUploadResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+	// the upload made with the token is kept as sent, like one made with the keys
+	upload, err := dataStore.GetMetaDataByUUID(resp.Id)
+	assert.NoError(t, err)
+	saved, err := fileStore.GetFile(upload.FilePath)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(original.Bytes(), saved), "Expected the upload to be kept as sent")
+}
+
+func TestTokenRefreshHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		body          string
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "invalid json",
+			body:          `{`,
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "unexpected EOF"}`,
+		},
+		{
+			name:          "invalid refresh token",
+			body:          `{"refresh_token": "rt_used"}`,
+			expStatusCode: 401,
+			expResponse:   `{"errorMessage": "invalid or expired refresh token"}`,
+		},
+		{
+			name:          "valid case",
+			body:          `{"refresh_token": "rt_valid"}`,
+			expStatusCode: 200,
+			expResponse:   `{"access_token": "token1.1", "token_type": "Bearer", "expires_in": 900, "refresh_token": "rt_new", "refresh_expires_in": 2592000}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/token/refresh", bytes.NewBufferString(tc.body))
+
+			// calling the refresh handler
+			handler := NewHandler(&mockService{})
+			handler.TokenRefreshHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+func (m mockService) CreateUploadSession(clientID, planID int, payload types.UploadSessionPayload) (*types.UploadSession, error) {
+	return &types.UploadSession{ID: "session1", ClientID: clientID, Type: payload.Type, FileName: payload.FileName, FileSize: payload.FileSize}, nil
+}
+
+func (m mockService) GetUploadSession(clientID int, sessionID string) (*types.UploadSession, error) {
+	if sessionID != "session1" {
+		return nil, service.ErrUploadSessionNotFound
+	}
+	return &types.UploadSession{ID: sessionID, ClientID: clientID}, nil
+}
+
+func (m mockService) UploadChunk(clientID int, sessionID string, number int, content io.Reader) (*types.UploadChunk, error) {
+	if sessionID == "completed" {
+		return nil, service.ErrUploadSessionCompleted
+	}
+	if sessionID != "session1" {
+		return nil, service.ErrUploadSessionNotFound
+	}
+	if number > 2 {
+		return nil, service.ErrInvalidChunkNumber
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	return &types.UploadChunk{Number: number, Size: int64(len(data))}, nil
+}
+
+func (m mockService) CompleteUploadSession(clientID, planID int, sessionID string, keepOriginal bool) (*types.FileUploadResponse, error) {
+	switch sessionID {
+	case "session1":
+		return &types.FileUploadResponse{Id: "imgUuid1"}, nil
+	case "incomplete":
+		return nil, service.ErrUploadIncomplete
+	case "corrupt":
+		return nil, service.ErrCorruptImage
+	}
+	return nil, service.ErrUploadSessionNotFound
+}
+
+func (m mockService) AbortUploadSession(clientID int, sessionID string) error {
+	if sessionID != "session1" {
+		return service.ErrUploadSessionNotFound
+	}
+	return nil
+}
+
+func (m mockService) PresignUpload(clientID int, payload types.PresignedUploadPayload) (*types.PresignedUploadResponse, error) {
+	return &types.PresignedUploadResponse{Id: "imgUuid1", URL: "http://minio/1/imgUuid1.png", Method: "PUT", ExpiresIn: 900}, nil
+}
+
+func (m mockService) ConfirmPresignedUpload(clientID, planID int, imgUuid string, payload types.PresignedUploadPayload, keepOriginal bool) (*types.FileUploadResponse, error) {
+	if payload.Type != types.FACE_TYPE {
+		return nil, service.ErrInvalidFileType
+	}
+	switch imgUuid {
+	case "imgUuid1":
+		return &types.FileUploadResponse{Id: imgUuid}, nil
+	case "large":
+		return nil, fmt.Errorf("%w, which is %d KB", service.ErrFileTooLarge, 5242)
+	}
+	return nil, service.ErrPresignedUploadNotFound
+}
+
+func (m mockService) PresignDownload(clientID int, imgUuid string) (*types.PresignedURLResponse, error) {
+	if imgUuid == "imgUuid3" {
+		return nil, fmt.Errorf("error while presigning: %w", store.ErrPresignNotSupported)
+	}
+	if imgUuid != "imgUuid1" {
+		return nil, service.ErrUploadNotFound
+	}
+	return &types.PresignedURLResponse{URL: "http://minio/1/imgUuid1.png", ExpiresIn: 900}, nil
+}
+
+func (m mockService) ListUploads(clientID int, query types.UploadListQuery) (*types.UploadListResponse, error) {
+	if query.Type == "invalid" {
+		return nil, service.ErrInvalidFileType
+	}
+
+	return &types.UploadListResponse{
+		Uploads: []*types.UploadResponse{
+			{Id: "imgUuid1", Type: types.FACE_TYPE, FileName: "face.png", FileSizeKB: 12, CreatedAt: "timestamp"},
+		},
+		NextCursor: "next",
+	}, nil
+}
+
+func (m mockService) GetUpload(clientID int, imgUuid string) (*types.UploadResponse, error) {
+	if imgUuid != "imgUuid1" {
+		return nil, service.ErrUploadNotFound
+	}
+	return &types.UploadResponse{Id: imgUuid, Type: types.FACE_TYPE, FileName: "face.png", FileSizeKB: 12, CreatedAt: "timestamp"}, nil
+}
+
+func (m mockService) DeleteUpload(clientID int, imgUuid string) error {
+	switch imgUuid {
+	case "imgUuid1":
+		return nil
+	case "pending":
+		return store.ErrUploadInUse
+	}
+	return service.ErrUploadNotFound
+}
+
+func TestWebhookRegisterHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		payload       types.WebhookPayload
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "invalid url",
+			payload:       types.WebhookPayload{URL: "invalid"},
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "invalid webhook url, must be an absolute http or https url"}`,
+		},
+		{
+			name:          "valid case",
+			payload:       types.WebhookPayload{URL: "https://example.com/callback"},
+			expStatusCode: 200,
+			expResponse:   `{"id": 1, "url": "https://example.com/callback", "created_at": "timestamp", "secret": "whsec_secret"}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// marhalling the payload into json
+			body, err := json.Marshal(tc.payload)
+			if err != nil {
+				t.Fatalf("Error while marshalling payload: %v", err)
+			}
+
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/webhooks", bytes.NewBuffer(body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			c.Set("client_id", 1)
+
+			// calling the webhook register handler
+			handler := NewHandler(&mockService{})
+			handler.WebhookRegisterHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+func TestWebhookRedeliverHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		deliveryID    string
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "non numeric delivery id",
+			deliveryID:    "abc",
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "webhook delivery not found"}`,
+		},
+		{
+			name:          "unknown delivery id",
+			deliveryID:    "2",
+			expStatusCode: 404,
+			expResponse:   `{"errorMessage": "webhook delivery not found"}`,
+		},
+		{
+			name:          "valid case",
+			deliveryID:    "1",
+			expStatusCode: 200,
+			expResponse:   `{"message": "delivery scheduled"}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", fmt.Sprintf("/webhooks/deliveries/%s/redeliver", tc.deliveryID), nil)
+			c.Set("client_id", 1)
+			c.Params = []gin.Param{
+				{Key: "deliveryID", Value: tc.deliveryID},
+			}
+
+			// calling the redeliver handler
+			handler := NewHandler(&mockService{})
+			handler.WebhookRedeliverHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+func TestUploadChunkHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		sessionID     string
+		chunkNumber   string
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "non numeric chunk number",
+			sessionID:     "session1",
+			chunkNumber:   "abc",
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "invalid chunk number, must be between 1 and the chunk count of the session"}`,
+		},
+		{
+			name:          "chunk number out of range",
+			sessionID:     "session1",
+			chunkNumber:   "3",
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "invalid chunk number, must be between 1 and the chunk count of the session"}`,
+		},
+		{
+			name:          "unknown session",
+			sessionID:     "session2",
+			chunkNumber:   "1",
+			expStatusCode: 404,
+			expResponse:   `{"errorMessage": "upload session not found or expired"}`,
+		},
+		{
+			name:          "completed session",
+			sessionID:     "completed",
+			chunkNumber:   "1",
+			expStatusCode: 409,
+			expResponse:   `{"errorMessage": "upload session is already completed"}`,
+		},
+		{
+			name:          "valid case",
+			sessionID:     "session1",
+			chunkNumber:   "2",
+			expStatusCode: 200,
+			expResponse:   `{"number": 2, "size": 5}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("PUT", fmt.Sprintf("/upload/sessions/%s/chunks/%s", tc.sessionID, tc.chunkNumber), strings.NewReader("chunk"))
+			c.Set("client_id", 1)
+			c.Params = []gin.Param{
+				{Key: "sessionID", Value: tc.sessionID},
+				{Key: "chunkNumber", Value: tc.chunkNumber},
+			}
+
+			// calling the upload chunk handler
+			handler := NewHandler(&mockService{})
+			handler.UploadChunkHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+func TestUploadSessionCompleteHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		sessionID     string
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "unknown session",
+			sessionID:     "session2",
+			expStatusCode: 404,
+			expResponse:   `{"errorMessage": "upload session not found or expired"}`,
+		},
+		{
+			name:          "missing chunks",
+			sessionID:     "incomplete",
+			expStatusCode: 409,
+			expResponse:   `{"errorMessage": "upload is missing chunks, they must all be uploaded before completing it"}`,
+		},
+		{
+			name:          "assembled file is not a valid image",
+			sessionID:     "corrupt",
+			expStatusCode: 400,
+			expResponse:   fmt.Sprintf(`{"errorMessage": %q}`, service.ErrCorruptImage.Error()),
+		},
+		{
+			name:          "valid case",
+			sessionID:     "session1",
+			expStatusCode: 200,
+			expResponse:   `{"id": "imgUuid1", "deduplicated": false}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", fmt.Sprintf("/upload/sessions/%s/complete", tc.sessionID), nil)
+			c.Set("client_id", 1)
+			c.Params = []gin.Param{
+				{Key: "sessionID", Value: tc.sessionID},
+			}
+
+			// calling the upload session complete handler
+			handler := NewHandler(&mockService{})
+			handler.UploadSessionCompleteHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+func TestPresignedUploadConfirmHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		uploadID      string
+		payload       types.PresignedUploadPayload
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "invalid type",
+			uploadID:      "imgUuid1",
+			payload:       types.PresignedUploadPayload{Type: "selfie", FileName: "face.png"},
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "invalid type, supported types are face or id_card"}`,
+		},
+		{
+			name:          "file never put",
+			uploadID:      "imgUuid2",
+			payload:       types.PresignedUploadPayload{Type: types.FACE_TYPE, FileName: "face.png"},
+			expStatusCode: 404,
+			expResponse:   `{"errorMessage": "no file was put with the presigned url of this upload, or it expired"}`,
+		},
+		{
+			name:          "file too large",
+			uploadID:      "large",
+			payload:       types.PresignedUploadPayload{Type: types.FACE_TYPE, FileName: "face.png"},
+			expStatusCode: 413,
+			expResponse:   `{"errorMessage": "file is larger than the upload size limit of the plan, which is 5242 KB"}`,
+		},
+		{
+			name:          "valid case",
+			uploadID:      "imgUuid1",
+			payload:       types.PresignedUploadPayload{Type: types.FACE_TYPE, FileName: "face.png"},
+			expStatusCode: 200,
+			expResponse:   `{"id": "imgUuid1", "deduplicated": false}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// marhalling the payload into json
+			body, err := json.Marshal(tc.payload)
+			if err != nil {
+				t.Fatalf("Error while marshalling payload: %v", err)
+			}
+
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", fmt.Sprintf("/upload/presigned/%s/confirm", tc.uploadID), bytes.NewBuffer(body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			c.Set("client_id", 1)
+			c.Params = []gin.Param{
+				{Key: "uploadID", Value: tc.uploadID},
+			}
+
+			// calling the confirm handler
+			handler := NewHandler(&mockService{})
+			handler.PresignedUploadConfirmHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+func TestPresignedDownloadHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		uploadID      string
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "upload of another client",
+			uploadID:      "imgUuid2",
+			expStatusCode: 404,
+			expResponse:   `{"errorMessage": "upload not found"}`,
+		},
+		{
+			name:          "file store without presigned urls",
+			uploadID:      "imgUuid3",
+			expStatusCode: 501,
+			expResponse:   `{"errorMessage": "error while presigning: presigned urls are not supported by the file store"}`,
+		},
+		{
+			name:          "valid case",
+			uploadID:      "imgUuid1",
+			expStatusCode: 200,
+			expResponse:   `{"url": "http://minio/1/imgUuid1.png", "expires_in": 900}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", fmt.Sprintf("/upload/%s/download", tc.uploadID), nil)
+			c.Set("client_id", 1)
+			c.Params = []gin.Param{
+				{Key: "uploadID", Value: tc.uploadID},
+			}
+
+			// calling the download handler
+			handler := NewHandler(&mockService{})
+			handler.PresignedDownloadHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+func TestUploadListHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		query         string
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "invalid type",
+			query:         "?type=invalid",
+			expStatusCode: 400,
+			expResponse:   `{"errorMessage": "invalid type, supported types are face or id_card"}`,
+		},
+		{
+			name:          "valid case",
+			query:         "?type=face&limit=1",
+			expStatusCode: 200,
+			expResponse: `{
+				"uploads": [{
+					"id": "imgUuid1", "type": "face", "file_name": "face.png", "file_size_kb": 12, "sha256": "",
+					"width": 0, "height": 0, "format": "", "created_at": "timestamp"
+				}],
+				"next_cursor": "next"
+			}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/uploads"+tc.query, nil)
+			c.Set("client_id", 1)
+
+			// calling the upload list handler
+			handler := NewHandler(&mockService{})
+			handler.UploadListHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+func TestUploadGetHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		uploadID      string
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "upload not found",
+			uploadID:      "imgUuid2",
+			expStatusCode: 404,
+			expResponse:   `{"errorMessage": "upload not found"}`,
+		},
+		{
+			name:          "valid case",
+			uploadID:      "imgUuid1",
+			expStatusCode: 200,
+			expResponse: `{
+				"id": "imgUuid1", "type": "face", "file_name": "face.png", "file_size_kb": 12, "sha256": "",
+				"width": 0, "height": 0, "format": "", "created_at": "timestamp"
+			}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", fmt.Sprintf("/uploads/%s", tc.uploadID), nil)
+			c.Set("client_id", 1)
+			c.Params = []gin.Param{
+				{Key: "uploadID", Value: tc.uploadID},
+			}
+
+			// calling the upload handler
+			handler := NewHandler(&mockService{})
+			handler.UploadGetHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
+
+func TestUploadDeleteHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tt := []struct {
+		name          string
+		uploadID      string
+		expStatusCode int
+		expResponse   string
+	}{
+		{
+			name:          "upload not found",
+			uploadID:      "imgUuid2",
+			expStatusCode: 404,
+			expResponse:   `{"errorMessage": "upload not found"}`,
+		},
+		{
+			name:          "upload used by a pending job",
+			uploadID:      "pending",
+			expStatusCode: 409,
+			expResponse:   `{"errorMessage": "upload is in use by a pending job"}`,
+		},
+		{
+			name:          "valid case",
+			uploadID:      "imgUuid1",
+			expStatusCode: 200,
+			expResponse:   `{"message": "upload deleted"}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// preparing the test
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("DELETE", fmt.Sprintf("/uploads/%s", tc.uploadID), nil)
+			c.Set("client_id", 1)
+			c.Params = []gin.Param{
+				{Key: "uploadID", Value: tc.uploadID},
+			}
+
+			// calling the upload delete handler
+			handler := NewHandler(&mockService{})
+			handler.UploadDeleteHandler(c)
+
+			// asserting the values
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			assert.JSONEq(t, tc.expResponse, w.Body.String())
+		})
+	}
+}
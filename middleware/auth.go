@@ -1,57 +1,211 @@
 package middleware
 
 import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthMiddleware struct {
-	store store.DataStore
+	store       store.DataStore
+	limiter     *RateLimiter
+	credentials *service.CredentialCache
+	signatures  *SignatureVerifier
+	tokens      *service.TokenSigner
+}
+
+type AuthMiddlewareConfig struct {
+	DataStore store.DataStore
+
+	// clients are limited by their plan when it's set
+	Limiter *RateLimiter
+
+	// verified credentials are cached when it's set, skipping the lookup and bcrypt check on a hit
+	Credentials *service.CredentialCache
+
+	// signed requests are accepted when it's set
+	Signatures *SignatureVerifier
+
+	// access tokens are accepted when it's set
+	Tokens *service.TokenSigner
 }
 
-func NewAuthMiddleware(store store.DataStore) *AuthMiddleware {
-	return &AuthMiddleware{store: store}
+func NewAuthMiddleware(config *AuthMiddlewareConfig) *AuthMiddleware {
+	return &AuthMiddleware{
+		store:       config.DataStore,
+		limiter:     config.Limiter,
+		credentials: config.Credentials,
+		signatures:  config.Signatures,
+		tokens:      config.Tokens,
+	}
 }
 
 func (am *AuthMiddleware) Middleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// extract keys from headers
-		accessKey := c.GetHeader("accessKey")
-		secretKey := c.GetHeader("secretKey")
-
-		if len(accessKey) == 0 || len(secretKey) == 0 {
-			c.JSON(401, gin.H{"errorMessage": "invalid access or secret key"})
-			c.Abort()
-			return
-		}
-
-		// get user details on the basis of access key
-		clientData, err := am.store.GetClientFromAccessKey(accessKey)
-		if err != nil {
-			c.JSON(500, gin.H{"errorMessage": "invalid access or secret key"})
-			c.Abort()
-			return
+		// requests carry an access token, or else are authenticated by their key with the scheme
+		// it was created with, either signing them or sending the secret key in the headers
+		var clientData *types.ClientData
+		token, isToken := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		switch {
+		case isToken:
+			clientData = am.tokenClient(c, token)
+		case len(c.GetHeader(SIGNATURE_HEADER)) != 0:
+			clientData = am.signedClient(c)
+		default:
+			clientData = am.headerClient(c)
 		}
 		if clientData == nil {
-			c.JSON(401, gin.H{"errorMessage": "invalid access or secret key"})
-			c.Abort()
-			return
-		}
-
-		// match the hash of the key
-		err = bcrypt.CompareHashAndPassword([]byte(clientData.SecretKeyHash), []byte(secretKey))
-		if err != nil {
-			c.JSON(401, gin.H{"errorMessage": "invalid access or secret key"})
 			c.Abort()
 			return
 		}
 
 		// set the client id on gin.Context
 		c.Set("client_id", clientData.Id)
+		c.Set("plan_id", clientData.PlanID)
+		c.Set("key_id", clientData.KeyID)
+		c.Set("scopes", clientData.Scopes)
+		c.Set("sandbox", clientData.Sandbox)
+		c.Set("keep_original_uploads", clientData.KeepOriginalUploads)
+		c.Set("token", isToken)
+
+		// enforce the rate limits and quotas of the plan
+		if am.limiter != nil && !am.limiter.Allow(c, clientData) {
+			return
+		}
 
 		// call the next handler
 		c.Next()
 	}
 }
+
+// headerClient returns the client of the access and secret key in the headers,
+// or responds with the error and returns nil
+func (am *AuthMiddleware) headerClient(c *gin.Context) *types.ClientData {
+	// extract keys from headers
+	accessKey := c.GetHeader("accessKey")
+	secretKey := c.GetHeader("secretKey")
+
+	if len(accessKey) == 0 || len(secretKey) == 0 {
+		c.JSON(401, gin.H{"errorMessage": "invalid access or secret key"})
+		return nil
+	}
+
+	// credentials verified a moment ago are trusted as they are
+	clientData, ok := am.cachedClient(accessKey, secretKey)
+	if ok {
+		return clientData
+	}
+
+	// get user details on the basis of access key, revoked and expired keys aren't found
+	clientData = am.lookupClient(c, accessKey)
+	if clientData == nil {
+		return nil
+	}
+
+	// keys signing their requests never send the secret key
+	if clientData.AuthScheme == service.AUTH_SCHEME_SIGNATURE {
+		c.JSON(401, gin.H{"errorMessage": "access key requires signed requests"})
+		return nil
+	}
+
+	// match the hash of the key
+	err := bcrypt.CompareHashAndPassword([]byte(clientData.SecretKeyHash), []byte(secretKey))
+	if err != nil {
+		c.JSON(401, gin.H{"errorMessage": "invalid access or secret key"})
+		return nil
+	}
+
+	if am.credentials != nil {
+		am.credentials.Set(accessKey, secretKey, clientData)
+	}
+
+	return clientData
+}
+
+// signedClient returns the client of a signed request, or responds with the error and returns nil
+func (am *AuthMiddleware) signedClient(c *gin.Context) *types.ClientData {
+	if am.signatures == nil {
+		c.JSON(401, gin.H{"errorMessage": "signed requests are not enabled"})
+		return nil
+	}
+
+	accessKey := c.GetHeader("accessKey")
+	if len(accessKey) == 0 {
+		c.JSON(401, gin.H{"errorMessage": "invalid access key or signature"})
+		return nil
+	}
+
+	clientData := am.lookupClient(c, accessKey)
+	if clientData == nil {
+		return nil
+	}
+	if clientData.AuthScheme != service.AUTH_SCHEME_SIGNATURE {
+		c.JSON(401, gin.H{"errorMessage": "access key doesn't sign its requests, send the secret key instead"})
+		return nil
+	}
+
+	err := am.signatures.Verify(c, clientData)
+	switch {
+	case err == nil:
+		return clientData
+	case errors.Is(err, ErrSignedBodyTooLarge):
+		c.JSON(413, gin.H{"errorMessage": err.Error()})
+	case errors.Is(err, ErrSignatureExpired), errors.Is(err, ErrInvalidNonce), errors.Is(err, ErrNonceReused), errors.Is(err, ErrInvalidSignature):
+		c.JSON(401, gin.H{"errorMessage": err.Error()})
+	default:
+		log.Printf("Error while verifying the signed request (%s): %s\n", accessKey, err.Error())
+		c.JSON(500, gin.H{"errorMessage": "invalid access key or signature"})
+	}
+
+	return nil
+}
+
+// tokenClient returns the client the access token was issued to, or responds with the error and returns nil
+func (am *AuthMiddleware) tokenClient(c *gin.Context, token string) *types.ClientData {
+	if am.tokens == nil {
+		c.JSON(401, gin.H{"errorMessage": service.ErrTokensDisabled.Error()})
+		return nil
+	}
+
+	clientData, err := am.tokens.Verify(token)
+	if err != nil {
+		c.JSON(401, gin.H{"errorMessage": err.Error()})
+		return nil
+	}
+
+	return clientData
+}
+
+// lookupClient returns the client owning the access key, or responds with the error and returns nil
+func (am *AuthMiddleware) lookupClient(c *gin.Context, accessKey string) *types.ClientData {
+	clientData, err := am.store.GetClientFromAccessKey(accessKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(401, gin.H{"errorMessage": "invalid access or secret key"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"errorMessage": "invalid access or secret key"})
+		return nil
+	}
+	if clientData == nil {
+		c.JSON(401, gin.H{"errorMessage": "invalid access or secret key"})
+		return nil
+	}
+
+	return clientData
+}
+
+func (am *AuthMiddleware) cachedClient(accessKey, secretKey string) (*types.ClientData, bool) {
+	if am.credentials == nil {
+		return nil, false
+	}
+	return am.credentials.Get(accessKey, secretKey)
+}
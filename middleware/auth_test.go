@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// countingStore counts the access key lookups going to the data store
+type countingStore struct {
+	*service.MemoryStore
+	lookups int
+}
+
+func (s *countingStore) GetClientFromAccessKey(accessKey string) (*types.ClientData, error) {
+	s.lookups++
+	return s.MemoryStore.GetClientFromAccessKey(accessKey)
+}
+
+// newAuthRouter signs up a client with the key pair, and serves a route behind the auth middleware
+func newAuthRouter(tb testing.TB, credentials *service.CredentialCache) (*gin.Engine, *countingStore) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.DefaultCost)
+	if err != nil {
+		tb.Fatalf("Unexpected error: %v", err)
+	}
+	dStore := &countingStore{MemoryStore: service.NewMemoryStore()}
+	err = dStore.InsertClientData(1, types.SignupPayload{Name: "test", Email: "test@example.com", Plan: "basic"}, "access", string(hash))
+	if err != nil {
+		tb.Fatalf("Unexpected error: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(NewAuthMiddleware(&AuthMiddlewareConfig{
+		DataStore:   dStore,
+		Credentials: credentials,
+	}).Middleware())
+	router.GET("/api/v1/jobs", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"clientID": c.GetInt("client_id")})
+	})
+
+	return router, dStore
+}
+
+func callWithKeys(router *gin.Engine, accessKey, secretKey string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/jobs", nil)
+	req.Header.Set("accessKey", accessKey)
+	req.Header.Set("secretKey", secretKey)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	router, dStore := newAuthRouter(t, nil)
+
+	w := callWithKeys(router, "access", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"clientID": 1}`, w.Body.String())
+
+	assert.Equal(t, http.StatusUnauthorized, callWithKeys(router, "access", "wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, callWithKeys(router, "missing", "secret").Code)
+	assert.Equal(t, http.StatusUnauthorized, callWithKeys(router, "", "").Code)
+	assert.Equal(t, 3, dStore.lookups, "every call without a cache is looked up")
+}
+
+func TestAuthMiddlewareCredentialCache(t *testing.T) {
+	credentials := service.NewCredentialCache(service.NewMemoryCacheStore(), []byte("key"), time.Minute)
+	router, dStore := newAuthRouter(t, credentials)
+
+	// only the first call is looked up, the rest are served from the cache
+	for range 3 {
+		w := callWithKeys(router, "access", "secret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"clientID": 1}`, w.Body.String())
+	}
+	assert.Equal(t, 1, dStore.lookups)
+
+	// a wrong secret key isn't let through by the cached credentials
+	assert.Equal(t, http.StatusUnauthorized, callWithKeys(router, "access", "wrong").Code)
+
+	// revoked keys stop working once their credentials are dropped
+	_, err := dStore.RevokeAccessKey(1, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, credentials.Invalidate("access"))
+	assert.Equal(t, http.StatusUnauthorized, callWithKeys(router, "access", "secret").Code)
+}
+
+func TestAuthMiddlewareTokens(t *testing.T) {
+	tokens, err := service.NewTokenSigner(map[string]string{"k1": "key1"}, "k1", time.Minute)
+	assert.NoError(t, err)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(NewAuthMiddleware(&AuthMiddlewareConfig{
+		DataStore: service.NewMemoryStore(),
+		Tokens:    tokens,
+	}).Middleware())
+	router.GET("/api/v1/jobs", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"clientID": c.GetInt("client_id"), "sandbox": c.GetBool("sandbox"), "token": c.GetBool("token")})
+	})
+
+	// tokens are verified without a lookup, the client doesn't even have to be in the store
+	token, err := tokens.Issue(&types.ClientData{Id: 7, PlanID: 1, Sandbox: true})
+	assert.NoError(t, err)
+	w := callWithToken(router, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"clientID": 7, "sandbox": true, "token": true}`, w.Body.String())
+
+	w = callWithToken(router, token+"x")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"errorMessage": "invalid or expired token"}`, w.Body.String())
+
+	// tokens aren't accepted when the server doesn't issue them
+	router, _ = newAuthRouter(t, nil)
+	w = callWithToken(router, token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"errorMessage": "tokens are not enabled on this server"}`, w.Body.String())
+}
+
+func callWithToken(router *gin.Engine, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/jobs", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func BenchmarkAuthMiddleware(b *testing.B) {
+	b.Run("uncached", func(b *testing.B) {
+		router, _ := newAuthRouter(b, nil)
+		b.ResetTimer()
+		for range b.N {
+			callWithKeys(router, "access", "secret")
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		credentials := service.NewCredentialCache(service.NewMemoryCacheStore(), []byte("key"), time.Minute)
+		router, _ := newAuthRouter(b, credentials)
+		b.ResetTimer()
+		for range b.N {
+			callWithKeys(router, "access", "secret")
+		}
+	})
+	b.Run("token", func(b *testing.B) {
+		tokens, _ := service.NewTokenSigner(map[string]string{"k1": "key1"}, "k1", time.Minute)
+		router := gin.New()
+		router.Use(NewAuthMiddleware(&AuthMiddlewareConfig{Tokens: tokens}).Middleware())
+		router.GET("/api/v1/jobs", func(c *gin.Context) { c.Status(http.StatusOK) })
+		token, _ := tokens.Issue(&types.ClientData{Id: 1, PlanID: 1})
+		b.ResetTimer()
+		for range b.N {
+			callWithToken(router, token)
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+)
+
+const IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+const IDEMPOTENT_REPLAYED_HEADER = "Idempotent-Replayed"
+const DEFAULT_IDEMPOTENCY_TTL = 24 * time.Hour
+const MAX_IDEMPOTENCY_KEY_LENGTH = 255
+const MAX_IDEMPOTENT_BODY_SIZE = 32 << 20
+
+var ErrInvalidIdempotencyKey = errors.New("idempotency key must be at most 255 characters")
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used with a different request")
+var ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still in progress, retry later")
+var ErrIdempotentBodyTooLarge = errors.New("request body is too large to be sent with an idempotency key")
+
+// Idempotency makes requests sent with an Idempotency-Key header safe to retry: the first response
+// of every key of a client is kept for the ttl, and replayed to the retries instead of running them again.
+// A key is bound to the method, path and body of its first request, reusing it for another one is rejected.
+// Server errors aren't kept, so requests failing on them can be retried with the same key.
+type Idempotency struct {
+	store store.DataStore
+	ttl   time.Duration
+}
+
+// NewIdempotency returns the middleware keeping the responses in dataStore for ttl
+func NewIdempotency(dataStore store.DataStore, ttl time.Duration) *Idempotency {
+	if ttl <= 0 {
+		ttl = DEFAULT_IDEMPOTENCY_TTL
+	}
+
+	return &Idempotency{
+		store: dataStore,
+		ttl:   ttl,
+	}
+}
+
+// Middleware goes after the auth middleware, as the keys are scoped to the client
+func (i *Idempotency) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IDEMPOTENCY_KEY_HEADER)
+		if len(key) == 0 {
+			c.Next()
+			return
+		}
+		if len(key) > MAX_IDEMPOTENCY_KEY_LENGTH {
+			c.JSON(400, gin.H{"errorMessage": ErrInvalidIdempotencyKey.Error()})
+			c.Abort()
+			return
+		}
+
+		body, err := readBody(c.Request, MAX_IDEMPOTENT_BODY_SIZE, ErrIdempotentBodyTooLarge)
+		if errors.Is(err, ErrIdempotentBodyTooLarge) {
+			c.JSON(413, gin.H{"errorMessage": err.Error()})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(400, gin.H{"errorMessage": err.Error()})
+			c.Abort()
+			return
+		}
+		clientID := c.GetInt("client_id")
+		requestHash := hashRequest(c.Request.Method, c.Request.URL.Path, c.GetHeader("Content-Type"), body)
+
+		reserved, err := i.store.InsertIdempotencyKey(clientID, key, requestHash, i.ttl)
+		if err != nil {
+			log.Printf("Error while reserving the idempotency key (%d, %s): %s\n", clientID, key, err.Error())
+			c.JSON(500, gin.H{"errorMessage": "error while checking the idempotency key"})
+			c.Abort()
+			return
+		}
+		if !reserved {
+			i.replay(c, clientID, key, requestHash)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		// deferred so the key is freed when the handler panics too, the panic goes on to the recovery middleware
+		panicked := true
+		defer func() {
+			statusCode := recorder.Status()
+			if panicked {
+				statusCode = 500
+			}
+			i.save(clientID, key, statusCode, recorder.body.String())
+		}()
+		c.Next()
+		panicked = false
+	}
+}
+
+// replay responds with the response kept for the key, unless it was used for another request or is still in progress
+func (i *Idempotency) replay(c *gin.Context, clientID int, key, requestHash string) {
+	record, err := i.store.GetIdempotencyKey(clientID, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the key was freed after a server error, or expired, in the meantime
+		c.JSON(409, gin.H{"errorMessage": ErrIdempotencyKeyInProgress.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error while fetching the idempotency key (%d, %s): %s\n", clientID, key, err.Error())
+		c.JSON(500, gin.H{"errorMessage": "error while checking the idempotency key"})
+		return
+	}
+
+	switch {
+	case record.RequestHash != requestHash:
+		c.JSON(422, gin.H{"errorMessage": ErrIdempotencyKeyReused.Error()})
+	case record.StatusCode == 0:
+		c.JSON(409, gin.H{"errorMessage": ErrIdempotencyKeyInProgress.Error()})
+	default:
+		c.Header(IDEMPOTENT_REPLAYED_HEADER, "true")
+		c.Data(record.StatusCode, "application/json; charset=utf-8", []byte(record.ResponseBody))
+	}
+}
+
+// save keeps the response for the retries, or frees the key after a server error so the request can be retried
+func (i *Idempotency) save(clientID int, key string, statusCode int, responseBody string) {
+	if statusCode < 500 {
+		err := i.store.SaveIdempotentResponse(clientID, key, statusCode, responseBody)
+		if err == nil {
+			return
+		}
+		log.Printf("Error while saving the response of the idempotency key (%d, %s): %s\n", clientID, key, err.Error())
+	}
+
+	err := i.store.DeleteIdempotencyKey(clientID, key)
+	if err != nil {
+		log.Printf("Error while freeing the idempotency key (%d, %s): %s\n", clientID, key, err.Error())
+	}
+}
+
+// hashRequest returns the hex SHA-256 of what a key is bound to.
+// Clients pick a new boundary for every multipart body they send, so those are hashed part by part instead.
+func hashRequest(method, path, contentType string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + "\n" + path + "\n"))
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" || hashParts(h, params["boundary"], body) != nil {
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashParts writes the name, file name and content of every part of the multipart body to h
+func hashParts(h hash.Hash, boundary string, body []byte) error {
+	parts := sha256.New()
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		content := sha256.New()
+		if _, err := io.Copy(content, part); err != nil {
+			return err
+		}
+		fmt.Fprintf(parts, "%q %q %x\n", part.FormName(), part.FileName(), content.Sum(nil))
+	}
+
+	h.Write(parts.Sum(nil))
+	return nil
+}
+
+// responseRecorder keeps a copy of the body written to the client
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
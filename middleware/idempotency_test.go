@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// newIdempotentRouter serves a route counting its calls behind the idempotency middleware,
+// failing with a server error while fail is set
+func newIdempotentRouter(calls *int, fail *bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("client_id", 1)
+	})
+	router.POST("/api/v1/ocr", NewIdempotency(service.NewMemoryStore(), time.Hour).Middleware(), func(c *gin.Context) {
+		*calls++
+		if *fail {
+			c.JSON(500, gin.H{"errorMessage": "queue unavailable"})
+			return
+		}
+		c.JSON(200, gin.H{"calls": *calls})
+	})
+
+	return router
+}
+
+func callIdempotent(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/ocr", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IDEMPOTENCY_KEY_HEADER, key)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotency(t *testing.T) {
+	calls, fail := 0, false
+	router := newIdempotentRouter(&calls, &fail)
+
+	// the first response is replayed to the retries
+	w := callIdempotent(router, "key1", `{"image":"img1"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"calls": 1}`, w.Body.String())
+	w = callIdempotent(router, "key1", `{"image":"img1"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"calls": 1}`, w.Body.String())
+	assert.Equal(t, "true", w.Header().Get(IDEMPOTENT_REPLAYED_HEADER))
+	assert.Equal(t, 1, calls)
+
+	// the key can't be used for another request
+	w = callIdempotent(router, "key1", `{"image":"img2"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"errorMessage": "idempotency key was already used with a different request"}`, w.Body.String())
+
+	// requests without a key, or with another one, run every time
+	assert.JSONEq(t, `{"calls": 2}`, callIdempotent(router, "", `{"image":"img1"}`).Body.String())
+	assert.JSONEq(t, `{"calls": 3}`, callIdempotent(router, "key2", `{"image":"img1"}`).Body.String())
+
+	w = callIdempotent(router, strings.Repeat("k", 256), `{"image":"img1"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 3, calls)
+}
+
+func TestIdempotencyServerError(t *testing.T) {
+	calls, fail := 0, true
+	router := newIdempotentRouter(&calls, &fail)
+
+	// server errors aren't kept, so the request runs again on a retry
+	assert.Equal(t, http.StatusInternalServerError, callIdempotent(router, "key1", `{"image":"img1"}`).Code)
+	fail = false
+	w := callIdempotent(router, "key1", `{"image":"img1"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"calls": 2}`, w.Body.String())
+	assert.Empty(t, w.Header().Get(IDEMPOTENT_REPLAYED_HEADER))
+}
+
+func TestIdempotencyPanic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(gin.Recovery(), func(c *gin.Context) {
+		c.Set("client_id", 1)
+	})
+	calls := 0
+	router.POST("/api/v1/ocr", NewIdempotency(service.NewMemoryStore(), time.Hour).Middleware(), func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			panic("handler bug")
+		}
+		c.JSON(200, gin.H{"calls": calls})
+	})
+
+	// the key is freed after a panic like after a server error, instead of staying in progress
+	assert.Equal(t, http.StatusInternalServerError, callIdempotent(router, "key1", `{"image":"img1"}`).Code)
+	w := callIdempotent(router, "key1", `{"image":"img1"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"calls": 2}`, w.Body.String())
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	dStore := service.NewMemoryStore()
+	reserved, err := dStore.InsertIdempotencyKey(1, "key1", hashRequest("POST", "/api/v1/ocr", "", []byte(`{"image":"img1"}`)), time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("client_id", 1)
+	})
+	router.POST("/api/v1/ocr", NewIdempotency(dStore, time.Hour).Middleware(), func(c *gin.Context) {
+		t.Error("Expected the request in progress not to run again")
+	})
+
+	w := callIdempotent(router, "key1", `{"image":"img1"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"errorMessage": "a request with this idempotency key is still in progress, retry later"}`, w.Body.String())
+}
+
+func TestHashRequestMultipart(t *testing.T) {
+	form := func(boundary, content string) (string, []byte) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.SetBoundary(boundary)
+		writer.WriteField("type", "face")
+		part, _ := writer.CreateFormFile("file", "face.png")
+		part.Write([]byte(content))
+		writer.Close()
+		return writer.FormDataContentType(), body.Bytes()
+	}
+
+	// retries of the same upload get a new boundary, but the same hash
+	contentType, body := form("boundary1", "image")
+	retryType, retry := form("boundary2", "image")
+	otherType, other := form("boundary3", "other image")
+	hash := hashRequest("POST", "/api/v1/upload", contentType, body)
+	assert.Equal(t, hash, hashRequest("POST", "/api/v1/upload", retryType, retry))
+	assert.NotEqual(t, hash, hashRequest("POST", "/api/v1/upload", otherType, other))
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+const API_PREFIX = "/api/v1/"
+
+// PLAN_LIMIT_CACHE_TTL is how long the limits of a plan are kept in process, so changes to them apply within it
+const PLAN_LIMIT_CACHE_TTL = 30 * time.Second
+
+// RateLimiter enforces the rate limits and quotas of the client's plan, per endpoint.
+// Endpoints are named by the first segment of their route (e.g. 'face-match' for /api/v1/face-match),
+// the ones without a limit of their own share the '*' limit of the plan.
+type RateLimiter struct {
+	dStore store.DataStore
+	limits store.RateLimitStore
+	now    func() time.Time
+
+	// limits of the plans by plan and endpoint, so they aren't fetched from the db on every request
+	mu         sync.Mutex
+	planLimits map[string]cachedPlanLimit
+}
+
+// cachedPlanLimit is the limit of a plan on an endpoint, nil when it has none
+type cachedPlanLimit struct {
+	limit     *types.PlanLimit
+	expiresAt time.Time
+}
+
+func NewRateLimiter(dataStore store.DataStore, limitStore store.RateLimitStore) *RateLimiter {
+	return &RateLimiter{
+		dStore:     dataStore,
+		limits:     limitStore,
+		now:        time.Now,
+		planLimits: map[string]cachedPlanLimit{},
+	}
+}
+
+// Allow takes a call of the client off its limits, setting the X-RateLimit-* headers.
+// When a limit is hit the request is aborted with 429 and a Retry-After header.
+// Errors of the stores are logged and let the request through, so an outage of redis doesn't take the api down.
+func (rl *RateLimiter) Allow(c *gin.Context, client *types.ClientData) bool {
+	endpoint := endpointName(c.FullPath())
+	limit, err := rl.planLimit(client.PlanID, endpoint)
+	if err != nil {
+		log.Printf("Error while fetching the limit of plan %d on %s: %s\n", client.PlanID, endpoint, err.Error())
+		return true
+	}
+	if limit == nil {
+		return true
+	}
+
+	now := rl.now().UTC()
+	prefix := fmt.Sprintf("ratelimit:%d:%s", client.Id, limit.Endpoint)
+
+	// token bucket first, calls turned away by it don't count against the quotas
+	if limit.RatePerMinute > 0 {
+		bucket, err := rl.limits.TakeToken(prefix, float64(limit.RatePerMinute)/60, limit.Burst, now)
+		if err != nil {
+			log.Printf("Error while taking a token for client %d: %s\n", client.Id, err.Error())
+			return true
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(bucket.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(bucket.ResetAfter)))
+		if !bucket.Allowed {
+			tooManyRequests(c, bucket.RetryAfter, "rate limit exceeded")
+			return false
+		}
+	}
+
+	// daily quota, reset at midnight UTC
+	if limit.DailyQuota > 0 {
+		resetAt := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		key := fmt.Sprintf("%s:daily:%s", prefix, now.Format(time.DateOnly))
+		if !rl.takeQuota(c, client, key, "Daily", limit.DailyQuota, resetAt.Sub(now)) {
+			return false
+		}
+	}
+
+	// monthly quota, reset on the first of the month UTC
+	if limit.MonthlyQuota > 0 {
+		resetAt := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		key := fmt.Sprintf("%s:monthly:%s", prefix, now.Format("2006-01"))
+		if !rl.takeQuota(c, client, key, "Monthly", limit.MonthlyQuota, resetAt.Sub(now)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// planLimit returns the limit of the plan on the endpoint, nil when it has none.
+// Limits are cached for PLAN_LIMIT_CACHE_TTL, plans without a limit included, but errors aren't.
+func (rl *RateLimiter) planLimit(planID int, endpoint string) (*types.PlanLimit, error) {
+	key := fmt.Sprintf("%d:%s", planID, endpoint)
+	now := rl.now()
+
+	rl.mu.Lock()
+	cached, ok := rl.planLimits[key]
+	rl.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.limit, nil
+	}
+
+	limit, err := rl.dStore.GetPlanLimit(planID, endpoint)
+	if errors.Is(err, sql.ErrNoRows) {
+		limit, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rl.mu.Lock()
+	rl.planLimits[key] = cachedPlanLimit{limit: limit, expiresAt: now.Add(PLAN_LIMIT_CACHE_TTL)}
+	rl.mu.Unlock()
+
+	return limit, nil
+}
+
+func (rl *RateLimiter) takeQuota(c *gin.Context, client *types.ClientData, key, period string, quota int, resetAfter time.Duration) bool {
+	count, err := rl.limits.IncrementQuota(key, rl.now().Add(resetAfter))
+	if err != nil {
+		log.Printf("Error while counting the %s quota of client %d: %s\n", strings.ToLower(period), client.Id, err.Error())
+		return true
+	}
+
+	c.Header("X-RateLimit-"+period+"-Limit", strconv.Itoa(quota))
+	c.Header("X-RateLimit-"+period+"-Remaining", strconv.Itoa(max(quota-count, 0)))
+	c.Header("X-RateLimit-"+period+"-Reset", strconv.Itoa(ceilSeconds(resetAfter)))
+	if count > quota {
+		tooManyRequests(c, resetAfter, strings.ToLower(period)+" quota exceeded")
+		return false
+	}
+
+	return true
+}
+
+func tooManyRequests(c *gin.Context, retryAfter time.Duration, message string) {
+	c.Header("Retry-After", strconv.Itoa(max(ceilSeconds(retryAfter), 1)))
+	c.JSON(429, gin.H{"errorMessage": message})
+	c.Abort()
+}
+
+// endpointName returns the first segment of the route after the api prefix
+func endpointName(fullPath string) string {
+	endpoint, _, _ := strings.Cut(strings.TrimPrefix(fullPath, API_PREFIX), "/")
+	return endpoint
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
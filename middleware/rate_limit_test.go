@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// newLimitedRouter serves the routes to the client, limited by the plan limits of the memory store
+func newLimitedRouter(limiter *RateLimiter, client *types.ClientData) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := func(c *gin.Context) {
+		if !limiter.Allow(c, client) {
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	router.POST("/api/v1/face-match", handler)
+	router.GET("/api/v1/jobs", handler)
+	router.GET("/api/v1/result/:jobType/:jobID", handler)
+
+	return router
+}
+
+func call(router *gin.Engine, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	dStore := service.NewMemoryStore()
+	dStore.SetPlanLimit(types.PlanLimit{PlanID: 1, Endpoint: "face-match", RatePerMinute: 60, Burst: 2})
+	limiter := NewRateLimiter(dStore, service.NewMemoryRateLimitStore())
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	router := newLimitedRouter(limiter, &types.ClientData{Id: 1, PlanID: 1})
+
+	for i := range 2 {
+		w := call(router, "POST", "/api/v1/face-match")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, []string{"1", "0"}[i], w.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	w := call(router, "POST", "/api/v1/face-match")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Reset"))
+	assert.JSONEq(t, `{"errorMessage": "rate limit exceeded"}`, w.Body.String())
+
+	// the bucket is refilled with a token a second
+	now = now.Add(time.Second)
+	w = call(router, "POST", "/api/v1/face-match")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRateLimiterQuota(t *testing.T) {
+	dStore := service.NewMemoryStore()
+	dStore.SetPlanLimit(types.PlanLimit{PlanID: 1, Endpoint: "face-match", DailyQuota: 2, MonthlyQuota: 10})
+	limiter := NewRateLimiter(dStore, service.NewMemoryRateLimitStore())
+	now := time.Date(2030, time.March, 14, 23, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	router := newLimitedRouter(limiter, &types.ClientData{Id: 1, PlanID: 1})
+
+	for i := range 2 {
+		w := call(router, "POST", "/api/v1/face-match")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "2", w.Header().Get("X-RateLimit-Daily-Limit"))
+		assert.Equal(t, []string{"1", "0"}[i], w.Header().Get("X-RateLimit-Daily-Remaining"))
+		assert.Equal(t, []string{"9", "8"}[i], w.Header().Get("X-RateLimit-Monthly-Remaining"))
+		assert.Empty(t, w.Header().Get("X-RateLimit-Limit"), "no rate limit configured")
+	}
+
+	// retried after midnight UTC
+	w := call(router, "POST", "/api/v1/face-match")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"errorMessage": "daily quota exceeded"}`, w.Body.String())
+
+	// the quota is reset the next day, calls turned away didn't count against the monthly quota
+	now = now.Add(2 * time.Hour)
+	w = call(router, "POST", "/api/v1/face-match")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "7", w.Header().Get("X-RateLimit-Monthly-Remaining"))
+}
+
+func TestRateLimiterEndpoints(t *testing.T) {
+	dStore := service.NewMemoryStore()
+	dStore.SetPlanLimit(types.PlanLimit{PlanID: 1, Endpoint: "*", RatePerMinute: 60, Burst: 1})
+	dStore.SetPlanLimit(types.PlanLimit{PlanID: 1, Endpoint: "face-match", RatePerMinute: 60, Burst: 1})
+	limiter := NewRateLimiter(dStore, service.NewMemoryRateLimitStore())
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	// endpoints with their own limit have their own bucket, the rest share the '*' one
+	router := newLimitedRouter(limiter, &types.ClientData{Id: 1, PlanID: 1})
+	assert.Equal(t, http.StatusOK, call(router, "POST", "/api/v1/face-match").Code)
+	assert.Equal(t, http.StatusOK, call(router, "GET", "/api/v1/jobs").Code)
+	assert.Equal(t, http.StatusTooManyRequests, call(router, "GET", "/api/v1/result/ocr/job1").Code)
+
+	// every client has its own buckets
+	router = newLimitedRouter(limiter, &types.ClientData{Id: 2, PlanID: 1})
+	assert.Equal(t, http.StatusOK, call(router, "GET", "/api/v1/jobs").Code)
+
+	// plans without limits aren't limited
+	router = newLimitedRouter(limiter, &types.ClientData{Id: 3, PlanID: 99})
+	for range 5 {
+		assert.Equal(t, http.StatusOK, call(router, "GET", "/api/v1/jobs").Code)
+	}
+}
+
+// countingDataStore counts the plan limit lookups reaching the memory store
+type countingDataStore struct {
+	*service.MemoryStore
+	lookups int
+}
+
+func (s *countingDataStore) GetPlanLimit(planID int, endpoint string) (*types.PlanLimit, error) {
+	s.lookups++
+	return s.MemoryStore.GetPlanLimit(planID, endpoint)
+}
+
+func TestRateLimiterPlanLimitCache(t *testing.T) {
+	dStore := &countingDataStore{MemoryStore: service.NewMemoryStore()}
+	dStore.SetPlanLimit(types.PlanLimit{PlanID: 1, Endpoint: "*", RatePerMinute: 60, Burst: 10})
+	limiter := NewRateLimiter(dStore, service.NewMemoryRateLimitStore())
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	// the limit is looked up once, for plans without limits too
+	router := newLimitedRouter(limiter, &types.ClientData{Id: 1, PlanID: 1})
+	unlimited := newLimitedRouter(limiter, &types.ClientData{Id: 2, PlanID: 99})
+	for range 3 {
+		assert.Equal(t, http.StatusOK, call(router, "GET", "/api/v1/jobs").Code)
+		assert.Equal(t, http.StatusOK, call(unlimited, "GET", "/api/v1/jobs").Code)
+	}
+	assert.Equal(t, 2, dStore.lookups)
+
+	// changes to the limits apply once the cached ones expire
+	dStore.SetPlanLimit(types.PlanLimit{PlanID: 1, Endpoint: "*", DailyQuota: 1})
+	now = now.Add(PLAN_LIMIT_CACHE_TTL)
+	assert.Equal(t, http.StatusOK, call(router, "GET", "/api/v1/jobs").Code)
+	assert.Equal(t, http.StatusTooManyRequests, call(router, "GET", "/api/v1/jobs").Code)
+	assert.Equal(t, 3, dStore.lookups)
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireScope only lets through requests whose key has all the scopes, it goes after the auth middleware
+func RequireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		held := c.GetStringSlice("scopes")
+		for _, scope := range scopes {
+			if !slices.Contains(held, scope) {
+				c.JSON(403, gin.H{"errorMessage": "access key is missing the " + scope + " scope"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRequireScope(t *testing.T) {
+	router, dStore := newAuthRouter(t, nil)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	_, err = dStore.InsertAccessKey(1, "limited", string(hash), service.AUTH_SCHEME_HEADER, "", "", []string{service.SCOPE_UPLOAD}, 0)
+	assert.NoError(t, err)
+
+	router.GET("/api/v1/upload", RequireScope(service.SCOPE_UPLOAD), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.GET("/api/v1/keys", RequireScope(service.ALL_SCOPES...), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tt := []struct {
+		name          string
+		accessKey     string
+		path          string
+		expStatusCode int
+		expResponse   string
+	}{
+		{name: "key with the scope", accessKey: "limited", path: "/api/v1/upload", expStatusCode: 200},
+		{name: "key with all the scopes", accessKey: "access", path: "/api/v1/keys", expStatusCode: 200},
+		{
+			name:          "key missing the scope",
+			accessKey:     "limited",
+			path:          "/api/v1/keys",
+			expStatusCode: 403,
+			expResponse:   `{"errorMessage": "access key is missing the face_match scope"}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tc.path, nil)
+			req.Header.Set("accessKey", tc.accessKey)
+			req.Header.Set("secretKey", "secret")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expStatusCode, w.Code)
+			if tc.expResponse != "" {
+				assert.JSONEq(t, tc.expResponse, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+const SIGNATURE_ALGORITHM = "EKYC-HMAC-SHA256"
+const TIMESTAMP_HEADER = "X-Ekyc-Timestamp"
+const NONCE_HEADER = "X-Ekyc-Nonce"
+const SIGNATURE_HEADER = "X-Ekyc-Signature"
+const NONCE_CACHE_PREFIX = "nonce:"
+const DEFAULT_SIGNATURE_MAX_SKEW = 5 * time.Minute
+const MAX_NONCE_LENGTH = 64
+const MAX_SIGNED_BODY_SIZE = 32 << 20
+
+var ErrSignatureExpired = errors.New("request timestamp is missing or too far from the server time")
+var ErrInvalidNonce = errors.New("request nonce is missing or longer than 64 characters")
+var ErrNonceReused = errors.New("request nonce was already used")
+var ErrInvalidSignature = errors.New("invalid request signature")
+var ErrSignedBodyTooLarge = errors.New("signed request body is too large")
+
+// SignatureVerifier checks requests signed with the secret key of their access key, so the
+// secret key itself never goes over the wire. The signature is the hex HMAC-SHA256, keyed
+// with the secret key, of the request as put together by stringToSign.
+// Requests are only accepted within the clock skew of their timestamp, and every nonce
+// only once, so captured requests can't be replayed.
+type SignatureVerifier struct {
+	secrets *service.SecretBox
+	nonces  store.CacheStore
+	maxSkew time.Duration
+
+	now func() time.Time
+}
+
+// NewSignatureVerifier returns a verifier opening the signing secrets with secrets,
+// and keeping the used nonces in nonces
+func NewSignatureVerifier(secrets *service.SecretBox, nonces store.CacheStore, maxSkew time.Duration) *SignatureVerifier {
+	if maxSkew <= 0 {
+		maxSkew = DEFAULT_SIGNATURE_MAX_SKEW
+	}
+
+	return &SignatureVerifier{
+		secrets: secrets,
+		nonces:  nonces,
+		maxSkew: maxSkew,
+		now:     time.Now,
+	}
+}
+
+// Verify checks the signature of the request against the signing secret of the client
+func (sv *SignatureVerifier) Verify(c *gin.Context, client *types.ClientData) error {
+	timestamp := c.GetHeader(TIMESTAMP_HEADER)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignatureExpired
+	}
+	skew := sv.now().Sub(time.Unix(unix, 0))
+	if skew > sv.maxSkew || skew < -sv.maxSkew {
+		return ErrSignatureExpired
+	}
+
+	nonce := c.GetHeader(NONCE_HEADER)
+	if len(nonce) == 0 || len(nonce) > MAX_NONCE_LENGTH {
+		return ErrInvalidNonce
+	}
+
+	body, err := readBody(c.Request, MAX_SIGNED_BODY_SIZE, ErrSignedBodyTooLarge)
+	if err != nil {
+		return err
+	}
+
+	secretKey, err := sv.secrets.Open(client.SigningSecret)
+	if err != nil {
+		return fmt.Errorf("error while opening the signing secret: %w", err)
+	}
+
+	signature, err := hex.DecodeString(c.GetHeader(SIGNATURE_HEADER))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	expected := sign(secretKey, stringToSign(c.Request, body, timestamp, nonce, client.AccessKey))
+	if !hmac.Equal(signature, expected) {
+		return ErrInvalidSignature
+	}
+
+	// the nonce is only taken once the signature checks out, so it can't be burnt by anyone else.
+	// Timestamps are accepted for maxSkew either way, so the nonce is kept for twice that.
+	taken, err := sv.nonces.SetObjectIfNotExists(NONCE_CACHE_PREFIX+client.AccessKey+":"+nonce, timestamp, 2*sv.maxSkew)
+	if err != nil {
+		return fmt.Errorf("error while taking the nonce: %w", err)
+	}
+	if !taken {
+		return ErrNonceReused
+	}
+
+	return nil
+}
+
+// SignRequest signs the request with the key pair, for clients of keys signing their requests.
+// The body is read and put back, so it must be set before signing.
+func SignRequest(req *http.Request, accessKey, secretKey string) error {
+	body, err := readBody(req, MAX_SIGNED_BODY_SIZE, ErrSignedBodyTooLarge)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("accessKey", accessKey)
+	req.Header.Set(TIMESTAMP_HEADER, timestamp)
+	req.Header.Set(NONCE_HEADER, hex.EncodeToString(nonce))
+	signature := sign(secretKey, stringToSign(req, body, timestamp, req.Header.Get(NONCE_HEADER), accessKey))
+	req.Header.Set(SIGNATURE_HEADER, hex.EncodeToString(signature))
+
+	return nil
+}
+
+// stringToSign puts the parts of the request covered by the signature together, one a line:
+// the algorithm, method, escaped path, query, hex sha256 of the body, timestamp, nonce and access key
+func stringToSign(req *http.Request, body []byte, timestamp, nonce, accessKey string) string {
+	bodyHash := sha256.Sum256(body)
+
+	return strings.Join([]string{
+		SIGNATURE_ALGORITHM,
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(), // sorted by key, repeated keys keep their order
+		hex.EncodeToString(bodyHash[:]),
+		timestamp,
+		nonce,
+		accessKey,
+	}, "\n")
+}
+
+func sign(secretKey, toSign string) []byte {
+	h := hmac.New(sha256.New, []byte(secretKey))
+	h.Write([]byte(toSign))
+	return h.Sum(nil)
+}
+
+// readBody reads the whole body of the request and puts it back for the handlers,
+// returning tooLarge when it's bigger than maxSize
+func readBody(req *http.Request, maxSize int64, tooLarge error) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxSize+1))
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, tooLarge
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newSignedRouter signs up a client with a header key ("access", "secret") and a signing key
+// ("signer", "signing-secret"), and serves a route echoing the body behind the auth middleware
+func newSignedRouter(t *testing.T) (*gin.Engine, *SignatureVerifier) {
+	secrets, err := service.NewSecretBox([]byte("key"))
+	assert.NoError(t, err)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	signingHash, err := bcrypt.GenerateFromPassword([]byte("signing-secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	sealed, err := secrets.Seal("signing-secret")
+	assert.NoError(t, err)
+
+	dStore := service.NewMemoryStore()
+	err = dStore.InsertClientData(1, types.SignupPayload{Name: "test", Email: "test@example.com", Plan: "basic"}, "access", string(hash))
+	assert.NoError(t, err)
+	_, err = dStore.InsertAccessKey(1, "signer", string(signingHash), service.AUTH_SCHEME_SIGNATURE, sealed, "", service.ALL_SCOPES, 0)
+	assert.NoError(t, err)
+
+	signatures := NewSignatureVerifier(secrets, service.NewMemoryCacheStore(), time.Minute)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(NewAuthMiddleware(&AuthMiddlewareConfig{
+		DataStore:  dStore,
+		Signatures: signatures,
+	}).Middleware())
+	router.POST("/api/v1/jobs", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+
+	return router, signatures
+}
+
+func newSignedRequest(t *testing.T, accessKey, secretKey, body string) *http.Request {
+	req, _ := http.NewRequest("POST", "/api/v1/jobs?b=2&a=1", bytes.NewBufferString(body))
+	assert.NoError(t, SignRequest(req, accessKey, secretKey))
+	return req
+}
+
+func serve(router *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestSignedRequests(t *testing.T) {
+	router, _ := newSignedRouter(t)
+
+	// the handlers still get the body that was signed
+	w := serve(router, newSignedRequest(t, "signer", "signing-secret", `{"a": 1}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"a": 1}`, w.Body.String())
+
+	tt := []struct {
+		name       string
+		req        func() *http.Request
+		expCode    int
+		expMessage string
+	}{
+		{
+			name:       "wrong secret key",
+			req:        func() *http.Request { return newSignedRequest(t, "signer", "wrong", "") },
+			expCode:    http.StatusUnauthorized,
+			expMessage: "invalid request signature",
+		},
+		{
+			name: "tampered body",
+			req: func() *http.Request {
+				req := newSignedRequest(t, "signer", "signing-secret", `{"a": 1}`)
+				req.Body = io.NopCloser(bytes.NewBufferString(`{"a": 2}`))
+				return req
+			},
+			expCode:    http.StatusUnauthorized,
+			expMessage: "invalid request signature",
+		},
+		{
+			name: "tampered query",
+			req: func() *http.Request {
+				req := newSignedRequest(t, "signer", "signing-secret", "")
+				req.URL.RawQuery = "a=1&b=3"
+				return req
+			},
+			expCode:    http.StatusUnauthorized,
+			expMessage: "invalid request signature",
+		},
+		{
+			name: "missing nonce",
+			req: func() *http.Request {
+				req := newSignedRequest(t, "signer", "signing-secret", "")
+				req.Header.Del(NONCE_HEADER)
+				return req
+			},
+			expCode:    http.StatusUnauthorized,
+			expMessage: "request nonce is missing or longer than 64 characters",
+		},
+		{
+			name:       "header key signing its request",
+			req:        func() *http.Request { return newSignedRequest(t, "access", "secret", "") },
+			expCode:    http.StatusUnauthorized,
+			expMessage: "access key doesn't sign its requests, send the secret key instead",
+		},
+		{
+			name: "signing key sending its secret key",
+			req: func() *http.Request {
+				req, _ := http.NewRequest("POST", "/api/v1/jobs", nil)
+				req.Header.Set("accessKey", "signer")
+				req.Header.Set("secretKey", "signing-secret")
+				return req
+			},
+			expCode:    http.StatusUnauthorized,
+			expMessage: "access key requires signed requests",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(router, tc.req())
+			assert.Equal(t, tc.expCode, w.Code)
+			assert.JSONEq(t, `{"errorMessage": "`+tc.expMessage+`"}`, w.Body.String())
+		})
+	}
+}
+
+func TestSignedRequestReplay(t *testing.T) {
+	router, _ := newSignedRouter(t)
+	req := newSignedRequest(t, "signer", "signing-secret", "body")
+	replay := req.Clone(req.Context())
+	replay.Body = io.NopCloser(bytes.NewBufferString("body"))
+
+	assert.Equal(t, http.StatusOK, serve(router, req).Code)
+	w := serve(router, replay)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"errorMessage": "request nonce was already used"}`, w.Body.String())
+}
+
+func TestSignedRequestClockSkew(t *testing.T) {
+	router, signatures := newSignedRouter(t)
+	now := time.Now()
+	signatures.now = func() time.Time { return now }
+
+	tt := []struct {
+		name    string
+		offset  time.Duration
+		expCode int
+	}{
+		{name: "within skew behind", offset: -50 * time.Second, expCode: http.StatusOK},
+		{name: "within skew ahead", offset: 50 * time.Second, expCode: http.StatusOK},
+		{name: "too old", offset: -2 * time.Minute, expCode: http.StatusUnauthorized},
+		{name: "too far ahead", offset: 2 * time.Minute, expCode: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// the request is signed by a client whose clock is off by the offset
+			req := newSignedRequest(t, "signer", "signing-secret", "")
+			signed, _ := strconv.ParseInt(req.Header.Get(TIMESTAMP_HEADER), 10, 64)
+			now = time.Unix(signed, 0).Add(-tc.offset)
+
+			w := serve(router, req)
+			assert.Equal(t, tc.expCode, w.Code)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/handler"
@@ -19,7 +20,23 @@ type Server struct {
 	db    store.DataStore
 	minio store.FileStore
 	redis store.CacheStore
+	limit store.RateLimitStore
 	queue service.TaskQueue
+
+	credentialKey []byte
+	credentialTTL time.Duration
+
+	signingKey       []byte
+	signatureMaxSkew time.Duration
+
+	tokenKeys  map[string]string
+	tokenKeyID string
+	tokenTTL   time.Duration
+	refreshTTL time.Duration
+
+	idempotencyTTL   time.Duration
+	uploadSessionTTL time.Duration
+	presignTTL       time.Duration
 }
 
 type ServerConfig struct {
@@ -28,6 +45,36 @@ type ServerConfig struct {
 	FileStore  store.FileStore
 	CacheStore store.CacheStore
 	Queue      service.TaskQueue
+
+	// rate limits and quotas of the plans are enforced when it's set
+	RateLimitStore store.RateLimitStore
+
+	// verified credentials are kept in the cache store for CredentialTTL, hashed with CredentialKey.
+	// A zero ttl turns the cache off.
+	CredentialKey []byte
+	CredentialTTL time.Duration
+
+	// keys signing their requests can be used when SigningKey is set, their secret keys are sealed with it.
+	// Signed requests are accepted up to SignatureMaxSkew away from the server time.
+	SigningKey       []byte
+	SignatureMaxSkew time.Duration
+
+	// access tokens can be issued when TokenKeys is set, signed with the key of TokenKeyID and valid for TokenTTL.
+	// The other keys are only used to verify tokens, so the signing key can be rotated.
+	// Refresh tokens are valid for RefreshTTL.
+	TokenKeys  map[string]string
+	TokenKeyID string
+	TokenTTL   time.Duration
+	RefreshTTL time.Duration
+
+	// responses of requests sent with an Idempotency-Key header are replayed to their retries for IdempotencyTTL
+	IdempotencyTTL time.Duration
+
+	// chunked uploads not completed within UploadSessionTTL are dropped
+	UploadSessionTTL time.Duration
+
+	// presigned urls to put and get files straight to and from the file store are valid for PresignTTL
+	PresignTTL time.Duration
 }
 
 func New(serverConfig *ServerConfig) *Server {
@@ -36,7 +83,23 @@ func New(serverConfig *ServerConfig) *Server {
 		db:    serverConfig.DataStore,
 		minio: serverConfig.FileStore,
 		redis: serverConfig.CacheStore,
+		limit: serverConfig.RateLimitStore,
 		queue: serverConfig.Queue,
+
+		credentialKey: serverConfig.CredentialKey,
+		credentialTTL: serverConfig.CredentialTTL,
+
+		signingKey:       serverConfig.SigningKey,
+		signatureMaxSkew: serverConfig.SignatureMaxSkew,
+
+		tokenKeys:  serverConfig.TokenKeys,
+		tokenKeyID: serverConfig.TokenKeyID,
+		tokenTTL:   serverConfig.TokenTTL,
+		refreshTTL: serverConfig.RefreshTTL,
+
+		idempotencyTTL:   serverConfig.IdempotencyTTL,
+		uploadSessionTTL: serverConfig.UploadSessionTTL,
+		presignTTL:       serverConfig.PresignTTL,
 	}
 }
 
@@ -51,7 +114,39 @@ func (s *Server) Run() {
 	// endpoint for health check
 	unprotectedRouter.GET("/health", HealthCheckHandler)
 
-	authMiddleware := middleware.NewAuthMiddleware(s.db)
+	var limiter *middleware.RateLimiter
+	if s.limit != nil {
+		limiter = middleware.NewRateLimiter(s.db, s.limit)
+	}
+	var credentials *service.CredentialCache
+	if s.credentialTTL > 0 {
+		credentials = service.NewCredentialCache(s.redis, s.credentialKey, s.credentialTTL)
+	}
+	var secrets *service.SecretBox
+	var signatures *middleware.SignatureVerifier
+	if len(s.signingKey) > 0 {
+		var err error
+		secrets, err = service.NewSecretBox(s.signingKey)
+		if err != nil {
+			log.Fatalf("Error while creating the secret box: %v", err)
+		}
+		signatures = middleware.NewSignatureVerifier(secrets, s.redis, s.signatureMaxSkew)
+	}
+	var tokens *service.TokenSigner
+	if len(s.tokenKeys) > 0 {
+		var err error
+		tokens, err = service.NewTokenSigner(s.tokenKeys, s.tokenKeyID, s.tokenTTL)
+		if err != nil {
+			log.Fatalf("Error while creating the token signer: %v", err)
+		}
+	}
+	authMiddleware := middleware.NewAuthMiddleware(&middleware.AuthMiddlewareConfig{
+		DataStore:   s.db,
+		Limiter:     limiter,
+		Credentials: credentials,
+		Signatures:  signatures,
+		Tokens:      tokens,
+	})
 	protectedRouter := router.Group("/api/v1")
 	protectedRouter.Use(authMiddleware.Middleware())
 
@@ -69,11 +164,20 @@ func (s *Server) Run() {
 		OCR:        dummyOcr,
 		Queue:      s.queue,
 		UUID:       uuid,
+
+		Credentials: credentials,
+		Secrets:     secrets,
+		Tokens:      tokens,
+		RefreshTTL:  s.refreshTTL,
+
+		UploadSessionTTL: s.uploadSessionTTL,
+		PresignTTL:       s.presignTTL,
 	}
 	service := service.NewService(serviceConfig)
 	handler := handler.NewHandler(service)
 	handler.RegisterRoutes(unprotectedRouter)
-	handler.RegisterProtectedRoutes(protectedRouter)
+	idempotency := middleware.NewIdempotency(s.db, s.idempotencyTTL)
+	handler.RegisterProtectedRoutes(protectedRouter, idempotency.Middleware())
 
 	log.Println("Server listening on", s.addr)
 	if err := http.ListenAndServe(s.addr, router); err != nil {
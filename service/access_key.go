@@ -0,0 +1,133 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+const MAX_ACCESS_KEY_LABEL_LENGTH = 50
+const MAX_ACCESS_KEY_EXPIRY_DAYS = 3650
+
+// CreateAccessKey issues a new key pair to the client, so a leaked one can be rotated out.
+// The secret key is only ever returned here.
+func (c Service) CreateAccessKey(clientID int, payload types.AccessKeyPayload) (*types.AccessKeyResponse, error) {
+	if len(payload.Label) > MAX_ACCESS_KEY_LABEL_LENGTH {
+		return nil, ErrInvalidKeyLabel
+	}
+	if payload.ExpiresInDays < 0 || payload.ExpiresInDays > MAX_ACCESS_KEY_EXPIRY_DAYS {
+		return nil, ErrInvalidKeyExpiry
+	}
+	authScheme := payload.AuthScheme
+	if authScheme == "" {
+		authScheme = AUTH_SCHEME_HEADER
+	}
+	if authScheme != AUTH_SCHEME_HEADER && authScheme != AUTH_SCHEME_SIGNATURE {
+		return nil, ErrInvalidAuthScheme
+	}
+	if authScheme == AUTH_SCHEME_SIGNATURE && c.secrets == nil {
+		return nil, ErrSigningDisabled
+	}
+	scopes, err := keyScopes(payload.Scopes, payload.HeldScopes)
+	if err != nil {
+		return nil, err
+	}
+
+	// generate keys, sandbox clients keep getting sandbox ones
+	keyPair, err := c.keyService.GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	if payload.Sandbox {
+		keyPair.accessKey = SANDBOX_ACCESS_KEY_PREFIX + keyPair.accessKey
+	}
+
+	// the server needs the secret key itself to check signatures, so it's kept sealed
+	var signingSecret string
+	if authScheme == AUTH_SCHEME_SIGNATURE {
+		signingSecret, err = c.secrets.Seal(keyPair.secretKey)
+		if err != nil {
+			log.Printf("Error while sealing the signing secret: %s\n", err.Error())
+			return nil, err
+		}
+	}
+
+	expiresIn := time.Duration(payload.ExpiresInDays) * 24 * time.Hour
+	key, err := c.dataStore.InsertAccessKey(clientID, keyPair.accessKey, keyPair.GetSecretKeyHash(), authScheme, signingSecret, payload.Label, scopes, expiresIn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.AccessKeyResponse{
+		ID:         key.ID,
+		AccessKey:  keyPair.accessKey,
+		SecretKey:  keyPair.secretKey,
+		Label:      key.Label,
+		AuthScheme: key.AuthScheme,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+	}, nil
+}
+
+// keyScopes returns the scopes asked for in the order of ALL_SCOPES, or the held ones when none are.
+// Keys can't be given scopes the key creating them doesn't have, so a limited key can't create an unlimited one.
+func keyScopes(requested, held []string) ([]string, error) {
+	if len(requested) == 0 {
+		requested = held
+	}
+	for _, scope := range requested {
+		if !slices.Contains(ALL_SCOPES, scope) {
+			return nil, ErrInvalidScope
+		}
+		if !slices.Contains(held, scope) {
+			return nil, ErrScopeNotHeld
+		}
+	}
+
+	scopes := []string{}
+	for _, scope := range ALL_SCOPES {
+		if slices.Contains(requested, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+func (c Service) ListAccessKeys(clientID int) ([]*types.AccessKey, error) {
+	keys, err := c.dataStore.ListAccessKeys(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = []*types.AccessKey{}
+	}
+
+	return keys, nil
+}
+
+// RevokeAccessKey stops the key from working right away, it's still listed afterwards
+func (c Service) RevokeAccessKey(clientID, keyID int) error {
+	accessKey, err := c.dataStore.RevokeAccessKey(clientID, keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAccessKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// drop the verified credentials, otherwise the key keeps working until they expire
+	if c.credentials != nil {
+		err = c.credentials.Invalidate(accessKey)
+		if err != nil {
+			log.Printf("Error while dropping the cached credentials of revoked key (%d): %s\n", keyID, err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+	"github.com/redis/go-redis/v9"
+)
+
+const CREDENTIAL_CACHE_PREFIX = "auth:"
+
+// CredentialCache remembers verified credentials for a short while, so the secret key
+// isn't checked against its bcrypt hash on every request.
+// Entries are kept under the access key, so they can be dropped when the key is revoked,
+// and hold a keyed hash of the access and secret key rather than the secret key itself.
+type CredentialCache struct {
+	store store.CacheStore
+	key   []byte
+	ttl   time.Duration
+}
+
+type cachedCredential struct {
+	MAC    string           `json:"mac"`
+	Client types.ClientData `json:"client"`
+}
+
+// NewCredentialCache returns a cache keeping credentials in cacheStore for up to ttl, hashed with key.
+// Servers sharing the cache store must share the key too.
+func NewCredentialCache(cacheStore store.CacheStore, key []byte, ttl time.Duration) *CredentialCache {
+	return &CredentialCache{
+		store: cacheStore,
+		key:   key,
+		ttl:   ttl,
+	}
+}
+
+// Get returns the client of the credentials, when they were verified within the ttl
+func (cc *CredentialCache) Get(accessKey, secretKey string) (*types.ClientData, bool) {
+	val, err := cc.store.GetObject(CREDENTIAL_CACHE_PREFIX + accessKey)
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Error while fetching credentials from cache (%s): %s\n", accessKey, err.Error())
+		}
+		return nil, false
+	}
+
+	var cached cachedCredential
+	err = json.Unmarshal([]byte(val), &cached)
+	if err != nil {
+		log.Printf("Error while unmarshaling cached credentials (%s): %s\n", accessKey, err.Error())
+		return nil, false
+	}
+
+	// a wrong secret key falls through to the bcrypt check
+	mac, err := hex.DecodeString(cached.MAC)
+	if err != nil || !hmac.Equal(mac, cc.mac(accessKey, secretKey)) {
+		return nil, false
+	}
+
+	return &cached.Client, true
+}
+
+// Set caches verified credentials, no longer than until their access key expires
+func (cc *CredentialCache) Set(accessKey, secretKey string, client *types.ClientData) {
+	ttl := cc.ttl
+	if client.KeyExpiresIn > 0 && client.KeyExpiresIn < ttl {
+		ttl = client.KeyExpiresIn
+	}
+
+	// the secret key hash isn't needed once the credentials are verified
+	cached := cachedCredential{
+		MAC:    hex.EncodeToString(cc.mac(accessKey, secretKey)),
+		Client: *client,
+	}
+	cached.Client.SecretKeyHash = ""
+
+	val, err := json.Marshal(cached)
+	if err != nil {
+		log.Printf("Error while marshaling credentials (%s): %s\n", accessKey, err.Error())
+		return
+	}
+
+	err = cc.store.SetObjectWithTTL(CREDENTIAL_CACHE_PREFIX+accessKey, string(val), ttl)
+	if err != nil {
+		log.Printf("Error while setting credentials in cache (%s): %s\n", accessKey, err.Error())
+	}
+}
+
+// Invalidate drops the cached credentials of the access key
+func (cc *CredentialCache) Invalidate(accessKey string) error {
+	return cc.store.DeleteObject(CREDENTIAL_CACHE_PREFIX + accessKey)
+}
+
+func (cc *CredentialCache) mac(accessKey, secretKey string) []byte {
+	h := hmac.New(sha256.New, cc.key)
+	h.Write([]byte(accessKey))
+	h.Write([]byte{0})
+	h.Write([]byte(secretKey))
+	return h.Sum(nil)
+}
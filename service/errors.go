@@ -9,9 +9,46 @@ var (
 	ErrInvalidPlan       = errors.New("invalid plan, supported plans are basic, advanced, or enterprise")
 	ErrInvalidFileType   = errors.New("invalid type, supported types are face or id_card")
 	ErrInvalidFileFormat = errors.New("invalid file format, supported formats are png or jpeg")
+	ErrContentMismatch   = errors.New("file content doesn't match its extension, png files must hold a png image and jpg or jpeg files a jpeg one")
+	ErrCorruptImage      = errors.New("file is not a valid image, it couldn't be decoded")
+	ErrInvalidImageSize  = errors.New("invalid image dimensions, width and height must be between 100 and 10000 pixels, and the image at most 40 megapixels")
+	ErrFileTooLarge      = errors.New("file is larger than the upload size limit of the plan")
 	ErrInvalidImgId      = errors.New("invalid or missing image id")
 	ErrNotFaceImg        = errors.New("not a face image")
 	ErrNotIDCardImg      = errors.New("not an id card image")
 	ErrInvalidJobId      = errors.New("invalid or missing job id")
 	ErrCacheNotFound     = errors.New("cache not found")
+	ErrInvalidJobType    = errors.New("invalid job type, supported types are face_match or ocr")
+	ErrInvalidJobStatus  = errors.New("invalid job status, supported statuses are created, processing, completed or failed")
+	ErrInvalidTimeRange  = errors.New("invalid time range, from and to must be RFC3339 timestamps with from before to")
+	ErrInvalidCursor     = errors.New("invalid or expired cursor")
+	ErrInvalidLimit      = errors.New("invalid limit, must be a number between 1 and 100")
+	ErrInvalidWebhookURL = errors.New("invalid webhook url, must be an absolute http or https url")
+	ErrWebhookNotFound   = errors.New("webhook not found")
+	ErrWebhookHost       = errors.New("invalid webhook url, the host must resolve to public addresses only")
+	ErrDeliveryNotFound  = errors.New("webhook delivery not found")
+	ErrInvalidKeyLabel   = errors.New("invalid label, must be at most 50 characters")
+	ErrInvalidKeyExpiry  = errors.New("invalid expiry, expires_in_days must be between 0 and 3650")
+	ErrAccessKeyNotFound = errors.New("access key not found or already revoked")
+	ErrInvalidAuthScheme = errors.New("invalid auth scheme, supported schemes are header or signature")
+	ErrInvalidScope      = errors.New("invalid scope, supported scopes are upload, face_match, ocr, results:read or reports:read")
+	ErrScopeNotHeld      = errors.New("a key can't be given scopes the key creating it doesn't have")
+	ErrSigningDisabled   = errors.New("signed requests are not enabled on this server")
+	ErrTokensDisabled    = errors.New("tokens are not enabled on this server")
+	ErrInvalidToken      = errors.New("invalid or expired token")
+	ErrInvalidRefresh    = errors.New("invalid or expired refresh token")
+	ErrTokenForToken     = errors.New("tokens can't be issued for a token, use the refresh token instead")
+	ErrRetriesExhausted  = errors.New("job retries exhausted, message dead lettered")
+	ErrQueueClosed       = errors.New("queue closed")
+
+	ErrInvalidFileName        = errors.New("invalid file_name, must be at most 255 characters")
+	ErrInvalidFileSize        = errors.New("invalid file_size, must be a positive number of bytes")
+	ErrUploadSessionNotFound  = errors.New("upload session not found or expired")
+	ErrInvalidChunkNumber     = errors.New("invalid chunk number, must be between 1 and the chunk count of the session")
+	ErrInvalidChunkSize       = errors.New("invalid chunk size, every chunk but the last one must be chunk_size bytes, the last one holds the rest of the file")
+	ErrUploadIncomplete       = errors.New("upload is missing chunks, they must all be uploaded before completing it")
+	ErrUploadSessionCompleted = errors.New("upload session is already completed")
+
+	ErrPresignedUploadNotFound = errors.New("no file was put with the presigned url of this upload, or it expired")
+	ErrUploadNotFound          = errors.New("upload not found")
 )
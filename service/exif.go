@@ -0,0 +1,196 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+const JPEG_QUALITY = 95
+
+const EXIF_ORIENTATION_TAG = 0x0112
+
+// normalizeImage turns the image upright as told by its EXIF orientation, and re-encodes it in the same format.
+// Only the pixels are encoded again, so everything else the file held, like GPS location or device serials,
+// is left out. img is the image decoded from data in the given format, data is decoded here when it's nil.
+// It returns the re-encoded file along with its width and height.
+func normalizeImage(data []byte, img image.Image, format string) (*bytes.Buffer, int, int, error) {
+	if img == nil {
+		var err error
+		img, format, err = image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, 0, 0, ErrCorruptImage
+		}
+	}
+	img = orientImage(img, exifOrientation(data, format))
+
+	var buf bytes.Buffer
+	var err error
+	if format == types.IMAGE_FORMAT_PNG {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: JPEG_QUALITY})
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return &buf, img.Bounds().Dx(), img.Bounds().Dy(), nil
+}
+
+// exifOrientation returns the EXIF orientation of the jpeg or png image, 1 when it has none.
+// Jpeg images keep their EXIF data in an APP1 segment, png ones in an eXIf chunk.
+func exifOrientation(data []byte, format string) int {
+	var tiff []byte
+	if format == types.IMAGE_FORMAT_PNG {
+		tiff = pngExif(data)
+	} else {
+		tiff = jpegExif(data)
+	}
+
+	orientation := tiffOrientation(tiff)
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// jpegExif returns the TIFF data held by the Exif APP1 segment of the jpeg image, nil when there's none
+func jpegExif(data []byte) []byte {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// fill byte before a marker
+			i++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// markers without a segment
+			i += 2
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// the metadata segments all come before the scan
+			return nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i = end
+	}
+
+	return nil
+}
+
+// pngExif returns the TIFF data held by the eXIf chunk of the png image, nil when there's none
+func pngExif(data []byte) []byte {
+	const signatureLength = 8
+	for i := signatureLength; i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 8 + length
+		if end+4 > len(data) {
+			return nil
+		}
+		switch chunkType {
+		case "eXIf":
+			return data[i+8 : end]
+		case "IEND":
+			return nil
+		}
+		i = end + 4 // skipping the CRC
+	}
+
+	return nil
+}
+
+// tiffOrientation returns the orientation tag of the first IFD of the TIFF data, 0 when it has none
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		// the orientation is a single SHORT, held in the value field of its entry
+		if order.Uint16(tiff[entry:]) == EXIF_ORIENTATION_TAG {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 0
+}
+
+// orientImage returns the image turned upright from the EXIF orientation it was taken with
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation == 1 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// orientations 5 to 8 were taken sideways, so the width and height are swapped
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	// pixel of the image every pixel of the upright one is taken from, along with how the image is turned
+	source := map[int]func(x, y int) (int, int){
+		2: func(x, y int) (int, int) { return w - 1 - x, y },         // flipped horizontally
+		3: func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }, // rotated 180°
+		4: func(x, y int) (int, int) { return x, h - 1 - y },         // flipped vertically
+		5: func(x, y int) (int, int) { return y, x },                 // transposed
+		6: func(x, y int) (int, int) { return y, h - 1 - x },         // rotated 90° clockwise
+		7: func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }, // transversed
+		8: func(x, y int) (int, int) { return w - 1 - y, x },         // rotated 90° counter clockwise
+	}[orientation]
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			sx, sy := source(x, y)
+			dst.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+
+	return dst
+}
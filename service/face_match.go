@@ -1,17 +1,18 @@
 package service
 
 import (
+	"context"
 	"math/rand"
 
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 )
 
 type FaceMatcher interface {
-	PerformFaceMatch(payload types.FaceMatchPayload) (int, error)
+	PerformFaceMatch(ctx context.Context, image1, image2 *types.ImageData) (int, error)
 }
 
 type DummyFaceMatchService struct{}
 
-func (d *DummyFaceMatchService) PerformFaceMatch(payload types.FaceMatchPayload) (int, error) {
+func (d *DummyFaceMatchService) PerformFaceMatch(ctx context.Context, image1, image2 *types.ImageData) (int, error) {
 	return rand.Intn(100) + 1, nil
 }
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/db"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+)
+
+const (
+	FILE_STORE_MINIO = "minio"
+	FILE_STORE_LOCAL = "local"
+)
+
+type FileStoreConfig struct {
+	// minio connection and bucket, for the minio backend
+	Minio  *db.MinioConn
+	Bucket string
+
+	// directory the files are kept under, for the local backend
+	Root string
+}
+
+// NewFileStore returns the file store by backend name
+func NewFileStore(backend string, config *FileStoreConfig) (store.FileStore, error) {
+	switch backend {
+	case FILE_STORE_MINIO:
+		if config.Minio.Endpoint == "" || config.Bucket == "" {
+			return nil, fmt.Errorf("endpoint or bucket of the %s file store is missing", backend)
+		}
+		return NewMinioStore(config.Minio, config.Bucket), nil
+	case FILE_STORE_LOCAL:
+		if config.Root == "" {
+			return nil, fmt.Errorf("root directory of the %s file store is missing", backend)
+		}
+		return NewLocalFileStore(config.Root)
+	default:
+		return nil, fmt.Errorf("unknown file store backend %q", backend)
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+const MIN_IMAGE_DIMENSION = 100
+const MAX_IMAGE_DIMENSION = 10000
+
+// MAX_IMAGE_PIXELS caps the memory a decoded image takes, a 10000x10000 one would take up to 400 MB
+const MAX_IMAGE_PIXELS = 40_000_000
+
+// DEFAULT_MAX_UPLOAD_BYTES applies to the plans without a limit of their own
+const DEFAULT_MAX_UPLOAD_BYTES = 10 << 20
+
+// sniffed content types of the supported formats
+var imageContentTypes = map[string]string{
+	"image/png":  types.IMAGE_FORMAT_PNG,
+	"image/jpeg": types.IMAGE_FORMAT_JPEG,
+}
+
+// formats the content of the files of every supported extension must have
+var extImageFormats = map[string]string{
+	types.VALID_FORMAT_PNG:  types.IMAGE_FORMAT_PNG,
+	types.VALID_FORMAT_JPEG: types.IMAGE_FORMAT_JPEG,
+	types.VALID_FORMAT_JPG:  types.IMAGE_FORMAT_JPEG,
+}
+
+// decodeImage checks the content of the file named fileName is an image in the format of its extension,
+// within the supported dimensions, and returns the decoded image and its format.
+// The format is sniffed from the magic bytes, and the dimensions are read from the header
+// before the whole image is decoded, so images too large to decode are rejected early.
+func decodeImage(file io.ReadSeeker, fileName string) (image.Image, string, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, "", ErrCorruptImage
+	}
+	format, ok := imageContentTypes[http.DetectContentType(header[:n])]
+	if !ok || format != extImageFormats[filepath.Ext(fileName)] {
+		return nil, "", ErrContentMismatch
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, "", ErrCorruptImage
+	}
+	if !validImageDimension(config.Width) || !validImageDimension(config.Height) || config.Width*config.Height > MAX_IMAGE_PIXELS {
+		return nil, "", ErrInvalidImageSize
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, "", ErrCorruptImage
+	}
+
+	return img, format, nil
+}
+
+func validImageDimension(pixels int) bool {
+	return pixels >= MIN_IMAGE_DIMENSION && pixels <= MAX_IMAGE_DIMENSION
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"io"
 	"mime/multipart"
 
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
@@ -8,11 +9,33 @@ import (
 
 type ServiceManager interface {
 	SignupClient(payload types.SignupPayload) (*KeyPair, error)
-	ValidateFile(fileName, fileType string) error
-	SaveFile(fileHeader *multipart.FileHeader, uploadMetaData *types.UploadMetaData) error
+	ValidateFile(fileHeader *multipart.FileHeader, uploadMetaData *types.UploadMetaData, planID int) error
+	SaveFile(fileHeader *multipart.FileHeader, uploadMetaData *types.UploadMetaData) (*types.FileUploadResponse, error)
+	CreateUploadSession(clientID, planID int, payload types.UploadSessionPayload) (*types.UploadSession, error)
+	GetUploadSession(clientID int, sessionID string) (*types.UploadSession, error)
+	UploadChunk(clientID int, sessionID string, number int, content io.Reader) (*types.UploadChunk, error)
+	CompleteUploadSession(clientID, planID int, sessionID string, keepOriginal bool) (*types.FileUploadResponse, error)
+	AbortUploadSession(clientID int, sessionID string) error
+	PresignUpload(clientID int, payload types.PresignedUploadPayload) (*types.PresignedUploadResponse, error)
+	ConfirmPresignedUpload(clientID, planID int, imgUuid string, payload types.PresignedUploadPayload, keepOriginal bool) (*types.FileUploadResponse, error)
+	PresignDownload(clientID int, imgUuid string) (*types.PresignedURLResponse, error)
+	ListUploads(clientID int, query types.UploadListQuery) (*types.UploadListResponse, error)
+	GetUpload(clientID int, imgUuid string) (*types.UploadResponse, error)
+	DeleteUpload(clientID int, imgUuid string) error
 	PerformFaceMatch(payload types.FaceMatchPayload, clientID int) (string, error)
 	PerformOCR(payload types.OCRPayload, clientID int) (string, error)
 	GetJobDetailsByJobID(jobID, jobType string) (*types.JobRecord, error)
+	ListJobs(clientID int, query types.JobListQuery) (*types.JobListResponse, error)
+	CreateAccessKey(clientID int, payload types.AccessKeyPayload) (*types.AccessKeyResponse, error)
+	ListAccessKeys(clientID int) ([]*types.AccessKey, error)
+	RevokeAccessKey(clientID, keyID int) error
+	IssueToken(client *types.ClientData) (*types.TokenResponse, error)
+	RefreshToken(payload types.RefreshTokenPayload) (*types.TokenResponse, error)
+	RegisterWebhook(clientID int, payload types.WebhookPayload) (*types.WebhookResponse, error)
+	ListWebhooks(clientID int) ([]*types.Webhook, error)
+	DeleteWebhook(clientID, webhookID int) error
+	ListWebhookDeliveries(clientID int) ([]*types.WebhookDelivery, error)
+	RedeliverWebhook(clientID, deliveryID int) error
 	FetchDataFromCache(payload interface{}, clientID int, jobType string) (string, bool)
 	SetDataInCache(payload interface{}, clientID int, jobType, jobID string)
 }
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+const JWT_ALGORITHM = "HS256"
+const JWT_ISSUER = "go-ekyc"
+const DEFAULT_TOKEN_TTL = 15 * time.Minute
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	PlanID    int    `json:"plan_id"`
+	Sandbox   bool   `json:"sandbox"`
+	KeyID     int    `json:"key_id"`
+	Scope     string `json:"scope"` // space separated, like OAuth scopes
+
+	KeepOriginalUploads bool `json:"keep_original_uploads,omitempty"`
+}
+
+// TokenSigner issues and verifies short-lived JWT access tokens, signed with HS256.
+// Tokens carry what the auth middleware needs about the client, so they're verified without a lookup.
+// Every signing key has an id, put in the kid header of the tokens: the active key signs new tokens,
+// while tokens signed with the other keys keep being accepted until they expire, so keys can be rotated.
+type TokenSigner struct {
+	keys      map[string][]byte
+	activeKID string
+	ttl       time.Duration
+
+	now func() time.Time
+}
+
+// NewTokenSigner returns a signer of tokens valid for ttl, signed with the key of activeKID out of keys
+func NewTokenSigner(keys map[string]string, activeKID string, ttl time.Duration) (*TokenSigner, error) {
+	if _, ok := keys[activeKID]; !ok {
+		return nil, fmt.Errorf("signing key %q not found among the token signing keys", activeKID)
+	}
+	if ttl <= 0 {
+		ttl = DEFAULT_TOKEN_TTL
+	}
+
+	signingKeys := make(map[string][]byte, len(keys))
+	for kid, key := range keys {
+		if len(key) == 0 {
+			return nil, fmt.Errorf("token signing key %q is empty", kid)
+		}
+		signingKeys[kid] = []byte(key)
+	}
+
+	return &TokenSigner{
+		keys:      signingKeys,
+		activeKID: activeKID,
+		ttl:       ttl,
+		now:       time.Now,
+	}, nil
+}
+
+// TTL returns how long tokens issued to the client are valid for, no longer than until its access key expires
+func (ts *TokenSigner) TTL(client *types.ClientData) time.Duration {
+	if client.KeyExpiresIn > 0 && client.KeyExpiresIn < ts.ttl {
+		return client.KeyExpiresIn
+	}
+	return ts.ttl
+}
+
+// Issue returns a token for the client, signed with the active key
+func (ts *TokenSigner) Issue(client *types.ClientData) (string, error) {
+	now := ts.now()
+	header, err := json.Marshal(jwtHeader{Algorithm: JWT_ALGORITHM, Type: "JWT", KeyID: ts.activeKID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(jwtClaims{
+		Issuer:    JWT_ISSUER,
+		Subject:   strconv.Itoa(client.Id),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ts.TTL(client)).Unix(),
+		PlanID:    client.PlanID,
+		Sandbox:   client.Sandbox,
+		KeyID:     client.KeyID,
+		Scope:     strings.Join(client.Scopes, " "),
+
+		KeepOriginalUploads: client.KeepOriginalUploads,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	signature := ts.sign(ts.keys[ts.activeKID], signingInput)
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks the signature and expiry of the token, and returns the client it was issued to
+func (ts *TokenSigner) Verify(token string) (*types.ClientData, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	// only HS256 is accepted, whatever the token claims, so it can't pick a weaker algorithm
+	var header jwtHeader
+	if err := decodeTokenPart(parts[0], &header); err != nil || header.Algorithm != JWT_ALGORITHM {
+		return nil, ErrInvalidToken
+	}
+	key, ok := ts.keys[header.KeyID]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, ts.sign(key, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims jwtClaims
+	if err := decodeTokenPart(parts[1], &claims); err != nil || claims.Issuer != JWT_ISSUER {
+		return nil, ErrInvalidToken
+	}
+	if !ts.now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, ErrInvalidToken
+	}
+	clientID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &types.ClientData{
+		Id:      clientID,
+		PlanID:  claims.PlanID,
+		Sandbox: claims.Sandbox,
+		KeyID:   claims.KeyID,
+		Scopes:  strings.Fields(claims.Scope),
+
+		KeepOriginalUploads: claims.KeepOriginalUploads,
+	}, nil
+}
+
+func (ts *TokenSigner) sign(key []byte, signingInput string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(signingInput))
+	return h.Sum(nil)
+}
+
+func decodeTokenPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
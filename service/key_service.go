@@ -12,6 +12,28 @@ import (
 
 const ACCESS_KEY_LENGTH = 10
 const SECRET_KEY_LENGTH = 20
+const WEBHOOK_SECRET_LENGTH = 32
+const REFRESH_TOKEN_LENGTH = 40
+const SANDBOX_ACCESS_KEY_PREFIX = "test_"
+const DEFAULT_ACCESS_KEY_LABEL = "default"
+
+// operations a key can be allowed, picked when the key is created
+const (
+	SCOPE_UPLOAD       = "upload"
+	SCOPE_FACE_MATCH   = "face_match"
+	SCOPE_OCR          = "ocr"
+	SCOPE_RESULTS_READ = "results:read"
+	SCOPE_REPORTS_READ = "reports:read"
+)
+
+// ALL_SCOPES are the scopes of the keys given at signup
+var ALL_SCOPES = []string{SCOPE_UPLOAD, SCOPE_FACE_MATCH, SCOPE_OCR, SCOPE_RESULTS_READ, SCOPE_REPORTS_READ}
+
+// schemes a key can authenticate requests with, picked when the key is created
+const (
+	AUTH_SCHEME_HEADER    = "header"    // the secret key is sent in the secretKey header
+	AUTH_SCHEME_SIGNATURE = "signature" // requests are signed with the secret key, see middleware.SignRequest
+)
 
 var ErrMissingAccessKey = errors.New("access key not found")
 var ErrMissingSecretKey = errors.New("secret key not found")
@@ -41,6 +63,8 @@ func (kp *KeyPair) GetSecretKeyHash() string {
 
 type KeyGenerator interface {
 	GenerateKeyPair() (*KeyPair, error)
+	GenerateWebhookSecret() (string, error)
+	GenerateRefreshToken() (string, error)
 }
 
 type KeyService struct{}
@@ -77,6 +101,26 @@ func (t KeyService) GenerateKeyPair() (*KeyPair, error) {
 	return keyPair, nil
 }
 
+func (t KeyService) GenerateWebhookSecret() (string, error) {
+	secret, err := t.generateRandomString(WEBHOOK_SECRET_LENGTH)
+	if err != nil {
+		log.Printf("Error while generating webhook secret: %v\n", err)
+		return "", fmt.Errorf("%w: %w", ErrGenKey, err)
+	}
+
+	return "whsec_" + secret, nil
+}
+
+func (t KeyService) GenerateRefreshToken() (string, error) {
+	token, err := t.generateRandomString(REFRESH_TOKEN_LENGTH)
+	if err != nil {
+		log.Printf("Error while generating refresh token: %v\n", err)
+		return "", fmt.Errorf("%w: %w", ErrGenKey, err)
+	}
+
+	return "rt_" + token, nil
+}
+
 func (t KeyService) generateRandomString(n int) (string, error) {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	var result []byte
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+// LocalFileStore keeps the files on disk under a root directory, for installs without an object store.
+// Under the root, files holds the files by their path, meta the content type of every file in a json sidecar,
+// uploads the parts of the multipart uploads in progress, and tmp the files being written.
+// Files are written to tmp and renamed into place, so readers never see a file half written.
+type LocalFileStore struct {
+	root string
+}
+
+// localFileMeta is the sidecar kept next to every file, and every multipart upload
+type localFileMeta struct {
+	ContentType string `json:"content_type"`
+}
+
+// NewLocalFileStore returns the store of the files under root, creating it if needed
+func NewLocalFileStore(root string) (*LocalFileStore, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{"files", "meta", "uploads", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o750); err != nil {
+			return nil, err
+		}
+	}
+
+	return &LocalFileStore{root: root}, nil
+}
+
+func (l *LocalFileStore) SaveFile(file *types.FileUpload) error {
+	dataPath, metaPath, err := l.paths(file.Name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.writeFile(dataPath, file.Content); err != nil {
+		return err
+	}
+	return l.writeMeta(metaPath, file.Headers["Content-Type"])
+}
+
+func (l *LocalFileStore) GetFile(filePath string) ([]byte, error) {
+	dataPath, _, err := l.paths(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(dataPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", store.ErrFileNotFound, filePath)
+	}
+	return data, err
+}
+
+func (l *LocalFileStore) DeleteFile(filePath string) error {
+	dataPath, metaPath, err := l.paths(filePath)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []string{dataPath, metaPath} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *LocalFileStore) StatFile(filePath string) (*types.FileInfo, error) {
+	dataPath, metaPath, err := l.paths(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(dataPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", store.ErrFileNotFound, filePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta, err := readMeta(metaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.FileInfo{Size: info.Size(), ContentType: meta.ContentType}, nil
+}
+
+// StartMultipartUpload keeps the parts of the upload in a directory of their own until it's completed
+func (l *LocalFileStore) StartMultipartUpload(filePath, contentType string) (string, error) {
+	if _, _, err := l.paths(filePath); err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+	if err := os.Mkdir(filepath.Join(l.root, "uploads", uploadID), 0o750); err != nil {
+		return "", err
+	}
+	if err := l.writeMeta(filepath.Join(l.root, "uploads", uploadID, "meta.json"), contentType); err != nil {
+		return "", err
+	}
+
+	return uploadID, nil
+}
+
+// SaveFilePart keeps the part, its etag is the hex MD5 of its content like with minio
+func (l *LocalFileStore) SaveFilePart(filePath, uploadID string, partNumber int, content io.Reader, size int64) (string, error) {
+	uploadDir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	hash := md5.New()
+	written, err := l.writeFile(filepath.Join(uploadDir, strconv.Itoa(partNumber)), io.TeeReader(content, hash))
+	if err != nil {
+		return "", err
+	}
+	if written != size {
+		return "", fmt.Errorf("part %d is %d bytes, expected %d", partNumber, written, size)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// CompleteMultipartUpload saves the file out of the given parts, in the order of their numbers
+func (l *LocalFileStore) CompleteMultipartUpload(filePath, uploadID string, parts []*types.UploadChunk) error {
+	dataPath, metaPath, err := l.paths(filePath)
+	if err != nil {
+		return err
+	}
+	uploadDir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return err
+	}
+
+	sorted := append([]*types.UploadChunk{}, parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Number < sorted[j].Number })
+	readers := make([]io.Reader, 0, len(sorted))
+	for _, part := range sorted {
+		data, err := os.ReadFile(filepath.Join(uploadDir, strconv.Itoa(part.Number)))
+		sum := md5.Sum(data)
+		if err != nil || hex.EncodeToString(sum[:]) != part.ETag {
+			return fmt.Errorf("part %d of multipart upload %s not found", part.Number, uploadID)
+		}
+		readers = append(readers, bytes.NewReader(data))
+	}
+
+	if _, err := l.writeFile(dataPath, io.MultiReader(readers...)); err != nil {
+		return err
+	}
+	meta, err := readMeta(filepath.Join(uploadDir, "meta.json"))
+	if err != nil {
+		return err
+	}
+	if err := l.writeMeta(metaPath, meta.ContentType); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(uploadDir)
+}
+
+func (l *LocalFileStore) AbortMultipartUpload(filePath, uploadID string) error {
+	uploadDir, err := l.uploadDir(uploadID)
+	if errors.Is(err, store.ErrFileNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(uploadDir)
+}
+
+// PresignPutURL isn't supported, there's nothing serving the files to sign urls for
+func (l *LocalFileStore) PresignPutURL(filePath, contentType string, expiresIn time.Duration) (string, error) {
+	return "", store.ErrPresignNotSupported
+}
+
+// PresignGetURL isn't supported, there's nothing serving the files to sign urls for
+func (l *LocalFileStore) PresignGetURL(filePath string, expiresIn time.Duration) (string, error) {
+	return "", store.ErrPresignNotSupported
+}
+
+// paths returns where the file with the given path and its sidecar are kept.
+// Paths are relative to the root and can't leave it, so absolute paths and .. elements are rejected.
+func (l *LocalFileStore) paths(filePath string) (string, string, error) {
+	if strings.Contains(filePath, `\`) || !filepath.IsLocal(filepath.FromSlash(filePath)) {
+		return "", "", fmt.Errorf("%w: %q", store.ErrInvalidFilePath, filePath)
+	}
+	for _, element := range strings.Split(filePath, "/") {
+		if element == ".." {
+			return "", "", fmt.Errorf("%w: %q", store.ErrInvalidFilePath, filePath)
+		}
+	}
+
+	name := filepath.Clean(filepath.FromSlash(filePath))
+	return filepath.Join(l.root, "files", name), filepath.Join(l.root, "meta", name+".json"), nil
+}
+
+// uploadDir returns the directory of the parts of the multipart upload, which must have been started
+func (l *LocalFileStore) uploadDir(uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", fmt.Errorf("multipart upload %s not found", uploadID)
+	}
+
+	uploadDir := filepath.Join(l.root, "uploads", uploadID)
+	if _, err := os.Stat(uploadDir); err != nil {
+		return "", fmt.Errorf("%w: multipart upload %s", store.ErrFileNotFound, uploadID)
+	}
+	return uploadDir, nil
+}
+
+// writeFile writes the content to a temp file, renamed to path once it's all written, and returns its size
+func (l *LocalFileStore) writeFile(path string, content io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(l.root, "tmp"), "file-*")
+	if err != nil {
+		return 0, err
+	}
+	// a no-op once the temp file is renamed
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return written, os.Rename(tmp.Name(), path)
+}
+
+func (l *LocalFileStore) writeMeta(path, contentType string) error {
+	data, err := json.Marshal(localFileMeta{ContentType: contentType})
+	if err != nil {
+		return err
+	}
+	_, err = l.writeFile(path, bytes.NewReader(data))
+	return err
+}
+
+// readMeta returns the sidecar at path, files saved without one have no content type
+func readMeta(path string) (*localFileMeta, error) {
+	var meta localFileMeta
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &meta, json.Unmarshal(data, &meta)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MemoryCacheStore keeps the cache in process memory, for local dev and tests.
+// Missing keys return redis.Nil like the redis store does.
+type MemoryCacheStore struct {
+	mu        sync.RWMutex
+	objects   map[string]string
+	expiresAt map[string]time.Time
+
+	now func() time.Time
+}
+
+func NewMemoryCacheStore() *MemoryCacheStore {
+	return &MemoryCacheStore{
+		objects:   map[string]string{},
+		expiresAt: map[string]time.Time{},
+		now:       time.Now,
+	}
+}
+
+func (m *MemoryCacheStore) GetObject(key string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	val, ok := m.objects[key]
+	if !ok {
+		return "", redis.Nil
+	}
+
+	// expired keys are left in place until they're set or deleted again
+	if expiresAt, ok := m.expiresAt[key]; ok && !m.now().Before(expiresAt) {
+		return "", redis.Nil
+	}
+
+	return val, nil
+}
+
+func (m *MemoryCacheStore) SetObject(key, val string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = val
+	delete(m.expiresAt, key)
+
+	return nil
+}
+
+func (m *MemoryCacheStore) SetObjectWithTTL(key, val string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = val
+	m.expiresAt[key] = m.now().Add(ttl)
+
+	return nil
+}
+
+func (m *MemoryCacheStore) SetObjectIfNotExists(key, val string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.objects[key]
+	if expiresAt, expiring := m.expiresAt[key]; ok && (!expiring || m.now().Before(expiresAt)) {
+		return false, nil
+	}
+	m.objects[key] = val
+	m.expiresAt[key] = m.now().Add(ttl)
+
+	return true, nil
+}
+
+func (m *MemoryCacheStore) DeleteObject(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	delete(m.expiresAt, key)
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+// MemoryFileStore keeps the uploaded files in process memory, for local dev and tests
+type MemoryFileStore struct {
+	mu           sync.RWMutex
+	files        map[string][]byte
+	contentTypes map[string]string
+
+	// parts of the multipart uploads in progress, by upload id and part number
+	uploads   map[string]map[int][]byte
+	uploadSeq int
+}
+
+func NewMemoryFileStore() *MemoryFileStore {
+	return &MemoryFileStore{
+		files:        map[string][]byte{},
+		contentTypes: map[string]string{},
+		uploads:      map[string]map[int][]byte{},
+	}
+}
+
+func (m *MemoryFileStore) SaveFile(file *types.FileUpload) error {
+	data, err := io.ReadAll(file.Content)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[file.Name] = data
+	m.contentTypes[file.Name] = file.Headers["Content-Type"]
+
+	return nil
+}
+
+func (m *MemoryFileStore) GetFile(filePath string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, ok := m.files[filePath]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", store.ErrFileNotFound, filePath)
+	}
+
+	return append([]byte{}, data...), nil
+}
+
+func (m *MemoryFileStore) DeleteFile(filePath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, filePath)
+	delete(m.contentTypes, filePath)
+
+	return nil
+}
+
+func (m *MemoryFileStore) StartMultipartUpload(filePath, contentType string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.uploadSeq++
+	uploadID := fmt.Sprintf("%s#%d", filePath, m.uploadSeq)
+	m.uploads[uploadID] = map[int][]byte{}
+
+	return uploadID, nil
+}
+
+// SaveFilePart keeps the part, its etag is the hex MD5 of its content like with minio
+func (m *MemoryFileStore) SaveFilePart(filePath, uploadID string, partNumber int, content io.Reader, size int64) (string, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) != size {
+		return "", fmt.Errorf("part %d is %d bytes, expected %d", partNumber, len(data), size)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parts, ok := m.uploads[uploadID]
+	if !ok {
+		return "", fmt.Errorf("multipart upload %s not found", uploadID)
+	}
+	parts[partNumber] = data
+
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CompleteMultipartUpload saves the file out of the given parts, in the order of their numbers
+func (m *MemoryFileStore) CompleteMultipartUpload(filePath, uploadID string, parts []*types.UploadChunk) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved, ok := m.uploads[uploadID]
+	if !ok {
+		return fmt.Errorf("multipart upload %s not found", uploadID)
+	}
+
+	sorted := append([]*types.UploadChunk{}, parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Number < sorted[j].Number })
+	var file []byte
+	for _, part := range sorted {
+		data, ok := saved[part.Number]
+		sum := md5.Sum(data)
+		if !ok || hex.EncodeToString(sum[:]) != part.ETag {
+			return fmt.Errorf("part %d of multipart upload %s not found", part.Number, uploadID)
+		}
+		file = append(file, data...)
+	}
+	m.files[filePath] = file
+	delete(m.uploads, uploadID)
+
+	return nil
+}
+
+func (m *MemoryFileStore) AbortMultipartUpload(filePath, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploads, uploadID)
+
+	return nil
+}
+
+// PresignPutURL returns a memory:// url, which can't be fetched: files are put with SaveFile instead
+func (m *MemoryFileStore) PresignPutURL(filePath, contentType string, expiresIn time.Duration) (string, error) {
+	return presignMemoryURL("PUT", filePath, expiresIn), nil
+}
+
+// PresignGetURL returns a memory:// url, which can't be fetched: files are read with GetFile instead
+func (m *MemoryFileStore) PresignGetURL(filePath string, expiresIn time.Duration) (string, error) {
+	return presignMemoryURL("GET", filePath, expiresIn), nil
+}
+
+func (m *MemoryFileStore) StatFile(filePath string) (*types.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, ok := m.files[filePath]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", store.ErrFileNotFound, filePath)
+	}
+
+	return &types.FileInfo{Size: int64(len(data)), ContentType: m.contentTypes[filePath]}, nil
+}
+
+func presignMemoryURL(method, filePath string, expiresIn time.Duration) string {
+	query := url.Values{}
+	query.Set("method", method)
+	query.Set("expires", fmt.Sprint(time.Now().Add(expiresIn).Unix()))
+	return (&url.URL{Scheme: "memory", Path: "/" + filePath, RawQuery: query.Encode()}).String()
+}
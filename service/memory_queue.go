@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryQueue is an in-process TaskQueue backed by a channel, for local dev and tests.
+// Retries and dead letters behave like RabbitMqQueue, but nothing survives a restart.
+type MemoryQueue struct {
+	jobs       chan *Message
+	done       chan struct{}
+	closeOnce  sync.Once
+	maxRetries int
+	retryDelay time.Duration
+
+	mu          sync.Mutex
+	deadLetters []DeadLetter
+}
+
+// NewMemoryQueue returns a queue holding up to size jobs, pushing blocks once it's full
+func NewMemoryQueue(size, maxRetries int, retryDelay time.Duration) *MemoryQueue {
+	return &MemoryQueue{
+		jobs:       make(chan *Message, size),
+		done:       make(chan struct{}),
+		maxRetries: maxRetries,
+		retryDelay: retryDelay,
+	}
+}
+
+func (q *MemoryQueue) PushJobOnQueue(payload []byte) error {
+	return q.push(payload, 0)
+}
+
+// PullJobFromQueue returns a channel of jobs, which is closed when the context is cancelled or the queue is closed.
+// Jobs are shared between all the pulled channels, like competing consumers of a broker.
+// Jobs are handed over one at a time, so prefetch has no effect.
+func (q *MemoryQueue) PullJobFromQueue(ctx context.Context, prefetch int) (<-chan *Message, error) {
+	messages := make(chan *Message)
+	go func() {
+		defer close(messages)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.done:
+				return
+			case msg := <-q.jobs:
+				select {
+				case messages <- msg:
+				case <-ctx.Done():
+					msg.Requeue()
+					return
+				case <-q.done:
+					return
+				}
+			}
+		}
+	}()
+
+	return messages, nil
+}
+
+// Close stops delivering jobs, pending jobs and retries are dropped
+func (q *MemoryQueue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.done)
+	})
+}
+
+// DeadLetters returns the jobs which were dead lettered so far
+func (q *MemoryQueue) DeadLetters() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	deadLetters := make([]DeadLetter, len(q.deadLetters))
+	copy(deadLetters, q.deadLetters)
+	return deadLetters
+}
+
+// ReplayDeadLetters moves up to limit dead letters back to the queue with a fresh attempt count
+func (q *MemoryQueue) ReplayDeadLetters(limit int) (int, error) {
+	q.mu.Lock()
+	if limit > len(q.deadLetters) {
+		limit = len(q.deadLetters)
+	}
+	replay := q.deadLetters[:limit]
+	q.deadLetters = q.deadLetters[limit:]
+	q.mu.Unlock()
+
+	for i, d := range replay {
+		err := q.push(d.Body, 0)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(replay), nil
+}
+
+func (q *MemoryQueue) push(payload []byte, attempt int) error {
+	msg := &Message{
+		Body:    payload,
+		Attempt: attempt,
+	}
+	msg.ack = func() error {
+		return nil
+	}
+	msg.nack = func(requeue bool, reason string) error {
+		return q.nack(msg, requeue, reason)
+	}
+	msg.requeue = func() error {
+		return q.push(msg.Body, msg.Attempt)
+	}
+
+	select {
+	case <-q.done:
+		return ErrQueueClosed
+	case q.jobs <- msg:
+		return nil
+	}
+}
+
+func (q *MemoryQueue) nack(msg *Message, requeue bool, reason string) error {
+	attempt := msg.Attempt + 1
+	if !requeue || attempt > q.maxRetries {
+		q.mu.Lock()
+		q.deadLetters = append(q.deadLetters, DeadLetter{
+			Body:           msg.Body,
+			Attempts:       msg.Attempt,
+			LastError:      reason,
+			DeadLetteredAt: time.Now().UTC().Format(time.RFC3339),
+		})
+		q.mu.Unlock()
+
+		if requeue {
+			return ErrRetriesExhausted
+		}
+		return nil
+	}
+
+	// the job is pushed back once the delay is over
+	time.AfterFunc(RetryDelay(attempt, q.retryDelay), func() {
+		q.push(msg.Body, attempt)
+	})
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type memoryQuota struct {
+	count     int
+	expiresAt time.Time
+}
+
+// MemoryRateLimitStore keeps the token buckets and quota counts in process memory, for local dev and tests
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	quotas  map[string]*memoryQuota
+
+	now func() time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: map[string]*memoryBucket{},
+		quotas:  map[string]*memoryQuota{},
+		now:     time.Now,
+	}
+}
+
+func (m *MemoryRateLimitStore) TakeToken(key string, ratePerSecond float64, burst int, now time.Time) (*types.RateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// new buckets start full
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(burst), last: now}
+		m.buckets[key] = bucket
+	}
+
+	// refill for the time since the last call
+	if elapsed := now.Sub(bucket.last).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(float64(burst), bucket.tokens+elapsed*ratePerSecond)
+		bucket.last = now
+	}
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+
+	return bucketResult(bucket.tokens, allowed, ratePerSecond, burst), nil
+}
+
+func (m *MemoryRateLimitStore) IncrementQuota(key string, expiresAt time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// drop the counts of past windows
+	now := m.now()
+	for k, quota := range m.quotas {
+		if !now.Before(quota.expiresAt) {
+			delete(m.quotas, k)
+		}
+	}
+
+	quota, ok := m.quotas[key]
+	if !ok {
+		quota = &memoryQuota{expiresAt: expiresAt}
+		m.quotas[key] = quota
+	}
+	quota.count++
+
+	return quota.count, nil
+}
@@ -0,0 +1,1571 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+type memoryPlan struct {
+	id              int
+	name            string
+	perCallCost     float64
+	uploadCostPerMB float64
+	maxUploadBytes  int64
+}
+
+type memoryClient struct {
+	data          types.ClientData
+	webhookSecret string
+}
+
+type memoryUpload struct {
+	data      types.UploadMetaData
+	createdAt time.Time
+	deletedAt *time.Time
+}
+
+type memoryJob struct {
+	jobType      types.WorkType
+	id           int
+	clientID     int
+	jobID        string
+	uploadIDs    []int
+	status       string
+	attempts     int
+	createdAt    time.Time
+	enqueuedAt   time.Time
+	processedAt  *time.Time
+	completedAt  *time.Time
+	failedAt     *time.Time
+	failedReason *string
+	matchScore   *int
+	details      []byte
+}
+
+type memoryAccessKey struct {
+	data          types.AccessKey
+	secretKeyHash string
+	signingSecret string
+	createdAt     time.Time
+	expiresAt     *time.Time
+	revokedAt     *time.Time
+
+	refreshTokenHash      string
+	refreshTokenExpiresAt time.Time
+}
+
+// usable reports whether the key is neither revoked nor expired at now
+func (k *memoryAccessKey) usable(now time.Time) bool {
+	return k.revokedAt == nil && (k.expiresAt == nil || k.expiresAt.After(now))
+}
+
+func (k *memoryAccessKey) toAccessKey() *types.AccessKey {
+	data := k.data
+	data.Scopes = slices.Clone(k.data.Scopes)
+	data.CreatedAt = formatMemoryTime(&k.createdAt)
+	data.ExpiresAt = formatMemoryTime(k.expiresAt)
+	data.RevokedAt = formatMemoryTime(k.revokedAt)
+	return &data
+}
+
+type memoryIdempotencyKey struct {
+	data      types.IdempotencyRecord
+	expiresAt time.Time
+}
+
+type memoryUploadSession struct {
+	data      types.UploadSession
+	expiresAt time.Time
+}
+
+type memoryPresignedUpload struct {
+	data      types.PresignedUpload
+	expiresAt time.Time
+}
+
+func (u *memoryPresignedUpload) toPresignedUpload() *types.PresignedUpload {
+	data := u.data
+	data.ExpiresAt = u.expiresAt.Format(time.RFC3339Nano)
+	return &data
+}
+
+// toUploadSession returns a copy of the session, with its chunks ordered by their number
+func (u *memoryUploadSession) toUploadSession() *types.UploadSession {
+	data := u.data
+	data.Chunks = make([]*types.UploadChunk, 0, len(u.data.Chunks))
+	for _, chunk := range u.data.Chunks {
+		copied := *chunk
+		data.Chunks = append(data.Chunks, &copied)
+	}
+	slices.SortFunc(data.Chunks, func(a, b *types.UploadChunk) int { return a.Number - b.Number })
+	data.ExpiresAt = formatMemoryTime(&u.expiresAt)
+	return &data
+}
+
+type memoryWebhook struct {
+	data      types.Webhook
+	deletedAt *time.Time
+}
+
+type memoryDelivery struct {
+	data          types.WebhookDelivery
+	nextAttemptAt time.Time
+}
+
+// MemoryStore keeps everything in process memory, for local dev and tests.
+// It implements DataStore, WorkerDataStore, CronJobDataStore and WebhookDataStore,
+// returning sql.ErrNoRows for missing rows like the Postgres stores do.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	plans       []*memoryPlan
+	planLimits  []*types.PlanLimit
+	clients     []*memoryClient
+	accessKeys  []*memoryAccessKey
+	idempotency []*memoryIdempotencyKey
+	uploads     []*memoryUpload
+	sessions    []*memoryUploadSession
+	presigned   []*memoryPresignedUpload
+	faceMatch   []*memoryJob
+	ocr         []*memoryJob
+	webhooks    []*memoryWebhook
+	deliveries  []*memoryDelivery
+
+	now func() time.Time
+}
+
+// NewMemoryStore returns an empty store with the default plans and their limits
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		plans: []*memoryPlan{
+			{id: 1, name: "basic", perCallCost: 0.1, uploadCostPerMB: 0.1, maxUploadBytes: 5 << 20},
+			{id: 2, name: "advanced", perCallCost: 0.05, uploadCostPerMB: 0.05, maxUploadBytes: 10 << 20},
+			{id: 3, name: "enterprise", perCallCost: 0.1, uploadCostPerMB: 0.01, maxUploadBytes: 25 << 20},
+		},
+		planLimits: []*types.PlanLimit{
+			{PlanID: 1, Endpoint: "*", RatePerMinute: 60, Burst: 20},
+			{PlanID: 1, Endpoint: "face-match", RatePerMinute: 10, Burst: 5, DailyQuota: 100, MonthlyQuota: 2000},
+			{PlanID: 1, Endpoint: "ocr", RatePerMinute: 10, Burst: 5, DailyQuota: 100, MonthlyQuota: 2000},
+			{PlanID: 2, Endpoint: "*", RatePerMinute: 120, Burst: 40},
+			{PlanID: 2, Endpoint: "face-match", RatePerMinute: 30, Burst: 10, DailyQuota: 1000, MonthlyQuota: 20000},
+			{PlanID: 2, Endpoint: "ocr", RatePerMinute: 30, Burst: 10, DailyQuota: 1000, MonthlyQuota: 20000},
+			{PlanID: 3, Endpoint: "*", RatePerMinute: 600, Burst: 100},
+			{PlanID: 3, Endpoint: "face-match", RatePerMinute: 120, Burst: 30},
+			{PlanID: 3, Endpoint: "ocr", RatePerMinute: 120, Burst: 30},
+		},
+		now: func() time.Time {
+			// postgres timestamps hold microseconds
+			return time.Now().UTC().Truncate(time.Microsecond)
+		},
+	}
+}
+
+func (s *MemoryStore) GetPlanIdFromName(planName string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, plan := range s.plans {
+		if plan.name == planName {
+			return plan.id, nil
+		}
+	}
+
+	return 0, sql.ErrNoRows
+}
+
+// GetPlanMaxUploadBytes returns the size of the largest file the clients of the plan can upload
+func (s *MemoryStore) GetPlanMaxUploadBytes(planID int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plan := s.findPlan(planID)
+	if plan == nil {
+		return 0, sql.ErrNoRows
+	}
+
+	return plan.maxUploadBytes, nil
+}
+
+// GetPlanLimit returns the limit of the plan on the endpoint, falling back to the '*' one of the plan
+func (s *MemoryStore) GetPlanLimit(planID int, endpoint string) (*types.PlanLimit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var fallback *types.PlanLimit
+	for _, limit := range s.planLimits {
+		if limit.PlanID != planID {
+			continue
+		}
+		if limit.Endpoint == endpoint {
+			copied := *limit
+			return &copied, nil
+		}
+		if limit.Endpoint == "*" {
+			fallback = limit
+		}
+	}
+	if fallback == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	copied := *fallback
+	return &copied, nil
+}
+
+// SetPlanLimit adds or replaces the limit of a plan on an endpoint
+func (s *MemoryStore) SetPlanLimit(limit types.PlanLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.planLimits {
+		if existing.PlanID == limit.PlanID && existing.Endpoint == limit.Endpoint {
+			s.planLimits[i] = &limit
+			return
+		}
+	}
+	s.planLimits = append(s.planLimits, &limit)
+}
+
+func (s *MemoryStore) InsertClientData(planId int, payload types.SignupPayload, accessKey, secretKeyHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findPlan(planId) == nil {
+		return fmt.Errorf("plan %d doesn't exist", planId)
+	}
+
+	s.clients = append(s.clients, &memoryClient{
+		data: types.ClientData{
+			Id:      len(s.clients) + 1,
+			Name:    payload.Name,
+			Email:   payload.Email,
+			PlanID:  planId,
+			Sandbox: payload.Sandbox,
+
+			KeepOriginalUploads: payload.KeepOriginalUploads,
+		},
+	})
+	s.insertAccessKey(len(s.clients), accessKey, secretKeyHash, AUTH_SCHEME_HEADER, "", DEFAULT_ACCESS_KEY_LABEL, ALL_SCOPES, 0)
+
+	return nil
+}
+
+func (s *MemoryStore) GetClientFromAccessKey(accessKey string) (*types.ClientData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, key := range s.accessKeys {
+		if key.data.AccessKey == accessKey && key.usable(now) {
+			clientData := s.findClient(key.data.ClientID).data
+			clientData.AccessKey = key.data.AccessKey
+			clientData.KeyID = key.data.ID
+			clientData.SecretKeyHash = key.secretKeyHash
+			clientData.AuthScheme = key.data.AuthScheme
+			clientData.Scopes = slices.Clone(key.data.Scopes)
+			clientData.SigningSecret = key.signingSecret
+			if key.expiresAt != nil {
+				clientData.KeyExpiresIn = key.expiresAt.Sub(now)
+			}
+			return &clientData, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) InsertAccessKey(clientID int, accessKey, secretKeyHash, authScheme, signingSecret, label string, scopes []string, expiresIn time.Duration) (*types.AccessKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findClient(clientID) == nil {
+		return nil, fmt.Errorf("client %d doesn't exist", clientID)
+	}
+	for _, key := range s.accessKeys {
+		if key.data.AccessKey == accessKey {
+			return nil, fmt.Errorf("access key %s already exists", accessKey)
+		}
+	}
+
+	return s.insertAccessKey(clientID, accessKey, secretKeyHash, authScheme, signingSecret, label, scopes, expiresIn).toAccessKey(), nil
+}
+
+// insertAccessKey saves a key pair of the client, the lock must be held
+func (s *MemoryStore) insertAccessKey(clientID int, accessKey, secretKeyHash, authScheme, signingSecret, label string, scopes []string, expiresIn time.Duration) *memoryAccessKey {
+	key := &memoryAccessKey{
+		data: types.AccessKey{
+			ID:         len(s.accessKeys) + 1,
+			ClientID:   clientID,
+			AccessKey:  accessKey,
+			Label:      label,
+			AuthScheme: authScheme,
+			Scopes:     slices.Clone(scopes),
+		},
+		secretKeyHash: secretKeyHash,
+		signingSecret: signingSecret,
+		createdAt:     s.now(),
+	}
+	if expiresIn > 0 {
+		expiresAt := key.createdAt.Add(expiresIn)
+		key.expiresAt = &expiresAt
+	}
+	s.accessKeys = append(s.accessKeys, key)
+
+	return key
+}
+
+func (s *MemoryStore) ListAccessKeys(clientID int) ([]*types.AccessKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []*types.AccessKey
+	for _, key := range s.accessKeys {
+		if key.data.ClientID == clientID {
+			keys = append(keys, key.toAccessKey())
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *MemoryStore) RevokeAccessKey(clientID, keyID int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.accessKeys {
+		if key.data.ID == keyID && key.data.ClientID == clientID && key.revokedAt == nil {
+			now := s.now()
+			key.revokedAt = &now
+			return key.data.AccessKey, nil
+		}
+	}
+
+	return "", sql.ErrNoRows
+}
+
+func (s *MemoryStore) SetRefreshToken(clientID, keyID int, tokenHash string, expiresIn time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.accessKeys {
+		if key.data.ID == keyID && key.data.ClientID == clientID {
+			key.refreshTokenHash = tokenHash
+			key.refreshTokenExpiresAt = s.now().Add(expiresIn)
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *MemoryStore) RotateRefreshToken(tokenHash, newTokenHash string, expiresIn time.Duration) (*types.ClientData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, key := range s.accessKeys {
+		if key.refreshTokenHash == "" || key.refreshTokenHash != tokenHash || !key.refreshTokenExpiresAt.After(now) || !key.usable(now) {
+			continue
+		}
+		client := s.findClient(key.data.ClientID)
+		if client == nil {
+			continue
+		}
+
+		key.refreshTokenHash = newTokenHash
+		key.refreshTokenExpiresAt = now.Add(expiresIn)
+
+		clientData := client.data
+		clientData.KeyID = key.data.ID
+		clientData.AccessKey = key.data.AccessKey
+		clientData.AuthScheme = key.data.AuthScheme
+		clientData.Scopes = slices.Clone(key.data.Scopes)
+		if key.expiresAt != nil {
+			clientData.KeyExpiresIn = key.expiresAt.Sub(now)
+		}
+		return &clientData, nil
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) InsertIdempotencyKey(clientID int, key, requestHash string, expiresIn time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	record := types.IdempotencyRecord{ClientID: clientID, Key: key, RequestHash: requestHash}
+	if existing := s.findIdempotencyKey(clientID, key); existing != nil {
+		// expired keys can be used again
+		if existing.expiresAt.After(now) {
+			return false, nil
+		}
+		existing.data = record
+		existing.expiresAt = now.Add(expiresIn)
+		return true, nil
+	}
+	s.idempotency = append(s.idempotency, &memoryIdempotencyKey{data: record, expiresAt: now.Add(expiresIn)})
+
+	return true, nil
+}
+
+func (s *MemoryStore) GetIdempotencyKey(clientID int, key string) (*types.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := s.findIdempotencyKey(clientID, key)
+	if existing == nil || !existing.expiresAt.After(s.now()) {
+		return nil, sql.ErrNoRows
+	}
+	record := existing.data
+
+	return &record, nil
+}
+
+func (s *MemoryStore) SaveIdempotentResponse(clientID int, key string, statusCode int, responseBody string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := s.findIdempotencyKey(clientID, key)
+	if existing == nil {
+		return sql.ErrNoRows
+	}
+	existing.data.StatusCode = statusCode
+	existing.data.ResponseBody = responseBody
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteIdempotencyKey(clientID int, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.idempotency = slices.DeleteFunc(s.idempotency, func(existing *memoryIdempotencyKey) bool {
+		return existing.data.ClientID == clientID && existing.data.Key == key
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) InsertUploadMetaData(uploadMetaData *types.UploadMetaData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client := s.findClient(uploadMetaData.ClientID)
+	if client == nil {
+		return fmt.Errorf("client %d doesn't exist", uploadMetaData.ClientID)
+	}
+
+	for _, existing := range s.uploads {
+		if existing.data.UUID == uploadMetaData.UUID {
+			return fmt.Errorf("upload %s already exists", uploadMetaData.UUID)
+		}
+		if existing.deletedAt == nil && existing.isCopyOf(uploadMetaData, client.data.Sandbox) {
+			return store.ErrUploadExists
+		}
+	}
+
+	data := *uploadMetaData
+	data.Id = len(s.uploads) + 1
+	data.Image = nil // only needed until the file is saved, it would hold the decoded pixels in memory
+	s.uploads = append(s.uploads, &memoryUpload{data: data, createdAt: s.now()})
+
+	return nil
+}
+
+func (s *MemoryStore) GetMetaDataByUUID(imgUuid string) (*types.UploadMetaData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, upload := range s.uploads {
+		if upload.deletedAt == nil && upload.data.UUID == imgUuid {
+			return upload.metaData(), nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) GetUploadBySHA256(clientID int, uploadType, sha256, fileName string) (*types.UploadMetaData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client := s.findClient(clientID)
+	if client == nil {
+		return nil, sql.ErrNoRows
+	}
+	for _, upload := range s.uploads {
+		if upload.deletedAt != nil {
+			continue
+		}
+		if upload.isCopyOf(&types.UploadMetaData{ClientID: clientID, Type: uploadType, SHA256: sha256, FileName: fileName}, client.data.Sandbox) {
+			return upload.metaData(), nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) ListUploads(filter *types.UploadFilter) ([]*types.UploadMetaData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var uploads []*memoryUpload
+	for _, upload := range s.uploads {
+		if upload.deletedAt != nil || upload.data.ClientID != filter.ClientID {
+			continue
+		}
+		if filter.Type != "" && upload.data.Type != filter.Type {
+			continue
+		}
+		if filter.Cursor != nil && !upload.before(filter.Cursor) {
+			continue
+		}
+		uploads = append(uploads, upload)
+	}
+
+	// newest first, ties broken on id like the postgres store
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[j].before(&types.UploadCursor{CreatedAt: uploads[i].createdAt, ID: uploads[i].data.Id})
+	})
+
+	if filter.Limit > 0 && len(uploads) > filter.Limit {
+		uploads = uploads[:filter.Limit]
+	}
+
+	var records []*types.UploadMetaData
+	for _, upload := range uploads {
+		records = append(records, upload.metaData())
+	}
+
+	return records, nil
+}
+
+func (s *MemoryStore) DeleteUpload(clientID, uploadID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var upload *memoryUpload
+	for _, existing := range s.uploads {
+		if existing.deletedAt == nil && existing.data.ClientID == clientID && existing.data.Id == uploadID {
+			upload = existing
+		}
+	}
+	if upload == nil {
+		return sql.ErrNoRows
+	}
+
+	for _, job := range append(append([]*memoryJob{}, s.faceMatch...), s.ocr...) {
+		if job.status != types.JOB_STATUS_CREATED && job.status != types.JOB_STATUS_PROCESSING {
+			continue
+		}
+		if slices.Contains(job.uploadIDs, uploadID) {
+			return store.ErrUploadInUse
+		}
+	}
+
+	now := s.now()
+	upload.deletedAt = &now
+
+	return nil
+}
+
+func (s *MemoryStore) InsertUploadSession(session *types.UploadSession, expiresIn time.Duration) (*types.UploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findClient(session.ClientID) == nil {
+		return nil, fmt.Errorf("client %d doesn't exist", session.ClientID)
+	}
+	for _, existing := range s.sessions {
+		if existing.data.ID == session.ID {
+			return nil, fmt.Errorf("upload session %s already exists", session.ID)
+		}
+	}
+
+	saved := &memoryUploadSession{data: *session, expiresAt: s.now().Add(expiresIn)}
+	saved.data.Chunks = nil
+	s.sessions = append(s.sessions, saved)
+
+	return saved.toUploadSession(), nil
+}
+
+func (s *MemoryStore) GetUploadSession(clientID int, sessionID string) (*types.UploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.findUploadSession(sessionID)
+	if session == nil || session.data.ClientID != clientID || !session.expiresAt.After(s.now()) {
+		return nil, sql.ErrNoRows
+	}
+
+	return session.toUploadSession(), nil
+}
+
+func (s *MemoryStore) SaveUploadChunk(sessionID string, chunk *types.UploadChunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.findUploadSession(sessionID)
+	if session == nil {
+		return fmt.Errorf("upload session %s doesn't exist", sessionID)
+	}
+	saved := *chunk
+	for i, existing := range session.data.Chunks {
+		if existing.Number == chunk.Number {
+			session.data.Chunks[i] = &saved
+			return nil
+		}
+	}
+	session.data.Chunks = append(session.data.Chunks, &saved)
+
+	return nil
+}
+
+func (s *MemoryStore) SetUploadSessionUpload(clientID int, sessionID, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.findUploadSession(sessionID)
+	if session == nil || session.data.ClientID != clientID {
+		return sql.ErrNoRows
+	}
+	session.data.UploadID = uploadID
+
+	return nil
+}
+
+func (s *MemoryStore) InsertPresignedUpload(upload *types.PresignedUpload, expiresIn time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findClient(upload.ClientID) == nil {
+		return fmt.Errorf("client %d doesn't exist", upload.ClientID)
+	}
+	for _, existing := range s.presigned {
+		if existing.data.ID == upload.ID {
+			return fmt.Errorf("presigned upload %s already exists", upload.ID)
+		}
+	}
+	s.presigned = append(s.presigned, &memoryPresignedUpload{data: *upload, expiresAt: s.now().Add(expiresIn)})
+
+	return nil
+}
+
+func (s *MemoryStore) GetPresignedUpload(clientID int, id string) (*types.PresignedUpload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, upload := range s.presigned {
+		if upload.data.ID == id && upload.data.ClientID == clientID && upload.expiresAt.After(s.now()) {
+			return upload.toPresignedUpload(), nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) DeleteUploadSession(clientID int, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions = slices.DeleteFunc(s.sessions, func(existing *memoryUploadSession) bool {
+		return existing.data.ID == sessionID && existing.data.ClientID == clientID
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) InsertFaceMatchResult(result *types.FaceMatchData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	score := result.Score
+	s.faceMatch = append(s.faceMatch, &memoryJob{
+		jobType:    types.FACE_MATCH_WORK_TYPE,
+		id:         len(s.faceMatch) + 1,
+		clientID:   result.ClientID,
+		createdAt:  s.now(),
+		matchScore: &score,
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) InsertOCRResult(result *types.OCRData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ocr = append(s.ocr, &memoryJob{
+		jobType:   types.OCR_WORK_TYPE,
+		id:        len(s.ocr) + 1,
+		clientID:  result.ClientID,
+		createdAt: s.now(),
+		details:   []byte(result.Data),
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) InsertFaceMatchJobCreated(img1ID, img2ID, clientID int, jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.uploadsExist(img1ID, img2ID) {
+		return sql.ErrNoRows
+	}
+
+	now := s.now()
+	s.faceMatch = append(s.faceMatch, &memoryJob{
+		jobType:    types.FACE_MATCH_WORK_TYPE,
+		id:         len(s.faceMatch) + 1,
+		clientID:   clientID,
+		jobID:      jobID,
+		uploadIDs:  []int{img1ID, img2ID},
+		status:     types.JOB_STATUS_CREATED,
+		attempts:   1,
+		createdAt:  now,
+		enqueuedAt: now,
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) InsertOCRJobCreated(imgID, clientID int, jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.uploadsExist(imgID) {
+		return sql.ErrNoRows
+	}
+
+	now := s.now()
+	s.ocr = append(s.ocr, &memoryJob{
+		jobType:    types.OCR_WORK_TYPE,
+		id:         len(s.ocr) + 1,
+		clientID:   clientID,
+		jobID:      jobID,
+		uploadIDs:  []int{imgID},
+		status:     types.JOB_STATUS_CREATED,
+		attempts:   1,
+		createdAt:  now,
+		enqueuedAt: now,
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) UpdateFaceMatchJobCompleted(jobID string, score int) error {
+	s.updateJobs(types.FACE_MATCH_WORK_TYPE, jobID, func(job *memoryJob) {
+		now := s.now()
+		job.matchScore = &score
+		job.completedAt = &now
+		job.status = types.JOB_STATUS_COMPLETED
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) UpdateOCRJobCompleted(jobID string, data *types.OCRResponse) error {
+	s.updateJobs(types.OCR_WORK_TYPE, jobID, func(job *memoryJob) {
+		now := s.now()
+		job.details = []byte(data.String())
+		job.completedAt = &now
+		job.status = types.JOB_STATUS_COMPLETED
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) UpdateFaceMatchJobProcessed(jobID string) error {
+	s.updateJobs(types.FACE_MATCH_WORK_TYPE, jobID, func(job *memoryJob) {
+		now := s.now()
+		job.processedAt = &now
+		job.status = types.JOB_STATUS_PROCESSING
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) UpdateOCRJobProcessed(jobID string) error {
+	s.updateJobs(types.OCR_WORK_TYPE, jobID, func(job *memoryJob) {
+		now := s.now()
+		job.processedAt = &now
+		job.status = types.JOB_STATUS_PROCESSING
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) UpdateFaceMatchJobFailed(jobID, reason string) error {
+	s.updateJobs(types.FACE_MATCH_WORK_TYPE, jobID, func(job *memoryJob) {
+		now := s.now()
+		job.failedAt = &now
+		job.status = types.JOB_STATUS_FAILED
+		job.failedReason = &reason
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) UpdateOCRJobFailed(jobID, reason string) error {
+	s.updateJobs(types.OCR_WORK_TYPE, jobID, func(job *memoryJob) {
+		now := s.now()
+		job.failedAt = &now
+		job.status = types.JOB_STATUS_FAILED
+		job.failedReason = &reason
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) GetFaceMatchByJobID(jobID string) (*types.JobRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.faceMatch {
+		if job.jobID == jobID {
+			return job.record()
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) GetOCRByJobID(jobID string) (*types.JobRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.ocr {
+		if job.jobID == jobID {
+			return job.record()
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) ListJobs(filter *types.JobFilter) ([]*types.JobRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var jobs []*memoryJob
+	for _, job := range append(append([]*memoryJob{}, s.faceMatch...), s.ocr...) {
+		if job.clientID != filter.ClientID {
+			continue
+		}
+		if filter.Type != "" && string(job.jobType) != filter.Type {
+			continue
+		}
+		if filter.Status != "" && job.status != filter.Status {
+			continue
+		}
+		if filter.CreatedFrom != nil && job.createdAt.Before(*filter.CreatedFrom) {
+			continue
+		}
+		if filter.CreatedTo != nil && !job.createdAt.Before(*filter.CreatedTo) {
+			continue
+		}
+		if filter.Cursor != nil && !job.before(filter.Cursor) {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	// newest first, ties broken on type and id like the postgres store
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[j].before(&types.JobCursor{
+			CreatedAt: jobs[i].createdAt,
+			Type:      string(jobs[i].jobType),
+			ID:        jobs[i].id,
+		})
+	})
+
+	if filter.Limit > 0 && len(jobs) > filter.Limit {
+		jobs = jobs[:filter.Limit]
+	}
+
+	var records []*types.JobRecord
+	for _, job := range jobs {
+		record, err := job.record()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (s *MemoryStore) GetWebhookSecret(clientID int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client := s.findClient(clientID)
+	if client == nil {
+		return "", sql.ErrNoRows
+	}
+
+	return client.webhookSecret, nil
+}
+
+func (s *MemoryStore) SetWebhookSecret(clientID int, secret string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client := s.findClient(clientID)
+	if client == nil {
+		return "", sql.ErrNoRows
+	}
+	if client.webhookSecret == "" {
+		client.webhookSecret = secret
+	}
+
+	return client.webhookSecret, nil
+}
+
+func (s *MemoryStore) InsertWebhook(clientID int, url string) (*types.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findClient(clientID) == nil {
+		return nil, fmt.Errorf("client %d doesn't exist", clientID)
+	}
+
+	webhook := types.Webhook{
+		ID:        len(s.webhooks) + 1,
+		ClientID:  clientID,
+		URL:       url,
+		CreatedAt: s.now().Format(time.RFC3339Nano),
+	}
+	s.webhooks = append(s.webhooks, &memoryWebhook{data: webhook})
+
+	return &webhook, nil
+}
+
+func (s *MemoryStore) ListWebhooks(clientID int) ([]*types.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var webhooks []*types.Webhook
+	for _, webhook := range s.webhooks {
+		if webhook.data.ClientID == clientID && webhook.deletedAt == nil {
+			data := webhook.data
+			webhooks = append(webhooks, &data)
+		}
+	}
+
+	return webhooks, nil
+}
+
+func (s *MemoryStore) DeleteWebhook(clientID, webhookID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, webhook := range s.webhooks {
+		if webhook.data.ID == webhookID && webhook.data.ClientID == clientID && webhook.deletedAt == nil {
+			now := s.now()
+			webhook.deletedAt = &now
+
+			// its pending deliveries can't be made anymore
+			for _, delivery := range s.deliveries {
+				if delivery.data.WebhookID == webhookID && delivery.data.Status == types.DELIVERY_STATUS_PENDING {
+					delivery.data.Status = types.DELIVERY_STATUS_FAILED
+					delivery.data.LastError = WEBHOOK_DELETED_ERROR
+				}
+			}
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *MemoryStore) ListWebhookDeliveries(clientID, limit int) ([]*types.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// newest first
+	var deliveries []*types.WebhookDelivery
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		delivery := s.deliveries[i]
+		if delivery.data.ClientID == clientID {
+			deliveries = append(deliveries, delivery.view())
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (s *MemoryStore) ResetWebhookDelivery(clientID, deliveryID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, delivery := range s.deliveries {
+		if delivery.data.ID == deliveryID && delivery.data.ClientID == clientID && s.webhookActive(delivery.data.WebhookID) {
+			delivery.data.Status = types.DELIVERY_STATUS_PENDING
+			delivery.data.Attempts = 0
+			delivery.nextAttemptAt = s.now()
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *MemoryStore) InsertWebhookDeliveries(jobType, jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.insertDeliveries(jobType, jobID)
+	return nil
+}
+
+// webhookActive reports if the webhook exists and isn't deleted, the lock must be held
+func (s *MemoryStore) webhookActive(webhookID int) bool {
+	return slices.ContainsFunc(s.webhooks, func(webhook *memoryWebhook) bool {
+		return webhook.data.ID == webhookID && webhook.deletedAt == nil
+	})
+}
+
+// insertDeliveries adds one delivery for every active webhook of the client who owns the job, the lock must be held
+func (s *MemoryStore) insertDeliveries(jobType, jobID string) {
+	for _, job := range s.jobsOf(jobType) {
+		if job.jobID != jobID {
+			continue
+		}
+		for _, webhook := range s.webhooks {
+			if webhook.data.ClientID != job.clientID || webhook.deletedAt != nil {
+				continue
+			}
+
+			now := s.now()
+			s.deliveries = append(s.deliveries, &memoryDelivery{
+				data: types.WebhookDelivery{
+					ID:        len(s.deliveries) + 1,
+					WebhookID: webhook.data.ID,
+					ClientID:  job.clientID,
+					URL:       webhook.data.URL,
+					JobType:   jobType,
+					JobID:     jobID,
+					Status:    types.DELIVERY_STATUS_PENDING,
+					CreatedAt: now.Format(time.RFC3339Nano),
+				},
+				nextAttemptAt: now,
+			})
+		}
+	}
+}
+
+func (s *MemoryStore) ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]*types.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// claimed deliveries are pushed ahead by the lease, like the postgres store does
+	now := s.now()
+	var due []*memoryDelivery
+	for _, delivery := range s.deliveries {
+		if delivery.data.Status == types.DELIVERY_STATUS_PENDING && !delivery.nextAttemptAt.After(now) && s.webhookActive(delivery.data.WebhookID) {
+			due = append(due, delivery)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].nextAttemptAt.Before(due[j].nextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	var deliveries []*types.WebhookDelivery
+	for _, delivery := range due {
+		delivery.data.Attempts++
+		delivery.nextAttemptAt = now.Add(lease)
+
+		claimed := delivery.view()
+		claimed.Secret = s.findClient(delivery.data.ClientID).webhookSecret
+		deliveries = append(deliveries, claimed)
+	}
+
+	return deliveries, nil
+}
+
+func (s *MemoryStore) MarkWebhookDeliverySucceeded(deliveryID, statusCode int) error {
+	s.updateDelivery(deliveryID, func(delivery *memoryDelivery) {
+		delivery.data.Status = types.DELIVERY_STATUS_SUCCEEDED
+		delivery.data.LastStatusCode = statusCode
+		delivery.data.LastError = ""
+		now := s.now()
+		delivery.data.DeliveredAt = formatMemoryTime(&now)
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) ScheduleWebhookDeliveryRetry(deliveryID, statusCode int, reason string, delay time.Duration) error {
+	s.updateDelivery(deliveryID, func(delivery *memoryDelivery) {
+		delivery.data.LastStatusCode = statusCode
+		delivery.data.LastError = truncate(reason, MAX_DELIVERY_ERROR_LENGTH)
+		delivery.nextAttemptAt = s.now().Add(delay)
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) MarkWebhookDeliveryFailed(deliveryID, statusCode int, reason string) error {
+	s.updateDelivery(deliveryID, func(delivery *memoryDelivery) {
+		delivery.data.Status = types.DELIVERY_STATUS_FAILED
+		delivery.data.LastStatusCode = statusCode
+		delivery.data.LastError = truncate(reason, MAX_DELIVERY_ERROR_LENGTH)
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) GetReportData(date string) ([]*types.ClientReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	day, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return nil, err
+	}
+
+	var report []*types.ClientReport
+	for _, client := range s.clients {
+		// sandbox clients aren't billed
+		if client.data.Sandbox {
+			continue
+		}
+
+		plan := s.findPlan(client.data.PlanID)
+		faceMatchCount := countJobsOn(s.faceMatch, client.data.Id, day)
+		ocrCount := countJobsOn(s.ocr, client.data.Id, day)
+		storageMB := float64(s.uploadedKB(client.data.Id, nil)) / 1000
+
+		report = append(report, &types.ClientReport{
+			ClientID:          strconv.Itoa(client.data.Id),
+			Name:              client.data.Name,
+			Plan:              plan.name,
+			Date:              date,
+			TotalFaceMatch:    strconv.Itoa(faceMatchCount),
+			TotalOcr:          strconv.Itoa(ocrCount),
+			TotalImgStorageMB: formatAmount(storageMB),
+			TotalAPIUsageCost: formatAmount(float64(faceMatchCount+ocrCount) * plan.perCallCost),
+			TotalStorageCost:  formatAmount(storageMB * plan.uploadCostPerMB),
+		})
+	}
+
+	return report, nil
+}
+
+func (s *MemoryStore) GetMonthlyReport(currentMonth, currentYear int) ([][]*types.ClientReportMonthly, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var finalReports [][]*types.ClientReportMonthly
+	for _, client := range s.clients {
+		// sandbox clients aren't billed
+		if client.data.Sandbox {
+			continue
+		}
+
+		plan := s.findPlan(client.data.PlanID)
+
+		// days of the month on which the client ran any job
+		var days []time.Time
+		seen := map[time.Time]bool{}
+		for _, job := range append(append([]*memoryJob{}, s.faceMatch...), s.ocr...) {
+			day := truncateToDay(job.createdAt)
+			if job.clientID != client.data.Id || int(day.Month()) != currentMonth || day.Year() != currentYear || seen[day] {
+				continue
+			}
+			seen[day] = true
+			days = append(days, day)
+		}
+		sort.Slice(days, func(i, j int) bool {
+			return days[i].Before(days[j])
+		})
+
+		var clientReports []*types.ClientReportMonthly
+		for _, day := range days {
+			faceMatchCount := countJobsOn(s.faceMatch, client.data.Id, day)
+			ocrCount := countJobsOn(s.ocr, client.data.Id, day)
+			storageMB := float64(s.uploadedKB(client.data.Id, &day)) / 1024
+
+			clientReports = append(clientReports, &types.ClientReportMonthly{
+				ClientID:          strconv.Itoa(client.data.Id),
+				Date:              day.Format(time.DateOnly),
+				TotalFaceMatch:    strconv.Itoa(faceMatchCount),
+				TotalOcr:          strconv.Itoa(ocrCount),
+				TotalImgStorageMB: formatAmount(storageMB),
+				TotalAPIUsageCost: formatAmount(float64(faceMatchCount+ocrCount) * plan.perCallCost),
+				TotalStorageCost:  formatAmount(storageMB * plan.uploadCostPerMB),
+			})
+		}
+
+		finalReports = append(finalReports, clientReports)
+	}
+
+	return finalReports, nil
+}
+
+func (s *MemoryStore) GetStaleJobs(jobType string, timeout time.Duration) ([]*types.StaleJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if jobType != types.FACE_MATCH_WORK_TYPE && jobType != types.OCR_WORK_TYPE {
+		return nil, fmt.Errorf("unknown job type %q", jobType)
+	}
+
+	// jobs are stale when they sit on the queue or in a worker for longer than the timeout
+	cutoff := s.now().Add(-timeout)
+	var jobs []*types.StaleJob
+	for _, job := range s.jobsOf(jobType) {
+		if !job.stale(cutoff) {
+			continue
+		}
+
+		staleJob := &types.StaleJob{
+			Type:     job.jobType,
+			JobID:    job.jobID,
+			Status:   job.status,
+			Attempts: job.attempts,
+		}
+		if client := s.findClient(job.clientID); client != nil {
+			staleJob.Sandbox = client.data.Sandbox
+		}
+		for _, uploadID := range job.uploadIDs {
+			for _, upload := range s.uploads {
+				if upload.data.Id == uploadID {
+					staleJob.ImageIDs = append(staleJob.ImageIDs, upload.data.UUID)
+				}
+			}
+		}
+		jobs = append(jobs, staleJob)
+	}
+
+	return jobs, nil
+}
+
+func (s *MemoryStore) RequeueStaleJob(jobType, jobID string, attempts int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job := s.findStaleJob(jobType, jobID, attempts)
+	if job == nil {
+		return sql.ErrNoRows
+	}
+
+	job.status = types.JOB_STATUS_CREATED
+	job.attempts++
+	job.enqueuedAt = s.now()
+	job.processedAt = nil
+
+	return nil
+}
+
+func (s *MemoryStore) FailStaleJob(jobType, jobID string, attempts int, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job := s.findStaleJob(jobType, jobID, attempts)
+	if job == nil {
+		return sql.ErrNoRows
+	}
+
+	now := s.now()
+	job.status = types.JOB_STATUS_FAILED
+	job.failedAt = &now
+	job.failedReason = &reason
+	s.insertDeliveries(jobType, jobID)
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpiredIdempotencyKeys() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	count := len(s.idempotency)
+	s.idempotency = slices.DeleteFunc(s.idempotency, func(existing *memoryIdempotencyKey) bool {
+		return !existing.expiresAt.After(now)
+	})
+
+	return int64(count - len(s.idempotency)), nil
+}
+
+func (s *MemoryStore) DeleteExpiredUploadSessions() ([]*types.UploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var expired []*types.UploadSession
+	s.sessions = slices.DeleteFunc(s.sessions, func(existing *memoryUploadSession) bool {
+		if existing.expiresAt.After(now) {
+			return false
+		}
+		expired = append(expired, existing.toUploadSession())
+		return true
+	})
+
+	return expired, nil
+}
+
+func (s *MemoryStore) DeleteExpiredPresignedUploads() ([]*types.PresignedUpload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var expired []*types.PresignedUpload
+	s.presigned = slices.DeleteFunc(s.presigned, func(existing *memoryPresignedUpload) bool {
+		if existing.expiresAt.After(now) {
+			return false
+		}
+		expired = append(expired, existing.toPresignedUpload())
+		return true
+	})
+
+	return expired, nil
+}
+
+func (s *MemoryStore) findUploadSession(sessionID string) *memoryUploadSession {
+	for _, session := range s.sessions {
+		if session.data.ID == sessionID {
+			return session
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) findPlan(planID int) *memoryPlan {
+	for _, plan := range s.plans {
+		if plan.id == planID {
+			return plan
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) findIdempotencyKey(clientID int, key string) *memoryIdempotencyKey {
+	for _, existing := range s.idempotency {
+		if existing.data.ClientID == clientID && existing.data.Key == key {
+			return existing
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) findClient(clientID int) *memoryClient {
+	for _, client := range s.clients {
+		if client.data.Id == clientID {
+			return client
+		}
+	}
+
+	return nil
+}
+
+// uploadedKB sums the size of the uploads of a client, only those of the given day if one is passed
+// uploadsExist reports if none of the uploads is missing or deleted
+func (s *MemoryStore) uploadsExist(uploadIDs ...int) bool {
+	for _, uploadID := range uploadIDs {
+		found := slices.ContainsFunc(s.uploads, func(upload *memoryUpload) bool {
+			return upload.deletedAt == nil && upload.data.Id == uploadID
+		})
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *MemoryStore) uploadedKB(clientID int, day *time.Time) int64 {
+	var total int64
+	for _, upload := range s.uploads {
+		// deleted uploads aren't stored anymore
+		if upload.deletedAt != nil || upload.data.ClientID != clientID {
+			continue
+		}
+		if day != nil && !truncateToDay(upload.createdAt).Equal(*day) {
+			continue
+		}
+		total += upload.data.FileSizeKB
+	}
+
+	return total
+}
+
+func (s *MemoryStore) updateJobs(jobType types.WorkType, jobID string, update func(job *memoryJob)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobsOf(string(jobType)) {
+		if job.jobID == jobID {
+			update(job)
+		}
+	}
+}
+
+func (s *MemoryStore) jobsOf(jobType string) []*memoryJob {
+	switch jobType {
+	case types.FACE_MATCH_WORK_TYPE:
+		return s.faceMatch
+	case types.OCR_WORK_TYPE:
+		return s.ocr
+	default:
+		return nil
+	}
+}
+
+// findStaleJob returns the job if it's still pending at the given attempt, the lock must be held
+func (s *MemoryStore) findStaleJob(jobType, jobID string, attempts int) *memoryJob {
+	for _, job := range s.jobsOf(jobType) {
+		if job.jobID == jobID && job.attempts == attempts && job.pending() {
+			return job
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) updateDelivery(deliveryID int, update func(delivery *memoryDelivery)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, delivery := range s.deliveries {
+		if delivery.data.ID == deliveryID {
+			update(delivery)
+		}
+	}
+}
+
+func (j *memoryJob) pending() bool {
+	return j.status == types.JOB_STATUS_CREATED || j.status == types.JOB_STATUS_PROCESSING
+}
+
+// stale reports whether the job has been on the queue or in a worker since before the cutoff
+func (j *memoryJob) stale(cutoff time.Time) bool {
+	switch j.status {
+	case types.JOB_STATUS_CREATED:
+		return j.enqueuedAt.Before(cutoff)
+	case types.JOB_STATUS_PROCESSING:
+		return j.processedAt != nil && j.processedAt.Before(cutoff)
+	default:
+		return false
+	}
+}
+
+// before reports whether the job comes after the cursor in newest first order
+func (u *memoryUpload) before(cursor *types.UploadCursor) bool {
+	if !u.createdAt.Equal(cursor.CreatedAt) {
+		return u.createdAt.Before(cursor.CreatedAt)
+	}
+
+	return u.data.Id < cursor.ID
+}
+
+// metaData returns a copy of the upload the way the postgres store scans it
+// isCopyOf reports if the upload is of the same file by the same client as the same type, and under the same name
+// for sandbox clients
+func (u *memoryUpload) isCopyOf(upload *types.UploadMetaData, sandbox bool) bool {
+	return u.data.SHA256 != "" && u.data.SHA256 == upload.SHA256 && u.data.ClientID == upload.ClientID &&
+		u.data.Type == upload.Type && (!sandbox || u.data.FileName == upload.FileName)
+}
+
+func (u *memoryUpload) metaData() *types.UploadMetaData {
+	data := u.data
+	data.CreatedAt = u.createdAt.Format(time.RFC3339Nano)
+	return &data
+}
+
+func (j *memoryJob) before(cursor *types.JobCursor) bool {
+	if !j.createdAt.Equal(cursor.CreatedAt) {
+		return j.createdAt.Before(cursor.CreatedAt)
+	}
+	if string(j.jobType) != cursor.Type {
+		return string(j.jobType) < cursor.Type
+	}
+
+	return j.id < cursor.ID
+}
+
+// record formats the job the way the postgres store scans it
+func (j *memoryJob) record() (*types.JobRecord, error) {
+	record := &types.JobRecord{
+		Type:         j.jobType,
+		ID:           j.id,
+		ClientID:     j.clientID,
+		CreatedAt:    j.createdAt.Format(time.RFC3339Nano),
+		JobID:        j.jobID,
+		Status:       j.status,
+		CompletedAt:  formatMemoryTime(j.completedAt),
+		ProcessedAt:  formatMemoryTime(j.processedAt),
+		FailedAt:     formatMemoryTime(j.failedAt),
+		FailedReason: "NULL",
+	}
+	if j.failedReason != nil {
+		record.FailedReason = *j.failedReason
+	}
+	if j.matchScore != nil {
+		record.MatchScore = types.FaceMatchResponse(*j.matchScore)
+	}
+	if j.details != nil {
+		record.RawOCRDetails = append(json.RawMessage{}, j.details...)
+		if err := json.Unmarshal(j.details, &record.OCRDetails); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal ocr details: %v", err)
+		}
+	}
+
+	return record, nil
+}
+
+func (d *memoryDelivery) view() *types.WebhookDelivery {
+	data := d.data
+	data.NextAttemptAt = formatMemoryTime(&d.nextAttemptAt)
+	if data.DeliveredAt == "" {
+		data.DeliveredAt = "NULL"
+	}
+
+	return &data
+}
+
+func formatMemoryTime(t *time.Time) string {
+	if t == nil {
+		return "NULL"
+	}
+
+	return t.Format("2006-01-02 15:04:05.000000")
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+func countJobsOn(jobs []*memoryJob, clientID int, day time.Time) int {
+	count := 0
+	for _, job := range jobs {
+		if job.clientID == clientID && truncateToDay(job.createdAt).Equal(day) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func truncateToDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
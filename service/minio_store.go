@@ -2,10 +2,14 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"time"
 
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/db"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 	"github.com/minio/minio-go/v7"
 )
@@ -55,15 +59,94 @@ func (m MinioStore) GetFile(filePath string) ([]byte, error) {
 	// fetch the object
 	object, err := m.client.GetObject(context.Background(), m.bucketName, filePath, minio.GetObjectOptions{})
 	if err != nil {
-		return nil, err
+		return nil, minioError(err)
 	}
 	defer object.Close()
 
-	// read the object in bytes
+	// read the object in bytes, a missing object only shows up here
 	data, err := io.ReadAll(object)
 	if err != nil {
-		return nil, err
+		return nil, minioError(err)
 	}
 
 	return data, nil
 }
+
+func (m MinioStore) DeleteFile(filePath string) error {
+	return m.client.RemoveObject(context.Background(), m.bucketName, filePath, minio.RemoveObjectOptions{})
+}
+
+// StartMultipartUpload returns the id of a new multipart upload of the file.
+// Every part but the last one must be at least 5 MiB, minio rejects the upload on completion otherwise.
+func (m MinioStore) StartMultipartUpload(filePath, contentType string) (string, error) {
+	core := minio.Core{Client: m.client}
+	return core.NewMultipartUpload(context.Background(), m.bucketName, filePath, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+}
+
+// SaveFilePart uploads a part of the multipart upload, and returns its etag
+func (m MinioStore) SaveFilePart(filePath, uploadID string, partNumber int, content io.Reader, size int64) (string, error) {
+	core := minio.Core{Client: m.client}
+	part, err := core.PutObjectPart(context.Background(), m.bucketName, filePath, uploadID, partNumber, content, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	return part.ETag, nil
+}
+
+func (m MinioStore) CompleteMultipartUpload(filePath, uploadID string, parts []*types.UploadChunk) error {
+	completeParts := make([]minio.CompletePart, 0, len(parts))
+	for _, part := range parts {
+		completeParts = append(completeParts, minio.CompletePart{PartNumber: part.Number, ETag: part.ETag})
+	}
+
+	core := minio.Core{Client: m.client}
+	_, err := core.CompleteMultipartUpload(context.Background(), m.bucketName, filePath, uploadID, completeParts, minio.PutObjectOptions{})
+	return err
+}
+
+func (m MinioStore) AbortMultipartUpload(filePath, uploadID string) error {
+	core := minio.Core{Client: m.client}
+	return core.AbortMultipartUpload(context.Background(), m.bucketName, filePath, uploadID)
+}
+
+// PresignPutURL signs the content type along with the url, so minio rejects files sent as another one
+func (m MinioStore) PresignPutURL(filePath, contentType string, expiresIn time.Duration) (string, error) {
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+	u, err := m.client.PresignHeader(context.Background(), http.MethodPut, m.bucketName, filePath, expiresIn, nil, headers)
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
+
+func (m MinioStore) PresignGetURL(filePath string, expiresIn time.Duration) (string, error) {
+	u, err := m.client.PresignedGetObject(context.Background(), m.bucketName, filePath, expiresIn, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
+
+func (m MinioStore) StatFile(filePath string) (*types.FileInfo, error) {
+	info, err := m.client.StatObject(context.Background(), m.bucketName, filePath, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, minioError(err)
+	}
+
+	return &types.FileInfo{Size: info.Size, ContentType: info.ContentType}, nil
+}
+
+// minioError maps a missing object to store.ErrFileNotFound
+func minioError(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fmt.Errorf("%w: %s", store.ErrFileNotFound, err.Error())
+	}
+
+	return err
+}
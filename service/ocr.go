@@ -1,16 +1,18 @@
 package service
 
 import (
+	"context"
+
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 )
 
 type OCRPerformer interface {
-	PerformOCR(payload types.OCRPayload) (*types.OCRResponse, error)
+	PerformOCR(ctx context.Context, image *types.ImageData) (*types.OCRResponse, error)
 }
 
 type DummyOcrService struct{}
 
-func (d *DummyOcrService) PerformOCR(payload types.OCRPayload) (*types.OCRResponse, error) {
+func (d *DummyOcrService) PerformOCR(ctx context.Context, image *types.ImageData) (*types.OCRResponse, error) {
 	return &types.OCRResponse{
 		Name:      "John Adams",
 		Gender:    "Male",
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+const DEFAULT_PRESIGN_TTL = 15 * time.Minute
+
+// time the client has to confirm a presigned upload once its url expired, the staged file is purged after it
+const PRESIGNED_UPLOAD_CONFIRM_WINDOW = time.Hour
+
+// content types the files of every supported extension are put with
+var extContentTypes = map[string]string{
+	types.VALID_FORMAT_PNG:  "image/png",
+	types.VALID_FORMAT_JPEG: "image/jpeg",
+	types.VALID_FORMAT_JPG:  "image/jpeg",
+}
+
+// PresignUpload returns a url the client puts the file to straight into the file store. The file is staged at
+// incoming/clientID/uuid.extension, out of reach of the upload, which only exists once it's confirmed.
+func (c Service) PresignUpload(clientID int, payload types.PresignedUploadPayload) (*types.PresignedUploadResponse, error) {
+	err := validatePresignedUpload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	imgUuid := c.uuid.New()
+	filePath := fmt.Sprintf("incoming/%d/%s%s", clientID, imgUuid, filepath.Ext(payload.FileName))
+	contentType := extContentTypes[filepath.Ext(payload.FileName)]
+	url, err := c.fileStore.PresignPutURL(filePath, contentType, c.presignedURLTTL())
+	if err != nil {
+		log.Printf("Error while presigning the upload of %s: %s\n", filePath, err.Error())
+		return nil, err
+	}
+
+	// the upload is kept past the url for the client to confirm it, and for the purge of its staged file
+	err = c.dataStore.InsertPresignedUpload(&types.PresignedUpload{
+		ID:       imgUuid,
+		ClientID: clientID,
+		FilePath: filePath,
+	}, c.presignedURLTTL()+PRESIGNED_UPLOAD_CONFIRM_WINDOW)
+	if err != nil {
+		log.Printf("Error while saving the presigned upload of %s: %s\n", filePath, err.Error())
+		return nil, err
+	}
+
+	return &types.PresignedUploadResponse{
+		Id:        imgUuid,
+		URL:       url,
+		Method:    http.MethodPut,
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresIn: int(c.presignedURLTTL().Seconds()),
+	}, nil
+}
+
+// ConfirmPresignedUpload records the file put with a presigned url as an upload, once it's checked like a file
+// uploaded at once. The staged file is read back from the file store for it and saved at the path of the upload,
+// upright and stripped of its metadata like the others, so puts made after it don't change the upload. The staged
+// file is deleted once it's saved or fails the checks, on other errors it's kept so the upload can be confirmed
+// again, until the purge drops it. Confirming an upload again returns it as is.
+func (c Service) ConfirmPresignedUpload(clientID, planID int, imgUuid string, payload types.PresignedUploadPayload, keepOriginal bool) (*types.FileUploadResponse, error) {
+	err := validatePresignedUpload(payload)
+	if err != nil {
+		return nil, err
+	}
+	// the id picks the path of the file, so only the ids handed out are taken
+	if _, err := uuid.Parse(imgUuid); err != nil {
+		return nil, ErrPresignedUploadNotFound
+	}
+
+	existing, err := c.dataStore.GetMetaDataByUUID(imgUuid)
+	if err == nil && existing.ClientID == clientID {
+		return &types.FileUploadResponse{Id: imgUuid}, nil
+	}
+	if err == nil {
+		return nil, ErrPresignedUploadNotFound
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	presigned, err := c.dataStore.GetPresignedUpload(clientID, imgUuid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPresignedUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stagedPath := presigned.FilePath
+	info, err := c.fileStore.StatFile(stagedPath)
+	if errors.Is(err, store.ErrFileNotFound) {
+		return nil, ErrPresignedUploadNotFound
+	}
+	if err != nil {
+		log.Printf("Error while checking the presigned upload of %s: %s\n", stagedPath, err.Error())
+		return nil, err
+	}
+
+	resp, err := c.confirmPresignedFile(stagedPath, info, planID, &types.UploadMetaData{
+		UUID:         imgUuid,
+		Type:         payload.Type,
+		ClientID:     clientID,
+		FilePath:     fmt.Sprintf("%d/%s%s", clientID, imgUuid, filepath.Ext(payload.FileName)),
+		FileSizeKB:   info.Size / 1000,
+		FileName:     payload.FileName,
+		KeepOriginal: keepOriginal,
+	})
+	if err == nil || isValidationError(err) {
+		c.deleteFile(stagedPath)
+	}
+
+	return resp, err
+}
+
+// confirmPresignedFile checks the file staged at filePath and saves it as an upload
+func (c Service) confirmPresignedFile(filePath string, info *types.FileInfo, planID int, uploadMetaData *types.UploadMetaData) (*types.FileUploadResponse, error) {
+	// the size and type are checked before reading the file
+	err := c.validateUploadSize(info.Size, planID)
+	if err != nil {
+		return nil, err
+	}
+	if info.ContentType != extContentTypes[filepath.Ext(uploadMetaData.FileName)] {
+		return nil, ErrContentMismatch
+	}
+
+	data, err := c.fileStore.GetFile(filePath)
+	if err != nil {
+		log.Printf("Error while reading the presigned upload of %s: %s\n", filePath, err.Error())
+		return nil, err
+	}
+	err = c.validateUpload(bytes.NewReader(data), uploadMetaData.FileName, int64(len(data)), uploadMetaData, planID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.saveUpload(bytes.NewReader(data), int64(len(data)), info.ContentType, uploadMetaData)
+}
+
+// PresignDownload returns a url the client gets its upload with straight from the file store
+func (c Service) PresignDownload(clientID int, imgUuid string) (*types.PresignedURLResponse, error) {
+	upload, err := c.getClientUpload(clientID, imgUuid)
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := c.fileStore.PresignGetURL(upload.FilePath, c.presignedURLTTL())
+	if err != nil {
+		log.Printf("Error while presigning the download of %s: %s\n", upload.FilePath, err.Error())
+		return nil, err
+	}
+
+	return &types.PresignedURLResponse{
+		URL:       url,
+		ExpiresIn: int(c.presignedURLTTL().Seconds()),
+	}, nil
+}
+
+func (c Service) presignedURLTTL() time.Duration {
+	if c.presignTTL <= 0 {
+		return DEFAULT_PRESIGN_TTL
+	}
+	return c.presignTTL
+}
+
+func (c Service) deleteFile(filePath string) {
+	err := c.fileStore.DeleteFile(filePath)
+	if err != nil {
+		log.Printf("Error while deleting %s: %s\n", filePath, err.Error())
+	}
+}
+
+func validatePresignedUpload(payload types.PresignedUploadPayload) error {
+	err := validateFileType(payload.Type)
+	if err != nil {
+		return err
+	}
+	err = validateFileExt(payload.FileName)
+	if err != nil {
+		return err
+	}
+	if len(payload.FileName) > MAX_FILE_NAME_LENGTH {
+		return ErrInvalidFileName
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+	"github.com/lib/pq"
+)
+
+// InsertAccessKey saves a key pair of the client, which expires after expiresIn unless it's zero.
+// The signing secret is only kept for keys signing their requests.
+func (s PsqlStore) InsertAccessKey(clientID int, accessKey, secretKeyHash, authScheme, signingSecret, label string, scopes []string, expiresIn time.Duration) (*types.AccessKey, error) {
+	key := types.AccessKey{
+		ClientID:   clientID,
+		AccessKey:  accessKey,
+		Label:      label,
+		AuthScheme: authScheme,
+		Scopes:     scopes,
+	}
+	var createdAt, expiresAt sql.NullTime
+	err := s.db.QueryRow(`
+		INSERT INTO access_key (client_id, access_key, secret_key_hash, label, expires_at, auth_scheme, signing_secret, scopes)
+		VALUES ($1, $2, $3, $4, CASE WHEN $5::FLOAT > 0 THEN NOW() + make_interval(secs => $5::FLOAT) END, $6, NULLIF($7, ''), $8)
+		RETURNING id, created_at, expires_at
+	`, clientID, accessKey, secretKeyHash, label, expiresIn.Seconds(), authScheme, signingSecret, pq.Array(scopes)).Scan(&key.ID, &createdAt, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	key.CreatedAt = parseTimeValue(createdAt)
+	key.ExpiresAt = parseTimeValue(expiresAt)
+	key.RevokedAt = parseTimeValue(sql.NullTime{})
+
+	return &key, nil
+}
+
+// ListAccessKeys returns the metadata of all the keys of the client, including the revoked and expired ones
+func (s PsqlStore) ListAccessKeys(clientID int) ([]*types.AccessKey, error) {
+	rows, err := s.db.Query(
+		"SELECT id, client_id, access_key, label, auth_scheme, scopes, created_at, expires_at, revoked_at FROM access_key WHERE client_id = $1 ORDER BY id",
+		clientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*types.AccessKey
+	for rows.Next() {
+		var key types.AccessKey
+		var createdAt, expiresAt, revokedAt sql.NullTime
+		err := rows.Scan(&key.ID, &key.ClientID, &key.AccessKey, &key.Label, &key.AuthScheme, pq.Array(&key.Scopes), &createdAt, &expiresAt, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+		key.CreatedAt = parseTimeValue(createdAt)
+		key.ExpiresAt = parseTimeValue(expiresAt)
+		key.RevokedAt = parseTimeValue(revokedAt)
+		keys = append(keys, &key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// RevokeAccessKey stops the key of the client from working and returns its access key
+func (s PsqlStore) RevokeAccessKey(clientID, keyID int) (string, error) {
+	var accessKey string
+	err := s.db.QueryRow(
+		"UPDATE access_key SET revoked_at = NOW() WHERE id = $1 AND client_id = $2 AND revoked_at IS NULL RETURNING access_key",
+		keyID, clientID,
+	).Scan(&accessKey)
+	if err != nil {
+		return "", err
+	}
+
+	return accessKey, nil
+}
+
+// SetRefreshToken keeps the hash of the refresh token issued to the client for the key,
+// replacing the one issued before for the same key
+func (s PsqlStore) SetRefreshToken(clientID, keyID int, tokenHash string, expiresIn time.Duration) error {
+	res, err := s.db.Exec(`
+		UPDATE access_key
+		SET refresh_token = $1, refresh_token_expires_at = NOW() + make_interval(secs => $2::FLOAT)
+		WHERE id = $3 AND client_id = $4
+	`, tokenHash, expiresIn.Seconds(), keyID, clientID)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(res)
+}
+
+// RotateRefreshToken swaps the refresh token for a new one and returns its client, as long as
+// neither the token nor the key it was issued for is revoked or expired.
+// The swap is atomic, so a refresh token can only be used once.
+func (s PsqlStore) RotateRefreshToken(tokenHash, newTokenHash string, expiresIn time.Duration) (*types.ClientData, error) {
+	var clientData types.ClientData
+	var expiresInSecs float64
+	err := s.db.QueryRow(`
+		UPDATE access_key k
+		SET refresh_token = $2, refresh_token_expires_at = NOW() + make_interval(secs => $3::FLOAT)
+		FROM client c
+		WHERE k.refresh_token = $1 AND k.refresh_token_expires_at > NOW()
+			AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > NOW()) AND c.id = k.client_id
+		RETURNING c.id, c.name, c.email, c.plan_id, c.sandbox, c.keep_original_uploads, k.id, k.access_key, k.auth_scheme, k.scopes,
+			COALESCE(EXTRACT(EPOCH FROM k.expires_at - NOW()), 0)
+	`, tokenHash, newTokenHash, expiresIn.Seconds()).Scan(
+		&clientData.Id,
+		&clientData.Name,
+		&clientData.Email,
+		&clientData.PlanID,
+		&clientData.Sandbox,
+		&clientData.KeepOriginalUploads,
+		&clientData.KeyID,
+		&clientData.AccessKey,
+		&clientData.AuthScheme,
+		pq.Array(&clientData.Scopes),
+		&expiresInSecs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	clientData.KeyExpiresIn = time.Duration(expiresInSecs * float64(time.Second))
+
+	return &clientData, nil
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+// InsertIdempotencyKey reserves the key for the request, unless the client already used it and it hasn't expired.
+// It reports whether the key was reserved, an expired key is reserved again for the new request.
+func (s PsqlStore) InsertIdempotencyKey(clientID int, key, requestHash string, expiresIn time.Duration) (bool, error) {
+	res, err := s.db.Exec(`
+		INSERT INTO idempotency_key (client_id, idempotency_key, request_hash, expires_at)
+		VALUES ($1, $2, $3, NOW() + make_interval(secs => $4::FLOAT))
+		ON CONFLICT (client_id, idempotency_key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status_code = NULL, response_body = NULL,
+			created_at = NOW(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_key.expires_at <= NOW()
+	`, clientID, key, requestHash, expiresIn.Seconds())
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count == 1, nil
+}
+
+// GetIdempotencyKey returns the request the key was used for along with its response, expired keys aren't found
+func (s PsqlStore) GetIdempotencyKey(clientID int, key string) (*types.IdempotencyRecord, error) {
+	record := types.IdempotencyRecord{ClientID: clientID, Key: key}
+	err := s.db.QueryRow(`
+		SELECT request_hash, COALESCE(status_code, 0), COALESCE(response_body, '')
+		FROM idempotency_key
+		WHERE client_id = $1 AND idempotency_key = $2 AND expires_at > NOW()
+	`, clientID, key).Scan(&record.RequestHash, &record.StatusCode, &record.ResponseBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// SaveIdempotentResponse keeps the response of the request the key was reserved for, to replay it to the retries
+func (s PsqlStore) SaveIdempotentResponse(clientID int, key string, statusCode int, responseBody string) error {
+	_, err := s.db.Exec(
+		"UPDATE idempotency_key SET status_code = $1, response_body = $2 WHERE client_id = $3 AND idempotency_key = $4",
+		statusCode, responseBody, clientID, key,
+	)
+
+	return err
+}
+
+// DeleteIdempotencyKey frees the key, so the request can be retried with it
+func (s PsqlStore) DeleteIdempotencyKey(clientID int, key string) error {
+	_, err := s.db.Exec("DELETE FROM idempotency_key WHERE client_id = $1 AND idempotency_key = $2", clientID, key)
+
+	return err
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+// InsertPresignedUpload saves the presigned upload handed out, expiring after expiresIn
+func (s PsqlStore) InsertPresignedUpload(upload *types.PresignedUpload, expiresIn time.Duration) error {
+	_, err := s.db.Exec(`
+		INSERT INTO presigned_upload (id, client_id, file_path, expires_at)
+		VALUES ($1, $2, $3, NOW() + make_interval(secs => $4::FLOAT))
+	`, upload.ID, upload.ClientID, upload.FilePath, expiresIn.Seconds())
+
+	return err
+}
+
+// GetPresignedUpload returns the presigned upload of the client, expired ones aren't found
+func (s PsqlStore) GetPresignedUpload(clientID int, id string) (*types.PresignedUpload, error) {
+	var upload types.PresignedUpload
+	var expiresAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT id, client_id, file_path, expires_at
+		FROM presigned_upload
+		WHERE id = $1 AND client_id = $2 AND expires_at > NOW()
+	`, id, clientID).Scan(&upload.ID, &upload.ClientID, &upload.FilePath, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	upload.ExpiresAt = parseTimeValue(expiresAt)
+
+	return &upload, nil
+}
@@ -3,10 +3,14 @@ package service
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/db"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+	"github.com/lib/pq"
 )
 
 type PsqlStore struct {
@@ -20,16 +24,32 @@ func NewPsqlStore(dsn string) PsqlStore {
 	}
 }
 
+// InsertClientData saves the client along with its first key pair, labelled 'default'
 func (s PsqlStore) InsertClientData(planId int, payload types.SignupPayload, accessKey, secretKeyHash string) error {
-	_, err := s.db.Exec(
-		"INSERT INTO client (name, email, access_key, secret_key_hash, plan_id) VALUES ($1, $2, $3, $4, $5)",
-		payload.Name, payload.Email, accessKey, secretKeyHash, planId,
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var clientID int
+	err = tx.QueryRow(
+		"INSERT INTO client (name, email, plan_id, sandbox, keep_original_uploads) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		payload.Name, payload.Email, planId, payload.Sandbox, payload.KeepOriginalUploads,
+	).Scan(&clientID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO access_key (client_id, access_key, secret_key_hash, label) VALUES ($1, $2, $3, $4)",
+		clientID, accessKey, secretKeyHash, DEFAULT_ACCESS_KEY_LABEL,
 	)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (s PsqlStore) GetPlanIdFromName(planName string) (int, error) {
@@ -42,49 +62,149 @@ func (s PsqlStore) GetPlanIdFromName(planName string) (int, error) {
 	return planId, nil
 }
 
+// GetPlanLimit returns the limit of the plan on the endpoint, falling back to the '*' one of the plan
+func (s PsqlStore) GetPlanLimit(planID int, endpoint string) (*types.PlanLimit, error) {
+	var limit types.PlanLimit
+	err := s.db.QueryRow(`
+		SELECT plan_id, endpoint, COALESCE(rate_per_minute, 0), burst, COALESCE(daily_quota, 0), COALESCE(monthly_quota, 0)
+		FROM plan_limit
+		WHERE plan_id = $1 AND endpoint IN ($2, '*')
+		ORDER BY endpoint = '*'
+		LIMIT 1
+	`, planID, endpoint).Scan(
+		&limit.PlanID,
+		&limit.Endpoint,
+		&limit.RatePerMinute,
+		&limit.Burst,
+		&limit.DailyQuota,
+		&limit.MonthlyQuota,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limit, nil
+}
+
+// GetPlanMaxUploadBytes returns the size of the largest file the clients of the plan can upload
+func (s PsqlStore) GetPlanMaxUploadBytes(planID int) (int64, error) {
+	var maxBytes int64
+	err := s.db.QueryRow("SELECT max_upload_bytes FROM plan WHERE id = $1", planID).Scan(&maxBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	return maxBytes, nil
+}
+
+// GetClientFromAccessKey returns the client owning the access key, as long as the key is neither revoked nor expired
 func (s PsqlStore) GetClientFromAccessKey(accessKey string) (*types.ClientData, error) {
 	var clientData types.ClientData
-	err := s.db.QueryRow(
-		"SELECT id, name, email, plan_id, access_key, secret_key_hash FROM client WHERE access_key = $1",
-		accessKey,
-	).Scan(
+	var expiresInSecs float64
+	err := s.db.QueryRow(`
+		SELECT c.id, c.name, c.email, c.plan_id, k.access_key, k.id, k.secret_key_hash, c.sandbox, c.keep_original_uploads,
+			k.auth_scheme, k.scopes, COALESCE(k.signing_secret, ''), COALESCE(EXTRACT(EPOCH FROM k.expires_at - NOW()), 0)
+		FROM access_key k
+		JOIN client c ON c.id = k.client_id
+		WHERE k.access_key = $1 AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > NOW())
+	`, accessKey).Scan(
 		&clientData.Id,
 		&clientData.Name,
 		&clientData.Email,
 		&clientData.PlanID,
 		&clientData.AccessKey,
+		&clientData.KeyID,
 		&clientData.SecretKeyHash,
+		&clientData.Sandbox,
+		&clientData.KeepOriginalUploads,
+		&clientData.AuthScheme,
+		pq.Array(&clientData.Scopes),
+		&clientData.SigningSecret,
+		&expiresInSecs,
 	)
 	if err != nil {
 		return nil, err
 	}
+	clientData.KeyExpiresIn = time.Duration(expiresInSecs * float64(time.Second))
 
 	return &clientData, nil
 }
 
+// InsertUploadMetaData saves the upload, it returns store.ErrUploadExists if the client already has an upload of
+// the file as the same type, and under the same name for sandbox clients
 func (s PsqlStore) InsertUploadMetaData(uploadMetaData *types.UploadMetaData) error {
-	_, err := s.db.Exec(
-		"INSERT INTO upload (type, client_id, file_path, file_size_kb) VALUES ($1, $2, $3, $4)",
-		uploadMetaData.Type, uploadMetaData.ClientID, uploadMetaData.FilePath, uploadMetaData.FileSizeKB,
+	res, err := s.db.Exec(
+		`INSERT INTO upload (uuid, type, client_id, file_path, file_size_kb, file_name, sha256, width, height, format, dedup_name)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, 0), NULLIF($9, 0), NULLIF($10, ''),
+			CASE WHEN (SELECT sandbox FROM client WHERE id = $3) THEN $6 ELSE '' END)
+		ON CONFLICT (client_id, type, sha256, dedup_name) WHERE deleted_at IS NULL DO NOTHING`,
+		uploadMetaData.UUID, uploadMetaData.Type, uploadMetaData.ClientID, uploadMetaData.FilePath, uploadMetaData.FileSizeKB, uploadMetaData.FileName,
+		uploadMetaData.SHA256, uploadMetaData.Width, uploadMetaData.Height, uploadMetaData.Format,
 	)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	err = checkRowsAffected(res)
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.ErrUploadExists
+	}
+
+	return err
 }
 
 func (s PsqlStore) GetMetaDataByUUID(imgUuid string) (*types.UploadMetaData, error) {
 	var uploadData types.UploadMetaData
 	err := s.db.QueryRow(
-		"SELECT id, type, client_id, file_path, file_size_kb FROM upload WHERE file_path LIKE '%' || $1 || '%'",
+		"SELECT id, uuid, type, client_id, file_path, file_size_kb, COALESCE(file_name, ''), COALESCE(sha256, ''), COALESCE(width, 0), COALESCE(height, 0), COALESCE(format, ''), created_at FROM upload WHERE uuid = $1 AND deleted_at IS NULL",
 		imgUuid,
 	).Scan(
 		&uploadData.Id,
+		&uploadData.UUID,
+		&uploadData.Type,
+		&uploadData.ClientID,
+		&uploadData.FilePath,
+		&uploadData.FileSizeKB,
+		&uploadData.FileName,
+		&uploadData.SHA256,
+		&uploadData.Width,
+		&uploadData.Height,
+		&uploadData.Format,
+		&uploadData.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &uploadData, nil
+}
+
+// GetUploadBySHA256 returns the first upload of the file by the client as the given type, sandbox clients only get
+// the one under the given name since their scenarios are picked by it
+func (s PsqlStore) GetUploadBySHA256(clientID int, uploadType, sha256, fileName string) (*types.UploadMetaData, error) {
+	var uploadData types.UploadMetaData
+	err := s.db.QueryRow(`
+		SELECT u.id, u.uuid, u.type, u.client_id, u.file_path, u.file_size_kb, COALESCE(u.file_name, ''), u.sha256,
+			COALESCE(u.width, 0), COALESCE(u.height, 0), COALESCE(u.format, ''), u.created_at
+		FROM upload u
+		JOIN client c ON c.id = u.client_id
+		WHERE u.client_id = $1 AND u.type = $2 AND u.sha256 = $3 AND (NOT c.sandbox OR u.file_name = $4) AND u.deleted_at IS NULL
+		ORDER BY u.id
+		LIMIT 1
+	`, clientID, uploadType, sha256, fileName,
+	).Scan(
+		&uploadData.Id,
+		&uploadData.UUID,
 		&uploadData.Type,
 		&uploadData.ClientID,
 		&uploadData.FilePath,
 		&uploadData.FileSizeKB,
+		&uploadData.FileName,
+		&uploadData.SHA256,
+		&uploadData.Width,
+		&uploadData.Height,
+		&uploadData.Format,
+		&uploadData.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -93,6 +213,135 @@ func (s PsqlStore) GetMetaDataByUUID(imgUuid string) (*types.UploadMetaData, err
 	return &uploadData, nil
 }
 
+// ListUploads returns the uploads of the client newest first, ties broken on id
+func (s PsqlStore) ListUploads(filter *types.UploadFilter) ([]*types.UploadMetaData, error) {
+	// cursor values are only used when a cursor was supplied
+	var cursorTime *time.Time
+	var cursorID int
+	if filter.Cursor != nil {
+		cursorTime = &filter.Cursor.CreatedAt
+		cursorID = filter.Cursor.ID
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, uuid, type, client_id, file_path, file_size_kb, COALESCE(file_name, ''), COALESCE(sha256, ''), COALESCE(width, 0), COALESCE(height, 0), COALESCE(format, ''), created_at
+		FROM upload
+		WHERE client_id = $1 AND deleted_at IS NULL
+			AND ($2::TEXT = '' OR type::TEXT = $2::TEXT)
+			AND ($3::TIMESTAMP IS NULL OR (created_at, id) < ($3::TIMESTAMP, $4::INTEGER))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $5`,
+		filter.ClientID, filter.Type, cursorTime, cursorID, filter.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []*types.UploadMetaData
+	for rows.Next() {
+		var uploadData types.UploadMetaData
+		err := rows.Scan(
+			&uploadData.Id,
+			&uploadData.UUID,
+			&uploadData.Type,
+			&uploadData.ClientID,
+			&uploadData.FilePath,
+			&uploadData.FileSizeKB,
+			&uploadData.FileName,
+			&uploadData.SHA256,
+			&uploadData.Width,
+			&uploadData.Height,
+			&uploadData.Format,
+			&uploadData.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, &uploadData)
+	}
+
+	return uploads, rows.Err()
+}
+
+// DeleteUpload soft deletes the upload of the client, unless a job referencing it is still pending.
+// The upload row is locked before the jobs are checked, and the job inserts lock it too, so a job
+// being created with the upload is either seen here or finds the upload deleted.
+func (s PsqlStore) DeleteUpload(clientID, uploadID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(
+		"SELECT id FROM upload WHERE id = $1 AND client_id = $2 AND deleted_at IS NULL FOR UPDATE",
+		uploadID, clientID,
+	).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	var inUse bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM face_match
+			WHERE (upload_id1 = $1 OR upload_id2 = $1) AND status IN ('created', 'processing')
+		) OR EXISTS (
+			SELECT 1 FROM ocr
+			WHERE upload_id = $1 AND status IN ('created', 'processing')
+		)`,
+		uploadID,
+	).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return store.ErrUploadInUse
+	}
+
+	_, err = tx.Exec("UPDATE upload SET deleted_at = NOW() WHERE id = $1", uploadID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockUploads locks the uploads against their deletion until the transaction ends.
+// It returns sql.ErrNoRows if any of them doesn't exist or is deleted.
+func lockUploads(tx *sql.Tx, uploadIDs ...int) error {
+	rows, err := tx.Query(
+		"SELECT id FROM upload WHERE id = ANY($1) AND deleted_at IS NULL FOR SHARE",
+		pq.Array(uploadIDs),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	locked := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		locked[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range uploadIDs {
+		if !locked[id] {
+			return sql.ErrNoRows
+		}
+	}
+
+	return nil
+}
+
 func (s PsqlStore) InsertFaceMatchResult(result *types.FaceMatchData) error {
 	_, err := s.db.Exec(
 		"INSERT INTO face_match (client_id, upload_id1, upload_id2, match_score) VALUES ($1, $2, $3, $4)",
@@ -117,8 +366,20 @@ func (s PsqlStore) InsertOCRResult(result *types.OCRData) error {
 	return nil
 }
 
+// InsertFaceMatchJobCreated saves the job, it returns sql.ErrNoRows if either upload is deleted
 func (s PsqlStore) InsertFaceMatchJobCreated(img1ID, img2ID, clientID int, jobID string) error {
-	_, err := s.db.Exec(
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockUploads(tx, img1ID, img2ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
 		"INSERT INTO face_match (job_id, status, client_id, upload_id1, upload_id2) VALUES ($1, $2, $3, $4, $5)",
 		jobID, types.JOB_STATUS_CREATED, clientID, img1ID, img2ID,
 	)
@@ -126,11 +387,23 @@ func (s PsqlStore) InsertFaceMatchJobCreated(img1ID, img2ID, clientID int, jobID
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
+// InsertOCRJobCreated saves the job, it returns sql.ErrNoRows if the upload is deleted
 func (s PsqlStore) InsertOCRJobCreated(imgID, clientID int, jobID string) error {
-	_, err := s.db.Exec(
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockUploads(tx, imgID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
 		"INSERT INTO ocr (job_id, status, client_id, upload_id) VALUES ($1, $2, $3, $4)",
 		jobID, types.JOB_STATUS_CREATED, clientID, imgID,
 	)
@@ -138,7 +411,7 @@ func (s PsqlStore) InsertOCRJobCreated(imgID, clientID int, jobID string) error
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (s PsqlStore) UpdateFaceMatchJobCompleted(jobID string, score int) error {
@@ -286,6 +559,7 @@ func (s PsqlStore) GetOCRByJobID(jobID string) (*types.JobRecord, error) {
 
 	// Check if rawOCRDetails is not nil before unmarshaling
 	if rawOCRDetails != nil {
+		ocrData.RawOCRDetails = *rawOCRDetails
 		if err := json.Unmarshal(*rawOCRDetails, &ocrData.OCRDetails); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal ocr details: %v", err)
 		}
@@ -302,6 +576,98 @@ func (s PsqlStore) GetOCRByJobID(jobID string) (*types.JobRecord, error) {
 	return &ocrData, nil
 }
 
+func (s PsqlStore) ListJobs(filter *types.JobFilter) ([]*types.JobRecord, error) {
+	// cursor values are only used when a cursor was supplied
+	var cursorTime *time.Time
+	var cursorType string
+	var cursorID int
+	if filter.Cursor != nil {
+		cursorTime = &filter.Cursor.CreatedAt
+		cursorType = filter.Cursor.Type
+		cursorID = filter.Cursor.ID
+	}
+
+	// both job tables are merged and ordered newest first, ties broken on type and id
+	query := `
+		SELECT job_type, id, client_id, created_at, job_id, status, completed_at, processed_at, failed_at, failed_reason, match_score, details
+		FROM (
+			SELECT 'face_match' AS job_type, id, client_id, created_at, job_id, status::TEXT AS status, completed_at, processed_at, failed_at, failed_reason, match_score, NULL::JSONB AS details
+			FROM face_match
+			WHERE client_id = $1
+			UNION ALL
+			SELECT 'ocr' AS job_type, id, client_id, created_at, job_id, status::TEXT AS status, completed_at, processed_at, failed_at, failed_reason, NULL::INTEGER AS match_score, details
+			FROM ocr
+			WHERE client_id = $1
+		) jobs
+		WHERE ($2::TEXT = '' OR job_type = $2::TEXT)
+			AND ($3::TEXT = '' OR status = $3::TEXT)
+			AND ($4::TIMESTAMP IS NULL OR created_at >= $4::TIMESTAMP)
+			AND ($5::TIMESTAMP IS NULL OR created_at < $5::TIMESTAMP)
+			AND ($6::TIMESTAMP IS NULL OR (created_at, job_type, id) < ($6::TIMESTAMP, $7::TEXT, $8::INTEGER))
+		ORDER BY created_at DESC, job_type DESC, id DESC
+		LIMIT $9;
+	`
+	rows, err := s.db.Query(
+		query,
+		filter.ClientID, filter.Type, filter.Status, filter.CreatedFrom, filter.CreatedTo,
+		cursorTime, cursorType, cursorID, filter.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*types.JobRecord
+	for rows.Next() {
+		var job types.JobRecord
+		var completedAt, processedAt, failedAt sql.NullTime
+		var failedReason sql.NullString
+		var matchScore sql.NullInt32
+		var rawOCRDetails []byte
+
+		err := rows.Scan(
+			&job.Type,
+			&job.ID,
+			&job.ClientID,
+			&job.CreatedAt,
+			&job.JobID,
+			&job.Status,
+			&completedAt,
+			&processedAt,
+			&failedAt,
+			&failedReason,
+			&matchScore,
+			&rawOCRDetails,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// parsing the values
+		job.CompletedAt = parseTimeValue(completedAt)
+		job.ProcessedAt = parseTimeValue(processedAt)
+		job.FailedAt = parseTimeValue(failedAt)
+		job.FailedReason = parseStringValue(failedReason)
+		if matchScore.Valid {
+			job.MatchScore = types.FaceMatchResponse(matchScore.Int32)
+		}
+		if rawOCRDetails != nil {
+			job.RawOCRDetails = rawOCRDetails
+			if err := json.Unmarshal(rawOCRDetails, &job.OCRDetails); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal ocr details: %v", err)
+			}
+		}
+
+		jobs = append(jobs, &job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
 func parseTimeValue(dbTime sql.NullTime) string {
 	if dbTime.Valid {
 		return dbTime.Time.Format("2006-01-02 15:04:05.000000")
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+// InsertUploadSession saves the chunked upload, expiring after expiresIn, and returns it along with its expiry
+func (s PsqlStore) InsertUploadSession(session *types.UploadSession, expiresIn time.Duration) (*types.UploadSession, error) {
+	saved := *session
+	saved.Chunks = []*types.UploadChunk{}
+	var expiresAt sql.NullTime
+	err := s.db.QueryRow(`
+		INSERT INTO upload_session (id, client_id, type, file_name, file_size, chunk_size, file_path, store_upload_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW() + make_interval(secs => $9::FLOAT))
+		RETURNING expires_at
+	`, session.ID, session.ClientID, session.Type, session.FileName, session.FileSize, session.ChunkSize,
+		session.FilePath, session.StoreUploadID, expiresIn.Seconds()).Scan(&expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	saved.ExpiresAt = parseTimeValue(expiresAt)
+
+	return &saved, nil
+}
+
+// GetUploadSession returns the chunked upload of the client along with the chunks received so far,
+// expired sessions aren't found
+func (s PsqlStore) GetUploadSession(clientID int, sessionID string) (*types.UploadSession, error) {
+	session := types.UploadSession{Chunks: []*types.UploadChunk{}}
+	var expiresAt sql.NullTime
+	var uploadID sql.NullString
+	err := s.db.QueryRow(`
+		SELECT id, client_id, type, file_name, file_size, chunk_size, file_path, store_upload_id, expires_at, upload_uuid
+		FROM upload_session
+		WHERE id = $1 AND client_id = $2 AND expires_at > NOW()
+	`, sessionID, clientID).Scan(
+		&session.ID,
+		&session.ClientID,
+		&session.Type,
+		&session.FileName,
+		&session.FileSize,
+		&session.ChunkSize,
+		&session.FilePath,
+		&session.StoreUploadID,
+		&expiresAt,
+		&uploadID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	session.ExpiresAt = parseTimeValue(expiresAt)
+	session.UploadID = uploadID.String
+
+	rows, err := s.db.Query(
+		"SELECT chunk_number, size, etag FROM upload_session_chunk WHERE session_id = $1 ORDER BY chunk_number",
+		sessionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chunk types.UploadChunk
+		if err := rows.Scan(&chunk.Number, &chunk.Size, &chunk.ETag); err != nil {
+			return nil, err
+		}
+		session.Chunks = append(session.Chunks, &chunk)
+	}
+
+	return &session, rows.Err()
+}
+
+// SaveUploadChunk records the chunk as received, replacing the one with the same number
+func (s PsqlStore) SaveUploadChunk(sessionID string, chunk *types.UploadChunk) error {
+	_, err := s.db.Exec(`
+		INSERT INTO upload_session_chunk (session_id, chunk_number, size, etag)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (session_id, chunk_number) DO UPDATE
+		SET size = EXCLUDED.size, etag = EXCLUDED.etag
+	`, sessionID, chunk.Number, chunk.Size, chunk.ETag)
+
+	return err
+}
+
+// SetUploadSessionUpload records the upload the session of the client was completed as
+func (s PsqlStore) SetUploadSessionUpload(clientID int, sessionID, uploadID string) error {
+	res, err := s.db.Exec(
+		"UPDATE upload_session SET upload_uuid = $1 WHERE id = $2 AND client_id = $3",
+		uploadID, sessionID, clientID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(res)
+}
+
+// DeleteUploadSession drops the chunked upload of the client, along with its chunks
+func (s PsqlStore) DeleteUploadSession(clientID int, sessionID string) error {
+	_, err := s.db.Exec("DELETE FROM upload_session WHERE id = $1 AND client_id = $2", sessionID, clientID)
+
+	return err
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+// max length of the last_error column in webhook_delivery
+const MAX_DELIVERY_ERROR_LENGTH = 500
+
+// last error of the deliveries failed along with their webhook
+const WEBHOOK_DELETED_ERROR = "webhook deleted"
+
+func (s PsqlStore) GetWebhookSecret(clientID int) (string, error) {
+	var secret sql.NullString
+	err := s.db.QueryRow("SELECT webhook_secret FROM client WHERE id = $1", clientID).Scan(&secret)
+	if err != nil {
+		return "", err
+	}
+
+	return secret.String, nil
+}
+
+// SetWebhookSecret sets the secret of the client unless it already has one, and returns the one it's left with
+func (s PsqlStore) SetWebhookSecret(clientID int, secret string) (string, error) {
+	var stored string
+	err := s.db.QueryRow(
+		"UPDATE client SET webhook_secret = COALESCE(webhook_secret, $1) WHERE id = $2 RETURNING webhook_secret",
+		secret, clientID,
+	).Scan(&stored)
+	if err != nil {
+		return "", err
+	}
+
+	return stored, nil
+}
+
+func (s PsqlStore) InsertWebhook(clientID int, url string) (*types.Webhook, error) {
+	webhook := types.Webhook{
+		ClientID: clientID,
+		URL:      url,
+	}
+	err := s.db.QueryRow(
+		"INSERT INTO webhook (client_id, url) VALUES ($1, $2) RETURNING id, created_at",
+		clientID, url,
+	).Scan(&webhook.ID, &webhook.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+func (s PsqlStore) ListWebhooks(clientID int) ([]*types.Webhook, error) {
+	rows, err := s.db.Query(
+		"SELECT id, client_id, url, created_at FROM webhook WHERE client_id = $1 AND deleted_at IS NULL ORDER BY id",
+		clientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []*types.Webhook
+	for rows.Next() {
+		var webhook types.Webhook
+		if err := rows.Scan(&webhook.ID, &webhook.ClientID, &webhook.URL, &webhook.CreatedAt); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// DeleteWebhook soft deletes the webhook, and fails its pending deliveries as they can't be made anymore
+func (s PsqlStore) DeleteWebhook(clientID, webhookID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		"UPDATE webhook SET deleted_at = NOW() WHERE id = $1 AND client_id = $2 AND deleted_at IS NULL",
+		webhookID, clientID,
+	)
+	if err != nil {
+		return err
+	}
+	err = checkRowsAffected(res)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE webhook_delivery SET status = $1, last_error = $2 WHERE webhook_id = $3 AND status = $4",
+		types.DELIVERY_STATUS_FAILED, WEBHOOK_DELETED_ERROR, webhookID, types.DELIVERY_STATUS_PENDING,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s PsqlStore) ListWebhookDeliveries(clientID, limit int) ([]*types.WebhookDelivery, error) {
+	rows, err := s.db.Query(`
+		SELECT d.id, d.webhook_id, d.client_id, w.url, d.job_type, d.job_id, d.status, d.attempts,
+			d.last_status_code, d.last_error, d.next_attempt_at, d.created_at, d.delivered_at
+		FROM webhook_delivery d
+		JOIN webhook w ON w.id = d.webhook_id
+		WHERE d.client_id = $1
+		ORDER BY d.id DESC
+		LIMIT $2`,
+		clientID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*types.WebhookDelivery
+	for rows.Next() {
+		var delivery types.WebhookDelivery
+		var lastStatusCode sql.NullInt32
+		var lastError sql.NullString
+		var nextAttemptAt, deliveredAt sql.NullTime
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.ClientID,
+			&delivery.URL,
+			&delivery.JobType,
+			&delivery.JobID,
+			&delivery.Status,
+			&delivery.Attempts,
+			&lastStatusCode,
+			&lastError,
+			&nextAttemptAt,
+			&delivery.CreatedAt,
+			&deliveredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// parsing the values
+		delivery.LastStatusCode = int(lastStatusCode.Int32)
+		delivery.LastError = lastError.String
+		delivery.NextAttemptAt = parseTimeValue(nextAttemptAt)
+		delivery.DeliveredAt = parseTimeValue(deliveredAt)
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// ResetWebhookDelivery makes the delivery due again, deliveries of deleted webhooks aren't found
+func (s PsqlStore) ResetWebhookDelivery(clientID, deliveryID int) error {
+	res, err := s.db.Exec(`
+		UPDATE webhook_delivery d SET status = $1, attempts = 0, next_attempt_at = NOW()
+		FROM webhook w
+		WHERE d.id = $2 AND d.client_id = $3 AND w.id = d.webhook_id AND w.deleted_at IS NULL`,
+		types.DELIVERY_STATUS_PENDING, deliveryID, clientID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(res)
+}
+
+func (s PsqlStore) ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]*types.WebhookDelivery, error) {
+	// claimed deliveries are pushed ahead by the lease, so other dispatchers skip them
+	// until the attempt is recorded, or the lease runs out because the dispatcher died
+	query := `
+		WITH due AS (
+			SELECT d.id
+			FROM webhook_delivery d
+			JOIN webhook w ON w.id = d.webhook_id
+			WHERE d.status = $1 AND d.next_attempt_at <= NOW() AND w.deleted_at IS NULL
+			ORDER BY d.next_attempt_at
+			LIMIT $2
+			FOR UPDATE OF d SKIP LOCKED
+		), claimed AS (
+			UPDATE webhook_delivery d
+			SET attempts = d.attempts + 1, next_attempt_at = NOW() + $3::DOUBLE PRECISION * INTERVAL '1 millisecond'
+			FROM due
+			WHERE d.id = due.id
+			RETURNING d.id, d.webhook_id, d.client_id, d.job_type, d.job_id, d.status, d.attempts, d.created_at
+		)
+		SELECT claimed.id, claimed.webhook_id, claimed.client_id, w.url, COALESCE(c.webhook_secret, ''),
+			claimed.job_type, claimed.job_id, claimed.status, claimed.attempts, claimed.created_at
+		FROM claimed
+		JOIN webhook w ON w.id = claimed.webhook_id
+		JOIN client c ON c.id = claimed.client_id;
+	`
+	rows, err := s.db.Query(query, types.DELIVERY_STATUS_PENDING, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*types.WebhookDelivery
+	for rows.Next() {
+		var delivery types.WebhookDelivery
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.ClientID,
+			&delivery.URL,
+			&delivery.Secret,
+			&delivery.JobType,
+			&delivery.JobID,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (s PsqlStore) MarkWebhookDeliverySucceeded(deliveryID, statusCode int) error {
+	_, err := s.db.Exec(
+		"UPDATE webhook_delivery SET status = $1, last_status_code = $2, last_error = NULL, delivered_at = NOW() WHERE id = $3",
+		types.DELIVERY_STATUS_SUCCEEDED, statusCode, deliveryID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s PsqlStore) ScheduleWebhookDeliveryRetry(deliveryID, statusCode int, reason string, delay time.Duration) error {
+	_, err := s.db.Exec(
+		"UPDATE webhook_delivery SET last_status_code = NULLIF($1, 0), last_error = $2, next_attempt_at = NOW() + $3::DOUBLE PRECISION * INTERVAL '1 millisecond' WHERE id = $4",
+		statusCode, truncate(reason, MAX_DELIVERY_ERROR_LENGTH), delay.Milliseconds(), deliveryID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s PsqlStore) MarkWebhookDeliveryFailed(deliveryID, statusCode int, reason string) error {
+	_, err := s.db.Exec(
+		"UPDATE webhook_delivery SET status = $1, last_status_code = NULLIF($2, 0), last_error = $3 WHERE id = $4",
+		types.DELIVERY_STATUS_FAILED, statusCode, truncate(reason, MAX_DELIVERY_ERROR_LENGTH), deliveryID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// returns sql.ErrNoRows when an update or delete didn't match any row
+func checkRowsAffected(res sql.Result) error {
+	count, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error while reading affected rows: %w", err)
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	return s[:n]
+}
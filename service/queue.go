@@ -1,22 +1,78 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+const (
+	ATTEMPT_HEADER          = "x-attempt"
+	LAST_ERROR_HEADER       = "x-last-error"
+	DEAD_LETTER_AT_HEADER   = "x-dead-lettered-at"
+	RETRY_QUEUE_SUFFIX      = ".retry"
+	DEAD_LETTER_SUFFIX      = ".dead"
+	DEAD_LETTER_EXCH_SUFFIX = ".dlx"
+	MAX_RETRY_DELAY         = 15 * time.Minute
+)
+
 type TaskQueue interface {
 	PushJobOnQueue(payload []byte) error
-	PullJobFromQueue() (<-chan amqp.Delivery, error)
+	PullJobFromQueue(ctx context.Context, prefetch int) (<-chan *Message, error)
+}
+
+// Message is a job pulled from a TaskQueue, it must be settled with either Ack or Nack
+type Message struct {
+	Body    []byte
+	Attempt int
+
+	ack     func() error
+	nack    func(requeue bool, reason string) error
+	requeue func() error
+}
+
+// Ack settles the message after successful processing
+func (m *Message) Ack() error {
+	return m.ack()
+}
+
+// Nack settles the message after failed processing. With requeue it's retried after a delay,
+// unless the retries are used up, then it's dead lettered and ErrRetriesExhausted is returned.
+// Without requeue it's dead lettered right away.
+func (m *Message) Nack(requeue bool, reason string) error {
+	return m.nack(requeue, reason)
+}
+
+// Requeue hands the message back for redelivery right away, without counting an attempt.
+// It's meant for jobs which were interrupted rather than failed, like on shutdown.
+func (m *Message) Requeue() error {
+	return m.requeue()
+}
+
+type DeadLetter struct {
+	Body           []byte
+	Attempts       int
+	LastError      string
+	DeadLetteredAt string
 }
 
 type RabbitMqQueue struct {
-	ch    amqp.Channel
-	queue amqp.Queue
+	ch                 *amqp.Channel
+	queue              amqp.Queue
+	retryQueues        map[time.Duration]string
+	deadLetterExchange string
+	deadLetterQueue    string
+	maxRetries         int
+	retryDelay         time.Duration
 }
 
-func NewTaskQueue(dsn, name string) *RabbitMqQueue {
+// NewTaskQueue declares the job queue along with its retry queues and dead letter exchange.
+// Jobs are retried at most maxRetries times, waiting retryDelay before the first retry and twice as long for every next one.
+func NewTaskQueue(dsn, name string, maxRetries int, retryDelay time.Duration) *RabbitMqQueue {
 	conn, err := amqp.Dial(dsn)
 	if err != nil {
 		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
@@ -39,11 +95,71 @@ func NewTaskQueue(dsn, name string) *RabbitMqQueue {
 		log.Fatalf("Failed to open a queue: %v", err)
 	}
 
-	log.Println("Rabitmq client connected")
+	// messages wait in the retry queue of their delay until it expires, then go back to the job queue.
+	// Every delay has a queue of its own, as messages only expire at the head of a queue.
+	retryQueues := map[time.Duration]string{}
+	for _, delay := range RetryDelays(maxRetries, retryDelay) {
+		retryQueue := fmt.Sprintf("%s%s.%d", name, RETRY_QUEUE_SUFFIX, delay.Milliseconds())
+		_, err = ch.QueueDeclare(
+			retryQueue, // name
+			true,       // durable
+			false,      // delete when unused
+			false,      // exclusive
+			false,      // no-wait
+			amqp.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": name,
+			},
+		)
+		if err != nil {
+			log.Fatalf("Failed to open the retry queue: %v", err)
+		}
+		retryQueues[delay] = retryQueue
+	}
+
+	// messages which can't be processed end up in the dead letter queue through the dead letter exchange
+	deadLetterExchange := name + DEAD_LETTER_EXCH_SUFFIX
+	err = ch.ExchangeDeclare(
+		deadLetterExchange, // name
+		"direct",           // kind
+		true,               // durable
+		false,              // auto-deleted
+		false,              // internal
+		false,              // no-wait
+		nil,                // arguments
+	)
+	if err != nil {
+		log.Fatalf("Failed to declare the dead letter exchange: %v", err)
+	}
+
+	deadLetterQueue := name + DEAD_LETTER_SUFFIX
+	_, err = ch.QueueDeclare(
+		deadLetterQueue, // name
+		true,            // durable
+		false,           // delete when unused
+		false,           // exclusive
+		false,           // no-wait
+		nil,             // arguments
+	)
+	if err != nil {
+		log.Fatalf("Failed to open the dead letter queue: %v", err)
+	}
+
+	err = ch.QueueBind(deadLetterQueue, name, deadLetterExchange, false, nil)
+	if err != nil {
+		log.Fatalf("Failed to bind the dead letter queue: %v", err)
+	}
 
+	log.Println("Rabitmq client connected")
 	return &RabbitMqQueue{
-		queue: q,
-		ch:    *ch,
+		queue:              q,
+		ch:                 ch,
+		retryQueues:        retryQueues,
+		deadLetterExchange: deadLetterExchange,
+		deadLetterQueue:    deadLetterQueue,
+		maxRetries:         maxRetries,
+		retryDelay:         retryDelay,
 	}
 }
 
@@ -67,19 +183,22 @@ func (t *RabbitMqQueue) PushJobOnQueue(payload []byte) error {
 	return nil
 }
 
-func (t *RabbitMqQueue) PullJobFromQueue() (<-chan amqp.Delivery, error) {
+// PullJobFromQueue consumes jobs with up to prefetch of them unacked at a time.
+// Consuming stops when the context is cancelled, the returned channel is closed after that.
+func (t *RabbitMqQueue) PullJobFromQueue(ctx context.Context, prefetch int) (<-chan *Message, error) {
 	err := t.ch.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
+		prefetch, // prefetch count
+		0,        // prefetch size
+		false,    // global
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	consumerTag := fmt.Sprintf("%s-%d-%d", t.queue.Name, os.Getpid(), time.Now().UnixNano())
 	msgs, err := t.ch.Consume(
 		t.queue.Name, // queue
-		"",           // consumer
+		consumerTag,  // consumer
 		false,        // auto-ack
 		// true,  // auto-ack
 		false, // exclusive
@@ -91,5 +210,220 @@ func (t *RabbitMqQueue) PullJobFromQueue() (<-chan amqp.Delivery, error) {
 		return nil, err
 	}
 
-	return msgs, nil
+	// deliveries channel is closed by the library once the consumer is cancelled
+	go func() {
+		<-ctx.Done()
+		err := t.ch.Cancel(consumerTag, false)
+		if err != nil {
+			log.Println("Error while cancelling the consumer: ", err)
+		}
+	}()
+
+	// wrap the deliveries, so consumers don't depend on rabbitmq
+	messages := make(chan *Message)
+	go func() {
+		defer close(messages)
+		for d := range msgs {
+			messages <- t.newMessage(d)
+		}
+	}()
+
+	return messages, nil
+}
+
+func (t *RabbitMqQueue) newMessage(d amqp.Delivery) *Message {
+	return &Message{
+		Body:    d.Body,
+		Attempt: Attempt(d.Headers),
+		ack: func() error {
+			return d.Ack(false)
+		},
+		nack: func(requeue bool, reason string) error {
+			if !requeue {
+				return t.deadLetter(d, reason)
+			}
+			return t.retry(d, reason)
+		},
+		requeue: func() error {
+			return d.Nack(false, true)
+		},
+	}
+}
+
+// retry puts the message on the retry queue of its delay and acks the original.
+// Once the retries are used up the message is dead lettered instead.
+func (t *RabbitMqQueue) retry(payload amqp.Delivery, reason string) error {
+	attempt := Attempt(payload.Headers) + 1
+	if attempt > t.maxRetries {
+		err := t.deadLetter(payload, reason)
+		if err != nil {
+			return err
+		}
+		return ErrRetriesExhausted
+	}
+
+	headers := copyHeaders(payload.Headers)
+	headers[ATTEMPT_HEADER] = int32(attempt)
+	headers[LAST_ERROR_HEADER] = reason
+
+	err := t.ch.Publish(
+		"",
+		t.retryQueues[RetryDelay(attempt, t.retryDelay)],
+		false,
+		false,
+		amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  payload.ContentType,
+			Headers:      headers,
+			Body:         payload.Body,
+		},
+	)
+	if err != nil {
+		payload.Nack(false, true) // message requeued right away, so it isn't lost
+		return fmt.Errorf("error while passing message to retry queue: %w", err)
+	}
+
+	return payload.Ack(false)
+}
+
+// deadLetter moves the message to the dead letter queue and acks the original
+func (t *RabbitMqQueue) deadLetter(payload amqp.Delivery, reason string) error {
+	headers := copyHeaders(payload.Headers)
+	headers[LAST_ERROR_HEADER] = reason
+	headers[DEAD_LETTER_AT_HEADER] = time.Now().UTC().Format(time.RFC3339)
+
+	err := t.ch.Publish(
+		t.deadLetterExchange,
+		t.queue.Name,
+		false,
+		false,
+		amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  payload.ContentType,
+			Headers:      headers,
+			Body:         payload.Body,
+		},
+	)
+	if err != nil {
+		payload.Nack(false, true) // message requeued right away, so it isn't lost
+		return fmt.Errorf("error while passing message to dead letter queue: %w", err)
+	}
+
+	return payload.Ack(false)
+}
+
+// InspectDeadLetters returns up to limit messages from the dead letter queue, leaving them in place
+func (t *RabbitMqQueue) InspectDeadLetters(limit int) ([]DeadLetter, error) {
+	var deadLetters []DeadLetter
+	var lastTag uint64
+	for range limit {
+		msg, ok, err := t.ch.Get(t.deadLetterQueue, false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		lastTag = msg.DeliveryTag
+		deadLetters = append(deadLetters, DeadLetter{
+			Body:           msg.Body,
+			Attempts:       Attempt(msg.Headers),
+			LastError:      headerString(msg.Headers, LAST_ERROR_HEADER),
+			DeadLetteredAt: headerString(msg.Headers, DEAD_LETTER_AT_HEADER),
+		})
+	}
+
+	// put all of them back on the queue
+	if lastTag != 0 {
+		err := t.ch.Nack(lastTag, true, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return deadLetters, nil
+}
+
+// ReplayDeadLetters moves up to limit messages from the dead letter queue back to the job queue with a fresh attempt count
+func (t *RabbitMqQueue) ReplayDeadLetters(limit int) (int, error) {
+	replayed := 0
+	for range limit {
+		msg, ok, err := t.ch.Get(t.deadLetterQueue, false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+
+		err = t.PushJobOnQueue(msg.Body)
+		if err != nil {
+			msg.Nack(false, true)
+			return replayed, err
+		}
+
+		err = msg.Ack(false)
+		if err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// Attempt returns how many times a message has been retried, based on its headers
+func Attempt(headers amqp.Table) int {
+	switch v := headers[ATTEMPT_HEADER].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	default:
+		return 0
+	}
+}
+
+// RetryDelay returns the delay before a retry, doubling from base for every attempt and capped at MAX_RETRY_DELAY
+func RetryDelay(attempt int, base time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= MAX_RETRY_DELAY {
+			return MAX_RETRY_DELAY
+		}
+	}
+
+	return delay
+}
+
+// RetryDelays returns the distinct delays of up to maxRetries retries, in the order of the attempts
+func RetryDelays(maxRetries int, base time.Duration) []time.Duration {
+	var delays []time.Duration
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		delay := RetryDelay(attempt, base)
+		if len(delays) > 0 && delays[len(delays)-1] == delay {
+			break
+		}
+		delays = append(delays, delay)
+	}
+
+	return delays
+}
+
+func copyHeaders(headers amqp.Table) amqp.Table {
+	copied := amqp.Table{}
+	for key, val := range headers {
+		copied[key] = val
+	}
+
+	return copied
+}
+
+func headerString(headers amqp.Table, key string) string {
+	val, _ := headers[key].(string)
+	return val
 }
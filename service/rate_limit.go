@@ -0,0 +1,29 @@
+package service
+
+import (
+	"math"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+// bucketResult describes a token bucket left with tokens after a call, shared by the rate limit stores
+func bucketResult(tokens float64, allowed bool, ratePerSecond float64, burst int) *types.RateLimitResult {
+	result := &types.RateLimitResult{
+		Allowed:    allowed,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: secondsToDuration((float64(burst) - tokens) / ratePerSecond),
+	}
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / ratePerSecond)
+	}
+
+	return result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
@@ -2,8 +2,12 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/db"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -29,3 +33,79 @@ func (r *RedisStore) SetObject(key, val string) error {
 	}
 	return nil
 }
+
+func (r *RedisStore) SetObjectWithTTL(key, val string, ttl time.Duration) error {
+	return r.client.Set(context.Background(), key, val, ttl).Err()
+}
+
+func (r *RedisStore) DeleteObject(key string) error {
+	return r.client.Del(context.Background(), key).Err()
+}
+
+func (r *RedisStore) SetObjectIfNotExists(key, val string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(context.Background(), key, val, ttl).Result()
+}
+
+// takeTokenScript refills the bucket for the time since the last call and takes a token from it,
+// atomically so concurrent requests of a client can't take the same token.
+// The tokens are returned as a string, since redis truncates lua numbers to integers.
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(bucket[1])
+local last = tonumber(bucket[2])
+if tokens == nil then
+	tokens = burst
+	last = now
+end
+
+if now > last then
+	tokens = math.min(burst, tokens + (now - last) / 1000 * rate)
+	last = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', last)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+func (r *RedisStore) TakeToken(key string, ratePerSecond float64, burst int, now time.Time) (*types.RateLimitResult, error) {
+	res, err := takeTokenScript.Run(context.Background(), r.client, []string{key}, ratePerSecond, burst, now.UnixMilli()).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 2 {
+		return nil, fmt.Errorf("unexpected token bucket result: %v", res)
+	}
+
+	allowed, _ := res[0].(int64)
+	tokensStr, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected token bucket tokens %q: %w", tokensStr, err)
+	}
+
+	return bucketResult(tokens, allowed == 1, ratePerSecond, burst), nil
+}
+
+func (r *RedisStore) IncrementQuota(key string, expiresAt time.Time) (int, error) {
+	ctx := context.Background()
+	pipe := r.client.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.ExpireAt(ctx, key, expiresAt)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(count.Val()), nil
+}
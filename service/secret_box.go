@@ -0,0 +1,60 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+var ErrSealedSecret = errors.New("sealed secret is corrupt or sealed with another key")
+
+// SecretBox seals secrets the server has to read back later, like the secret keys of keys
+// signing their requests, so they aren't stored in the clear
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox returns a box sealing secrets with AES-GCM, under a key derived from key
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	derived := sha256.Sum256(append([]byte("ekyc-secret-box:"), key...))
+	block, err := aes.NewCipher(derived[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts the secret, the result is base64 encoded and starts with a random nonce
+func (sb *SecretBox) Seal(secret string) (string, error) {
+	nonce := make([]byte, sb.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := sb.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret sealed by Seal
+func (sb *SecretBox) Open(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < sb.aead.NonceSize() {
+		return "", ErrSealedSecret
+	}
+
+	nonce, ciphertext := data[:sb.aead.NonceSize()], data[sb.aead.NonceSize():]
+	secret, err := sb.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrSealedSecret
+	}
+
+	return string(secret), nil
+}
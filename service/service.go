@@ -2,25 +2,48 @@ package service
 
 import (
 	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"mime/multipart"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 	"github.com/redis/go-redis/v9"
 )
 
+const JOB_LIST_DEFAULT_LIMIT = 20
+const JOB_LIST_MAX_LIMIT = 100
+
 type Service struct {
 	// store
 	dataStore  store.DataStore
 	fileStore  store.FileStore
 	cacheStore store.CacheStore
 
+	// verified credentials, dropped when a key is revoked
+	credentials *CredentialCache
+
+	// seals the secret keys of keys signing their requests
+	secrets *SecretBox
+
+	// issues access tokens, with refresh tokens valid for refreshTTL
+	tokens     *TokenSigner
+	refreshTTL time.Duration
+
+	// chunked uploads expire after sessionTTL, presigned urls after presignTTL
+	sessionTTL time.Duration
+	presignTTL time.Duration
+
 	// business logic
 	faceMatch  FaceMatcher
 	ocrService OCRPerformer
@@ -31,6 +54,9 @@ type Service struct {
 	// utils
 	keyService KeyGenerator
 	uuid       UUIDGen
+
+	// resolves the hosts of webhooks, net.DefaultResolver when nil
+	resolver HostResolver
 }
 
 type ServiceConfig struct {
@@ -42,18 +68,40 @@ type ServiceConfig struct {
 	OCR        OCRPerformer
 	Queue      TaskQueue
 	UUID       UUIDGen
+
+	// cache of verified credentials the auth middleware uses, if any
+	Credentials *CredentialCache
+
+	// keys signing their requests can only be created when it's set
+	Secrets *SecretBox
+
+	// access tokens can only be issued when it's set, along with refresh tokens valid for RefreshTTL
+	Tokens     *TokenSigner
+	RefreshTTL time.Duration
+
+	// chunked uploads are dropped along with their chunks when they aren't completed within UploadSessionTTL
+	UploadSessionTTL time.Duration
+
+	// presigned urls to put and get files straight to and from the file store are valid for PresignTTL
+	PresignTTL time.Duration
 }
 
 func NewService(config *ServiceConfig) Service {
 	return Service{
-		dataStore:  config.DataStore,
-		keyService: config.KeyService,
-		fileStore:  config.FileStore,
-		cacheStore: config.CacheStore,
-		faceMatch:  config.FaceMatch,
-		ocrService: config.OCR,
-		queue:      config.Queue,
-		uuid:       config.UUID,
+		dataStore:   config.DataStore,
+		keyService:  config.KeyService,
+		fileStore:   config.FileStore,
+		cacheStore:  config.CacheStore,
+		credentials: config.Credentials,
+		secrets:     config.Secrets,
+		tokens:      config.Tokens,
+		refreshTTL:  config.RefreshTTL,
+		sessionTTL:  config.UploadSessionTTL,
+		presignTTL:  config.PresignTTL,
+		faceMatch:   config.FaceMatch,
+		ocrService:  config.OCR,
+		queue:       config.Queue,
+		uuid:        config.UUID,
 	}
 }
 
@@ -66,11 +114,14 @@ func (c Service) SignupClient(payload types.SignupPayload) (*KeyPair, error) {
 		return nil, err
 	}
 
-	// generate keys
+	// generate keys, sandbox ones are told apart by their prefix
 	keyPair, err := c.keyService.GenerateKeyPair()
 	if err != nil {
 		return nil, err
 	}
+	if payload.Sandbox {
+		keyPair.accessKey = SANDBOX_ACCESS_KEY_PREFIX + keyPair.accessKey
+	}
 
 	// save to db
 	planId, err := c.dataStore.GetPlanIdFromName(payload.Plan)
@@ -88,8 +139,22 @@ func (c Service) SignupClient(payload types.SignupPayload) (*KeyPair, error) {
 	return keyPair, nil
 }
 
-func (c Service) ValidateFile(fileName, fileType string) error {
-	err := validateFileType(fileType)
+// ValidateFile checks the type, extension and size of the upload, then decodes the file
+// and fills the width, height and format of the image in the upload metadata
+func (c Service) ValidateFile(fileHeader *multipart.FileHeader, uploadMetaData *types.UploadMetaData, planID int) error {
+	fileReader, err := fileHeader.Open()
+	if err != nil {
+		log.Printf("Error while reading the file: %s\n", err.Error())
+		return err
+	}
+	defer fileReader.Close()
+
+	return c.validateUpload(fileReader, fileHeader.Filename, fileHeader.Size, uploadMetaData, planID)
+}
+
+// validateUpload does the checks of ValidateFile on the content of a file of the given name and size
+func (c Service) validateUpload(file io.ReadSeeker, fileName string, size int64, uploadMetaData *types.UploadMetaData, planID int) error {
+	err := validateFileType(uploadMetaData.Type)
 	if err != nil {
 		return err
 	}
@@ -99,38 +164,133 @@ func (c Service) ValidateFile(fileName, fileType string) error {
 		return err
 	}
 
+	// the size limit is checked before reading the file
+	err = c.validateUploadSize(size, planID)
+	if err != nil {
+		return err
+	}
+
+	img, format, err := decodeImage(file, fileName)
+	if err != nil {
+		return err
+	}
+	uploadMetaData.Width = img.Bounds().Dx()
+	uploadMetaData.Height = img.Bounds().Dy()
+	uploadMetaData.Format = format
+	uploadMetaData.Image = img
+
 	return nil
 }
 
-func (c Service) SaveFile(fileHeader *multipart.FileHeader, uploadMetaData *types.UploadMetaData) error {
-	// save the file to filestore
+// validateUploadSize checks the size of the file is within the upload size limit of the plan
+func (c Service) validateUploadSize(size int64, planID int) error {
+	maxBytes, err := c.dataStore.GetPlanMaxUploadBytes(planID)
+	if errors.Is(err, sql.ErrNoRows) {
+		maxBytes = DEFAULT_MAX_UPLOAD_BYTES
+	} else if err != nil {
+		log.Printf("Error while fetching the upload size limit of plan %d: %s\n", planID, err.Error())
+		return err
+	}
+	if size > maxBytes {
+		return fmt.Errorf("%w, which is %d KB", ErrFileTooLarge, maxBytes/1000)
+	}
+
+	return nil
+}
+
+// isValidationError tells whether the file failed the checks of validateUpload, which it fails again every time
+func isValidationError(err error) bool {
+	return errors.Is(err, ErrInvalidFileType) || errors.Is(err, ErrInvalidFileFormat) || errors.Is(err, ErrFileTooLarge) ||
+		errors.Is(err, ErrContentMismatch) || errors.Is(err, ErrCorruptImage) || errors.Is(err, ErrInvalidImageSize)
+}
+
+// SaveFile saves the upload to the file store and its metadata to the db, and returns its id.
+// Uploads are content addressed: when the client already uploaded the same bytes as the same type, and under the
+// same name for sandbox clients, nothing is saved and the id of the earlier upload is returned instead, so it isn't
+// stored and billed twice.
+func (c Service) SaveFile(fileHeader *multipart.FileHeader, uploadMetaData *types.UploadMetaData) (*types.FileUploadResponse, error) {
 	fileReader, err := fileHeader.Open()
 	if err != nil {
 		log.Printf("Error while reading the file: %s\n", err.Error())
-		return err
+		return nil, err
+	}
+	defer fileReader.Close()
+
+	return c.saveUpload(fileReader, fileHeader.Size, fileHeader.Header.Get("Content-Type"), uploadMetaData)
+}
+
+// saveUpload does what SaveFile does with the content of a file of the given size and content type
+func (c Service) saveUpload(fileReader io.ReadSeeker, size int64, contentType string, uploadMetaData *types.UploadMetaData) (*types.FileUploadResponse, error) {
+	// the file is streamed through the hash, then read again from the start to be saved
+	hash := sha256.New()
+	if _, err := io.Copy(hash, fileReader); err != nil {
+		log.Printf("Error while hashing the file: %s\n", err.Error())
+		return nil, err
+	}
+	uploadMetaData.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	// the file name is only part of the key for sandbox clients, whose scenarios are picked by it
+	existing, err := c.dataStore.GetUploadBySHA256(uploadMetaData.ClientID, uploadMetaData.Type, uploadMetaData.SHA256, uploadMetaData.FileName)
+	if err == nil {
+		return &types.FileUploadResponse{Id: existing.UUID, Deduplicated: true}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error while looking up the upload by its hash: %s\n", err.Error())
+		return nil, err
+	}
+	if _, err := fileReader.Seek(0, io.SeekStart); err != nil {
+		log.Printf("Error while rewinding the file: %s\n", err.Error())
+		return nil, err
 	}
 
+	// the image is stored upright and without its metadata, unless the client asked to keep the original
+	var content io.Reader = fileReader
+	if !uploadMetaData.KeepOriginal {
+		data, err := io.ReadAll(fileReader)
+		if err != nil {
+			log.Printf("Error while reading the file: %s\n", err.Error())
+			return nil, err
+		}
+		normalized, width, height, err := normalizeImage(data, uploadMetaData.Image, uploadMetaData.Format)
+		if err != nil {
+			log.Printf("Error while stripping the metadata of the image: %s\n", err.Error())
+			return nil, err
+		}
+		content, size = normalized, int64(normalized.Len())
+		uploadMetaData.Width, uploadMetaData.Height = width, height
+		uploadMetaData.FileSizeKB = size / 1000
+	}
+
+	// save the file to filestore
 	file := &types.FileUpload{
 		Name:    uploadMetaData.FilePath,
-		Content: fileReader,
-		Size:    fileHeader.Size,
+		Content: content,
+		Size:    size,
 		Headers: map[string]string{
-			"Content-Type": fileHeader.Header.Get("Content-Type"),
+			"Content-Type": contentType,
 		},
 	}
 
 	err = c.fileStore.SaveFile(file)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// save the file upload metadata to db
+	// save the file upload metadata to db, the same file uploaded at the same time may have been saved first
 	err = c.dataStore.InsertUploadMetaData(uploadMetaData)
+	if errors.Is(err, store.ErrUploadExists) {
+		c.deleteFile(uploadMetaData.FilePath)
+		existing, err := c.dataStore.GetUploadBySHA256(uploadMetaData.ClientID, uploadMetaData.Type, uploadMetaData.SHA256, uploadMetaData.FileName)
+		if err != nil {
+			return nil, err
+		}
+		return &types.FileUploadResponse{Id: existing.UUID, Deduplicated: true}, nil
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &types.FileUploadResponse{Id: uploadMetaData.UUID}, nil
 }
 
 func (c Service) PerformFaceMatch(payload types.FaceMatchPayload, clientID int) (string, error) {
@@ -143,23 +303,30 @@ func (c Service) PerformFaceMatch(payload types.FaceMatchPayload, clientID int)
 	// generate the job id
 	jobID := c.uuid.New()
 
-	// get metadata of both images
-	img1Data, _ := c.dataStore.GetMetaDataByUUID(payload.Image1)
-	img2Data, _ := c.dataStore.GetMetaDataByUUID(payload.Image2)
+	// get metadata of both images, they may have been deleted since they were checked
+	img1Data, err := c.dataStore.GetMetaDataByUUID(payload.Image1)
+	if err != nil {
+		return "", jobUploadError(err)
+	}
+	img2Data, err := c.dataStore.GetMetaDataByUUID(payload.Image2)
+	if err != nil {
+		return "", jobUploadError(err)
+	}
 
-	// mark the job started on the db
+	// mark the job started on the db, which fails if an upload is deleted in the meantime
 	err = c.dataStore.InsertFaceMatchJobCreated(img1Data.Id, img2Data.Id, clientID, jobID)
 	if err != nil {
-		return "", err
+		return "", jobUploadError(err)
 	}
 
 	// push the job onto the queue
 	queuePayload := types.FaceMatchQueuePayload{
 		Type: types.FACE_MATCH_WORK_TYPE,
 		Msg: types.FaceMatchInternalPayload{
-			JobID:  jobID,
-			Image1: payload.Image1,
-			Image2: payload.Image2,
+			JobID:   jobID,
+			Image1:  payload.Image1,
+			Image2:  payload.Image2,
+			Sandbox: payload.Sandbox,
 		},
 	}
 	jsonBytes, err := json.Marshal(queuePayload)
@@ -181,21 +348,25 @@ func (c Service) PerformOCR(payload types.OCRPayload, clientID int) (string, err
 	// generate the job id
 	jobID := c.uuid.New()
 
-	// get metadata of both images
-	imgData, _ := c.dataStore.GetMetaDataByUUID(payload.Image)
+	// get metadata of the image, it may have been deleted since it was checked
+	imgData, err := c.dataStore.GetMetaDataByUUID(payload.Image)
+	if err != nil {
+		return "", jobUploadError(err)
+	}
 
-	// mark the job started on the db
+	// mark the job started on the db, which fails if the upload is deleted in the meantime
 	err = c.dataStore.InsertOCRJobCreated(imgData.Id, clientID, jobID)
 	if err != nil {
-		return "", err
+		return "", jobUploadError(err)
 	}
 
 	// push the job onto the queue
 	queuePayload := types.OCRQueuePayload{
 		Type: types.OCR_WORK_TYPE,
 		Msg: types.OCRInternalPayload{
-			JobID: jobID,
-			Image: payload.Image,
+			JobID:   jobID,
+			Image:   payload.Image,
+			Sandbox: payload.Sandbox,
 		},
 	}
 	jsonBytes, err := json.Marshal(queuePayload)
@@ -227,7 +398,7 @@ func validateEmail(email string) error {
 
 func validateFileExt(fileName string) error {
 	switch filepath.Ext(fileName) {
-	case types.VALID_FORMAT_PNG, types.VALID_FORMAT_JPEG:
+	case types.VALID_FORMAT_PNG, types.VALID_FORMAT_JPEG, types.VALID_FORMAT_JPG:
 		return nil
 	default:
 		return ErrInvalidFileFormat
@@ -243,6 +414,14 @@ func validatePlan(plan string) error {
 	}
 }
 
+// jobUploadError maps the upload of a job not being found to the error of an invalid image id
+func jobUploadError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidImgId
+	}
+	return err
+}
+
 func (c Service) validateImagesForFaceMatch(payload types.FaceMatchPayload, clientID int) error {
 	// fetching meta data of images by uuid
 	imgData1, err := c.dataStore.GetMetaDataByUUID(payload.Image1)
@@ -316,6 +495,135 @@ func (c Service) GetJobDetailsByJobID(jobID, jobType string) (*types.JobRecord,
 	}
 }
 
+func (c Service) ListJobs(clientID int, query types.JobListQuery) (*types.JobListResponse, error) {
+	filter, err := parseJobListQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	filter.ClientID = clientID
+
+	// fetch one extra record to find out if there is a next page
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+	jobs, err := c.dataStore.ListJobs(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &types.JobListResponse{
+		Jobs: []*types.JobRecord{},
+	}
+	if len(jobs) > pageSize {
+		jobs = jobs[:pageSize]
+		nextCursor, err := encodeJobCursor(jobs[pageSize-1])
+		if err != nil {
+			return nil, err
+		}
+		resp.NextCursor = nextCursor
+	}
+	resp.Jobs = append(resp.Jobs, jobs...)
+
+	return resp, nil
+}
+
+func parseJobListQuery(query types.JobListQuery) (*types.JobFilter, error) {
+	filter := &types.JobFilter{
+		Type:   query.Type,
+		Status: query.Status,
+		Limit:  JOB_LIST_DEFAULT_LIMIT,
+	}
+
+	switch query.Type {
+	case "", types.FACE_MATCH_WORK_TYPE, types.OCR_WORK_TYPE:
+	default:
+		return nil, ErrInvalidJobType
+	}
+
+	switch query.Status {
+	case "", types.JOB_STATUS_CREATED, types.JOB_STATUS_PROCESSING, types.JOB_STATUS_COMPLETED, types.JOB_STATUS_FAILED:
+	default:
+		return nil, ErrInvalidJobStatus
+	}
+
+	// timestamps may come with any offset, they're compared with the created_at of the jobs in UTC
+	if query.From != "" {
+		from, err := time.Parse(time.RFC3339, query.From)
+		if err != nil {
+			return nil, ErrInvalidTimeRange
+		}
+		from = from.UTC()
+		filter.CreatedFrom = &from
+	}
+	if query.To != "" {
+		to, err := time.Parse(time.RFC3339, query.To)
+		if err != nil {
+			return nil, ErrInvalidTimeRange
+		}
+		to = to.UTC()
+		filter.CreatedTo = &to
+	}
+	if filter.CreatedFrom != nil && filter.CreatedTo != nil && !filter.CreatedFrom.Before(*filter.CreatedTo) {
+		return nil, ErrInvalidTimeRange
+	}
+
+	if query.Limit != "" {
+		limit, err := strconv.Atoi(query.Limit)
+		if err != nil || limit < 1 || limit > JOB_LIST_MAX_LIMIT {
+			return nil, ErrInvalidLimit
+		}
+		filter.Limit = limit
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeJobCursor(query.Cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		filter.Cursor = cursor
+	}
+
+	return filter, nil
+}
+
+// cursor is the position of the last job on a page, encoded as url safe base64 json
+func encodeJobCursor(job *types.JobRecord) (string, error) {
+	createdAt, err := time.Parse(time.RFC3339Nano, job.CreatedAt)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing created_at of job %s: %w", job.JobID, err)
+	}
+
+	cursorBytes, err := json.Marshal(types.JobCursor{
+		CreatedAt: createdAt,
+		Type:      string(job.Type),
+		ID:        job.ID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(cursorBytes), nil
+}
+
+func decodeJobCursor(cursor string) (*types.JobCursor, error) {
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobCursor types.JobCursor
+	if err := json.Unmarshal(cursorBytes, &jobCursor); err != nil {
+		return nil, err
+	}
+
+	switch jobCursor.Type {
+	case types.FACE_MATCH_WORK_TYPE, types.OCR_WORK_TYPE:
+	default:
+		return nil, ErrInvalidCursor
+	}
+
+	return &jobCursor, nil
+}
+
 func (c Service) FetchDataFromCache(payload interface{}, clientID int, jobType string) (string, bool) {
 	var cacheKey string
 	switch p := payload.(type) {
@@ -1,9 +1,33 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"mime/multipart"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store/storetest"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -11,6 +35,12 @@ import (
 type mockDataStore struct{}
 
 func (m *mockDataStore) GetPlanIdFromName(planName string) (int, error) { return 0, nil }
+func (m *mockDataStore) GetPlanLimit(planID int, endpoint string) (*types.PlanLimit, error) {
+	return nil, sql.ErrNoRows
+}
+func (m *mockDataStore) GetPlanMaxUploadBytes(planID int) (int64, error) {
+	return 0, sql.ErrNoRows
+}
 func (m *mockDataStore) InsertClientData(planId int, payload types.SignupPayload, accessKey, secretKeyHash string) error {
 	return nil
 }
@@ -21,6 +51,13 @@ func (m *mockDataStore) GetClientFromAccessKey(accessKey string) (*types.ClientD
 
 func (m *mockDataStore) InsertUploadMetaData(uploadMetaData *types.UploadMetaData) error { return nil }
 
+func (m *mockDataStore) GetUploadBySHA256(clientID int, uploadType, sha256, fileName string) (*types.UploadMetaData, error) {
+	return nil, sql.ErrNoRows
+}
+func (m *mockDataStore) ListUploads(filter *types.UploadFilter) ([]*types.UploadMetaData, error) {
+	return nil, nil
+}
+func (m *mockDataStore) DeleteUpload(clientID, uploadID int) error { return sql.ErrNoRows }
 func (m *mockDataStore) GetMetaDataByUUID(imgUuid string) (*types.UploadMetaData, error) {
 	if imgUuid == "abc" {
 		return &types.UploadMetaData{
@@ -89,16 +126,117 @@ func (m *mockDataStore) InsertFaceMatchJobCreated(id1, id2, clientID int, jobID
 func (m *mockDataStore) InsertOCRJobCreated(id1, client_id int, jobID string) error { return nil }
 func (m *mockDataStore) GetFaceMatchByJobID(jobID string) (*types.JobRecord, error) { return nil, nil }
 func (m *mockDataStore) GetOCRByJobID(jobID string) (*types.JobRecord, error)       { return nil, nil }
+func (m *mockDataStore) ListJobs(filter *types.JobFilter) ([]*types.JobRecord, error) {
+	jobs := []*types.JobRecord{
+		{Type: types.OCR_WORK_TYPE, ID: 3, ClientID: filter.ClientID, CreatedAt: "2024-11-22T10:00:03Z", JobID: "job3"},
+		{Type: types.FACE_MATCH_WORK_TYPE, ID: 2, ClientID: filter.ClientID, CreatedAt: "2024-11-22T10:00:02Z", JobID: "job2"},
+		{Type: types.FACE_MATCH_WORK_TYPE, ID: 1, ClientID: filter.ClientID, CreatedAt: "2024-11-22T10:00:01Z", JobID: "job1"},
+	}
+
+	// skip the jobs up to and including the cursor
+	if filter.Cursor != nil {
+		for i, job := range jobs {
+			if job.ID == filter.Cursor.ID && string(job.Type) == filter.Cursor.Type {
+				jobs = jobs[i+1:]
+				break
+			}
+		}
+	}
+
+	if len(jobs) > filter.Limit {
+		jobs = jobs[:filter.Limit]
+	}
+	return jobs, nil
+}
+
+func (m *mockDataStore) InsertAccessKey(clientID int, accessKey, secretKeyHash, authScheme, signingSecret, label string, scopes []string, expiresIn time.Duration) (*types.AccessKey, error) {
+	expiresAt := "NULL"
+	if expiresIn > 0 {
+		expiresAt = expiresIn.String()
+	}
+	return &types.AccessKey{ID: 2, ClientID: clientID, AccessKey: accessKey, Label: label, AuthScheme: authScheme, Scopes: scopes, ExpiresAt: expiresAt}, nil
+}
+func (m *mockDataStore) ListAccessKeys(clientID int) ([]*types.AccessKey, error) { return nil, nil }
+func (m *mockDataStore) RevokeAccessKey(clientID, keyID int) (string, error) {
+	if keyID != 1 {
+		return "", sql.ErrNoRows
+	}
+	return "testAccess", nil
+}
+func (m *mockDataStore) SetRefreshToken(clientID, keyID int, tokenHash string, expiresIn time.Duration) error {
+	return nil
+}
+func (m *mockDataStore) RotateRefreshToken(tokenHash, newTokenHash string, expiresIn time.Duration) (*types.ClientData, error) {
+	return nil, sql.ErrNoRows
+}
+func (m *mockDataStore) InsertIdempotencyKey(clientID int, key, requestHash string, expiresIn time.Duration) (bool, error) {
+	return true, nil
+}
+func (m *mockDataStore) GetIdempotencyKey(clientID int, key string) (*types.IdempotencyRecord, error) {
+	return nil, sql.ErrNoRows
+}
+func (m *mockDataStore) SaveIdempotentResponse(clientID int, key string, statusCode int, responseBody string) error {
+	return nil
+}
+func (m *mockDataStore) DeleteIdempotencyKey(clientID int, key string) error { return nil }
+func (m *mockDataStore) GetWebhookSecret(clientID int) (string, error) {
+	if clientID == 2 {
+		return "whsec_existing", nil
+	}
+	return "", nil
+}
+func (m *mockDataStore) SetWebhookSecret(clientID int, secret string) (string, error) {
+	if clientID == 3 {
+		return "whsec_raced", nil
+	}
+	return secret, nil
+}
+func (m *mockDataStore) InsertWebhook(clientID int, url string) (*types.Webhook, error) {
+	return &types.Webhook{ID: 1, ClientID: clientID, URL: url}, nil
+}
+func (m *mockDataStore) ListWebhooks(clientID int) ([]*types.Webhook, error) { return nil, nil }
+func (m *mockDataStore) DeleteWebhook(clientID, webhookID int) error {
+	if webhookID != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+func (m *mockDataStore) ListWebhookDeliveries(clientID, limit int) ([]*types.WebhookDelivery, error) {
+	return nil, nil
+}
+func (m *mockDataStore) ResetWebhookDelivery(clientID, deliveryID int) error {
+	if deliveryID != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+func (m *mockDataStore) InsertUploadSession(session *types.UploadSession, expiresIn time.Duration) (*types.UploadSession, error) {
+	return session, nil
+}
+func (m *mockDataStore) GetUploadSession(clientID int, sessionID string) (*types.UploadSession, error) {
+	return nil, sql.ErrNoRows
+}
+func (m *mockDataStore) SaveUploadChunk(sessionID string, chunk *types.UploadChunk) error { return nil }
+func (m *mockDataStore) SetUploadSessionUpload(clientID int, sessionID, uploadID string) error {
+	return nil
+}
+func (m *mockDataStore) DeleteUploadSession(clientID int, sessionID string) error { return nil }
+func (m *mockDataStore) InsertPresignedUpload(upload *types.PresignedUpload, expiresIn time.Duration) error {
+	return nil
+}
+func (m *mockDataStore) GetPresignedUpload(clientID int, id string) (*types.PresignedUpload, error) {
+	return nil, sql.ErrNoRows
+}
 
 type mockFaceMatch struct{}
 
-func (mfm *mockFaceMatch) PerformFaceMatch(payload types.FaceMatchPayload) (int, error) {
+func (mfm *mockFaceMatch) PerformFaceMatch(ctx context.Context, image1, image2 *types.ImageData) (int, error) {
 	return 45, nil
 }
 
 type mockOCR struct{}
 
-func (mfm *mockOCR) PerformOCR(payload types.OCRPayload) (*types.OCRResponse, error) {
+func (mfm *mockOCR) PerformOCR(ctx context.Context, image *types.ImageData) (*types.OCRResponse, error) {
 	return &types.OCRResponse{
 		Name:      "John Adams",
 		Gender:    "Male",
@@ -118,10 +256,14 @@ func (u *mockUuid) New() string {
 
 type mockTaskQueue struct{}
 
-func (tq *mockTaskQueue) PushJobOnQueue(payload []byte) error             { return nil }
-func (tq *mockTaskQueue) PullJobFromQueue() (<-chan amqp.Delivery, error) { return nil, nil }
+func (tq *mockTaskQueue) PushJobOnQueue(payload []byte) error { return nil }
+func (tq *mockTaskQueue) PullJobFromQueue(ctx context.Context, prefetch int) (<-chan *Message, error) {
+	return nil, nil
+}
 
-type mockKeyService struct{}
+type mockKeyService struct {
+	refreshTokens int
+}
 
 func (u *mockKeyService) GenerateKeyPair() (*KeyPair, error) {
 	return &KeyPair{
@@ -130,6 +272,15 @@ func (u *mockKeyService) GenerateKeyPair() (*KeyPair, error) {
 	}, nil
 }
 
+func (u *mockKeyService) GenerateWebhookSecret() (string, error) {
+	return "whsec_new", nil
+}
+
+func (u *mockKeyService) GenerateRefreshToken() (string, error) {
+	u.refreshTokens++
+	return fmt.Sprintf("rt_test%d", u.refreshTokens), nil
+}
+
 func TestSignupClient(t *testing.T) {
 	tt := []struct {
 		name    string
@@ -166,6 +317,18 @@ func TestSignupClient(t *testing.T) {
 				accessKey: "testAccess",
 				secretKey: "secretAccess",
 			},
+		}, {
+			name: "sandbox client gets test keys",
+			payload: types.SignupPayload{
+				Name:    "abc corp",
+				Email:   "test@abc.corp",
+				Plan:    "basic",
+				Sandbox: true,
+			},
+			expKey: &KeyPair{
+				accessKey: "test_testAccess",
+				secretKey: "secretAccess",
+			},
 		},
 	}
 
@@ -383,3 +546,1725 @@ func TestPerformOCR(t *testing.T) {
 		})
 	}
 }
+
+// newFileHeader returns the header of a file uploaded in a multipart form
+func newFileHeader(t *testing.T, fileName, content string) *multipart.FileHeader {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	part.Write([]byte(content))
+	writer.Close()
+
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return form.File["file"][0]
+}
+
+// encodeImage returns a blank image of the given dimensions, encoded in format
+func encodeImage(t *testing.T, format string, width, height int) string {
+	var buf bytes.Buffer
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	var err error
+	if format == types.IMAGE_FORMAT_PNG {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return buf.String()
+}
+
+// withPNGSize returns the png image with the width and height in its IHDR chunk replaced, the pixels are left as they are
+func withPNGSize(img string, width, height int) string {
+	// the IHDR chunk follows the 8 byte signature, its data starts with the width and height
+	ihdr := []byte(img[8:33])
+	binary.BigEndian.PutUint32(ihdr[8:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[12:], uint32(height))
+	binary.BigEndian.PutUint32(ihdr[21:], crc32.ChecksumIEEE(ihdr[4:21]))
+	return img[:8] + string(ihdr) + img[33:]
+}
+
+func TestValidateFile(t *testing.T) {
+	pngImage := encodeImage(t, types.IMAGE_FORMAT_PNG, 400, 300)
+	jpegImage := encodeImage(t, types.IMAGE_FORMAT_JPEG, 300, 400)
+
+	tt := []struct {
+		name      string
+		fileName  string
+		fileType  string
+		planID    int
+		content   string
+		expErr    error
+		expWidth  int
+		expHeight int
+		expFormat string
+	}{
+		{name: "png image", fileName: "face.png", fileType: types.FACE_TYPE, planID: 1, content: pngImage, expWidth: 400, expHeight: 300, expFormat: "png"},
+		{name: "jpeg image", fileName: "card.jpeg", fileType: types.ID_CARD_TYPE, planID: 1, content: jpegImage, expWidth: 300, expHeight: 400, expFormat: "jpeg"},
+		{name: "jpeg image with the jpg extension", fileName: "card.jpg", fileType: types.ID_CARD_TYPE, planID: 1, content: jpegImage, expWidth: 300, expHeight: 400, expFormat: "jpeg"},
+		{name: "invalid type", fileName: "face.png", fileType: "selfie", planID: 1, content: pngImage, expErr: ErrInvalidFileType},
+		{name: "invalid extension", fileName: "face.gif", fileType: types.FACE_TYPE, planID: 1, content: pngImage, expErr: ErrInvalidFileFormat},
+		{name: "png image with the jpeg extension", fileName: "face.jpeg", fileType: types.FACE_TYPE, planID: 1, content: pngImage, expErr: ErrContentMismatch},
+		{name: "text with the png extension", fileName: "face.png", fileType: types.FACE_TYPE, planID: 1, content: "Hello, world!", expErr: ErrContentMismatch},
+		{name: "truncated image", fileName: "face.png", fileType: types.FACE_TYPE, planID: 1, content: pngImage[:len(pngImage)/2], expErr: ErrCorruptImage},
+		{name: "image too small", fileName: "face.png", fileType: types.FACE_TYPE, planID: 1, content: encodeImage(t, types.IMAGE_FORMAT_PNG, 400, 99), expErr: ErrInvalidImageSize},
+		{name: "image too large", fileName: "face.png", fileType: types.FACE_TYPE, planID: 1, content: encodeImage(t, types.IMAGE_FORMAT_PNG, 10001, 100), expErr: ErrInvalidImageSize},
+		// rejected from the header, the pixels wouldn't even decode
+		{name: "image over 40 megapixels", fileName: "face.png", fileType: types.FACE_TYPE, planID: 1, content: withPNGSize(pngImage, 8000, 6000), expErr: ErrInvalidImageSize},
+		{name: "file larger than the plan allows", fileName: "face.png", fileType: types.FACE_TYPE, planID: 1, content: pngImage + strings.Repeat("0", 5<<20), expErr: ErrFileTooLarge},
+		{name: "file within the limit of another plan", fileName: "face.png", fileType: types.FACE_TYPE, planID: 3, content: pngImage + strings.Repeat("0", 5<<20), expWidth: 400, expHeight: 300, expFormat: "png"},
+	}
+
+	service := &Service{dataStore: NewMemoryStore()}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			uploadMetaData := &types.UploadMetaData{Type: tc.fileType}
+			err := service.ValidateFile(newFileHeader(t, tc.fileName, tc.content), uploadMetaData, tc.planID)
+			if !errors.Is(err, tc.expErr) {
+				t.Fatalf("Expected error %v but got %v", tc.expErr, err)
+			}
+			if uploadMetaData.Width != tc.expWidth || uploadMetaData.Height != tc.expHeight || uploadMetaData.Format != tc.expFormat {
+				t.Errorf("Expected a %dx%d %q image but got %+v", tc.expWidth, tc.expHeight, tc.expFormat, uploadMetaData)
+			}
+			if (uploadMetaData.Image != nil) != (tc.expErr == nil) {
+				t.Errorf("Expected the decoded image to be kept only for valid images, got %v", uploadMetaData.Image != nil)
+			}
+		})
+	}
+}
+
+func TestSaveFile(t *testing.T) {
+	dataStore := NewMemoryStore()
+	// the third client is a sandbox one
+	for clientID := 1; clientID <= 3; clientID++ {
+		if err := dataStore.InsertClientData(1, types.SignupPayload{Name: "test", Email: "test@example.com", Sandbox: clientID == 3}, fmt.Sprintf("access%d", clientID), "hash"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	fileStore := NewMemoryFileStore()
+	service := &Service{
+		dataStore: dataStore,
+		fileStore: fileStore,
+	}
+	selfie := encodeImage(t, types.IMAGE_FORMAT_PNG, 200, 100)
+
+	tt := []struct {
+		name            string
+		clientID        int
+		fileType        string
+		fileName        string
+		content         string
+		expDeduplicated bool
+	}{
+		{name: "first upload", clientID: 1, fileType: types.FACE_TYPE, fileName: "selfie.png", content: selfie},
+		{name: "same file again", clientID: 1, fileType: types.FACE_TYPE, fileName: "selfie.png", content: selfie, expDeduplicated: true},
+		{name: "same file as another type", clientID: 1, fileType: types.ID_CARD_TYPE, fileName: "selfie.png", content: selfie},
+		{name: "same file by another client", clientID: 2, fileType: types.FACE_TYPE, fileName: "selfie.png", content: selfie},
+		{name: "same file under another name", clientID: 1, fileType: types.FACE_TYPE, fileName: "selfie_force_failure.png", content: selfie, expDeduplicated: true},
+		{name: "same file by a sandbox client", clientID: 3, fileType: types.FACE_TYPE, fileName: "selfie.png", content: selfie},
+		{name: "same file under a sandbox scenario name", clientID: 3, fileType: types.FACE_TYPE, fileName: "selfie_force_failure.png", content: selfie},
+		{name: "another file", clientID: 1, fileType: types.FACE_TYPE, fileName: "selfie.png", content: encodeImage(t, types.IMAGE_FORMAT_PNG, 100, 200)},
+	}
+
+	firstID := ""
+	for i, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			objectName := fmt.Sprintf("uuid%d", i)
+			uploadMetaData := &types.UploadMetaData{
+				UUID:     objectName,
+				Type:     tc.fileType,
+				ClientID: tc.clientID,
+				FilePath: fmt.Sprintf("%d/%s.png", tc.clientID, objectName),
+				FileName: tc.fileName,
+			}
+
+			resp, err := service.SaveFile(newFileHeader(t, tc.fileName, tc.content), uploadMetaData)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if resp.Deduplicated != tc.expDeduplicated {
+				t.Errorf("Expected deduplicated to be %v but got %v", tc.expDeduplicated, resp.Deduplicated)
+			}
+			if i == 0 {
+				firstID = resp.Id
+			}
+
+			// duplicates get the id of the first upload, and nothing is saved for them
+			_, saveErr := fileStore.GetFile(uploadMetaData.FilePath)
+			if tc.expDeduplicated {
+				if resp.Id != firstID {
+					t.Errorf("Expected the id of the first upload %q but got %q", firstID, resp.Id)
+				}
+				if saveErr == nil {
+					t.Errorf("Expected the duplicate not to be saved")
+				}
+				return
+			}
+			if resp.Id != objectName {
+				t.Errorf("Expected id %q but got %q", objectName, resp.Id)
+			}
+			if saveErr != nil {
+				t.Errorf("Expected the file to be saved but got %v", saveErr)
+			}
+			upload, err := dataStore.GetMetaDataByUUID(objectName)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			sum := sha256.Sum256([]byte(tc.content))
+			if upload.SHA256 != hex.EncodeToString(sum[:]) || upload.FileName != tc.fileName {
+				t.Errorf("Expected the hash and name of the file but got %+v", upload)
+			}
+		})
+	}
+}
+
+// racingStore misses the upload of the file once, like an upload of it made at the same time would
+type racingStore struct {
+	*MemoryStore
+	miss bool
+}
+
+func (s *racingStore) GetUploadBySHA256(clientID int, uploadType, sha256, fileName string) (*types.UploadMetaData, error) {
+	if s.miss {
+		s.miss = false
+		return nil, sql.ErrNoRows
+	}
+	return s.MemoryStore.GetUploadBySHA256(clientID, uploadType, sha256, fileName)
+}
+
+func TestSaveFileConcurrentDuplicate(t *testing.T) {
+	dataStore := &racingStore{MemoryStore: NewMemoryStore()}
+	if err := dataStore.InsertClientData(1, types.SignupPayload{Name: "test", Email: "test@example.com"}, "access1", "hash"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fileStore := NewMemoryFileStore()
+	service := &Service{
+		dataStore: dataStore,
+		fileStore: fileStore,
+	}
+	selfie := encodeImage(t, types.IMAGE_FORMAT_PNG, 200, 100)
+
+	save := func(objectName string) (*types.FileUploadResponse, error) {
+		return service.SaveFile(newFileHeader(t, "selfie.png", selfie), &types.UploadMetaData{
+			UUID:     objectName,
+			Type:     types.FACE_TYPE,
+			ClientID: 1,
+			FilePath: fmt.Sprintf("1/%s.png", objectName),
+			FileName: "selfie.png",
+		})
+	}
+	if _, err := save("first"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the second upload gets past the lookup, the insert catches it
+	dataStore.miss = true
+	resp, err := save("second")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Id != "first" || !resp.Deduplicated {
+		t.Errorf("Expected the first upload to be returned but got %+v", resp)
+	}
+	if _, err := fileStore.GetFile("1/second.png"); err == nil {
+		t.Errorf("Expected the file of the duplicate to be deleted")
+	}
+	if _, err := dataStore.GetMetaDataByUUID("second"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected error %v but got %v", sql.ErrNoRows, err)
+	}
+}
+
+// withExif returns the jpeg or png image with EXIF data holding the orientation, and a camera serial number
+func withExif(t *testing.T, format, img string, orientation uint16) string {
+	var tiff bytes.Buffer
+	tiff.WriteString("II*\x00")
+	for _, field := range []interface{}{
+		uint32(8),                                                    // offset of the first IFD
+		uint16(2),                                                    // entries of the IFD
+		uint16(0x0112), uint16(3), uint32(1), orientation, uint16(0), // orientation, a single SHORT
+		uint16(0xA431), uint16(2), uint32(4), []byte("SN1\x00"), // serial number, an ASCII string
+		uint32(0), // no next IFD
+	} {
+		binary.Write(&tiff, binary.LittleEndian, field)
+	}
+
+	if format == types.IMAGE_FORMAT_PNG {
+		// the eXIf chunk goes after the IHDR one, which ends after 33 bytes
+		var chunk bytes.Buffer
+		binary.Write(&chunk, binary.BigEndian, uint32(tiff.Len()))
+		chunk.WriteString("eXIf")
+		chunk.Write(tiff.Bytes())
+		binary.Write(&chunk, binary.BigEndian, crc32.ChecksumIEEE(chunk.Bytes()[4:]))
+		return img[:33] + chunk.String() + img[33:]
+	}
+
+	// the APP1 segment goes right after the SOI marker
+	var segment bytes.Buffer
+	segment.Write([]byte{0xFF, 0xE1})
+	binary.Write(&segment, binary.BigEndian, uint16(2+6+tiff.Len()))
+	segment.WriteString("Exif\x00\x00")
+	segment.Write(tiff.Bytes())
+	return img[:2] + segment.String() + img[2:]
+}
+
+func TestNormalizeImage(t *testing.T) {
+	// a landscape image, with a white square in its top left corner
+	img := image.NewGray(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	var pngBuf, jpegBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := jpeg.Encode(&jpegBuf, img, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tt := []struct {
+		name        string
+		format      string
+		orientation uint16
+		expWidth    int
+		expHeight   int
+		expWhite    image.Point // a pixel of the white square once upright
+	}{
+		{name: "upright jpeg", format: types.IMAGE_FORMAT_JPEG, orientation: 1, expWidth: 200, expHeight: 100, expWhite: image.Pt(10, 10)},
+		{name: "upside down jpeg", format: types.IMAGE_FORMAT_JPEG, orientation: 3, expWidth: 200, expHeight: 100, expWhite: image.Pt(190, 90)},
+		{name: "jpeg taken sideways", format: types.IMAGE_FORMAT_JPEG, orientation: 6, expWidth: 100, expHeight: 200, expWhite: image.Pt(90, 10)},
+		{name: "mirrored jpeg", format: types.IMAGE_FORMAT_JPEG, orientation: 2, expWidth: 200, expHeight: 100, expWhite: image.Pt(190, 10)},
+		{name: "png taken sideways", format: types.IMAGE_FORMAT_PNG, orientation: 8, expWidth: 100, expHeight: 200, expWhite: image.Pt(10, 190)},
+		{name: "transposed png", format: types.IMAGE_FORMAT_PNG, orientation: 5, expWidth: 100, expHeight: 200, expWhite: image.Pt(10, 10)},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			original := jpegBuf.String()
+			if tc.format == types.IMAGE_FORMAT_PNG {
+				original = pngBuf.String()
+			}
+			data := []byte(withExif(t, tc.format, original, tc.orientation))
+			if exifOrientation(data, tc.format) != int(tc.orientation) {
+				t.Fatalf("Expected orientation %d but got %d", tc.orientation, exifOrientation(data, tc.format))
+			}
+
+			normalized, width, height, err := normalizeImage(data, nil, "")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if width != tc.expWidth || height != tc.expHeight {
+				t.Errorf("Expected a %dx%d image but got %dx%d", tc.expWidth, tc.expHeight, width, height)
+			}
+
+			// the metadata is gone, along with the serial number
+			if bytes.Contains(normalized.Bytes(), []byte("Exif")) || bytes.Contains(normalized.Bytes(), []byte("eXIf")) || bytes.Contains(normalized.Bytes(), []byte("SN1")) {
+				t.Errorf("Expected the metadata to be stripped")
+			}
+
+			upright, format, err := image.Decode(bytes.NewReader(normalized.Bytes()))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if format != tc.format {
+				t.Errorf("Expected the image to stay a %s one but got %s", tc.format, format)
+			}
+			if gray := color.GrayModel.Convert(upright.At(tc.expWhite.X, tc.expWhite.Y)).(color.Gray); gray.Y < 200 {
+				t.Errorf("Expected the white square at %v but got %v", tc.expWhite, gray)
+			}
+		})
+	}
+}
+
+func TestSaveFileKeepsOriginal(t *testing.T) {
+	selfie := withExif(t, types.IMAGE_FORMAT_JPEG, encodeImage(t, types.IMAGE_FORMAT_JPEG, 200, 100), 6)
+
+	for _, keepOriginal := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep original %v", keepOriginal), func(t *testing.T) {
+			dataStore := NewMemoryStore()
+			if err := dataStore.InsertClientData(1, types.SignupPayload{Name: "test", Email: "test@example.com"}, "access", "hash"); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			fileStore := NewMemoryFileStore()
+			service := &Service{
+				dataStore: dataStore,
+				fileStore: fileStore,
+			}
+
+			_, err := service.SaveFile(newFileHeader(t, "selfie.jpeg", selfie), &types.UploadMetaData{
+				UUID:         "uuid",
+				Type:         types.FACE_TYPE,
+				ClientID:     1,
+				FilePath:     "1/uuid.jpeg",
+				FileName:     "selfie.jpeg",
+				Width:        200,
+				Height:       100,
+				KeepOriginal: keepOriginal,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			saved, err := fileStore.GetFile("1/uuid.jpeg")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if keepOriginal != (string(saved) == selfie) {
+				t.Errorf("Expected the original to be kept to be %v", keepOriginal)
+			}
+
+			// the dimensions are the upright ones, unless the original is kept
+			upload, err := dataStore.GetMetaDataByUUID("uuid")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			expWidth, expHeight := 100, 200
+			if keepOriginal {
+				expWidth, expHeight = 200, 100
+			}
+			if upload.Width != expWidth || upload.Height != expHeight {
+				t.Errorf("Expected a %dx%d image but got %dx%d", expWidth, expHeight, upload.Width, upload.Height)
+			}
+
+			// uploads are still deduplicated by the file as sent
+			sum := sha256.Sum256([]byte(selfie))
+			if upload.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("Expected the hash of the file as sent but got %q", upload.SHA256)
+			}
+		})
+	}
+}
+
+type seqUuid struct {
+	n int
+}
+
+func (u *seqUuid) New() string {
+	u.n++
+	return fmt.Sprintf("uuid%d", u.n)
+}
+
+func TestUploadSession(t *testing.T) {
+	dataStore := NewMemoryStore()
+	if err := dataStore.InsertClientData(2, types.SignupPayload{Name: "test", Email: "test@example.com"}, "access", "hash"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fileStore := NewMemoryFileStore()
+	service := &Service{
+		dataStore: dataStore,
+		fileStore: fileStore,
+		uuid:      &seqUuid{},
+	}
+
+	// noise doesn't compress, so the image is split in two chunks
+	noise := image.NewGray(image.Rect(0, 0, 2400, 2400))
+	rand.New(rand.NewSource(1)).Read(noise.Pix)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, noise); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	file := buf.Bytes()
+	payload := types.UploadSessionPayload{Type: types.FACE_TYPE, FileName: "selfie.png", FileSize: int64(len(file))}
+
+	// the size limit of the plan is checked up front, the basic plan only takes 5MB
+	if _, err := service.CreateUploadSession(1, 1, payload); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("Expected error %v but got %v", ErrFileTooLarge, err)
+	}
+	session, err := service.CreateUploadSession(1, 2, payload)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if session.ChunkCount != 2 || session.ChunkSize != UPLOAD_CHUNK_SIZE {
+		t.Fatalf("Expected 2 chunks of %d bytes but got %d of %d", UPLOAD_CHUNK_SIZE, session.ChunkCount, session.ChunkSize)
+	}
+	chunks := [][]byte{file[:UPLOAD_CHUNK_SIZE], file[UPLOAD_CHUNK_SIZE:]}
+
+	if _, err := service.UploadChunk(1, session.ID, 3, bytes.NewReader(chunks[1])); !errors.Is(err, ErrInvalidChunkNumber) {
+		t.Errorf("Expected error %v but got %v", ErrInvalidChunkNumber, err)
+	}
+	if _, err := service.UploadChunk(1, session.ID, 1, bytes.NewReader(chunks[1])); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("Expected error %v but got %v", ErrInvalidChunkSize, err)
+	}
+	if _, err := service.UploadChunk(2, session.ID, 1, bytes.NewReader(chunks[0])); !errors.Is(err, ErrUploadSessionNotFound) {
+		t.Errorf("Expected error %v for another client but got %v", ErrUploadSessionNotFound, err)
+	}
+
+	// the session can't be completed before every chunk is received
+	if _, err := service.UploadChunk(1, session.ID, 2, bytes.NewReader(chunks[1])); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := service.CompleteUploadSession(1, 2, session.ID, false); !errors.Is(err, ErrUploadIncomplete) {
+		t.Errorf("Expected error %v but got %v", ErrUploadIncomplete, err)
+	}
+	if _, err := service.UploadChunk(1, session.ID, 1, bytes.NewReader(chunks[0])); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resumed, err := service.GetUploadSession(1, session.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(resumed.Chunks) != 2 || resumed.Chunks[0].Number != 1 || resumed.Chunks[1].Number != 2 {
+		t.Errorf("Expected both chunks to be received but got %+v", resumed.Chunks)
+	}
+
+	resp, err := service.CompleteUploadSession(1, 2, session.ID, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	upload, err := dataStore.GetMetaDataByUUID(resp.Id)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sum := sha256.Sum256(file)
+	if upload.Type != types.FACE_TYPE || upload.FileName != "selfie.png" || upload.FilePath != fmt.Sprintf("1/%s.png", resp.Id) ||
+		upload.Width != 2400 || upload.Height != 2400 || upload.Format != types.IMAGE_FORMAT_PNG || upload.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected upload: %+v", upload)
+	}
+
+	// the session keeps the upload, the assembled file is deleted
+	completed, err := service.GetUploadSession(1, session.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if completed.UploadID != resp.Id {
+		t.Errorf("Expected the session to be completed as %s but got %q", resp.Id, completed.UploadID)
+	}
+	if _, err := fileStore.GetFile(session.FilePath); err == nil {
+		t.Errorf("Expected the assembled file to be deleted")
+	}
+	if _, err := service.UploadChunk(1, session.ID, 1, bytes.NewReader(chunks[0])); !errors.Is(err, ErrUploadSessionCompleted) {
+		t.Errorf("Expected error %v but got %v", ErrUploadSessionCompleted, err)
+	}
+	if err := service.AbortUploadSession(1, session.ID); !errors.Is(err, ErrUploadSessionCompleted) {
+		t.Errorf("Expected error %v but got %v", ErrUploadSessionCompleted, err)
+	}
+
+	// completing it again, like a client whose response got lost would, returns the same upload
+	retried, err := service.CompleteUploadSession(1, 2, session.ID, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if retried.Id != resp.Id {
+		t.Errorf("Expected the upload %s to be returned but got %+v", resp.Id, retried)
+	}
+
+	// the same file uploaded at once is the same upload
+	fileHeader := newFileHeader(t, "selfie.png", string(file))
+	again, err := service.SaveFile(fileHeader, &types.UploadMetaData{UUID: "uuid-again", Type: types.FACE_TYPE, ClientID: 1, FilePath: "1/uuid-again.png", FileName: "selfie.png"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !again.Deduplicated || again.Id != resp.Id {
+		t.Errorf("Expected the upload %s to be returned but got %+v", resp.Id, again)
+	}
+
+	// aborted sessions are gone
+	aborted, err := service.CreateUploadSession(1, 2, payload)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := service.AbortUploadSession(1, aborted.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := service.UploadChunk(1, aborted.ID, 1, bytes.NewReader(chunks[0])); !errors.Is(err, ErrUploadSessionNotFound) {
+		t.Errorf("Expected error %v but got %v", ErrUploadSessionNotFound, err)
+	}
+}
+
+// flakyStore fails to save the metadata of an upload once, like a db dropping the connection would
+type flakyStore struct {
+	*MemoryStore
+	fail bool
+}
+
+func (s *flakyStore) InsertUploadMetaData(uploadMetaData *types.UploadMetaData) error {
+	if s.fail {
+		s.fail = false
+		return errors.New("connection reset by peer")
+	}
+	return s.MemoryStore.InsertUploadMetaData(uploadMetaData)
+}
+
+func TestCompleteUploadSessionRetry(t *testing.T) {
+	dataStore := &flakyStore{MemoryStore: NewMemoryStore()}
+	if err := dataStore.InsertClientData(2, types.SignupPayload{Name: "test", Email: "test@example.com"}, "access", "hash"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fileStore := NewMemoryFileStore()
+	service := &Service{
+		dataStore: dataStore,
+		fileStore: fileStore,
+		uuid:      &seqUuid{},
+	}
+
+	start := func(file string) *types.UploadSession {
+		session, err := service.CreateUploadSession(1, 2, types.UploadSessionPayload{Type: types.FACE_TYPE, FileName: "selfie.png", FileSize: int64(len(file))})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := service.UploadChunk(1, session.ID, 1, strings.NewReader(file)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return session
+	}
+
+	// the session and the assembled file are kept when the upload can't be saved
+	session := start(encodeImage(t, types.IMAGE_FORMAT_PNG, 200, 100))
+	dataStore.fail = true
+	if _, err := service.CompleteUploadSession(1, 2, session.ID, false); err == nil {
+		t.Fatalf("Expected an error")
+	}
+	if _, err := service.GetUploadSession(1, session.ID); err != nil {
+		t.Fatalf("Expected the session to be kept but got %v", err)
+	}
+	if _, err := fileStore.GetFile(session.FilePath); err != nil {
+		t.Fatalf("Expected the assembled file to be kept but got %v", err)
+	}
+
+	resp, err := service.CompleteUploadSession(1, 2, session.ID, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := dataStore.GetMetaDataByUUID(resp.Id); err != nil {
+		t.Errorf("Expected the upload to be saved but got %v", err)
+	}
+	if _, err := fileStore.GetFile(session.FilePath); err == nil {
+		t.Errorf("Expected the assembled file to be deleted")
+	}
+
+	// files which don't pass the checks drop the session
+	invalid := start("not an image")
+	if _, err := service.CompleteUploadSession(1, 2, invalid.ID, false); !isValidationError(err) {
+		t.Fatalf("Expected a validation error but got %v", err)
+	}
+	if _, err := service.GetUploadSession(1, invalid.ID); !errors.Is(err, ErrUploadSessionNotFound) {
+		t.Errorf("Expected error %v but got %v", ErrUploadSessionNotFound, err)
+	}
+	if _, err := fileStore.GetFile(invalid.FilePath); err == nil {
+		t.Errorf("Expected the assembled file to be deleted")
+	}
+}
+
+func TestPresignedUpload(t *testing.T) {
+	dataStore := NewMemoryStore()
+	for clientID := 1; clientID <= 2; clientID++ {
+		if err := dataStore.InsertClientData(1, types.SignupPayload{Name: "test", Email: "test@example.com"}, fmt.Sprintf("access%d", clientID), "hash"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	fileStore := NewMemoryFileStore()
+	service := &Service{
+		dataStore: dataStore,
+		fileStore: fileStore,
+		uuid:      &UuidService{},
+	}
+	selfie := encodeImage(t, types.IMAGE_FORMAT_PNG, 200, 100)
+	payload := types.PresignedUploadPayload{Type: types.FACE_TYPE, FileName: "selfie.png"}
+
+	// put stands for the client putting the file with the presigned url
+	presign := func(t *testing.T) string {
+		resp, err := service.PresignUpload(1, payload)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if resp.Method != "PUT" || resp.Headers["Content-Type"] != "image/png" || resp.ExpiresIn != int(DEFAULT_PRESIGN_TTL.Seconds()) ||
+			!strings.Contains(resp.URL, fmt.Sprintf("incoming/1/%s.png", resp.Id)) {
+			t.Errorf("Unexpected presigned upload: %+v", resp)
+		}
+		return resp.Id
+	}
+	put := func(t *testing.T, imgUuid, contentType, content string) {
+		err := fileStore.SaveFile(&types.FileUpload{
+			Name:    fmt.Sprintf("incoming/1/%s.png", imgUuid),
+			Content: strings.NewReader(content),
+			Size:    int64(len(content)),
+			Headers: map[string]string{"Content-Type": contentType},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if _, err := service.PresignUpload(1, types.PresignedUploadPayload{Type: types.FACE_TYPE, FileName: "selfie.gif"}); !errors.Is(err, ErrInvalidFileFormat) {
+		t.Errorf("Expected error %v but got %v", ErrInvalidFileFormat, err)
+	}
+
+	imgUuid := presign(t)
+	if _, err := service.ConfirmPresignedUpload(1, 1, imgUuid, payload, false); !errors.Is(err, ErrPresignedUploadNotFound) {
+		t.Errorf("Expected error %v before the file is put but got %v", ErrPresignedUploadNotFound, err)
+	}
+	put(t, imgUuid, "image/png", selfie)
+	if _, err := service.ConfirmPresignedUpload(2, 1, imgUuid, payload, false); !errors.Is(err, ErrPresignedUploadNotFound) {
+		t.Errorf("Expected error %v for another client but got %v", ErrPresignedUploadNotFound, err)
+	}
+	if _, err := service.ConfirmPresignedUpload(1, 1, "../1/"+imgUuid, payload, false); !errors.Is(err, ErrPresignedUploadNotFound) {
+		t.Errorf("Expected error %v for an id which isn't a uuid but got %v", ErrPresignedUploadNotFound, err)
+	}
+
+	// the confirmed file is the upload, confirming it again returns it as is
+	for i := 0; i < 2; i++ {
+		resp, err := service.ConfirmPresignedUpload(1, 1, imgUuid, payload, false)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if resp.Id != imgUuid || resp.Deduplicated {
+			t.Errorf("Expected upload %s but got %+v", imgUuid, resp)
+		}
+	}
+	upload, err := dataStore.GetMetaDataByUUID(imgUuid)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if upload.ClientID != 1 || upload.Type != types.FACE_TYPE || upload.FileName != "selfie.png" || upload.Width != 200 || upload.Height != 100 ||
+		upload.Format != types.IMAGE_FORMAT_PNG || upload.FilePath != fmt.Sprintf("1/%s.png", imgUuid) {
+		t.Errorf("Unexpected upload: %+v", upload)
+	}
+	if _, err := fileStore.GetFile(fmt.Sprintf("incoming/1/%s.png", imgUuid)); err == nil {
+		t.Errorf("Expected the staged file to be deleted")
+	}
+
+	// the url can't change the upload once it's confirmed, the file put with it is only staged again
+	saved, err := fileStore.GetFile(upload.FilePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	put(t, imgUuid, "image/png", encodeImage(t, types.IMAGE_FORMAT_PNG, 300, 300))
+	if _, err := service.ConfirmPresignedUpload(1, 1, imgUuid, payload, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if after, err := fileStore.GetFile(upload.FilePath); err != nil || !bytes.Equal(after, saved) {
+		t.Errorf("Expected the file of the upload to be unchanged")
+	}
+
+	// uploads are only downloaded by their client
+	download, err := service.PresignDownload(1, imgUuid)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(download.URL, upload.FilePath) {
+		t.Errorf("Expected a url to %s but got %s", upload.FilePath, download.URL)
+	}
+	if _, err := service.PresignDownload(2, imgUuid); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected error %v but got %v", ErrUploadNotFound, err)
+	}
+
+	// the same file put again is deduplicated, and files failing the checks are deleted
+	tt := []struct {
+		name            string
+		contentType     string
+		content         string
+		expErr          error
+		expDeduplicated bool
+	}{
+		{name: "same file again", contentType: "image/png", content: selfie, expDeduplicated: true},
+		{name: "put as another content type", contentType: "image/jpeg", content: selfie, expErr: ErrContentMismatch},
+		{name: "not an image", contentType: "image/png", content: "not an image", expErr: ErrContentMismatch},
+		{name: "too small", contentType: "image/png", content: encodeImage(t, types.IMAGE_FORMAT_PNG, 50, 50), expErr: ErrInvalidImageSize},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			otherUuid := presign(t)
+			put(t, otherUuid, tc.contentType, tc.content)
+			resp, err := service.ConfirmPresignedUpload(1, 1, otherUuid, payload, false)
+			if !errors.Is(err, tc.expErr) {
+				t.Fatalf("Expected error %v but got %v", tc.expErr, err)
+			}
+			if tc.expDeduplicated && (resp.Id != imgUuid || !resp.Deduplicated) {
+				t.Errorf("Expected upload %s to be returned but got %+v", imgUuid, resp)
+			}
+			if _, err := fileStore.GetFile(fmt.Sprintf("incoming/1/%s.png", otherUuid)); err == nil {
+				t.Errorf("Expected the staged file to be deleted")
+			}
+			if _, err := fileStore.GetFile(fmt.Sprintf("1/%s.png", otherUuid)); err == nil {
+				t.Errorf("Expected no file to be saved for the upload")
+			}
+		})
+	}
+
+	// the staged file is kept when the upload can't be saved for now, so it can be confirmed again
+	flaky := &flakyStore{MemoryStore: dataStore, fail: true}
+	service.dataStore = flaky
+	retriedUuid := presign(t)
+	put(t, retriedUuid, "image/png", encodeImage(t, types.IMAGE_FORMAT_PNG, 300, 200))
+	if _, err := service.ConfirmPresignedUpload(1, 1, retriedUuid, payload, false); err == nil {
+		t.Fatalf("Expected an error")
+	}
+	if _, err := fileStore.GetFile(fmt.Sprintf("incoming/1/%s.png", retriedUuid)); err != nil {
+		t.Fatalf("Expected the staged file to be kept but got %v", err)
+	}
+	resp, err := service.ConfirmPresignedUpload(1, 1, retriedUuid, payload, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Id != retriedUuid {
+		t.Errorf("Expected upload %s but got %+v", retriedUuid, resp)
+	}
+	if _, err := fileStore.GetFile(fmt.Sprintf("incoming/1/%s.png", retriedUuid)); err == nil {
+		t.Errorf("Expected the staged file to be deleted")
+	}
+}
+
+func TestUploadLifecycle(t *testing.T) {
+	dataStore := NewMemoryStore()
+	for clientID := 1; clientID <= 2; clientID++ {
+		if err := dataStore.InsertClientData(1, types.SignupPayload{Name: "test", Email: "test@example.com"}, fmt.Sprintf("access%d", clientID), "hash"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	fileStore := NewMemoryFileStore()
+	service := &Service{
+		dataStore: dataStore,
+		fileStore: fileStore,
+		uuid:      &UuidService{},
+	}
+
+	// upload saves a file for client 1 and returns its id
+	upload := func(t *testing.T, uploadType string) string {
+		fileHeader := newFileHeader(t, "selfie.png", encodeImage(t, types.IMAGE_FORMAT_PNG, 200, 100))
+		imgUuid := service.uuid.New()
+		uploadMetaData := &types.UploadMetaData{UUID: imgUuid, Type: uploadType, ClientID: 1, FilePath: fmt.Sprintf("1/%s.png", imgUuid), FileName: "selfie.png"}
+		resp, err := service.SaveFile(fileHeader, uploadMetaData)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return resp.Id
+	}
+	first := upload(t, types.FACE_TYPE)
+	time.Sleep(2 * time.Millisecond)
+	second := upload(t, types.ID_CARD_TYPE)
+
+	// walk through the pages using the returned cursor
+	var ids []string
+	cursor := ""
+	for {
+		resp, err := service.ListUploads(1, types.UploadListQuery{Limit: "1", Cursor: cursor})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, upload := range resp.Uploads {
+			ids = append(ids, upload.Id)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+	if !reflect.DeepEqual(ids, []string{second, first}) {
+		t.Errorf("Expected uploads %v but got %v", []string{second, first}, ids)
+	}
+
+	for query, expErr := range map[types.UploadListQuery]error{
+		{Type: "selfie"}:   ErrInvalidFileType,
+		{Limit: "0"}:       ErrInvalidLimit,
+		{Cursor: "bogus!"}: ErrInvalidCursor,
+	} {
+		if _, err := service.ListUploads(1, query); !errors.Is(err, expErr) {
+			t.Errorf("Expected error %v for %+v but got %v", expErr, query, err)
+		}
+	}
+
+	got, err := service.GetUpload(1, first)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got.Id != first || got.Type != types.FACE_TYPE || got.FileName != "selfie.png" || got.Width != 200 || got.Height != 100 || got.CreatedAt == "" {
+		t.Errorf("Unexpected upload: %+v", got)
+	}
+	// uploads are only seen by their client, and by their whole id
+	if _, err := service.GetUpload(2, first); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected error %v but got %v", ErrUploadNotFound, err)
+	}
+	if _, err := service.GetUpload(1, first[:8]); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected error %v but got %v", ErrUploadNotFound, err)
+	}
+
+	// uploads used by a pending job aren't deleted
+	firstUpload, _ := dataStore.GetMetaDataByUUID(first)
+	if err := dataStore.InsertOCRJobCreated(firstUpload.Id, 1, "job1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := service.DeleteUpload(1, first); !errors.Is(err, store.ErrUploadInUse) {
+		t.Errorf("Expected error %v but got %v", store.ErrUploadInUse, err)
+	}
+	if _, err := fileStore.GetFile(firstUpload.FilePath); err != nil {
+		t.Errorf("Expected the file of the upload in use to be kept but got %v", err)
+	}
+
+	// deleted uploads are gone along with their file
+	secondUpload, _ := dataStore.GetMetaDataByUUID(second)
+	if err := service.DeleteUpload(2, second); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected error %v but got %v", ErrUploadNotFound, err)
+	}
+	if err := service.DeleteUpload(1, second); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := fileStore.GetFile(secondUpload.FilePath); !errors.Is(err, store.ErrFileNotFound) {
+		t.Errorf("Expected error %v but got %v", store.ErrFileNotFound, err)
+	}
+	if _, err := service.GetUpload(1, second); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected error %v but got %v", ErrUploadNotFound, err)
+	}
+	if err := service.DeleteUpload(1, second); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Expected error %v but got %v", ErrUploadNotFound, err)
+	}
+
+	// the same file uploaded again is a new upload
+	if again := upload(t, types.ID_CARD_TYPE); again == second {
+		t.Errorf("Expected a new upload but got the deleted one")
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	tt := []struct {
+		name       string
+		query      types.JobListQuery
+		expJobIDs  []string
+		expHasNext bool
+		expErr     error
+	}{
+		{
+			name:   "invalid job type",
+			query:  types.JobListQuery{Type: "invalid"},
+			expErr: ErrInvalidJobType,
+		},
+		{
+			name:   "invalid job status",
+			query:  types.JobListQuery{Status: "invalid"},
+			expErr: ErrInvalidJobStatus,
+		},
+		{
+			name:   "invalid from timestamp",
+			query:  types.JobListQuery{From: "yesterday"},
+			expErr: ErrInvalidTimeRange,
+		},
+		{
+			name:   "from after to",
+			query:  types.JobListQuery{From: "2024-11-23T00:00:00Z", To: "2024-11-22T00:00:00Z"},
+			expErr: ErrInvalidTimeRange,
+		},
+		{
+			name:   "limit out of range",
+			query:  types.JobListQuery{Limit: "101"},
+			expErr: ErrInvalidLimit,
+		},
+		{
+			name:   "malformed cursor",
+			query:  types.JobListQuery{Cursor: "not-a-cursor"},
+			expErr: ErrInvalidCursor,
+		},
+		{
+			name:      "all jobs in one page",
+			query:     types.JobListQuery{},
+			expJobIDs: []string{"job3", "job2", "job1"},
+		},
+		{
+			name:       "first page with next cursor",
+			query:      types.JobListQuery{Limit: "2"},
+			expJobIDs:  []string{"job3", "job2"},
+			expHasNext: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			service := &Service{
+				dataStore: &mockDataStore{},
+			}
+
+			resp, err := service.ListJobs(1, tc.query)
+			if tc.expErr != nil {
+				if !errors.Is(err, tc.expErr) {
+					t.Errorf("Expected error %q but got %v", tc.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var jobIDs []string
+			for _, job := range resp.Jobs {
+				jobIDs = append(jobIDs, job.JobID)
+			}
+			if !reflect.DeepEqual(jobIDs, tc.expJobIDs) {
+				t.Errorf("Expected jobs %v but got %v", tc.expJobIDs, jobIDs)
+			}
+			if tc.expHasNext != (resp.NextCursor != "") {
+				t.Errorf("Expected next cursor presence to be %v but got %q", tc.expHasNext, resp.NextCursor)
+			}
+		})
+	}
+}
+
+func TestParseJobListQueryTimeZone(t *testing.T) {
+	filter, err := parseJobListQuery(types.JobListQuery{From: "2024-11-22T15:30:00+05:30", To: "2024-11-22T08:00:00-04:00"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expFrom := time.Date(2024, 11, 22, 10, 0, 0, 0, time.UTC)
+	expTo := time.Date(2024, 11, 22, 12, 0, 0, 0, time.UTC)
+	if !filter.CreatedFrom.Equal(expFrom) || filter.CreatedFrom.Location() != time.UTC {
+		t.Errorf("Expected from %v but got %v", expFrom, filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.Equal(expTo) || filter.CreatedTo.Location() != time.UTC {
+		t.Errorf("Expected to %v but got %v", expTo, filter.CreatedTo)
+	}
+}
+
+func TestListJobsPagination(t *testing.T) {
+	service := &Service{
+		dataStore: &mockDataStore{},
+	}
+
+	// walk through the pages using the returned cursor
+	var jobIDs []string
+	cursor := ""
+	for {
+		resp, err := service.ListJobs(1, types.JobListQuery{Limit: "1", Cursor: cursor})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, job := range resp.Jobs {
+			jobIDs = append(jobIDs, job.JobID)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+
+	expJobIDs := []string{"job3", "job2", "job1"}
+	if !reflect.DeepEqual(jobIDs, expJobIDs) {
+		t.Errorf("Expected jobs %v but got %v", expJobIDs, jobIDs)
+	}
+}
+
+// mockResolver resolves the hosts it has, anything else isn't found
+type mockResolver map[string][]string
+
+func (r mockResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestRegisterWebhook(t *testing.T) {
+	tt := []struct {
+		name      string
+		clientID  int
+		payload   types.WebhookPayload
+		expSecret string
+		expErr    error
+	}{
+		{
+			name:     "relative url",
+			clientID: 1,
+			payload:  types.WebhookPayload{URL: "/callback"},
+			expErr:   ErrInvalidWebhookURL,
+		},
+		{
+			name:     "unsupported scheme",
+			clientID: 1,
+			payload:  types.WebhookPayload{URL: "ftp://example.com/callback"},
+			expErr:   ErrInvalidWebhookURL,
+		},
+		{
+			name:     "localhost",
+			clientID: 1,
+			payload:  types.WebhookPayload{URL: "http://localhost:8080/callback"},
+			expErr:   ErrWebhookHost,
+		},
+		{
+			name:     "loopback address",
+			clientID: 1,
+			payload:  types.WebhookPayload{URL: "http://127.0.0.1/callback"},
+			expErr:   ErrWebhookHost,
+		},
+		{
+			name:     "link local address",
+			clientID: 1,
+			payload:  types.WebhookPayload{URL: "http://169.254.169.254/latest/meta-data"},
+			expErr:   ErrWebhookHost,
+		},
+		{
+			name:     "host resolving to a private address",
+			clientID: 1,
+			payload:  types.WebhookPayload{URL: "https://internal.example.com/callback"},
+			expErr:   ErrWebhookHost,
+		},
+		{
+			name:     "host which doesn't resolve",
+			clientID: 1,
+			payload:  types.WebhookPayload{URL: "https://missing.example.com/callback"},
+			expErr:   ErrWebhookHost,
+		},
+		{
+			name:      "first webhook creates the secret",
+			clientID:  1,
+			payload:   types.WebhookPayload{URL: "https://example.com/callback"},
+			expSecret: "whsec_new",
+		},
+		{
+			name:      "later webhooks reuse the secret",
+			clientID:  2,
+			payload:   types.WebhookPayload{URL: "https://example.com/callback"},
+			expSecret: "whsec_existing",
+		},
+		{
+			name:      "secret set by a webhook registered at the same time",
+			clientID:  3,
+			payload:   types.WebhookPayload{URL: "https://example.com/callback"},
+			expSecret: "whsec_raced",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			service := &Service{
+				dataStore:  &mockDataStore{},
+				keyService: &mockKeyService{},
+				resolver: mockResolver{
+					"example.com":          {"93.184.216.34"},
+					"internal.example.com": {"93.184.216.34", "10.0.0.5"},
+				},
+			}
+
+			resp, err := service.RegisterWebhook(tc.clientID, tc.payload)
+			if tc.expErr != nil {
+				if !errors.Is(err, tc.expErr) {
+					t.Errorf("Expected error %q but got %v", tc.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if resp.Secret != tc.expSecret {
+				t.Errorf("Expected secret %q but got %q", tc.expSecret, resp.Secret)
+			}
+			if resp.URL != tc.payload.URL {
+				t.Errorf("Expected url %q but got %q", tc.payload.URL, resp.URL)
+			}
+		})
+	}
+}
+
+func TestDeleteWebhookAndRedeliver(t *testing.T) {
+	service := &Service{
+		dataStore: &mockDataStore{},
+	}
+
+	if err := service.DeleteWebhook(1, 1); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := service.DeleteWebhook(1, 2); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("Expected error %q but got %v", ErrWebhookNotFound, err)
+	}
+	if err := service.RedeliverWebhook(1, 1); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := service.RedeliverWebhook(1, 2); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("Expected error %q but got %v", ErrDeliveryNotFound, err)
+	}
+}
+
+func TestCreateAccessKey(t *testing.T) {
+	tt := []struct {
+		name         string
+		payload      types.AccessKeyPayload
+		expAccessKey string
+		expExpiresAt string
+		expScheme    string
+		expScopes    []string
+		expErr       error
+	}{
+		{
+			name:    "label too long",
+			payload: types.AccessKeyPayload{Label: strings.Repeat("a", 51)},
+			expErr:  ErrInvalidKeyLabel,
+		},
+		{
+			name:    "negative expiry",
+			payload: types.AccessKeyPayload{ExpiresInDays: -1},
+			expErr:  ErrInvalidKeyExpiry,
+		},
+		{
+			name:    "unknown auth scheme",
+			payload: types.AccessKeyPayload{AuthScheme: "basic"},
+			expErr:  ErrInvalidAuthScheme,
+		},
+		{
+			name:    "unknown scope",
+			payload: types.AccessKeyPayload{Scopes: []string{"admin"}, HeldScopes: ALL_SCOPES},
+			expErr:  ErrInvalidScope,
+		},
+		{
+			name:    "scope not held by the creating key",
+			payload: types.AccessKeyPayload{Scopes: []string{SCOPE_OCR}, HeldScopes: []string{SCOPE_UPLOAD}},
+			expErr:  ErrScopeNotHeld,
+		},
+		{
+			name:         "key without expiry",
+			payload:      types.AccessKeyPayload{Label: "ci", HeldScopes: ALL_SCOPES},
+			expAccessKey: "testAccess",
+			expExpiresAt: "NULL",
+			expScheme:    AUTH_SCHEME_HEADER,
+			expScopes:    ALL_SCOPES,
+		},
+		{
+			name:         "key with expiry",
+			payload:      types.AccessKeyPayload{Label: "ci", ExpiresInDays: 2, HeldScopes: ALL_SCOPES},
+			expAccessKey: "testAccess",
+			expExpiresAt: "48h0m0s",
+			expScheme:    AUTH_SCHEME_HEADER,
+			expScopes:    ALL_SCOPES,
+		},
+		{
+			name:         "sandbox client gets a sandbox key",
+			payload:      types.AccessKeyPayload{Sandbox: true, HeldScopes: ALL_SCOPES},
+			expAccessKey: "test_testAccess",
+			expExpiresAt: "NULL",
+			expScheme:    AUTH_SCHEME_HEADER,
+			expScopes:    ALL_SCOPES,
+		},
+		{
+			name:         "key signing its requests",
+			payload:      types.AccessKeyPayload{AuthScheme: AUTH_SCHEME_SIGNATURE, HeldScopes: ALL_SCOPES},
+			expAccessKey: "testAccess",
+			expExpiresAt: "NULL",
+			expScheme:    AUTH_SCHEME_SIGNATURE,
+			expScopes:    ALL_SCOPES,
+		},
+		{
+			name:         "key with some of the scopes",
+			payload:      types.AccessKeyPayload{Scopes: []string{SCOPE_RESULTS_READ, SCOPE_UPLOAD, SCOPE_UPLOAD}, HeldScopes: ALL_SCOPES},
+			expAccessKey: "testAccess",
+			expExpiresAt: "NULL",
+			expScheme:    AUTH_SCHEME_HEADER,
+			expScopes:    []string{SCOPE_UPLOAD, SCOPE_RESULTS_READ},
+		},
+		{
+			name:         "key of a limited key inherits its scopes",
+			payload:      types.AccessKeyPayload{HeldScopes: []string{SCOPE_OCR, SCOPE_UPLOAD}},
+			expAccessKey: "testAccess",
+			expExpiresAt: "NULL",
+			expScheme:    AUTH_SCHEME_HEADER,
+			expScopes:    []string{SCOPE_UPLOAD, SCOPE_OCR},
+		},
+	}
+
+	secrets, err := NewSecretBox([]byte("key"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			service := &Service{
+				dataStore:  &mockDataStore{},
+				keyService: &mockKeyService{},
+				secrets:    secrets,
+			}
+
+			resp, err := service.CreateAccessKey(1, tc.payload)
+			if tc.expErr != nil {
+				if !errors.Is(err, tc.expErr) {
+					t.Errorf("Expected error %q but got %v", tc.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if resp.AccessKey != tc.expAccessKey || resp.SecretKey != "secretAccess" {
+				t.Errorf("Expected keys %q and %q but got %q and %q", tc.expAccessKey, "secretAccess", resp.AccessKey, resp.SecretKey)
+			}
+			if resp.ExpiresAt != tc.expExpiresAt {
+				t.Errorf("Expected expiry %q but got %q", tc.expExpiresAt, resp.ExpiresAt)
+			}
+			if resp.AuthScheme != tc.expScheme {
+				t.Errorf("Expected auth scheme %q but got %q", tc.expScheme, resp.AuthScheme)
+			}
+			if !slices.Equal(resp.Scopes, tc.expScopes) {
+				t.Errorf("Expected scopes %v but got %v", tc.expScopes, resp.Scopes)
+			}
+		})
+	}
+}
+
+func TestCreateSigningAccessKey(t *testing.T) {
+	dataStore := NewMemoryStore()
+	if err := dataStore.InsertClientData(1, types.SignupPayload{Name: "test", Email: "test@example.com"}, "signup", "hash"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// signing keys can't be created without a secret box
+	service := &Service{
+		dataStore:  dataStore,
+		keyService: &mockKeyService{},
+	}
+	_, err := service.CreateAccessKey(1, types.AccessKeyPayload{AuthScheme: AUTH_SCHEME_SIGNATURE})
+	if !errors.Is(err, ErrSigningDisabled) {
+		t.Fatalf("Expected error %q but got %v", ErrSigningDisabled, err)
+	}
+
+	// the secret key is stored sealed, and can be opened again to check signatures
+	service.secrets, err = NewSecretBox([]byte("key"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp, err := service.CreateAccessKey(1, types.AccessKeyPayload{AuthScheme: AUTH_SCHEME_SIGNATURE})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client, err := dataStore.GetClientFromAccessKey(resp.AccessKey)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if client.SigningSecret == "" || client.SigningSecret == resp.SecretKey {
+		t.Fatalf("Expected a sealed signing secret but got %q", client.SigningSecret)
+	}
+	secret, err := service.secrets.Open(client.SigningSecret)
+	if err != nil || secret != resp.SecretKey {
+		t.Errorf("Expected signing secret %q but got %q, error: %v", resp.SecretKey, secret, err)
+	}
+}
+
+func TestSecretBox(t *testing.T) {
+	secrets, err := NewSecretBox([]byte("key"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sealed, err := secrets.Seal("secret")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if again, _ := secrets.Seal("secret"); again == sealed {
+		t.Errorf("Expected every seal to use a new nonce")
+	}
+
+	secret, err := secrets.Open(sealed)
+	if err != nil || secret != "secret" {
+		t.Errorf("Expected secret %q but got %q, error: %v", "secret", secret, err)
+	}
+
+	other, _ := NewSecretBox([]byte("other"))
+	for _, sealed := range []string{"", "not base64", sealed[:10]} {
+		if _, err := secrets.Open(sealed); !errors.Is(err, ErrSealedSecret) {
+			t.Errorf("Expected error %q opening %q but got %v", ErrSealedSecret, sealed, err)
+		}
+	}
+	if _, err := other.Open(sealed); !errors.Is(err, ErrSealedSecret) {
+		t.Errorf("Expected error %q opening with another key but got %v", ErrSealedSecret, err)
+	}
+}
+
+func TestRevokeAccessKey(t *testing.T) {
+	service := &Service{
+		dataStore: &mockDataStore{},
+	}
+
+	if err := service.RevokeAccessKey(1, 1); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := service.RevokeAccessKey(1, 2); !errors.Is(err, ErrAccessKeyNotFound) {
+		t.Errorf("Expected error %q but got %v", ErrAccessKeyNotFound, err)
+	}
+}
+
+func TestRevokeAccessKeyDropsCachedCredentials(t *testing.T) {
+	credentials := NewCredentialCache(NewMemoryCacheStore(), []byte("key"), time.Minute)
+	credentials.Set("testAccess", "secretAccess", &types.ClientData{Id: 1})
+	service := &Service{
+		dataStore:   &mockDataStore{},
+		credentials: credentials,
+	}
+
+	if err := service.RevokeAccessKey(1, 1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := credentials.Get("testAccess", "secretAccess"); ok {
+		t.Errorf("Expected the credentials of the revoked key to be dropped from the cache")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tt := []struct {
+		attempt  int
+		expDelay time.Duration
+	}{
+		{attempt: 1, expDelay: 5 * time.Second},
+		{attempt: 2, expDelay: 10 * time.Second},
+		{attempt: 4, expDelay: 40 * time.Second},
+		{attempt: 30, expDelay: MAX_RETRY_DELAY},
+	}
+
+	for _, tc := range tt {
+		delay := RetryDelay(tc.attempt, 5*time.Second)
+		if delay != tc.expDelay {
+			t.Errorf("Expected delay for attempt %d to be %s but got %s", tc.attempt, tc.expDelay, delay)
+		}
+	}
+}
+
+func TestRetryDelays(t *testing.T) {
+	// every retry queue is declared for a single delay, attempts past the cap share the last one
+	delays := RetryDelays(30, 5*time.Minute)
+	expDelays := []time.Duration{5 * time.Minute, 10 * time.Minute, MAX_RETRY_DELAY}
+	if !slices.Equal(delays, expDelays) {
+		t.Errorf("Expected delays %v but got %v", expDelays, delays)
+	}
+	if delays := RetryDelays(0, 5*time.Second); len(delays) != 0 {
+		t.Errorf("Expected no delays without retries but got %v", delays)
+	}
+}
+
+func TestAttempt(t *testing.T) {
+	tt := []struct {
+		name       string
+		headers    amqp.Table
+		expAttempt int
+	}{
+		{name: "no headers", headers: nil, expAttempt: 0},
+		{name: "attempt set by the queue", headers: amqp.Table{ATTEMPT_HEADER: int32(3)}, expAttempt: 3},
+		{name: "attempt decoded as int64", headers: amqp.Table{ATTEMPT_HEADER: int64(2)}, expAttempt: 2},
+		{name: "attempt of wrong type", headers: amqp.Table{ATTEMPT_HEADER: "3"}, expAttempt: 0},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if attempt := Attempt(tc.headers); attempt != tc.expAttempt {
+				t.Errorf("Expected attempt %d but got %d", tc.expAttempt, attempt)
+			}
+		})
+	}
+}
+
+func TestMemoryQueue(t *testing.T) {
+	queue := NewMemoryQueue(1, 2, time.Millisecond)
+	defer queue.Close()
+
+	msgs, err := queue.PullJobFromQueue(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := queue.PushJobOnQueue([]byte("job")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// retried with increasing attempts until the retries are used up
+	for expAttempt := 0; expAttempt <= 2; expAttempt++ {
+		msg := <-msgs
+		if msg.Attempt != expAttempt {
+			t.Fatalf("Expected attempt %d but got %d", expAttempt, msg.Attempt)
+		}
+
+		err := msg.Nack(true, "failed")
+		if expAttempt < 2 && err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expAttempt == 2 && !errors.Is(err, ErrRetriesExhausted) {
+			t.Fatalf("Expected error %v but got %v", ErrRetriesExhausted, err)
+		}
+	}
+
+	deadLetters := queue.DeadLetters()
+	if len(deadLetters) != 1 || string(deadLetters[0].Body) != "job" || deadLetters[0].LastError != "failed" {
+		t.Fatalf("Unexpected dead letters: %+v", deadLetters)
+	}
+
+	// replayed dead letters start over
+	count, err := queue.ReplayDeadLetters(10)
+	if err != nil || count != 1 {
+		t.Fatalf("Expected 1 replayed dead letter but got %d, error: %v", count, err)
+	}
+	msg := <-msgs
+	if msg.Attempt != 0 {
+		t.Errorf("Expected replayed job to start at attempt 0 but got %d", msg.Attempt)
+	}
+	if err := msg.Ack(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(queue.DeadLetters()) != 0 {
+		t.Errorf("Expected no dead letters after replay")
+	}
+}
+
+func TestMemoryStoreContract(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	storetest.RunDataStoreSuite(t, memoryStore, memoryStore, memoryStore)
+	storetest.RunCronJobDataStoreSuite(t, memoryStore, memoryStore)
+}
+
+func TestMemoryFileStoreContract(t *testing.T) {
+	storetest.RunFileStoreSuite(t, NewMemoryFileStore())
+}
+
+func TestLocalFileStoreContract(t *testing.T) {
+	fileStore, err := NewLocalFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	storetest.RunFileStoreSuite(t, fileStore)
+}
+
+func TestLocalFileStore(t *testing.T) {
+	root := t.TempDir()
+	fileStore, err := NewLocalFileStore(root)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// paths can't leave the root
+	for _, filePath := range []string{"", "/etc/passwd", "../outside.png", "1/../../outside.png", "1/../2/face.png", `1\..\outside.png`} {
+		err := fileStore.SaveFile(&types.FileUpload{Name: filePath, Content: strings.NewReader("content")})
+		if !errors.Is(err, store.ErrInvalidFilePath) {
+			t.Errorf("Expected error %v for %q but got %v", store.ErrInvalidFilePath, filePath, err)
+		}
+		if _, err := fileStore.GetFile(filePath); !errors.Is(err, store.ErrInvalidFilePath) {
+			t.Errorf("Expected error %v for %q but got %v", store.ErrInvalidFilePath, filePath, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "outside.png")); err == nil {
+		t.Errorf("Expected no file outside of the files directory")
+	}
+
+	// the content type is kept in a sidecar, so it outlives the store
+	err = fileStore.SaveFile(&types.FileUpload{
+		Name:    "reports/daily/20241122",
+		Content: strings.NewReader("report"),
+		Headers: map[string]string{"Content-Type": "text/csv"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	reopened, err := NewLocalFileStore(root)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	info, err := reopened.StatFile("reports/daily/20241122")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info.Size != 6 || info.ContentType != "text/csv" {
+		t.Errorf("Unexpected file info: %+v", info)
+	}
+
+	// temp files are renamed into place, none are left behind
+	leftovers, err := os.ReadDir(filepath.Join(root, "tmp"))
+	if err != nil || len(leftovers) != 0 {
+		t.Errorf("Expected no temp files left but got %d, error: %v", len(leftovers), err)
+	}
+
+	if _, err := fileStore.PresignGetURL("reports/daily/20241122", time.Minute); !errors.Is(err, store.ErrPresignNotSupported) {
+		t.Errorf("Expected error %v but got %v", store.ErrPresignNotSupported, err)
+	}
+}
+
+func TestMemoryCacheStoreContract(t *testing.T) {
+	storetest.RunCacheStoreSuite(t, NewMemoryCacheStore())
+}
+
+func TestMemoryRateLimitStoreContract(t *testing.T) {
+	storetest.RunRateLimitStoreSuite(t, NewMemoryRateLimitStore())
+}
+
+func TestCredentialCache(t *testing.T) {
+	cacheStore := NewMemoryCacheStore()
+	now := time.Now()
+	cacheStore.now = func() time.Time { return now }
+	credentials := NewCredentialCache(cacheStore, []byte("key"), time.Minute)
+
+	credentials.Set("access", "secret", &types.ClientData{Id: 1, SecretKeyHash: "hash"})
+	client, ok := credentials.Get("access", "secret")
+	if !ok || client.Id != 1 {
+		t.Fatalf("Expected client 1 from the cache but got %v", client)
+	}
+	if client.SecretKeyHash != "" {
+		t.Errorf("Expected the secret key hash not to be cached")
+	}
+	if _, ok := credentials.Get("access", "wrong"); ok {
+		t.Errorf("Expected a wrong secret key to miss the cache")
+	}
+	if _, ok := NewCredentialCache(cacheStore, []byte("other"), time.Minute).Get("access", "secret"); ok {
+		t.Errorf("Expected a cache with another key to miss")
+	}
+
+	// credentials are kept no longer than the ttl, nor past the expiry of the access key
+	credentials.Set("expiring", "secret", &types.ClientData{Id: 2, KeyExpiresIn: 10 * time.Second})
+	now = now.Add(30 * time.Second)
+	if _, ok := credentials.Get("access", "secret"); !ok {
+		t.Errorf("Expected the credentials to be cached within the ttl")
+	}
+	if _, ok := credentials.Get("expiring", "secret"); ok {
+		t.Errorf("Expected the credentials to expire with the access key")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := credentials.Get("access", "secret"); ok {
+		t.Errorf("Expected the credentials to expire after the ttl")
+	}
+}
+
+func TestTokenSigner(t *testing.T) {
+	signer, err := NewTokenSigner(map[string]string{"k1": "key1"}, "k1", time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	now := time.Now()
+	signer.now = func() time.Time { return now }
+
+	token, err := signer.Issue(&types.ClientData{Id: 7, PlanID: 2, KeyID: 3, Sandbox: true, Scopes: []string{SCOPE_UPLOAD, SCOPE_RESULTS_READ}, KeepOriginalUploads: true, SecretKeyHash: "hash"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client, err := signer.Verify(token)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expClient := &types.ClientData{Id: 7, PlanID: 2, KeyID: 3, Sandbox: true, Scopes: []string{SCOPE_UPLOAD, SCOPE_RESULTS_READ}, KeepOriginalUploads: true}
+	if !reflect.DeepEqual(client, expClient) {
+		t.Errorf("Expected client %+v but got %+v", expClient, client)
+	}
+
+	// tokens signed with the old key keep working after a rotation, until they expire
+	rotated, err := NewTokenSigner(map[string]string{"k1": "key1", "k2": "key2"}, "k2", time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rotated.now = signer.now
+	if _, err := rotated.Verify(token); err != nil {
+		t.Errorf("Expected a token of the old key to be accepted but got %v", err)
+	}
+	newToken, _ := rotated.Issue(expClient)
+	if _, err := signer.Verify(newToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a token of an unknown key to be rejected but got %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	tt := []struct {
+		name  string
+		token string
+	}{
+		{name: "not a jwt", token: "token"},
+		{name: "tampered claims", token: parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"go-ekyc","sub":"8","exp":9999999999}`)) + "." + parts[2]},
+		{name: "tampered signature", token: parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString([]byte("signature"))},
+		{name: "no algorithm", token: base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"k1"}`)) + "." + parts[1] + "."},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := signer.Verify(tc.token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Expected error %q but got %v", ErrInvalidToken, err)
+			}
+		})
+	}
+
+	// tokens expire with the access key they were issued for
+	expiring, err := signer.Issue(&types.ClientData{Id: 7, KeyExpiresIn: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// expired tokens are rejected
+	now = now.Add(10 * time.Second)
+	if _, err := signer.Verify(expiring); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a token of an expired key to be rejected but got %v", err)
+	}
+	if _, err := signer.Verify(token); err != nil {
+		t.Errorf("Expected the token to be accepted within its ttl but got %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := signer.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected an expired token to be rejected but got %v", err)
+	}
+
+	if _, err := NewTokenSigner(map[string]string{"k1": "key1"}, "k2", time.Minute); err == nil {
+		t.Errorf("Expected an error for a missing signing key")
+	}
+}
+
+func TestIssueAndRefreshToken(t *testing.T) {
+	dataStore := NewMemoryStore()
+	if err := dataStore.InsertClientData(1, types.SignupPayload{Name: "test", Email: "test@example.com"}, "access", "hash"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client, err := dataStore.GetClientFromAccessKey("access")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// tokens can't be issued without a signer
+	service := &Service{
+		dataStore:  dataStore,
+		keyService: &mockKeyService{},
+	}
+	if _, err := service.IssueToken(client); !errors.Is(err, ErrTokensDisabled) {
+		t.Fatalf("Expected error %q but got %v", ErrTokensDisabled, err)
+	}
+
+	service.tokens, err = NewTokenSigner(map[string]string{"k1": "key1"}, "k1", time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp, err := service.IssueToken(client)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.TokenType != "Bearer" || resp.ExpiresIn != 60 || resp.RefreshToken != "rt_test1" || resp.RefreshExpiresIn != int(DEFAULT_REFRESH_TOKEN_TTL.Seconds()) {
+		t.Errorf("Unexpected token response: %+v", resp)
+	}
+	if tokenClient, err := service.tokens.Verify(resp.AccessToken); err != nil || tokenClient.Id != client.Id || tokenClient.KeyID != client.KeyID {
+		t.Errorf("Expected a token of client %d but got %+v, error: %v", client.Id, tokenClient, err)
+	}
+
+	// refresh tokens can only be used once
+	refreshed, err := service.RefreshToken(types.RefreshTokenPayload{RefreshToken: resp.RefreshToken})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if refreshed.RefreshToken != "rt_test2" {
+		t.Errorf("Expected a new refresh token but got %q", refreshed.RefreshToken)
+	}
+	if _, err := service.RefreshToken(types.RefreshTokenPayload{RefreshToken: resp.RefreshToken}); !errors.Is(err, ErrInvalidRefresh) {
+		t.Errorf("Expected error %q reusing a refresh token but got %v", ErrInvalidRefresh, err)
+	}
+	if _, err := service.RefreshToken(types.RefreshTokenPayload{}); !errors.Is(err, ErrInvalidRefresh) {
+		t.Errorf("Expected error %q for a missing refresh token but got %v", ErrInvalidRefresh, err)
+	}
+
+	// tokens of a key expiring sooner expire with it
+	expiring := *client
+	expiring.KeyExpiresIn = 10 * time.Second
+	expiringResp, err := service.IssueToken(&expiring)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expiringResp.ExpiresIn != 10 {
+		t.Errorf("Expected a token expiring in 10 seconds but got %+v", expiringResp)
+	}
+
+	// refresh tokens stop working with their key
+	if _, err := dataStore.RevokeAccessKey(client.Id, client.KeyID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := service.RefreshToken(types.RefreshTokenPayload{RefreshToken: expiringResp.RefreshToken}); !errors.Is(err, ErrInvalidRefresh) {
+		t.Errorf("Expected error %q after revoking the key but got %v", ErrInvalidRefresh, err)
+	}
+}
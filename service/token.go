@@ -0,0 +1,86 @@
+package service
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+const TOKEN_TYPE = "Bearer"
+const DEFAULT_REFRESH_TOKEN_TTL = 30 * 24 * time.Hour
+
+// IssueToken returns an access token for the client authenticated with its key, along with a refresh token.
+// A client holds one refresh token per key at a time, issuing a new one replaces the one of the same key.
+func (c Service) IssueToken(client *types.ClientData) (*types.TokenResponse, error) {
+	if c.tokens == nil {
+		return nil, ErrTokensDisabled
+	}
+
+	refreshToken, err := c.keyService.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	err = c.dataStore.SetRefreshToken(client.Id, client.KeyID, hashRefreshToken(refreshToken), c.refreshTokenTTL())
+	if err != nil {
+		return nil, err
+	}
+
+	return c.tokenResponse(client, refreshToken)
+}
+
+// RefreshToken swaps the refresh token for a new access and refresh token.
+// Refresh tokens can only be used once, and stop working with the key they were issued for.
+func (c Service) RefreshToken(payload types.RefreshTokenPayload) (*types.TokenResponse, error) {
+	if c.tokens == nil {
+		return nil, ErrTokensDisabled
+	}
+	if len(payload.RefreshToken) == 0 {
+		return nil, ErrInvalidRefresh
+	}
+
+	refreshToken, err := c.keyService.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	client, err := c.dataStore.RotateRefreshToken(hashRefreshToken(payload.RefreshToken), hashRefreshToken(refreshToken), c.refreshTokenTTL())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidRefresh
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c.tokenResponse(client, refreshToken)
+}
+
+func (c Service) tokenResponse(client *types.ClientData, refreshToken string) (*types.TokenResponse, error) {
+	accessToken, err := c.tokens.Issue(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.TokenResponse{
+		AccessToken:      accessToken,
+		TokenType:        TOKEN_TYPE,
+		ExpiresIn:        int(c.tokens.TTL(client).Seconds()),
+		RefreshToken:     refreshToken,
+		RefreshExpiresIn: int(c.refreshTokenTTL().Seconds()),
+	}, nil
+}
+
+func (c Service) refreshTokenTTL() time.Duration {
+	if c.refreshTTL <= 0 {
+		return DEFAULT_REFRESH_TOKEN_TTL
+	}
+	return c.refreshTTL
+}
+
+// refresh tokens are only stored hashed, they're random enough for a plain hash
+func hashRefreshToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
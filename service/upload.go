@@ -0,0 +1,183 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+const UPLOAD_LIST_DEFAULT_LIMIT = 20
+const UPLOAD_LIST_MAX_LIMIT = 100
+
+// ListUploads returns a page of the uploads of the client, newest first
+func (c Service) ListUploads(clientID int, query types.UploadListQuery) (*types.UploadListResponse, error) {
+	filter, err := parseUploadListQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	filter.ClientID = clientID
+
+	// fetch one extra record to find out if there is a next page
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+	uploads, err := c.dataStore.ListUploads(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &types.UploadListResponse{
+		Uploads: []*types.UploadResponse{},
+	}
+	if len(uploads) > pageSize {
+		uploads = uploads[:pageSize]
+		nextCursor, err := encodeUploadCursor(uploads[pageSize-1])
+		if err != nil {
+			return nil, err
+		}
+		resp.NextCursor = nextCursor
+	}
+	for _, upload := range uploads {
+		resp.Uploads = append(resp.Uploads, uploadResponse(upload))
+	}
+
+	return resp, nil
+}
+
+func (c Service) GetUpload(clientID int, imgUuid string) (*types.UploadResponse, error) {
+	upload, err := c.getClientUpload(clientID, imgUuid)
+	if err != nil {
+		return nil, err
+	}
+
+	return uploadResponse(upload), nil
+}
+
+// DeleteUpload deletes the file of the upload and the upload with it, which is kept in the data store for
+// the jobs and reports referencing it. Uploads used by a job still pending can't be deleted.
+func (c Service) DeleteUpload(clientID int, imgUuid string) error {
+	upload, err := c.getClientUpload(clientID, imgUuid)
+	if err != nil {
+		return err
+	}
+
+	// the upload is deleted first, so no job can start using it once its file is gone
+	err = c.dataStore.DeleteUpload(clientID, upload.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUploadNotFound
+	}
+	if err != nil {
+		return err
+	}
+	c.deleteFile(upload.FilePath)
+
+	return nil
+}
+
+// getClientUpload returns the upload with the given id, as long as it's the client's
+func (c Service) getClientUpload(clientID int, imgUuid string) (*types.UploadMetaData, error) {
+	// the ids handed out are uuids, there's no upload to look up for anything else
+	if _, err := uuid.Parse(imgUuid); err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	upload, err := c.dataStore.GetMetaDataByUUID(imgUuid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if upload.ClientID != clientID {
+		return nil, ErrUploadNotFound
+	}
+
+	return upload, nil
+}
+
+func uploadResponse(upload *types.UploadMetaData) *types.UploadResponse {
+	return &types.UploadResponse{
+		Id:         upload.UUID,
+		Type:       upload.Type,
+		FileName:   upload.FileName,
+		FileSizeKB: upload.FileSizeKB,
+		SHA256:     upload.SHA256,
+		Width:      upload.Width,
+		Height:     upload.Height,
+		Format:     upload.Format,
+		CreatedAt:  upload.CreatedAt,
+	}
+}
+
+func parseUploadListQuery(query types.UploadListQuery) (*types.UploadFilter, error) {
+	filter := &types.UploadFilter{
+		Type:  query.Type,
+		Limit: UPLOAD_LIST_DEFAULT_LIMIT,
+	}
+
+	if query.Type != "" {
+		err := validateFileType(query.Type)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if query.Limit != "" {
+		limit, err := strconv.Atoi(query.Limit)
+		if err != nil || limit < 1 || limit > UPLOAD_LIST_MAX_LIMIT {
+			return nil, ErrInvalidLimit
+		}
+		filter.Limit = limit
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeUploadCursor(query.Cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		filter.Cursor = cursor
+	}
+
+	return filter, nil
+}
+
+// cursor is the position of the last upload on a page, encoded as url safe base64 json like the job ones
+func encodeUploadCursor(upload *types.UploadMetaData) (string, error) {
+	createdAt, err := time.Parse(time.RFC3339Nano, upload.CreatedAt)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing created_at of upload %d: %w", upload.Id, err)
+	}
+
+	cursorBytes, err := json.Marshal(types.UploadCursor{
+		CreatedAt: createdAt,
+		ID:        upload.Id,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(cursorBytes), nil
+}
+
+func decodeUploadCursor(cursor string) (*types.UploadCursor, error) {
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	var uploadCursor types.UploadCursor
+	if err := json.Unmarshal(cursorBytes, &uploadCursor); err != nil {
+		return nil, err
+	}
+	if uploadCursor.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &uploadCursor, nil
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+// UPLOAD_CHUNK_SIZE is the size of every chunk but the last one, the smallest part size minio accepts
+const UPLOAD_CHUNK_SIZE = 5 << 20
+const DEFAULT_UPLOAD_SESSION_TTL = 24 * time.Hour
+const MAX_FILE_NAME_LENGTH = 255
+
+// CreateUploadSession starts a chunked upload of the file, so it can be uploaded in chunks which are retried
+// on their own. The chunks are stored as the parts of a multipart upload in the file store, which are
+// assembled on completion. Sessions are dropped along with their chunks once they expire.
+func (c Service) CreateUploadSession(clientID, planID int, payload types.UploadSessionPayload) (*types.UploadSession, error) {
+	err := validateFileType(payload.Type)
+	if err != nil {
+		return nil, err
+	}
+	err = validateFileExt(payload.FileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload.FileName) > MAX_FILE_NAME_LENGTH {
+		return nil, ErrInvalidFileName
+	}
+	if payload.FileSize <= 0 {
+		return nil, ErrInvalidFileSize
+	}
+
+	// the size limit is checked up front, before any chunk is uploaded
+	err = c.validateUploadSize(payload.FileSize, planID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the chunks are assembled next to the uploads of the client, like clientID/sessions/uuid.extension
+	sessionID := c.uuid.New()
+	filePath := fmt.Sprintf("%d/sessions/%s%s", clientID, sessionID, filepath.Ext(payload.FileName))
+	storeUploadID, err := c.fileStore.StartMultipartUpload(filePath, "application/octet-stream")
+	if err != nil {
+		log.Printf("Error while starting the multipart upload of %s: %s\n", filePath, err.Error())
+		return nil, err
+	}
+
+	session, err := c.dataStore.InsertUploadSession(&types.UploadSession{
+		ID:            sessionID,
+		ClientID:      clientID,
+		Type:          payload.Type,
+		FileName:      payload.FileName,
+		FileSize:      payload.FileSize,
+		ChunkSize:     UPLOAD_CHUNK_SIZE,
+		FilePath:      filePath,
+		StoreUploadID: storeUploadID,
+	}, c.uploadSessionTTL())
+	if err != nil {
+		c.abortMultipartUpload(filePath, storeUploadID)
+		return nil, err
+	}
+	session.ChunkCount = chunkCount(session)
+
+	return session, nil
+}
+
+// GetUploadSession returns the session along with the chunks received so far, to resume it
+func (c Service) GetUploadSession(clientID int, sessionID string) (*types.UploadSession, error) {
+	session, err := c.dataStore.GetUploadSession(clientID, sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUploadSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	session.ChunkCount = chunkCount(session)
+
+	return session, nil
+}
+
+// UploadChunk saves the chunk with the given number, numbered from 1. Every chunk but the last one holds
+// chunk size bytes of the file, the last one holds the rest. A chunk uploaded again replaces the earlier one.
+func (c Service) UploadChunk(clientID int, sessionID string, number int, content io.Reader) (*types.UploadChunk, error) {
+	session, err := c.GetUploadSession(clientID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.UploadID != "" {
+		return nil, ErrUploadSessionCompleted
+	}
+	if number < 1 || number > session.ChunkCount {
+		return nil, ErrInvalidChunkNumber
+	}
+
+	// reading a byte more than expected tells a chunk too large apart
+	size := session.ChunkSize
+	if number == session.ChunkCount {
+		size = session.FileSize - int64(session.ChunkCount-1)*session.ChunkSize
+	}
+	data, err := io.ReadAll(io.LimitReader(content, size+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != size {
+		return nil, fmt.Errorf("%w, chunk %d must be %d bytes", ErrInvalidChunkSize, number, size)
+	}
+
+	etag, err := c.fileStore.SaveFilePart(session.FilePath, session.StoreUploadID, number, bytes.NewReader(data), size)
+	if err != nil {
+		log.Printf("Error while saving chunk %d of upload session %s: %s\n", number, sessionID, err.Error())
+		return nil, err
+	}
+	chunk := &types.UploadChunk{Number: number, Size: size, ETag: etag}
+	err = c.dataStore.SaveUploadChunk(sessionID, chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	return chunk, nil
+}
+
+// CompleteUploadSession assembles the chunks into the file, which is then validated and saved like a file
+// uploaded at once, with the same id and metadata. The session is kept until it expires along with the upload
+// it was completed as, which completing it again returns. When the file can't be saved for now the session
+// is kept as is, and completing it again reads the assembled file. Files which don't pass the checks drop it.
+func (c Service) CompleteUploadSession(clientID, planID int, sessionID string, keepOriginal bool) (*types.FileUploadResponse, error) {
+	session, err := c.GetUploadSession(clientID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.UploadID != "" {
+		return &types.FileUploadResponse{Id: session.UploadID}, nil
+	}
+	if len(session.Chunks) != session.ChunkCount {
+		return nil, fmt.Errorf("%w, %d of %d received", ErrUploadIncomplete, len(session.Chunks), session.ChunkCount)
+	}
+
+	// the chunks are only assembled once, the multipart upload is gone after that
+	assembled, err := c.isAssembled(session)
+	if err != nil {
+		return nil, err
+	}
+	if !assembled {
+		err = c.fileStore.CompleteMultipartUpload(session.FilePath, session.StoreUploadID, session.Chunks)
+		if err != nil {
+			log.Printf("Error while completing the multipart upload of upload session %s: %s\n", sessionID, err.Error())
+			return nil, err
+		}
+	}
+
+	data, err := c.fileStore.GetFile(session.FilePath)
+	if err != nil {
+		log.Printf("Error while reading the assembled file of upload session %s: %s\n", sessionID, err.Error())
+		return nil, err
+	}
+
+	imgUuid := c.uuid.New()
+	uploadMetaData := &types.UploadMetaData{
+		UUID:         imgUuid,
+		Type:         session.Type,
+		ClientID:     clientID,
+		FilePath:     fmt.Sprintf("%d/%s%s", clientID, imgUuid, filepath.Ext(session.FileName)), // filepath is saved like, clientID/uuid.extension
+		FileSizeKB:   int64(len(data)) / 1000,
+		FileName:     session.FileName,
+		KeepOriginal: keepOriginal,
+	}
+	err = c.validateUpload(bytes.NewReader(data), session.FileName, int64(len(data)), uploadMetaData, planID)
+	if isValidationError(err) {
+		c.deleteFile(session.FilePath)
+		if err := c.dataStore.DeleteUploadSession(clientID, sessionID); err != nil {
+			log.Printf("Error while deleting upload session %s: %s\n", sessionID, err.Error())
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.saveUpload(bytes.NewReader(data), int64(len(data)), "image/"+uploadMetaData.Format, uploadMetaData)
+	if err != nil {
+		return nil, err
+	}
+
+	// completing the session again after this returns the upload, even when it's saved again in the meantime
+	err = c.dataStore.SetUploadSessionUpload(clientID, sessionID, resp.Id)
+	if err != nil {
+		log.Printf("Error while recording the upload of upload session %s: %s\n", sessionID, err.Error())
+		return nil, err
+	}
+	c.deleteFile(session.FilePath)
+
+	return resp, nil
+}
+
+// AbortUploadSession drops the session along with the chunks received so far, or the file they were assembled in
+func (c Service) AbortUploadSession(clientID int, sessionID string) error {
+	session, err := c.GetUploadSession(clientID, sessionID)
+	if err != nil {
+		return err
+	}
+	if session.UploadID != "" {
+		return ErrUploadSessionCompleted
+	}
+
+	assembled, err := c.isAssembled(session)
+	if err != nil {
+		return err
+	}
+	if assembled {
+		err = c.fileStore.DeleteFile(session.FilePath)
+	} else {
+		err = c.fileStore.AbortMultipartUpload(session.FilePath, session.StoreUploadID)
+	}
+	if err != nil {
+		log.Printf("Error while dropping the chunks of upload session %s: %s\n", sessionID, err.Error())
+		return err
+	}
+
+	return c.dataStore.DeleteUploadSession(clientID, sessionID)
+}
+
+// isAssembled tells whether the chunks of the session were already assembled into the file,
+// by an earlier attempt at completing it
+func (c Service) isAssembled(session *types.UploadSession) (bool, error) {
+	_, err := c.fileStore.StatFile(session.FilePath)
+	if errors.Is(err, store.ErrFileNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		log.Printf("Error while checking the assembled file of upload session %s: %s\n", session.ID, err.Error())
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c Service) uploadSessionTTL() time.Duration {
+	if c.sessionTTL <= 0 {
+		return DEFAULT_UPLOAD_SESSION_TTL
+	}
+	return c.sessionTTL
+}
+
+// abortMultipartUpload drops the parts of a multipart upload which won't be completed
+func (c Service) abortMultipartUpload(filePath, storeUploadID string) {
+	err := c.fileStore.AbortMultipartUpload(filePath, storeUploadID)
+	if err != nil {
+		log.Printf("Error while aborting the multipart upload of %s: %s\n", filePath, err.Error())
+	}
+}
+
+// chunkCount returns the number of chunks the file of the session is split in
+func chunkCount(session *types.UploadSession) int {
+	return int((session.FileSize + session.ChunkSize - 1) / session.ChunkSize)
+}
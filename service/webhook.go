@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/webhook"
+)
+
+const WEBHOOK_DELIVERY_LIST_LIMIT = 100
+const WEBHOOK_RESOLVE_TIMEOUT = 5 * time.Second
+
+// HostResolver is implemented by net.Resolver
+type HostResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+func (c Service) RegisterWebhook(clientID int, payload types.WebhookPayload) (*types.WebhookResponse, error) {
+	if err := c.validateWebhookURL(payload.URL); err != nil {
+		return nil, err
+	}
+
+	// secret is created along with the first webhook of a client and shared by the rest
+	secret, err := c.dataStore.GetWebhookSecret(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		secret, err = c.keyService.GenerateWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+
+		// a webhook registered at the same time may have set its own, which is the one kept
+		secret, err = c.dataStore.SetWebhookSecret(clientID, secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	webhook, err := c.dataStore.InsertWebhook(clientID, payload.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.WebhookResponse{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		CreatedAt: webhook.CreatedAt,
+		Secret:    secret,
+	}, nil
+}
+
+func (c Service) ListWebhooks(clientID int) ([]*types.Webhook, error) {
+	webhooks, err := c.dataStore.ListWebhooks(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if webhooks == nil {
+		webhooks = []*types.Webhook{}
+	}
+
+	return webhooks, nil
+}
+
+func (c Service) DeleteWebhook(clientID, webhookID int) error {
+	err := c.dataStore.DeleteWebhook(clientID, webhookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrWebhookNotFound
+	}
+
+	return err
+}
+
+func (c Service) ListWebhookDeliveries(clientID int) ([]*types.WebhookDelivery, error) {
+	deliveries, err := c.dataStore.ListWebhookDeliveries(clientID, WEBHOOK_DELIVERY_LIST_LIMIT)
+	if err != nil {
+		return nil, err
+	}
+	if deliveries == nil {
+		deliveries = []*types.WebhookDelivery{}
+	}
+
+	return deliveries, nil
+}
+
+func (c Service) RedeliverWebhook(clientID, deliveryID int) error {
+	// the delivery is made pending again, and picked up by the dispatcher on its next poll
+	err := c.dataStore.ResetWebhookDelivery(clientID, deliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDeliveryNotFound
+	}
+
+	return err
+}
+
+// validateWebhookURL checks the url is absolute, and that its host only resolves to public addresses so webhooks
+// can't reach into the network of the server. The dispatcher checks the addresses again when it connects.
+func (c Service) validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ErrInvalidWebhookURL
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidWebhookURL
+	}
+
+	host := u.Hostname()
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrWebhookHost
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !webhook.IsPublicIP(ip) {
+			return ErrWebhookHost
+		}
+		return nil
+	}
+
+	var resolver HostResolver = net.DefaultResolver
+	if c.resolver != nil {
+		resolver = c.resolver
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), WEBHOOK_RESOLVE_TIMEOUT)
+	defer cancel()
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return ErrWebhookHost
+	}
+	for _, addr := range addrs {
+		if !webhook.IsPublicIP(addr.IP) {
+			return ErrWebhookHost
+		}
+	}
+
+	return nil
+}
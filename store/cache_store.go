@@ -1,6 +1,13 @@
 package store
 
+import "time"
+
 type CacheStore interface {
 	GetObject(key string) (string, error)
 	SetObject(key, val string) error
+	SetObjectWithTTL(key, val string, ttl time.Duration) error
+	DeleteObject(key string) error
+
+	// SetObjectIfNotExists sets the key only if it's missing or expired, and reports whether it did
+	SetObjectIfNotExists(key, val string, ttl time.Duration) (bool, error)
 }
@@ -1,8 +1,18 @@
 package store
 
-import "github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+import (
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
 
 type CronJobDataStore interface {
 	GetReportData(date string) ([]*types.ClientReport, error)
 	GetMonthlyReport(currentMonth, currentYear int) ([][]*types.ClientReportMonthly, error)
+	GetStaleJobs(jobType string, timeout time.Duration) ([]*types.StaleJob, error)
+	RequeueStaleJob(jobType, jobID string, attempts int) error
+	FailStaleJob(jobType, jobID string, attempts int, reason string) error
+	DeleteExpiredIdempotencyKeys() (int64, error)
+	DeleteExpiredUploadSessions() ([]*types.UploadSession, error)
+	DeleteExpiredPresignedUploads() ([]*types.PresignedUpload, error)
 }
@@ -1,19 +1,58 @@
 package store
 
 import (
+	"errors"
+	"time"
+
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 )
 
+// ErrUploadInUse is returned by DeleteUpload while a job referencing the upload is still pending
+var ErrUploadInUse = errors.New("upload is in use by a pending job")
+
+// ErrUploadExists is returned by InsertUploadMetaData when the client already has an upload of the file
+// as the same type, and under the same name for sandbox clients
+var ErrUploadExists = errors.New("upload of the file already exists")
+
 type DataStore interface {
 	GetPlanIdFromName(planName string) (int, error)
+	GetPlanLimit(planID int, endpoint string) (*types.PlanLimit, error)
+	GetPlanMaxUploadBytes(planID int) (int64, error)
 	InsertClientData(planId int, payload types.SignupPayload, accessKey, secretKeyHash string) error
 	GetClientFromAccessKey(accessKey string) (*types.ClientData, error)
+	InsertAccessKey(clientID int, accessKey, secretKeyHash, authScheme, signingSecret, label string, scopes []string, expiresIn time.Duration) (*types.AccessKey, error)
+	ListAccessKeys(clientID int) ([]*types.AccessKey, error)
+	RevokeAccessKey(clientID, keyID int) (string, error)
+	SetRefreshToken(clientID, keyID int, tokenHash string, expiresIn time.Duration) error
+	RotateRefreshToken(tokenHash, newTokenHash string, expiresIn time.Duration) (*types.ClientData, error)
+	InsertIdempotencyKey(clientID int, key, requestHash string, expiresIn time.Duration) (bool, error)
+	GetIdempotencyKey(clientID int, key string) (*types.IdempotencyRecord, error)
+	SaveIdempotentResponse(clientID int, key string, statusCode int, responseBody string) error
+	DeleteIdempotencyKey(clientID int, key string) error
 	InsertUploadMetaData(uploadMetaData *types.UploadMetaData) error
 	GetMetaDataByUUID(imgUuid string) (*types.UploadMetaData, error)
+	GetUploadBySHA256(clientID int, uploadType, sha256, fileName string) (*types.UploadMetaData, error)
+	ListUploads(filter *types.UploadFilter) ([]*types.UploadMetaData, error)
+	DeleteUpload(clientID, uploadID int) error
+	InsertUploadSession(session *types.UploadSession, expiresIn time.Duration) (*types.UploadSession, error)
+	GetUploadSession(clientID int, sessionID string) (*types.UploadSession, error)
+	SaveUploadChunk(sessionID string, chunk *types.UploadChunk) error
+	SetUploadSessionUpload(clientID int, sessionID, uploadID string) error
+	DeleteUploadSession(clientID int, sessionID string) error
+	InsertPresignedUpload(upload *types.PresignedUpload, expiresIn time.Duration) error
+	GetPresignedUpload(clientID int, id string) (*types.PresignedUpload, error)
 	InsertFaceMatchResult(result *types.FaceMatchData) error
 	InsertOCRResult(result *types.OCRData) error
 	InsertFaceMatchJobCreated(img1ID, img2ID, clientID int, jobID string) error
 	InsertOCRJobCreated(imgId, clientID int, jobID string) error
 	GetFaceMatchByJobID(jobID string) (*types.JobRecord, error)
 	GetOCRByJobID(jobID string) (*types.JobRecord, error)
+	ListJobs(filter *types.JobFilter) ([]*types.JobRecord, error)
+	GetWebhookSecret(clientID int) (string, error)
+	SetWebhookSecret(clientID int, secret string) (string, error)
+	InsertWebhook(clientID int, url string) (*types.Webhook, error)
+	ListWebhooks(clientID int) ([]*types.Webhook, error)
+	DeleteWebhook(clientID, webhookID int) error
+	ListWebhookDeliveries(clientID, limit int) ([]*types.WebhookDelivery, error)
+	ResetWebhookDelivery(clientID, deliveryID int) error
 }
@@ -1,10 +1,37 @@
 package store
 
 import (
+	"errors"
+	"io"
+	"time"
+
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 )
 
+// ErrFileNotFound is returned by GetFile when there's no file at the path
+var ErrFileNotFound = errors.New("file not found")
+
+// ErrInvalidFilePath is returned for paths leaving the file store, like absolute ones or ones with .. elements
+var ErrInvalidFilePath = errors.New("invalid file path")
+
+// ErrPresignNotSupported is returned by the file stores which can't presign urls
+var ErrPresignNotSupported = errors.New("presigned urls are not supported by the file store")
+
 type FileStore interface {
 	SaveFile(file *types.FileUpload) error
 	GetFile(filePath string) ([]byte, error)
+	DeleteFile(filePath string) error
+
+	// multipart uploads assemble a file at filePath out of parts saved one by one, numbered from 1.
+	// Parts can be saved again, the last one saved with a number is kept.
+	StartMultipartUpload(filePath, contentType string) (string, error)
+	SaveFilePart(filePath, uploadID string, partNumber int, content io.Reader, size int64) (string, error)
+	CompleteMultipartUpload(filePath, uploadID string, parts []*types.UploadChunk) error
+	AbortMultipartUpload(filePath, uploadID string) error
+
+	// presigned urls let clients put and get a file straight to and from the file store until they expire,
+	// put urls only take a file of the content type they were signed for
+	PresignPutURL(filePath, contentType string, expiresIn time.Duration) (string, error)
+	PresignGetURL(filePath string, expiresIn time.Duration) (string, error)
+	StatFile(filePath string) (*types.FileInfo, error)
 }
@@ -0,0 +1,17 @@
+package store
+
+import (
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+type RateLimitStore interface {
+	// TakeToken takes a token from the bucket of the key, which holds up to burst tokens
+	// and is refilled with ratePerSecond tokens a second since the last call
+	TakeToken(key string, ratePerSecond float64, burst int, now time.Time) (*types.RateLimitResult, error)
+
+	// IncrementQuota counts a call against the quota of the key and returns the calls counted so far,
+	// the count is dropped at expiresAt
+	IncrementQuota(key string, expiresAt time.Time) (int, error)
+}
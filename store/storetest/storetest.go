@@ -0,0 +1,1444 @@
+// Package storetest holds the contract every store implementation must pass,
+// so the in-memory stores can't drift from the postgres, minio and redis ones.
+package storetest
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	seed    = time.Now().UnixNano() % 1e7
+	counter atomic.Int64
+)
+
+// unique returns a short value which doesn't clash with rows of earlier runs against the same database,
+// it fits the 10 characters of an access key with a one letter prefix
+func unique(prefix string) string {
+	return fmt.Sprintf("%s%d", prefix, seed+counter.Add(1))
+}
+
+// newClient signs up a client on the basic plan and returns its id
+func newClient(t *testing.T, ds store.DataStore) int {
+	t.Helper()
+	return signupClient(t, ds, false)
+}
+
+// newSandboxClient signs up a sandbox client on the basic plan and returns its id
+func newSandboxClient(t *testing.T, ds store.DataStore) int {
+	t.Helper()
+	return signupClient(t, ds, true)
+}
+
+func signupClient(t *testing.T, ds store.DataStore, sandbox bool) int {
+	t.Helper()
+
+	planID, err := ds.GetPlanIdFromName("basic")
+	if err != nil {
+		t.Fatalf("Unexpected error while fetching plan: %v", err)
+	}
+
+	accessKey := unique("k")
+	payload := types.SignupPayload{Name: "contract", Email: accessKey + "@example.com", Plan: "basic", Sandbox: sandbox}
+	if err := ds.InsertClientData(planID, payload, accessKey, "hash"); err != nil {
+		t.Fatalf("Unexpected error while inserting client: %v", err)
+	}
+
+	client, err := ds.GetClientFromAccessKey(accessKey)
+	if err != nil {
+		t.Fatalf("Unexpected error while fetching client: %v", err)
+	}
+
+	return client.Id
+}
+
+// newUpload saves the metadata of an upload for the client and returns its id
+func newUpload(t *testing.T, ds store.DataStore, clientID int, uploadType string) int {
+	t.Helper()
+
+	imgUuid := unique("img")
+	err := ds.InsertUploadMetaData(&types.UploadMetaData{
+		UUID:       imgUuid,
+		Type:       uploadType,
+		ClientID:   clientID,
+		FilePath:   fmt.Sprintf("%d/%s.png", clientID, imgUuid),
+		FileSizeKB: 100,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error while inserting upload: %v", err)
+	}
+
+	upload, err := ds.GetMetaDataByUUID(imgUuid)
+	if err != nil {
+		t.Fatalf("Unexpected error while fetching upload: %v", err)
+	}
+
+	return upload.Id
+}
+
+func expectNoRows(t *testing.T, err error) {
+	t.Helper()
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected error %v but got %v", sql.ErrNoRows, err)
+	}
+}
+
+// RunDataStoreSuite checks the api facing, worker and webhook dispatcher sides of a data store against each other
+func RunDataStoreSuite(t *testing.T, ds store.DataStore, ws store.WorkerDataStore, hs store.WebhookDataStore) {
+	t.Run("plans", func(t *testing.T) {
+		planID, err := ds.GetPlanIdFromName("basic")
+		if err != nil || planID == 0 {
+			t.Errorf("Expected basic plan to exist but got id %d, error: %v", planID, err)
+		}
+
+		_, err = ds.GetPlanIdFromName("unknown")
+		expectNoRows(t, err)
+	})
+
+	t.Run("plan limits", func(t *testing.T) {
+		planID, _ := ds.GetPlanIdFromName("basic")
+		limit, err := ds.GetPlanLimit(planID, "face-match")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if limit.Endpoint != "face-match" || limit.RatePerMinute == 0 || limit.Burst == 0 || limit.DailyQuota == 0 || limit.MonthlyQuota == 0 {
+			t.Errorf("Unexpected limit: %+v", limit)
+		}
+
+		// endpoints without a limit of their own fall back to the one of the plan
+		limit, err = ds.GetPlanLimit(planID, "jobs")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if limit.Endpoint != "*" || limit.DailyQuota != 0 {
+			t.Errorf("Unexpected limit: %+v", limit)
+		}
+
+		_, err = ds.GetPlanLimit(0, "face-match")
+		expectNoRows(t, err)
+
+		// every plan has its own upload size limit
+		maxBytes, err := ds.GetPlanMaxUploadBytes(planID)
+		if err != nil || maxBytes <= 0 {
+			t.Errorf("Expected an upload size limit for the basic plan but got %d, error: %v", maxBytes, err)
+		}
+		enterpriseID, _ := ds.GetPlanIdFromName("enterprise")
+		enterpriseMaxBytes, err := ds.GetPlanMaxUploadBytes(enterpriseID)
+		if err != nil || enterpriseMaxBytes <= maxBytes {
+			t.Errorf("Expected the enterprise plan to allow larger uploads than %d but got %d, error: %v", maxBytes, enterpriseMaxBytes, err)
+		}
+		_, err = ds.GetPlanMaxUploadBytes(0)
+		expectNoRows(t, err)
+	})
+
+	t.Run("clients", func(t *testing.T) {
+		planID, _ := ds.GetPlanIdFromName("advanced")
+		accessKey := unique("k")
+		payload := types.SignupPayload{Name: "Jane", Email: "jane@example.com", Plan: "advanced"}
+		if err := ds.InsertClientData(planID, payload, accessKey, "hash"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		client, err := ds.GetClientFromAccessKey(accessKey)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if client.Id == 0 || client.Name != "Jane" || client.Email != "jane@example.com" || client.PlanID != planID || client.SecretKeyHash != "hash" || client.Sandbox || client.KeepOriginalUploads {
+			t.Errorf("Unexpected client: %+v", client)
+		}
+
+		sandboxKey := "test_" + unique("k")
+		payload = types.SignupPayload{Name: "QA", Email: "qa@example.com", Plan: "advanced", Sandbox: true, KeepOriginalUploads: true}
+		if err := ds.InsertClientData(planID, payload, sandboxKey, "hash"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		sandboxClient, err := ds.GetClientFromAccessKey(sandboxKey)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !sandboxClient.Sandbox || !sandboxClient.KeepOriginalUploads {
+			t.Errorf("Expected a sandbox client keeping its original uploads but got %+v", sandboxClient)
+		}
+
+		_, err = ds.GetClientFromAccessKey("missing")
+		expectNoRows(t, err)
+	})
+
+	t.Run("access keys", func(t *testing.T) {
+		clientID := newClient(t, ds)
+		keys, err := ds.ListAccessKeys(clientID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(keys) != 1 || keys[0].Label != "default" || keys[0].AuthScheme != "header" || keys[0].ExpiresAt != "NULL" || keys[0].RevokedAt != "NULL" {
+			t.Fatalf("Expected the key of the signup but got %+v", keys)
+		}
+		// the key of the signup has all the scopes
+		if len(keys[0].Scopes) != 5 {
+			t.Errorf("Expected the key of the signup to have all the scopes but got %v", keys[0].Scopes)
+		}
+		signupKey := keys[0]
+
+		// a second key works alongside the first one
+		accessKey := unique("k")
+		key, err := ds.InsertAccessKey(clientID, accessKey, "hash2", "header", "", "ci", []string{"upload", "results:read"}, time.Hour)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if key.ID == 0 || key.AccessKey != accessKey || key.Label != "ci" || key.ExpiresAt == "NULL" || !slices.Equal(key.Scopes, []string{"upload", "results:read"}) {
+			t.Errorf("Unexpected key: %+v", key)
+		}
+		client, err := ds.GetClientFromAccessKey(accessKey)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if client.Id != clientID || client.KeyID != key.ID || client.SecretKeyHash != "hash2" || client.KeyExpiresIn <= 0 || client.KeyExpiresIn > time.Hour {
+			t.Errorf("Unexpected client: %+v", client)
+		}
+		if client.AuthScheme != "header" || client.SigningSecret != "" {
+			t.Errorf("Expected a header key without a signing secret but got %+v", client)
+		}
+		if !slices.Equal(client.Scopes, []string{"upload", "results:read"}) {
+			t.Errorf("Expected the scopes of the key but got %v", client.Scopes)
+		}
+
+		// keys signing their requests keep their sealed secret
+		signingKey := unique("k")
+		key, err = ds.InsertAccessKey(clientID, signingKey, "hash4", "signature", "sealed", "signer", []string{"ocr"}, 0)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if key.AuthScheme != "signature" {
+			t.Errorf("Unexpected key: %+v", key)
+		}
+		client, err = ds.GetClientFromAccessKey(signingKey)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if client.AuthScheme != "signature" || client.SigningSecret != "sealed" || client.KeyExpiresIn != 0 {
+			t.Errorf("Unexpected client: %+v", client)
+		}
+
+		// revoked keys stop working, but are still listed
+		revoked, err := ds.RevokeAccessKey(clientID, signupKey.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if revoked != signupKey.AccessKey {
+			t.Errorf("Expected revoked access key %q but got %q", signupKey.AccessKey, revoked)
+		}
+		_, err = ds.GetClientFromAccessKey(signupKey.AccessKey)
+		expectNoRows(t, err)
+		_, err = ds.RevokeAccessKey(clientID, signupKey.ID)
+		expectNoRows(t, err)
+		_, err = ds.RevokeAccessKey(clientID+1, key.ID)
+		expectNoRows(t, err)
+
+		keys, err = ds.ListAccessKeys(clientID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(keys) != 3 || keys[0].RevokedAt == "NULL" || keys[1].RevokedAt != "NULL" || keys[2].AuthScheme != "signature" || !slices.Equal(keys[2].Scopes, []string{"ocr"}) {
+			t.Errorf("Expected the revoked and the new keys but got %+v", keys)
+		}
+
+		// expired keys stop working
+		expiringKey := unique("k")
+		if _, err := ds.InsertAccessKey(clientID, expiringKey, "hash3", "header", "", "", []string{"upload"}, time.Millisecond); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, err = ds.GetClientFromAccessKey(expiringKey)
+		expectNoRows(t, err)
+	})
+
+	t.Run("refresh tokens", func(t *testing.T) {
+		clientID := newClient(t, ds)
+		keys, err := ds.ListAccessKeys(clientID)
+		if err != nil || len(keys) != 1 {
+			t.Fatalf("Expected the key of the signup but got %+v, error: %v", keys, err)
+		}
+		keyID := keys[0].ID
+
+		// the token is swapped for the new one, and only works once
+		first, second, third := unique("rt"), unique("rt"), unique("rt")
+		if err := ds.SetRefreshToken(clientID, keyID, first, time.Hour); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		client, err := ds.RotateRefreshToken(first, second, time.Hour)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if client.Id != clientID || client.KeyID != keyID || client.PlanID == 0 || client.AccessKey != keys[0].AccessKey || client.AuthScheme != "header" || len(client.Scopes) != 5 {
+			t.Errorf("Unexpected client: %+v", client)
+		}
+		_, err = ds.RotateRefreshToken(first, third, time.Hour)
+		expectNoRows(t, err)
+
+		// setting a token replaces the one issued before
+		if err := ds.SetRefreshToken(clientID, keyID, third, time.Hour); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_, err = ds.RotateRefreshToken(second, unique("rt"), time.Hour)
+		expectNoRows(t, err)
+
+		// but not the one issued for another key of the client
+		otherKey, err := ds.InsertAccessKey(clientID, unique("k"), "hash2", "header", "", "other", []string{"upload"}, 0)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := ds.SetRefreshToken(clientID, otherKey.ID, unique("rt"), time.Hour); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		client, err = ds.RotateRefreshToken(third, unique("rt"), time.Hour)
+		if err != nil || client.KeyID != keyID {
+			t.Fatalf("Expected the token of key %d to keep working but got %+v, error: %v", keyID, client, err)
+		}
+
+		// a key can't be given a token for another client
+		expectNoRows(t, ds.SetRefreshToken(newClient(t, ds), keyID, unique("rt"), time.Hour))
+
+		// expired tokens stop working
+		expiring := unique("rt")
+		if err := ds.SetRefreshToken(clientID, keyID, expiring, time.Millisecond); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, err = ds.RotateRefreshToken(expiring, unique("rt"), time.Hour)
+		expectNoRows(t, err)
+
+		// so do the tokens of revoked keys
+		revoked := unique("rt")
+		if err := ds.SetRefreshToken(clientID, keyID, revoked, time.Hour); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := ds.RevokeAccessKey(clientID, keyID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_, err = ds.RotateRefreshToken(revoked, unique("rt"), time.Hour)
+		expectNoRows(t, err)
+	})
+
+	t.Run("idempotency keys", func(t *testing.T) {
+		clientID := newClient(t, ds)
+		key := unique("idem")
+
+		// a key is only reserved once, while it's in progress and after its response is saved
+		reserved, err := ds.InsertIdempotencyKey(clientID, key, "hash1", time.Hour)
+		if err != nil || !reserved {
+			t.Fatalf("Expected the key to be reserved but got %v, error: %v", reserved, err)
+		}
+		record, err := ds.GetIdempotencyKey(clientID, key)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if record.RequestHash != "hash1" || record.StatusCode != 0 || record.ResponseBody != "" {
+			t.Errorf("Expected a key in progress but got %+v", record)
+		}
+		if err := ds.SaveIdempotentResponse(clientID, key, 200, `{"id":"job1"}`); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		reserved, err = ds.InsertIdempotencyKey(clientID, key, "hash2", time.Hour)
+		if err != nil || reserved {
+			t.Fatalf("Expected the key not to be reserved again but got %v, error: %v", reserved, err)
+		}
+		record, err = ds.GetIdempotencyKey(clientID, key)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if record.RequestHash != "hash1" || record.StatusCode != 200 || record.ResponseBody != `{"id":"job1"}` {
+			t.Errorf("Expected the saved response but got %+v", record)
+		}
+
+		// keys are scoped to the client
+		otherClientID := newClient(t, ds)
+		_, err = ds.GetIdempotencyKey(otherClientID, key)
+		expectNoRows(t, err)
+		reserved, err = ds.InsertIdempotencyKey(otherClientID, key, "hash3", time.Hour)
+		if err != nil || !reserved {
+			t.Fatalf("Expected the key to be reserved for another client but got %v, error: %v", reserved, err)
+		}
+
+		// deleted keys can be reserved again
+		if err := ds.DeleteIdempotencyKey(clientID, key); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_, err = ds.GetIdempotencyKey(clientID, key)
+		expectNoRows(t, err)
+		reserved, err = ds.InsertIdempotencyKey(clientID, key, "hash4", time.Millisecond)
+		if err != nil || !reserved {
+			t.Fatalf("Expected a deleted key to be reserved again but got %v, error: %v", reserved, err)
+		}
+
+		// so can the expired ones
+		time.Sleep(10 * time.Millisecond)
+		_, err = ds.GetIdempotencyKey(clientID, key)
+		expectNoRows(t, err)
+		reserved, err = ds.InsertIdempotencyKey(clientID, key, "hash5", time.Hour)
+		if err != nil || !reserved {
+			t.Fatalf("Expected an expired key to be reserved again but got %v, error: %v", reserved, err)
+		}
+		record, err = ds.GetIdempotencyKey(clientID, key)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if record.RequestHash != "hash5" || record.StatusCode != 0 {
+			t.Errorf("Expected a new key in progress but got %+v", record)
+		}
+	})
+
+	t.Run("uploads", func(t *testing.T) {
+		clientID := newClient(t, ds)
+		imgUuid := unique("img")
+		sha256 := fmt.Sprintf("%064s", imgUuid)
+		err := ds.InsertUploadMetaData(&types.UploadMetaData{
+			UUID:       imgUuid,
+			Type:       types.ID_CARD_TYPE,
+			ClientID:   clientID,
+			FilePath:   fmt.Sprintf("%d/%s.png", clientID, imgUuid),
+			FileSizeKB: 42,
+			FileName:   "selfie.png",
+			SHA256:     sha256,
+			Width:      640,
+			Height:     480,
+			Format:     types.IMAGE_FORMAT_PNG,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		upload, err := ds.GetMetaDataByUUID(imgUuid)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if upload.Id == 0 || upload.UUID != imgUuid || upload.Type != types.ID_CARD_TYPE || upload.ClientID != clientID || upload.FileSizeKB != 42 || upload.FileName != "selfie.png" || upload.SHA256 != sha256 ||
+			upload.Width != 640 || upload.Height != 480 || upload.Format != types.IMAGE_FORMAT_PNG {
+			t.Errorf("Unexpected upload: %+v", upload)
+		}
+
+		_, err = ds.GetMetaDataByUUID(unique("missing"))
+		expectNoRows(t, err)
+
+		// uploads are only found by their whole id
+		for _, partial := range []string{imgUuid[:len(imgUuid)-1], imgUuid[1:], ".png", ""} {
+			_, err = ds.GetMetaDataByUUID(partial)
+			expectNoRows(t, err)
+		}
+
+		// uploads are found by their hash, only for the client and type they were uploaded as
+		byHash, err := ds.GetUploadBySHA256(clientID, types.ID_CARD_TYPE, sha256, "selfie.png")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if *byHash != *upload {
+			t.Errorf("Expected upload %+v but got %+v", upload, byHash)
+		}
+		_, err = ds.GetUploadBySHA256(clientID, types.FACE_TYPE, sha256, "selfie.png")
+		expectNoRows(t, err)
+		_, err = ds.GetUploadBySHA256(newClient(t, ds), types.ID_CARD_TYPE, sha256, "selfie.png")
+		expectNoRows(t, err)
+		_, err = ds.GetUploadBySHA256(clientID, types.ID_CARD_TYPE, "", "selfie.png")
+		expectNoRows(t, err)
+		byHash, err = ds.GetUploadBySHA256(clientID, types.ID_CARD_TYPE, sha256, "force_failure.png")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if byHash.UUID != imgUuid {
+			t.Errorf("Expected upload %s under any name but got %+v", imgUuid, byHash)
+		}
+
+		// a client has a single upload of a file as a type, whatever its name
+		copyUuid := unique("img")
+		duplicate := &types.UploadMetaData{
+			UUID:     copyUuid,
+			Type:     types.ID_CARD_TYPE,
+			ClientID: clientID,
+			FilePath: fmt.Sprintf("%d/%s.png", clientID, copyUuid),
+			FileName: "selfie.png",
+			SHA256:   sha256,
+		}
+		for _, fileName := range []string{"selfie.png", "force_failure.png"} {
+			duplicate.FileName = fileName
+			if err := ds.InsertUploadMetaData(duplicate); !errors.Is(err, store.ErrUploadExists) {
+				t.Errorf("Expected error %v for %s but got %v", store.ErrUploadExists, fileName, err)
+			}
+		}
+
+		// sandbox clients pick their scenarios by the file name, so the same file under another name is another upload
+		sandboxID := newSandboxClient(t, ds)
+		for _, fileName := range []string{"selfie.png", "force_failure.png"} {
+			sandboxUuid := unique("img")
+			err := ds.InsertUploadMetaData(&types.UploadMetaData{
+				UUID:     sandboxUuid,
+				Type:     types.ID_CARD_TYPE,
+				ClientID: sandboxID,
+				FilePath: fmt.Sprintf("%d/%s.png", sandboxID, sandboxUuid),
+				FileName: fileName,
+				SHA256:   sha256,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			byHash, err := ds.GetUploadBySHA256(sandboxID, types.ID_CARD_TYPE, sha256, fileName)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if byHash.UUID != sandboxUuid {
+				t.Errorf("Expected upload %s under %s but got %+v", sandboxUuid, fileName, byHash)
+			}
+		}
+		_, err = ds.GetUploadBySHA256(sandboxID, types.ID_CARD_TYPE, sha256, "other.png")
+		expectNoRows(t, err)
+
+		// the worker resolves the images of a job the same way
+		workerUpload, err := ws.GetMetaDataByUUID(imgUuid)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if *workerUpload != *upload {
+			t.Errorf("Expected upload %+v but got %+v", upload, workerUpload)
+		}
+		_, err = ws.GetMetaDataByUUID(unique("missing"))
+		expectNoRows(t, err)
+	})
+
+	t.Run("upload sessions", func(t *testing.T) {
+		clientID := newClient(t, ds)
+		sessionID := unique("session")
+		session, err := ds.InsertUploadSession(&types.UploadSession{
+			ID:            sessionID,
+			ClientID:      clientID,
+			Type:          types.FACE_TYPE,
+			FileName:      "selfie.png",
+			FileSize:      12 << 20,
+			ChunkSize:     5 << 20,
+			FilePath:      fmt.Sprintf("%d/sessions/%s.png", clientID, sessionID),
+			StoreUploadID: "upload1",
+		}, time.Hour)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if session.ExpiresAt == "" || len(session.Chunks) != 0 {
+			t.Errorf("Unexpected upload session: %+v", session)
+		}
+
+		// chunks are returned ordered by their number, one uploaded again replaces the earlier one
+		for _, chunk := range []*types.UploadChunk{{Number: 3, Size: 2 << 20, ETag: "etag3"}, {Number: 1, Size: 5 << 20, ETag: "old"}, {Number: 1, Size: 5 << 20, ETag: "etag1"}} {
+			if err := ds.SaveUploadChunk(sessionID, chunk); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		saved, err := ds.GetUploadSession(clientID, sessionID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if saved.Type != types.FACE_TYPE || saved.FileName != "selfie.png" || saved.FileSize != 12<<20 || saved.ChunkSize != 5<<20 ||
+			saved.FilePath != session.FilePath || saved.StoreUploadID != "upload1" || saved.ExpiresAt == "" {
+			t.Errorf("Unexpected upload session: %+v", saved)
+		}
+		if len(saved.Chunks) != 2 || *saved.Chunks[0] != (types.UploadChunk{Number: 1, Size: 5 << 20, ETag: "etag1"}) ||
+			*saved.Chunks[1] != (types.UploadChunk{Number: 3, Size: 2 << 20, ETag: "etag3"}) {
+			t.Errorf("Unexpected chunks: %+v", saved.Chunks)
+		}
+
+		// sessions are scoped to the client
+		otherClientID := newClient(t, ds)
+		_, err = ds.GetUploadSession(otherClientID, sessionID)
+		expectNoRows(t, err)
+
+		// completed sessions keep the upload they were completed as
+		if saved.UploadID != "" {
+			t.Errorf("Expected the session not to be completed but got %q", saved.UploadID)
+		}
+		expectNoRows(t, ds.SetUploadSessionUpload(otherClientID, sessionID, "image1"))
+		if err := ds.SetUploadSessionUpload(clientID, sessionID, "image1"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		completed, err := ds.GetUploadSession(clientID, sessionID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if completed.UploadID != "image1" {
+			t.Errorf("Expected the session to be completed as image1 but got %q", completed.UploadID)
+		}
+
+		if err := ds.DeleteUploadSession(clientID, sessionID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_, err = ds.GetUploadSession(clientID, sessionID)
+		expectNoRows(t, err)
+
+		// expired sessions aren't found
+		expiredID := unique("session")
+		_, err = ds.InsertUploadSession(&types.UploadSession{
+			ID:        expiredID,
+			ClientID:  clientID,
+			Type:      types.FACE_TYPE,
+			FileName:  "selfie.png",
+			FileSize:  1,
+			ChunkSize: 5 << 20,
+		}, time.Millisecond)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, err = ds.GetUploadSession(clientID, expiredID)
+		expectNoRows(t, err)
+	})
+
+	t.Run("presigned uploads", func(t *testing.T) {
+		clientID := newClient(t, ds)
+		id := unique("presigned")
+		filePath := fmt.Sprintf("incoming/%d/%s.png", clientID, id)
+		err := ds.InsertPresignedUpload(&types.PresignedUpload{ID: id, ClientID: clientID, FilePath: filePath}, time.Hour)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		upload, err := ds.GetPresignedUpload(clientID, id)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if upload.ID != id || upload.ClientID != clientID || upload.FilePath != filePath || upload.ExpiresAt == "" {
+			t.Errorf("Unexpected presigned upload: %+v", upload)
+		}
+
+		// presigned uploads are scoped to the client
+		_, err = ds.GetPresignedUpload(newClient(t, ds), id)
+		expectNoRows(t, err)
+
+		// expired presigned uploads aren't found
+		expiredID := unique("presigned")
+		err = ds.InsertPresignedUpload(&types.PresignedUpload{ID: expiredID, ClientID: clientID, FilePath: filePath}, time.Millisecond)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, err = ds.GetPresignedUpload(clientID, expiredID)
+		expectNoRows(t, err)
+	})
+
+	t.Run("job lifecycle", func(t *testing.T) {
+		clientID := newClient(t, ds)
+		faceID := newUpload(t, ds, clientID, types.FACE_TYPE)
+		cardID := newUpload(t, ds, clientID, types.ID_CARD_TYPE)
+
+		faceMatchJobID := unique("job")
+		if err := ds.InsertFaceMatchJobCreated(faceID, faceID, clientID, faceMatchJobID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		job, err := ds.GetFaceMatchByJobID(faceMatchJobID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if job.Status != types.JOB_STATUS_CREATED || job.ClientID != clientID || job.Type != types.FACE_MATCH_WORK_TYPE || job.CompletedAt != "NULL" {
+			t.Errorf("Unexpected created job: %+v", job)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, job.CreatedAt); err != nil {
+			t.Errorf("Expected created_at in RFC3339 but got %q", job.CreatedAt)
+		}
+
+		if err := ws.UpdateFaceMatchJobProcessed(faceMatchJobID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := ws.UpdateFaceMatchJobCompleted(faceMatchJobID, 87); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		job, _ = ds.GetFaceMatchByJobID(faceMatchJobID)
+		if job.Status != types.JOB_STATUS_COMPLETED || job.MatchScore != 87 || job.ProcessedAt == "NULL" || job.CompletedAt == "NULL" {
+			t.Errorf("Unexpected completed job: %+v", job)
+		}
+
+		ocrJobID := unique("job")
+		if err := ds.InsertOCRJobCreated(cardID, clientID, ocrJobID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		result := &types.OCRResponse{Name: "Jane", IdNumber: "1234"}
+		if err := ws.UpdateOCRJobCompleted(ocrJobID, result); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		job, err = ds.GetOCRByJobID(ocrJobID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if job.Status != types.JOB_STATUS_COMPLETED || job.OCRDetails != *result || len(job.RawOCRDetails) == 0 {
+			t.Errorf("Unexpected completed ocr job: %+v", job)
+		}
+
+		failedJobID := unique("job")
+		ds.InsertOCRJobCreated(cardID, clientID, failedJobID)
+		if err := ws.UpdateOCRJobFailed(failedJobID, "blurry image"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		job, _ = ds.GetOCRByJobID(failedJobID)
+		if job.Status != types.JOB_STATUS_FAILED || job.FailedReason != "blurry image" || job.FailedAt == "NULL" {
+			t.Errorf("Unexpected failed job: %+v", job)
+		}
+
+		_, err = ds.GetFaceMatchByJobID("missing")
+		expectNoRows(t, err)
+		_, err = ds.GetOCRByJobID("missing")
+		expectNoRows(t, err)
+	})
+
+	t.Run("job listing", func(t *testing.T) {
+		clientID := newClient(t, ds)
+		faceID := newUpload(t, ds, clientID, types.FACE_TYPE)
+		cardID := newUpload(t, ds, clientID, types.ID_CARD_TYPE)
+
+		// inserted apart, so their created_at differ
+		first, second, third := unique("job1"), unique("job2"), unique("job3")
+		ds.InsertFaceMatchJobCreated(faceID, faceID, clientID, first)
+		time.Sleep(2 * time.Millisecond)
+		ds.InsertOCRJobCreated(cardID, clientID, second)
+		time.Sleep(2 * time.Millisecond)
+		ds.InsertFaceMatchJobCreated(faceID, faceID, clientID, third)
+		ws.UpdateFaceMatchJobCompleted(third, 50)
+
+		jobs, err := ds.ListJobs(&types.JobFilter{ClientID: clientID, Limit: 2})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(jobs) != 2 || jobs[0].JobID != third || jobs[1].JobID != second {
+			t.Fatalf("Expected the two newest jobs first but got %+v", jobs)
+		}
+
+		// next page starts after the last job
+		createdAt, err := time.Parse(time.RFC3339Nano, jobs[1].CreatedAt)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		cursor := &types.JobCursor{CreatedAt: createdAt, Type: string(jobs[1].Type), ID: jobs[1].ID}
+		jobs, _ = ds.ListJobs(&types.JobFilter{ClientID: clientID, Cursor: cursor, Limit: 2})
+		if len(jobs) != 1 || jobs[0].JobID != first {
+			t.Errorf("Expected only the oldest job on the next page but got %+v", jobs)
+		}
+
+		jobs, _ = ds.ListJobs(&types.JobFilter{ClientID: clientID, Type: types.FACE_MATCH_WORK_TYPE, Status: types.JOB_STATUS_COMPLETED, Limit: 10})
+		if len(jobs) != 1 || jobs[0].JobID != third || jobs[0].MatchScore != 50 {
+			t.Errorf("Expected only the completed face match job but got %+v", jobs)
+		}
+
+		future := time.Now().Add(time.Hour)
+		jobs, _ = ds.ListJobs(&types.JobFilter{ClientID: clientID, CreatedFrom: &future, Limit: 10})
+		if len(jobs) != 0 {
+			t.Errorf("Expected no jobs created in the future but got %+v", jobs)
+		}
+	})
+
+	t.Run("upload listing", func(t *testing.T) {
+		clientID := newClient(t, ds)
+
+		// inserted apart, so their created_at differ
+		first := newUpload(t, ds, clientID, types.FACE_TYPE)
+		time.Sleep(2 * time.Millisecond)
+		second := newUpload(t, ds, clientID, types.ID_CARD_TYPE)
+		time.Sleep(2 * time.Millisecond)
+		third := newUpload(t, ds, clientID, types.FACE_TYPE)
+		newUpload(t, ds, newClient(t, ds), types.FACE_TYPE)
+
+		uploads, err := ds.ListUploads(&types.UploadFilter{ClientID: clientID, Limit: 2})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(uploads) != 2 || uploads[0].Id != third || uploads[1].Id != second {
+			t.Fatalf("Expected the two newest uploads first but got %+v", uploads)
+		}
+
+		// next page starts after the last upload
+		createdAt, err := time.Parse(time.RFC3339Nano, uploads[1].CreatedAt)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		uploads, _ = ds.ListUploads(&types.UploadFilter{ClientID: clientID, Cursor: &types.UploadCursor{CreatedAt: createdAt, ID: second}, Limit: 2})
+		if len(uploads) != 1 || uploads[0].Id != first {
+			t.Errorf("Expected only the oldest upload on the next page but got %+v", uploads)
+		}
+
+		uploads, _ = ds.ListUploads(&types.UploadFilter{ClientID: clientID, Type: types.ID_CARD_TYPE, Limit: 10})
+		if len(uploads) != 1 || uploads[0].Id != second {
+			t.Errorf("Expected only the id card upload but got %+v", uploads)
+		}
+	})
+
+	t.Run("upload deletion", func(t *testing.T) {
+		clientID := newClient(t, ds)
+		imgUuid := unique("img")
+		err := ds.InsertUploadMetaData(&types.UploadMetaData{
+			UUID:       imgUuid,
+			Type:       types.FACE_TYPE,
+			ClientID:   clientID,
+			FilePath:   fmt.Sprintf("%d/%s.png", clientID, imgUuid),
+			FileSizeKB: 42,
+			SHA256:     fmt.Sprintf("%064s", imgUuid),
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		upload, err := ds.GetMetaDataByUUID(imgUuid)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		// uploads used by a pending job can't be deleted, nor can the uploads of other clients
+		jobID := unique("job")
+		ds.InsertFaceMatchJobCreated(upload.Id, newUpload(t, ds, clientID, types.FACE_TYPE), clientID, jobID)
+		if err := ds.DeleteUpload(clientID, upload.Id); !errors.Is(err, store.ErrUploadInUse) {
+			t.Errorf("Expected error %v but got %v", store.ErrUploadInUse, err)
+		}
+		expectNoRows(t, ds.DeleteUpload(newClient(t, ds), upload.Id))
+
+		// once the job is done, the upload is deleted and no longer found
+		ws.UpdateFaceMatchJobCompleted(jobID, 50)
+		if err := ds.DeleteUpload(clientID, upload.Id); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_, err = ds.GetMetaDataByUUID(imgUuid)
+		expectNoRows(t, err)
+		_, err = ws.GetMetaDataByUUID(imgUuid)
+		expectNoRows(t, err)
+		_, err = ds.GetUploadBySHA256(clientID, types.FACE_TYPE, upload.SHA256, upload.FileName)
+		expectNoRows(t, err)
+		uploads, _ := ds.ListUploads(&types.UploadFilter{ClientID: clientID, Limit: 10})
+		for _, listed := range uploads {
+			if listed.Id == upload.Id {
+				t.Errorf("Expected the deleted upload not to be listed")
+			}
+		}
+		expectNoRows(t, ds.DeleteUpload(clientID, upload.Id))
+
+		// the jobs using it are still found, and no new job can use it
+		if _, err := ds.GetFaceMatchByJobID(jobID); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		expectNoRows(t, ds.InsertFaceMatchJobCreated(upload.Id, upload.Id, clientID, unique("job")))
+		expectNoRows(t, ds.InsertOCRJobCreated(upload.Id, clientID, unique("job")))
+	})
+
+	t.Run("webhooks", func(t *testing.T) {
+		clientID := newClient(t, ds)
+
+		secret, err := ds.GetWebhookSecret(clientID)
+		if err != nil || secret != "" {
+			t.Fatalf("Expected no secret but got %q, error: %v", secret, err)
+		}
+		if secret, err := ds.SetWebhookSecret(clientID, "whsec_contract"); err != nil || secret != "whsec_contract" {
+			t.Errorf("Expected secret to be set but got %q, error: %v", secret, err)
+		}
+		if secret, _ := ds.GetWebhookSecret(clientID); secret != "whsec_contract" {
+			t.Errorf("Expected secret to be set but got %q", secret)
+		}
+
+		// the secret is only set once, later ones get the first
+		if secret, err := ds.SetWebhookSecret(clientID, "whsec_other"); err != nil || secret != "whsec_contract" {
+			t.Errorf("Expected secret %q to be kept but got %q, error: %v", "whsec_contract", secret, err)
+		}
+
+		kept, err := ds.InsertWebhook(clientID, "https://example.com/kept")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		removed, _ := ds.InsertWebhook(clientID, "https://example.com/removed")
+		if err := ds.DeleteWebhook(clientID, removed.ID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expectNoRows(t, ds.DeleteWebhook(clientID, removed.ID))
+		expectNoRows(t, ds.DeleteWebhook(clientID+1000000, kept.ID))
+
+		webhooks, _ := ds.ListWebhooks(clientID)
+		if len(webhooks) != 1 || webhooks[0].ID != kept.ID || webhooks[0].URL != kept.URL {
+			t.Errorf("Expected only the kept webhook but got %+v", webhooks)
+		}
+	})
+
+	t.Run("webhook deliveries", func(t *testing.T) {
+		clientID := newClient(t, ds)
+		faceID := newUpload(t, ds, clientID, types.FACE_TYPE)
+		ds.SetWebhookSecret(clientID, "whsec_contract")
+		webhook, _ := ds.InsertWebhook(clientID, "https://example.com/hook")
+
+		jobID := unique("job")
+		ds.InsertFaceMatchJobCreated(faceID, faceID, clientID, jobID)
+		ws.UpdateFaceMatchJobCompleted(jobID, 70)
+		if err := ws.InsertWebhookDeliveries(types.FACE_MATCH_WORK_TYPE, jobID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		deliveries, _ := ds.ListWebhookDeliveries(clientID, 10)
+		if len(deliveries) != 1 {
+			t.Fatalf("Expected 1 delivery but got %d", len(deliveries))
+		}
+		delivery := deliveries[0]
+		if delivery.WebhookID != webhook.ID || delivery.URL != webhook.URL || delivery.JobID != jobID || delivery.Status != types.DELIVERY_STATUS_PENDING || delivery.Attempts != 0 {
+			t.Errorf("Unexpected delivery: %+v", delivery)
+		}
+
+		// claimed deliveries carry the secret and are leased to the claimer
+		claimed := claimDelivery(t, hs, delivery.ID)
+		if claimed == nil || claimed.Attempts != 1 || claimed.Secret != "whsec_contract" || claimed.URL != webhook.URL {
+			t.Fatalf("Unexpected claimed delivery: %+v", claimed)
+		}
+		if claimDelivery(t, hs, delivery.ID) != nil {
+			t.Errorf("Expected leased delivery not to be claimed again")
+		}
+
+		if err := hs.ScheduleWebhookDeliveryRetry(delivery.ID, 500, "server error", 0); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		claimed = claimDelivery(t, hs, delivery.ID)
+		if claimed == nil || claimed.Attempts != 2 {
+			t.Fatalf("Expected retried delivery to be claimed again but got %+v", claimed)
+		}
+
+		if err := hs.MarkWebhookDeliverySucceeded(delivery.ID, 200); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		deliveries, _ = ds.ListWebhookDeliveries(clientID, 10)
+		if deliveries[0].Status != types.DELIVERY_STATUS_SUCCEEDED || deliveries[0].LastStatusCode != 200 || deliveries[0].DeliveredAt == "NULL" {
+			t.Errorf("Unexpected succeeded delivery: %+v", deliveries[0])
+		}
+
+		if err := ds.ResetWebhookDelivery(clientID, delivery.ID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		deliveries, _ = ds.ListWebhookDeliveries(clientID, 10)
+		if deliveries[0].Status != types.DELIVERY_STATUS_PENDING || deliveries[0].Attempts != 0 {
+			t.Errorf("Unexpected reset delivery: %+v", deliveries[0])
+		}
+		expectNoRows(t, ds.ResetWebhookDelivery(clientID+1000000, delivery.ID))
+
+		// deliveries of a deleted webhook are failed and never claimed, nor can they be redelivered
+		if err := ds.DeleteWebhook(clientID, webhook.ID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if claimDelivery(t, hs, delivery.ID) != nil {
+			t.Errorf("Expected the delivery of a deleted webhook not to be claimed")
+		}
+		deliveries, _ = ds.ListWebhookDeliveries(clientID, 10)
+		if deliveries[0].Status != types.DELIVERY_STATUS_FAILED {
+			t.Errorf("Expected the delivery of a deleted webhook to be failed but got %+v", deliveries[0])
+		}
+		expectNoRows(t, ds.ResetWebhookDelivery(clientID, delivery.ID))
+	})
+}
+
+// claimDelivery claims the due deliveries and returns the one asked for, if it was claimed
+func claimDelivery(t *testing.T, hs store.WebhookDataStore, deliveryID int) *types.WebhookDelivery {
+	t.Helper()
+
+	deliveries, err := hs.ClaimDueWebhookDeliveries(1000, time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error while claiming deliveries: %v", err)
+	}
+	for _, delivery := range deliveries {
+		if delivery.ID == deliveryID {
+			return delivery
+		}
+	}
+
+	return nil
+}
+
+// RunCronJobDataStoreSuite checks the report data against jobs created through the data store
+func RunCronJobDataStoreSuite(t *testing.T, ds store.DataStore, cs store.CronJobDataStore) {
+	clientID := newClient(t, ds)
+	cardID := newUpload(t, ds, clientID, types.ID_CARD_TYPE)
+	faceMatchJobID := unique("job")
+
+	// the file name doesn't give the id away, so the stale jobs must carry the ids themselves
+	faceUuid := unique("img")
+	err := ds.InsertUploadMetaData(&types.UploadMetaData{
+		UUID:       faceUuid,
+		Type:       types.FACE_TYPE,
+		ClientID:   clientID,
+		FilePath:   fmt.Sprintf("%d/%s.png", clientID, unique("file")),
+		FileSizeKB: 100,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error while inserting upload: %v", err)
+	}
+	face, err := ds.GetMetaDataByUUID(faceUuid)
+	if err != nil {
+		t.Fatalf("Unexpected error while fetching upload: %v", err)
+	}
+	faceID := face.Id
+	ocrJobID := unique("job")
+	ds.InsertFaceMatchJobCreated(faceID, faceID, clientID, faceMatchJobID)
+	ds.InsertOCRJobCreated(cardID, clientID, ocrJobID)
+
+	// deleted uploads aren't billed for storage, this one alone would be over a gigabyte
+	deletedUuid := unique("img")
+	err = ds.InsertUploadMetaData(&types.UploadMetaData{
+		UUID:       deletedUuid,
+		Type:       types.FACE_TYPE,
+		ClientID:   clientID,
+		FilePath:   fmt.Sprintf("%d/%s.png", clientID, deletedUuid),
+		FileSizeKB: 1_000_000,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error while inserting upload: %v", err)
+	}
+	deleted, err := ds.GetMetaDataByUUID(deletedUuid)
+	if err != nil {
+		t.Fatalf("Unexpected error while fetching upload: %v", err)
+	}
+	if err := ds.DeleteUpload(clientID, deleted.Id); err != nil {
+		t.Fatalf("Unexpected error while deleting upload: %v", err)
+	}
+
+	// jobs of sandbox clients aren't billed, so they're left out of the reports
+	sandboxClientID := newSandboxClient(t, ds)
+	sandboxFaceID := newUpload(t, ds, sandboxClientID, types.FACE_TYPE)
+	ds.InsertFaceMatchJobCreated(sandboxFaceID, sandboxFaceID, sandboxClientID, unique("job"))
+
+	now := time.Now().UTC()
+	clientKey := fmt.Sprintf("%d", clientID)
+	sandboxClientKey := fmt.Sprintf("%d", sandboxClientID)
+
+	t.Run("daily report", func(t *testing.T) {
+		report, err := cs.GetReportData(now.Format(time.DateOnly))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		for _, r := range report {
+			if r.ClientID == sandboxClientKey {
+				t.Errorf("Expected sandbox client %s to be left out of the report", sandboxClientKey)
+			}
+		}
+		for _, r := range report {
+			if r.ClientID != clientKey {
+				continue
+			}
+			if r.TotalFaceMatch != "1" || r.TotalOcr != "1" || r.Plan != "basic" {
+				t.Errorf("Unexpected report row: %+v", r)
+			}
+			expectStorageUnderMB(t, r.TotalImgStorageMB, 1)
+			return
+		}
+		t.Errorf("Expected a report row for client %s", clientKey)
+	})
+
+	t.Run("monthly report", func(t *testing.T) {
+		reports, err := cs.GetMonthlyReport(int(now.Month()), now.Year())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		for _, clientReports := range reports {
+			for _, r := range clientReports {
+				if r.ClientID == sandboxClientKey {
+					t.Errorf("Expected sandbox client %s to be left out of the report", sandboxClientKey)
+				}
+			}
+		}
+		for _, clientReports := range reports {
+			for _, r := range clientReports {
+				if r.ClientID != clientKey {
+					continue
+				}
+				if r.TotalFaceMatch != "1" || r.TotalOcr != "1" {
+					t.Errorf("Unexpected report row: %+v", r)
+				}
+				expectStorageUnderMB(t, r.TotalImgStorageMB, 1)
+				return
+			}
+		}
+		t.Errorf("Expected a report row for client %s", clientKey)
+	})
+
+	t.Run("stale jobs", func(t *testing.T) {
+		// a negative timeout makes every pending job stale
+		findStale := func(jobType, jobID string) *types.StaleJob {
+			jobs, err := cs.GetStaleJobs(jobType, -time.Hour)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for _, job := range jobs {
+				if job.JobID == jobID {
+					return job
+				}
+			}
+			return nil
+		}
+
+		job := findStale(types.FACE_MATCH_WORK_TYPE, faceMatchJobID)
+		if job == nil {
+			t.Fatalf("Expected face match job %s to be stale", faceMatchJobID)
+		}
+		if job.Status != types.JOB_STATUS_CREATED || job.Attempts != 1 || !slices.Equal(job.ImageIDs, []string{faceUuid, faceUuid}) {
+			t.Errorf("Unexpected stale job: %+v", job)
+		}
+		if jobs, _ := cs.GetStaleJobs(types.FACE_MATCH_WORK_TYPE, time.Hour); containsStaleJob(jobs, faceMatchJobID) {
+			t.Errorf("Expected fresh job %s not to be stale", faceMatchJobID)
+		}
+
+		// requeueing counts an attempt, so a second reaper working off the same read loses
+		if err := cs.RequeueStaleJob(types.FACE_MATCH_WORK_TYPE, faceMatchJobID, 1); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := cs.RequeueStaleJob(types.FACE_MATCH_WORK_TYPE, faceMatchJobID, 1); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Expected sql.ErrNoRows for a requeue at an old attempt but got %v", err)
+		}
+		if job := findStale(types.FACE_MATCH_WORK_TYPE, faceMatchJobID); job == nil || job.Attempts != 2 {
+			t.Errorf("Expected requeued job at attempt 2 but got %+v", job)
+		}
+
+		if err := cs.FailStaleJob(types.OCR_WORK_TYPE, ocrJobID, 1, "timed out after 1 attempts"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		record, err := ds.GetOCRByJobID(ocrJobID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if record.Status != types.JOB_STATUS_FAILED || record.FailedReason != "timed out after 1 attempts" {
+			t.Errorf("Expected failed job but got %+v", record)
+		}
+		if job := findStale(types.OCR_WORK_TYPE, ocrJobID); job != nil {
+			t.Errorf("Expected failed job not to be stale")
+		}
+	})
+
+	t.Run("expired idempotency keys", func(t *testing.T) {
+		expired, kept := unique("idem"), unique("idem")
+		ds.InsertIdempotencyKey(clientID, expired, "hash", time.Millisecond)
+		ds.InsertIdempotencyKey(clientID, kept, "hash", time.Hour)
+		time.Sleep(10 * time.Millisecond)
+
+		count, err := cs.DeleteExpiredIdempotencyKeys()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if count < 1 {
+			t.Errorf("Expected the expired key to be deleted but got %d deleted", count)
+		}
+		if _, err := ds.GetIdempotencyKey(clientID, kept); err != nil {
+			t.Errorf("Expected the key in its ttl to be kept but got %v", err)
+		}
+	})
+
+	t.Run("expired upload sessions", func(t *testing.T) {
+		expired, kept := unique("session"), unique("session")
+		for id, ttl := range map[string]time.Duration{expired: time.Millisecond, kept: time.Hour} {
+			_, err := ds.InsertUploadSession(&types.UploadSession{
+				ID:            id,
+				ClientID:      clientID,
+				Type:          types.FACE_TYPE,
+				FileName:      "selfie.png",
+				FileSize:      1,
+				ChunkSize:     5 << 20,
+				FilePath:      fmt.Sprintf("%d/sessions/%s.png", clientID, id),
+				StoreUploadID: "upload-" + id,
+			}, ttl)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		ds.SaveUploadChunk(expired, &types.UploadChunk{Number: 1, Size: 1, ETag: "etag1"})
+		time.Sleep(10 * time.Millisecond)
+
+		// the deleted sessions are returned, so their multipart uploads can be aborted
+		sessions, err := cs.DeleteExpiredUploadSessions()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		found := false
+		for _, session := range sessions {
+			if session.ID == kept {
+				t.Errorf("Expected the session in its ttl to be kept")
+			}
+			if session.ID == expired {
+				found = session.StoreUploadID == "upload-"+expired && session.FilePath == fmt.Sprintf("%d/sessions/%s.png", clientID, expired)
+			}
+		}
+		if !found {
+			t.Errorf("Expected the expired session to be deleted but got %+v", sessions)
+		}
+		if _, err := ds.GetUploadSession(clientID, kept); err != nil {
+			t.Errorf("Expected the session in its ttl to be kept but got %v", err)
+		}
+	})
+
+	t.Run("expired presigned uploads", func(t *testing.T) {
+		expired, kept := unique("presigned"), unique("presigned")
+		for id, ttl := range map[string]time.Duration{expired: time.Millisecond, kept: time.Hour} {
+			err := ds.InsertPresignedUpload(&types.PresignedUpload{
+				ID:       id,
+				ClientID: clientID,
+				FilePath: fmt.Sprintf("incoming/%d/%s.png", clientID, id),
+			}, ttl)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		// the deleted uploads are returned, so their staged files can be deleted
+		uploads, err := cs.DeleteExpiredPresignedUploads()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		found := false
+		for _, upload := range uploads {
+			if upload.ID == kept {
+				t.Errorf("Expected the presigned upload in its ttl to be kept")
+			}
+			if upload.ID == expired {
+				found = upload.FilePath == fmt.Sprintf("incoming/%d/%s.png", clientID, expired)
+			}
+		}
+		if !found {
+			t.Errorf("Expected the expired presigned upload to be deleted but got %+v", uploads)
+		}
+		if _, err := ds.GetPresignedUpload(clientID, kept); err != nil {
+			t.Errorf("Expected the presigned upload in its ttl to be kept but got %v", err)
+		}
+	})
+}
+
+// expectStorageUnderMB checks the storage billed, which the backends round differently
+func expectStorageUnderMB(t *testing.T, storageMB string, limit float64) {
+	t.Helper()
+
+	storage, err := strconv.ParseFloat(storageMB, 64)
+	if err != nil {
+		t.Fatalf("Unexpected storage %q: %v", storageMB, err)
+	}
+	if storage >= limit {
+		t.Errorf("Expected the storage to be under %v MB but got %v", limit, storage)
+	}
+}
+
+func containsStaleJob(jobs []*types.StaleJob, jobID string) bool {
+	for _, job := range jobs {
+		if job.JobID == jobID {
+			return true
+		}
+	}
+	return false
+}
+
+// RunFileStoreSuite checks saved files can be read back and deleted, missing files return store.ErrFileNotFound,
+// and multipart uploads are assembled in order
+func RunFileStoreSuite(t *testing.T, fs store.FileStore) {
+	name := unique("contract/") + ".png"
+	content := []byte("contract file content")
+	err := fs.SaveFile(&types.FileUpload{
+		Name:    name,
+		Content: bytes.NewReader(content),
+		Size:    int64(len(content)),
+		Headers: map[string]string{"Content-Type": "image/png"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := fs.GetFile(name)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("Expected content %q but got %q", content, data)
+	}
+
+	if _, err := fs.GetFile(unique("missing/")); !errors.Is(err, store.ErrFileNotFound) {
+		t.Errorf("Expected error %v for missing file but got %v", store.ErrFileNotFound, err)
+	}
+
+	info, err := fs.StatFile(name)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info.Size != int64(len(content)) || info.ContentType != "image/png" {
+		t.Errorf("Unexpected file info: %+v", info)
+	}
+	if _, err := fs.StatFile(unique("missing/")); !errors.Is(err, store.ErrFileNotFound) {
+		t.Errorf("Expected error %v for missing file but got %v", store.ErrFileNotFound, err)
+	}
+
+	// presigned urls point at the file, for the stores supporting them
+	putURL, err := fs.PresignPutURL(name, "image/png", time.Minute)
+	if !errors.Is(err, store.ErrPresignNotSupported) && (err != nil || !strings.Contains(putURL, name)) {
+		t.Errorf("Expected a url to put %s but got %q, error: %v", name, putURL, err)
+	}
+	getURL, err := fs.PresignGetURL(name, time.Minute)
+	if !errors.Is(err, store.ErrPresignNotSupported) && (err != nil || !strings.Contains(getURL, name)) {
+		t.Errorf("Expected a url to get %s but got %q, error: %v", name, getURL, err)
+	}
+
+	// deleted files are gone, deleting them again is fine
+	if err := fs.DeleteFile(name); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := fs.GetFile(name); !errors.Is(err, store.ErrFileNotFound) {
+		t.Errorf("Expected error %v for deleted file but got %v", store.ErrFileNotFound, err)
+	}
+	if err := fs.DeleteFile(name); err != nil {
+		t.Errorf("Unexpected error deleting a missing file: %v", err)
+	}
+
+	// multipart uploads are assembled in the order of the part numbers, whatever order the parts were saved in,
+	// every part but the last one must be at least 5MB for minio
+	multipartName := unique("contract/") + ".png"
+	parts := [][]byte{bytes.Repeat([]byte("a"), 5<<20), []byte("last part")}
+	uploadID, err := fs.StartMultipartUpload(multipartName, "image/png")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	chunks := make([]*types.UploadChunk, len(parts))
+	for i := len(parts) - 1; i >= 0; i-- {
+		etag, err := fs.SaveFilePart(multipartName, uploadID, i+1, bytes.NewReader(parts[i]), int64(len(parts[i])))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		chunks[i] = &types.UploadChunk{Number: i + 1, Size: int64(len(parts[i])), ETag: etag}
+	}
+	if err := fs.CompleteMultipartUpload(multipartName, uploadID, chunks); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err = fs.GetFile(multipartName)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(data, bytes.Join(parts, nil)) {
+		t.Errorf("Expected the parts to be assembled in order, got %d bytes", len(data))
+	}
+	fs.DeleteFile(multipartName)
+
+	// aborted uploads can't be completed
+	abortedName := unique("contract/") + ".png"
+	uploadID, err = fs.StartMultipartUpload(abortedName, "image/png")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	etag, err := fs.SaveFilePart(abortedName, uploadID, 1, bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := fs.AbortMultipartUpload(abortedName, uploadID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := fs.CompleteMultipartUpload(abortedName, uploadID, []*types.UploadChunk{{Number: 1, Size: int64(len(content)), ETag: etag}}); err == nil {
+		t.Errorf("Expected an aborted upload not to be completed")
+	}
+	if _, err := fs.GetFile(abortedName); !errors.Is(err, store.ErrFileNotFound) {
+		t.Errorf("Expected error %v for aborted upload but got %v", store.ErrFileNotFound, err)
+	}
+}
+
+// RunCacheStoreSuite checks cached values can be read back, expire, be deleted and set only when missing, and missing keys return redis.Nil
+func RunCacheStoreSuite(t *testing.T, cs store.CacheStore) {
+	key := unique("contract:")
+	if err := cs.SetObject(key, "value"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	val, err := cs.GetObject(key)
+	if err != nil || val != "value" {
+		t.Errorf("Expected value %q but got %q, error: %v", "value", val, err)
+	}
+
+	_, err = cs.GetObject(unique("missing:"))
+	if !errors.Is(err, redis.Nil) {
+		t.Errorf("Expected error %v but got %v", redis.Nil, err)
+	}
+
+	// deleted keys are gone, deleting them again is fine
+	if err := cs.DeleteObject(key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := cs.GetObject(key); !errors.Is(err, redis.Nil) {
+		t.Errorf("Expected error %v for deleted key but got %v", redis.Nil, err)
+	}
+	if err := cs.DeleteObject(key); err != nil {
+		t.Errorf("Unexpected error deleting a missing key: %v", err)
+	}
+
+	// keys with a ttl are readable until it runs out
+	ttlKey := unique("contract:ttl:")
+	if err := cs.SetObjectWithTTL(ttlKey, "value", 200*time.Millisecond); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if val, err := cs.GetObject(ttlKey); err != nil || val != "value" {
+		t.Errorf("Expected value %q but got %q, error: %v", "value", val, err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, err := cs.GetObject(ttlKey); !errors.Is(err, redis.Nil) {
+		t.Errorf("Expected error %v for expired key but got %v", redis.Nil, err)
+	}
+
+	// keys are only set when missing or expired
+	nxKey := unique("contract:nx:")
+	for i, exp := range []bool{true, false} {
+		set, err := cs.SetObjectIfNotExists(nxKey, "value", 200*time.Millisecond)
+		if err != nil || set != exp {
+			t.Errorf("Expected set %t on attempt %d but got %t, error: %v", exp, i+1, set, err)
+		}
+	}
+	time.Sleep(300 * time.Millisecond)
+	if set, err := cs.SetObjectIfNotExists(nxKey, "value", time.Minute); err != nil || !set {
+		t.Errorf("Expected an expired key to be set again but got %t, error: %v", set, err)
+	}
+}
+
+// RunRateLimitStoreSuite checks token buckets are drained and refilled, and quota counts add up
+func RunRateLimitStoreSuite(t *testing.T, rs store.RateLimitStore) {
+	t.Run("token bucket", func(t *testing.T) {
+		key := unique("contract:bucket:")
+		now := time.Now().Truncate(time.Millisecond)
+
+		// new buckets start full
+		for i := range 2 {
+			res, err := rs.TakeToken(key, 1, 2, now)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !res.Allowed || res.Remaining != 1-i {
+				t.Errorf("Expected call %d to be allowed with %d remaining but got %+v", i+1, 1-i, res)
+			}
+		}
+
+		res, err := rs.TakeToken(key, 1, 2, now)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if res.Allowed || res.Remaining != 0 || res.RetryAfter != time.Second || res.ResetAfter != 2*time.Second {
+			t.Errorf("Expected an empty bucket but got %+v", res)
+		}
+
+		// a token is added every second
+		res, err = rs.TakeToken(key, 1, 2, now.Add(time.Second))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !res.Allowed || res.Remaining != 0 {
+			t.Errorf("Expected a refilled token but got %+v", res)
+		}
+	})
+
+	t.Run("quota", func(t *testing.T) {
+		key := unique("contract:quota:")
+		expiresAt := time.Now().Add(time.Hour)
+		for i := 1; i <= 3; i++ {
+			count, err := rs.IncrementQuota(key, expiresAt)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if count != i {
+				t.Errorf("Expected count %d but got %d", i, count)
+			}
+		}
+	})
+}
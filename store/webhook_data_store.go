@@ -0,0 +1,16 @@
+package store
+
+import (
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+type WebhookDataStore interface {
+	ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]*types.WebhookDelivery, error)
+	GetFaceMatchByJobID(jobID string) (*types.JobRecord, error)
+	GetOCRByJobID(jobID string) (*types.JobRecord, error)
+	MarkWebhookDeliverySucceeded(deliveryID, statusCode int) error
+	ScheduleWebhookDeliveryRetry(deliveryID, statusCode int, reason string, delay time.Duration) error
+	MarkWebhookDeliveryFailed(deliveryID, statusCode int, reason string) error
+}
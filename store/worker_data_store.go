@@ -9,4 +9,6 @@ type WorkerDataStore interface {
 	UpdateOCRJobProcessed(jobID string) error
 	UpdateFaceMatchJobFailed(jobID, reason string) error
 	UpdateOCRJobFailed(jobID, reason string) error
+	InsertWebhookDeliveries(jobType, jobID string) error
+	GetMetaDataByUUID(imgUuid string) (*types.UploadMetaData, error)
 }
@@ -0,0 +1,148 @@
+package contract
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/cronjob"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/db"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store/storetest"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/worker"
+)
+
+// the suites run against real backends only when they are configured, e.g. the ones from docker-compose.yml
+// with the migrations applied. They are the same suites the in-memory stores pass in the service package.
+
+func TestPsqlStoreContract(t *testing.T) {
+	dsn := os.Getenv("CONTRACT_DB_DSN")
+	if dsn == "" {
+		t.Skip("CONTRACT_DB_DSN not set")
+	}
+
+	psqlStore := service.NewPsqlStore(dsn)
+	storetest.RunDataStoreSuite(t, psqlStore, worker.NewPsqlWorkerStore(dsn), psqlStore)
+	storetest.RunCronJobDataStoreSuite(t, psqlStore, cronjob.NewPsqlCrobJobStore(dsn))
+}
+
+func TestMinioStoreContract(t *testing.T) {
+	endpoint := os.Getenv("CONTRACT_MINIO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("CONTRACT_MINIO_ENDPOINT not set")
+	}
+
+	minioConn := &db.MinioConn{
+		Endpoint: endpoint,
+		User:     os.Getenv("CONTRACT_MINIO_USER"),
+		Password: os.Getenv("CONTRACT_MINIO_PASSWORD"),
+	}
+	storetest.RunFileStoreSuite(t, service.NewMinioStore(minioConn, "contract"))
+}
+
+func TestRedisStoreContract(t *testing.T) {
+	dsn := os.Getenv("CONTRACT_REDIS_DSN")
+	if dsn == "" {
+		t.Skip("CONTRACT_REDIS_DSN not set")
+	}
+
+	redisStore := service.NewRedisStore(dsn)
+	storetest.RunCacheStoreSuite(t, redisStore)
+	storetest.RunRateLimitStoreSuite(t, redisStore)
+}
+
+// BenchmarkPsqlUploadLookup compares looking an upload up by its uuid column with the LIKE on file_path it replaced.
+// The table is seeded with CONTRACT_BENCH_UPLOADS more uploads (100000 by default) every run, e.g.
+//
+//	CONTRACT_DB_DSN=... go test ./test/contract -run '^$' -bench PsqlUploadLookup
+func BenchmarkPsqlUploadLookup(b *testing.B) {
+	dsn := os.Getenv("CONTRACT_DB_DSN")
+	if dsn == "" {
+		b.Skip("CONTRACT_DB_DSN not set")
+	}
+	count := 100000
+	if env := os.Getenv("CONTRACT_BENCH_UPLOADS"); env != "" {
+		var err error
+		if count, err = strconv.Atoi(env); err != nil || count < 1 {
+			b.Fatalf("Invalid CONTRACT_BENCH_UPLOADS %q", env)
+		}
+	}
+
+	psqlClient := db.NewPsqlClient(dsn)
+	defer psqlClient.Close()
+	uuids := seedUploads(b, service.NewPsqlStore(dsn), psqlClient, count)
+
+	queries := []struct {
+		name  string
+		query string
+	}{
+		{name: "like on file_path", query: "SELECT id FROM upload WHERE file_path LIKE '%' || $1 || '%'"},
+		{name: "uuid", query: "SELECT id FROM upload WHERE uuid = $1"},
+	}
+	for _, q := range queries {
+		b.Run(q.name, func(b *testing.B) {
+			for i := range b.N {
+				var id int
+				if err := psqlClient.QueryRow(q.query, uuids[i%len(uuids)]).Scan(&id); err != nil {
+					b.Fatalf("Unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// seedUploads inserts count uploads for a new client and returns the uuids of a random sample of them
+func seedUploads(b *testing.B, psqlStore service.PsqlStore, psqlClient *sql.DB, count int) []string {
+	b.Helper()
+
+	planID, err := psqlStore.GetPlanIdFromName("basic")
+	if err != nil {
+		b.Fatalf("Unexpected error while fetching plan: %v", err)
+	}
+	accessKey := fmt.Sprintf("bench%d", time.Now().UnixNano())
+	err = psqlStore.InsertClientData(planID, types.SignupPayload{Name: "bench", Email: accessKey + "@example.com"}, accessKey, "hash")
+	if err != nil {
+		b.Fatalf("Unexpected error while inserting client: %v", err)
+	}
+	client, err := psqlStore.GetClientFromAccessKey(accessKey)
+	if err != nil {
+		b.Fatalf("Unexpected error while fetching client: %v", err)
+	}
+
+	_, err = psqlClient.Exec(`
+		INSERT INTO upload (uuid, type, client_id, file_path, file_size_kb)
+		SELECT id, $1::FILE_UPLOAD_TYPE, $2, $3 || '/' || id || '.png', 100
+		FROM (SELECT gen_random_uuid()::TEXT AS id FROM generate_series(1, $4)) seeded`,
+		types.FACE_TYPE, client.Id, strconv.Itoa(client.Id), count,
+	)
+	if err != nil {
+		b.Fatalf("Unexpected error while seeding uploads: %v", err)
+	}
+	if _, err := psqlClient.Exec("ANALYZE upload"); err != nil {
+		b.Fatalf("Unexpected error while analyzing upload: %v", err)
+	}
+
+	rows, err := psqlClient.Query("SELECT uuid FROM upload WHERE client_id = $1 ORDER BY random() LIMIT 1000", client.Id)
+	if err != nil {
+		b.Fatalf("Unexpected error while sampling uploads: %v", err)
+	}
+	defer rows.Close()
+
+	var uuids []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			b.Fatalf("Unexpected error while sampling uploads: %v", err)
+		}
+		uuids = append(uuids, uuid)
+	}
+	if err := rows.Err(); err != nil {
+		b.Fatalf("Unexpected error while sampling uploads: %v", err)
+	}
+
+	return uuids
+}
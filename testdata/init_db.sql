@@ -24,13 +24,27 @@ BEGIN
 END
 $$;
 
+-- Create a new ENUM type `DELIVERY_STATUS_TYPE` if it does not already exist
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM pg_type
+        WHERE typname = 'delivery_status_type'
+    ) THEN
+        CREATE TYPE DELIVERY_STATUS_TYPE AS ENUM ('pending', 'succeeded', 'failed');
+    END IF;
+END
+$$;
+
 -- Create the `plan` table if it does not already exist
 CREATE TABLE IF NOT EXISTS plan (
     id SERIAL NOT NULL PRIMARY KEY, -- Primary key for the table
     name VARCHAR(50) NOT NULL, -- Name of the plan (e.g., 'basic', 'advanced')
     base_cost NUMERIC(10, 2) NOT NULL, -- Base cost of the plan
     per_call_cost NUMERIC(10, 2) NOT NULL, -- Cost per API call
-    upload_cost_per_mb NUMERIC(10, 2) NOT NULL -- Cost per MB of upload
+    upload_cost_per_mb NUMERIC(10, 2) NOT NULL, -- Cost per MB of upload
+    max_upload_bytes BIGINT NOT NULL DEFAULT 10485760 -- Largest file a client of the plan can upload, in bytes
 );
 
 -- Create the `client` table if it does not already exist
@@ -39,22 +53,68 @@ CREATE TABLE IF NOT EXISTS client (
     name VARCHAR(50) NOT NULL, -- Name of the client
     email VARCHAR(50) NOT NULL, -- Email address of the client
     plan_id INTEGER NOT NULL, -- Foreign key referencing the `plan` table
-    access_key VARCHAR(10), -- Stores a short access key for the client
-    secret_key_hash VARCHAR(200), -- Stores the hashed value of the client's secret key
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp of creation
+    webhook_secret VARCHAR(64), -- Secret used to sign webhook payloads
+    sandbox BOOLEAN NOT NULL DEFAULT FALSE, -- Whether the client was signed up for the sandbox
+    keep_original_uploads BOOLEAN NOT NULL DEFAULT FALSE, -- Whether uploads are stored as sent, instead of re-encoded without their metadata
+    FOREIGN KEY (plan_id) REFERENCES plan(id) -- Enforce plan_id must exist in `plan`
+);
+
+-- Create the `access_key` table if it does not already exist
+CREATE TABLE IF NOT EXISTS access_key (
+    id SERIAL PRIMARY KEY, -- Primary key for the key
+    client_id INTEGER NOT NULL, -- Foreign key referencing the `client` table
+    access_key VARCHAR(20) NOT NULL UNIQUE, -- Access key of the pair, sandbox keys have a `test_` prefix
+    secret_key_hash VARCHAR(200) NOT NULL, -- Hashed value of the secret key of the pair
+    label VARCHAR(50) NOT NULL DEFAULT '', -- Label given by the client to tell its keys apart
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp of creation
+    expires_at TIMESTAMP, -- Timestamp after which the key stops working, NULL for never
+    revoked_at TIMESTAMP, -- Timestamp indicating when the key was revoked
+    auth_scheme VARCHAR(20) NOT NULL DEFAULT 'header', -- 'header' sends the secret key as is, 'signature' signs requests with it
+    signing_secret VARCHAR(200), -- Secret key of 'signature' keys, encrypted with the server key
+    scopes TEXT[] NOT NULL DEFAULT ARRAY['upload', 'face_match', 'ocr', 'results:read', 'reports:read'], -- Operations the key is allowed
+    refresh_token VARCHAR(64), -- Hex SHA-256 of the refresh token issued for the key, NULL when none was issued
+    refresh_token_expires_at TIMESTAMP, -- Timestamp after which the refresh token stops working
+    FOREIGN KEY (client_id) REFERENCES client(id) -- Enforce client_id must exist in `client`
+);
+CREATE INDEX IF NOT EXISTS idx_access_key_client ON access_key (client_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_access_key_refresh_token ON access_key (refresh_token);
+
+-- Create the `plan_limit` table if it does not already exist
+CREATE TABLE IF NOT EXISTS plan_limit (
+    id SERIAL PRIMARY KEY, -- Primary key for the limit
+    plan_id INTEGER NOT NULL, -- Foreign key referencing the `plan` table
+    endpoint VARCHAR(50) NOT NULL, -- Endpoint the limit applies to (e.g., 'face-match'), '*' for the ones without their own limit
+    rate_per_minute INTEGER, -- Calls a minute the token bucket is refilled with, NULL for no rate limit
+    burst INTEGER NOT NULL DEFAULT 1, -- Size of the token bucket, the calls that can be made at once
+    daily_quota INTEGER, -- Calls allowed a day, NULL for no quota
+    monthly_quota INTEGER, -- Calls allowed a month, NULL for no quota
+    UNIQUE (plan_id, endpoint),
     FOREIGN KEY (plan_id) REFERENCES plan(id) -- Enforce plan_id must exist in `plan`
 );
 
 -- Create the `upload` table if it does not already exist
 CREATE TABLE IF NOT EXISTS upload (
     id SERIAL PRIMARY KEY, -- Primary key for the upload
+    uuid VARCHAR(36) NOT NULL, -- Id of the upload, the name of its file without the extension
     type FILE_UPLOAD_TYPE, -- Type of upload, referencing the ENUM
     client_id INTEGER NOT NULL, -- Foreign key referencing the `client` table
     file_path VARCHAR(100) NOT NULL, -- Path to the uploaded file
     file_size_kb BIGINT NOT NULL, -- Size of the uploaded file in KB
+    file_name VARCHAR(255), -- Name of the file as uploaded by the client
+    sha256 VARCHAR(64), -- Hex SHA-256 of the uploaded file, NULL for the uploads made before it was kept
+    width INTEGER, -- Width of the image in pixels, NULL for the uploads made before it was kept
+    height INTEGER, -- Height of the image in pixels
+    format VARCHAR(10), -- Format of the image sniffed from its content, png or jpeg
+    dedup_name VARCHAR(255) NOT NULL DEFAULT '', -- Name the upload is deduplicated under, empty but for sandbox clients
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp of creation
+    deleted_at TIMESTAMP, -- Timestamp of deletion, NULL while the upload exists
     FOREIGN KEY (client_id) REFERENCES client(id) -- Enforce client_id must exist in `client`
 );
+CREATE INDEX IF NOT EXISTS idx_upload_client_sha256 ON upload (client_id, sha256);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_upload_uuid ON upload (uuid);
+CREATE INDEX IF NOT EXISTS idx_upload_client_created_at ON upload (client_id, created_at DESC, id DESC) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_upload_dedup ON upload (client_id, type, sha256, dedup_name) WHERE deleted_at IS NULL;
 
 -- Create the `face_match` table if it does not already exist
 CREATE TABLE IF NOT EXISTS face_match (
@@ -70,6 +130,8 @@ CREATE TABLE IF NOT EXISTS face_match (
     processed_at TIMESTAMP, -- Timestamp indicating when the job started processing
     failed_reason VARCHAR(100), -- Reason for job failure, if applicable
     failed_at TIMESTAMP, -- Timestamp indicating when the job failed
+    attempts INTEGER NOT NULL DEFAULT 1, -- Number of times the job was put on the queue
+    enqueued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp indicating when the job was last put on the queue
     FOREIGN KEY (client_id) REFERENCES client(id),
     FOREIGN KEY (upload_id1) REFERENCES upload(id),
     FOREIGN KEY (upload_id2) REFERENCES upload(id)
@@ -88,13 +150,121 @@ CREATE TABLE IF NOT EXISTS ocr (
     processed_at TIMESTAMP, -- Timestamp indicating when the job started processing
     failed_reason VARCHAR(100), -- Reason for job failure, if applicable
     failed_at TIMESTAMP, -- Timestamp indicating when the job failed
+    attempts INTEGER NOT NULL DEFAULT 1, -- Number of times the job was put on the queue
+    enqueued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp indicating when the job was last put on the queue
     FOREIGN KEY (client_id) REFERENCES client(id),
     FOREIGN KEY (upload_id) REFERENCES upload(id)
 );
 
+-- Create the `webhook` table if it does not already exist
+CREATE TABLE IF NOT EXISTS webhook (
+    id SERIAL PRIMARY KEY, -- Primary key for the webhook
+    client_id INTEGER NOT NULL, -- Foreign key referencing the `client` table
+    url VARCHAR(500) NOT NULL, -- Url the job results are posted to
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp of creation
+    deleted_at TIMESTAMP, -- Timestamp indicating when the webhook was removed
+    FOREIGN KEY (client_id) REFERENCES client(id)
+);
+
+-- Create the `webhook_delivery` table if it does not already exist
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+    id SERIAL PRIMARY KEY, -- Primary key for the delivery
+    webhook_id INTEGER NOT NULL, -- Foreign key referencing the `webhook` table
+    client_id INTEGER NOT NULL, -- Foreign key referencing the `client` table
+    job_type VARCHAR(20) NOT NULL, -- Type of the job (e.g., 'face_match', 'ocr')
+    job_id VARCHAR(100) NOT NULL, -- Identifier of the job whose result is delivered
+    status DELIVERY_STATUS_TYPE NOT NULL DEFAULT 'pending', -- Current status of the delivery
+    attempts INTEGER NOT NULL DEFAULT 0, -- Number of delivery attempts made so far
+    last_status_code INTEGER, -- Http status code returned on the last attempt
+    last_error VARCHAR(500), -- Error seen on the last attempt, if applicable
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp after which the delivery is attempted again
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp of creation
+    delivered_at TIMESTAMP, -- Timestamp indicating when the delivery succeeded
+    FOREIGN KEY (webhook_id) REFERENCES webhook(id),
+    FOREIGN KEY (client_id) REFERENCES client(id)
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_due ON webhook_delivery (status, next_attempt_at);
+
+-- Create the `idempotency_key` table if it does not already exist
+CREATE TABLE IF NOT EXISTS idempotency_key (
+    client_id INTEGER NOT NULL, -- Foreign key referencing the `client` table
+    idempotency_key VARCHAR(255) NOT NULL, -- Key picked by the client, unique per client
+    request_hash VARCHAR(64) NOT NULL, -- Hex SHA-256 of the method, path and body of the first request
+    status_code INTEGER, -- Http status code of the response, NULL while the request is in progress
+    response_body TEXT, -- Body of the response, replayed to the retries
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp of creation
+    expires_at TIMESTAMP NOT NULL, -- Timestamp after which the key can be used again
+    PRIMARY KEY (client_id, idempotency_key),
+    FOREIGN KEY (client_id) REFERENCES client(id)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_key_expires_at ON idempotency_key (expires_at);
+
+-- Create the `upload_session` table if it does not already exist
+CREATE TABLE IF NOT EXISTS upload_session (
+    id VARCHAR(36) PRIMARY KEY, -- Id of the session, a uuid
+    client_id INTEGER NOT NULL, -- Foreign key referencing the `client` table
+    type FILE_UPLOAD_TYPE NOT NULL, -- Type of the upload, referencing the ENUM
+    file_name VARCHAR(255) NOT NULL, -- Name of the file as uploaded by the client
+    file_size BIGINT NOT NULL, -- Size of the whole file in bytes
+    chunk_size BIGINT NOT NULL, -- Size of every chunk in bytes, but the last one
+    file_path VARCHAR(100) NOT NULL, -- Path the chunks are assembled at in the file store
+    store_upload_id VARCHAR(255) NOT NULL, -- Id of the multipart upload in the file store
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp of creation
+    expires_at TIMESTAMP NOT NULL, -- Timestamp after which the session is dropped, with its chunks
+    upload_uuid VARCHAR(36), -- Uuid of the upload the session was completed as, NULL until it's completed
+    FOREIGN KEY (client_id) REFERENCES client(id)
+);
+CREATE INDEX IF NOT EXISTS idx_upload_session_expires_at ON upload_session (expires_at);
+
+-- Create the `upload_session_chunk` table if it does not already exist
+CREATE TABLE IF NOT EXISTS upload_session_chunk (
+    session_id VARCHAR(36) NOT NULL, -- Foreign key referencing the `upload_session` table
+    chunk_number INTEGER NOT NULL, -- Number of the chunk, starting at 1
+    size BIGINT NOT NULL, -- Size of the chunk in bytes
+    etag VARCHAR(255) NOT NULL, -- ETag of the part the chunk was stored as in the file store
+    PRIMARY KEY (session_id, chunk_number),
+    FOREIGN KEY (session_id) REFERENCES upload_session(id) ON DELETE CASCADE
+);
+
+-- Create the `presigned_upload` table if it does not already exist
+CREATE TABLE IF NOT EXISTS presigned_upload (
+    id VARCHAR(36) PRIMARY KEY, -- Id the upload will have, a uuid
+    client_id INTEGER NOT NULL, -- Foreign key referencing the `client` table
+    file_path VARCHAR(100) NOT NULL, -- Staging path the file is put at in the file store
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp of creation
+    expires_at TIMESTAMP NOT NULL, -- Timestamp after which the upload can't be confirmed, and its staged file is purged
+    FOREIGN KEY (client_id) REFERENCES client(id)
+);
+CREATE INDEX IF NOT EXISTS idx_presigned_upload_expires_at ON presigned_upload (expires_at);
+
+-- Indexes to support listing a client's jobs ordered by creation time
+CREATE INDEX IF NOT EXISTS idx_face_match_client_created_at ON face_match (client_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_ocr_client_created_at ON ocr (client_id, created_at DESC, id DESC);
+
+-- Indexes used by the reaper to find the jobs stuck in 'created' or 'processing'
+CREATE INDEX IF NOT EXISTS idx_face_match_status ON face_match (status);
+CREATE INDEX IF NOT EXISTS idx_ocr_status ON ocr (status);
+
 -- Insert default plans into the `plan` table
-INSERT INTO plan (name, base_cost, per_call_cost, upload_cost_per_mb)
+INSERT INTO plan (name, base_cost, per_call_cost, upload_cost_per_mb, max_upload_bytes)
 VALUES
-    ('basic', '10', '0.1', '0.1'),
-    ('advanced', '15', '0.05', '0.05'),
-    ('enterprise', '20', '0.1', '0.01');
+    ('basic', '10', '0.1', '0.1', 5242880),
+    ('advanced', '15', '0.05', '0.05', 10485760),
+    ('enterprise', '20', '0.1', '0.01', 26214400);
+
+-- Insert the default limits of the default plans into the `plan_limit` table
+INSERT INTO plan_limit (plan_id, endpoint, rate_per_minute, burst, daily_quota, monthly_quota)
+SELECT p.id, l.endpoint, l.rate_per_minute, l.burst, l.daily_quota, l.monthly_quota
+FROM plan p
+JOIN (
+    VALUES
+        ('basic', '*', 60, 20, NULL, NULL),
+        ('basic', 'face-match', 10, 5, 100, 2000),
+        ('basic', 'ocr', 10, 5, 100, 2000),
+        ('advanced', '*', 120, 40, NULL, NULL),
+        ('advanced', 'face-match', 30, 10, 1000, 20000),
+        ('advanced', 'ocr', 30, 10, 1000, 20000),
+        ('enterprise', '*', 600, 100, NULL, NULL),
+        ('enterprise', 'face-match', 120, 30, NULL, NULL),
+        ('enterprise', 'ocr', 120, 30, NULL, NULL)
+) AS l (plan_name, endpoint, rate_per_minute, burst, daily_quota, monthly_quota) ON l.plan_name = p.name;
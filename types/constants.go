@@ -20,9 +20,20 @@ const (
 	JOB_STATUS_COMPLETED  = "completed"
 	JOB_STATUS_FAILED     = "failed"
 
+	DELIVERY_STATUS_PENDING   = "pending"
+	DELIVERY_STATUS_SUCCEEDED = "succeeded"
+	DELIVERY_STATUS_FAILED    = "failed"
+
+	WEBHOOK_EVENT_JOB_COMPLETED = "job.completed"
+	WEBHOOK_EVENT_JOB_FAILED    = "job.failed"
+
 	FACE_TYPE    = "face"
 	ID_CARD_TYPE = "id_card"
 
 	VALID_FORMAT_PNG  = ".png"
 	VALID_FORMAT_JPEG = ".jpeg"
+	VALID_FORMAT_JPG  = ".jpg"
+
+	IMAGE_FORMAT_PNG  = "png"
+	IMAGE_FORMAT_JPEG = "jpeg"
 )
@@ -2,24 +2,111 @@ package types
 
 import (
 	"encoding/json"
+	"image"
 	"io"
+	"time"
 )
 
 type ClientData struct {
-	Id            int    `json:"id"`
-	Name          string `json:"name"`
-	Email         string `json:"email"`
-	PlanID        int    `json:"plan_id"`
-	AccessKey     string `json:"access_key"`
-	SecretKeyHash string `json:"secret_key_hash"`
+	Id            int      `json:"id"`
+	Name          string   `json:"name"`
+	Email         string   `json:"email"`
+	PlanID        int      `json:"plan_id"`
+	AccessKey     string   `json:"access_key"`
+	KeyID         int      `json:"key_id"`
+	SecretKeyHash string   `json:"secret_key_hash"`
+	Sandbox       bool     `json:"sandbox"`
+	AuthScheme    string   `json:"auth_scheme"`
+	Scopes        []string `json:"scopes"`
+
+	// uploads are stored as sent, with their metadata, instead of re-encoded without it
+	KeepOriginalUploads bool `json:"keep_original_uploads"`
+
+	// secret key of keys signing their requests, sealed with the server key
+	SigningSecret string `json:"-"`
+
+	// time left until the access key the client was found by expires, zero when it doesn't
+	KeyExpiresIn time.Duration `json:"-"`
+}
+
+type AccessKey struct {
+	ID         int      `json:"id"`
+	ClientID   int      `json:"client_id"`
+	AccessKey  string   `json:"access_key"`
+	Label      string   `json:"label"`
+	AuthScheme string   `json:"auth_scheme"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at"`
+	RevokedAt  string   `json:"revoked_at"`
+}
+
+// IdempotencyRecord is the first request sent with an idempotency key, and its response once there's one
+type IdempotencyRecord struct {
+	ClientID     int
+	Key          string
+	RequestHash  string
+	StatusCode   int // zero while the request is in progress
+	ResponseBody string
 }
 
 type UploadMetaData struct {
 	Id         int    `json:"id"`
+	UUID       string `json:"uuid"` // id clients know the upload by
 	Type       string `json:"type"`
 	ClientID   int    `json:"client_id"`
 	FilePath   string `json:"file_path"`
 	FileSizeKB int64  `json:"file_size_kb"`
+	FileName   string `json:"file_name"`
+	SHA256     string `json:"sha256"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Format     string `json:"format"`
+	CreatedAt  string `json:"created_at"`
+
+	// KeepOriginal stores the file as sent, set from the client
+	KeepOriginal bool `json:"-"`
+
+	// Image is decoded when the upload is validated, so it isn't decoded again to be normalized
+	Image image.Image `json:"-"`
+}
+
+// UploadSession is a chunked upload, its chunks are stored as the parts of a multipart upload in the file store
+type UploadSession struct {
+	ID         string         `json:"id"`
+	ClientID   int            `json:"client_id"`
+	Type       string         `json:"type"`
+	FileName   string         `json:"file_name"`
+	FileSize   int64          `json:"file_size"`
+	ChunkSize  int64          `json:"chunk_size"`
+	ChunkCount int            `json:"chunk_count"`
+	Chunks     []*UploadChunk `json:"chunks"` // received so far, ordered by their number
+	ExpiresAt  string         `json:"expires_at"`
+	UploadID   string         `json:"upload_id,omitempty"` // id of the upload the session was completed as
+
+	// where the chunks are assembled in the file store, and the id of the multipart upload there
+	FilePath      string `json:"-"`
+	StoreUploadID string `json:"-"`
+}
+
+// PresignedUpload is an upload handed a presigned url, whose file is staged in the file store until it's confirmed
+type PresignedUpload struct {
+	ID        string `json:"id"`
+	ClientID  int    `json:"client_id"`
+	FilePath  string `json:"file_path"` // staging path the file is put at
+	ExpiresAt string `json:"expires_at"`
+}
+
+type UploadChunk struct {
+	Number int    `json:"number"`
+	Size   int64  `json:"size"`
+	ETag   string `json:"-"`
+}
+
+// FileInfo describes a file in the file store without reading it
+type FileInfo struct {
+	Size        int64
+	ContentType string
 }
 
 type FileUpload struct {
@@ -29,6 +116,15 @@ type FileUpload struct {
 	Headers map[string]string
 }
 
+// ImageData is an uploaded image loaded from the file store
+type ImageData struct {
+	ID      string      // uuid the client knows the upload by
+	Name    string      // name of the file as uploaded
+	Format  string      // format it was decoded from, png or jpeg
+	Content []byte      // bytes as stored
+	Image   image.Image // decoded image
+}
+
 type FaceMatchData struct {
 	ClientID int `json:"client_id"`
 	ImageID1 int `json:"upload_id1"`
@@ -64,6 +160,16 @@ type JobRecord struct {
 	OCRDetails    OCRResponse       `json:"-"`
 }
 
+// StaleJob is a job stuck in 'created' or 'processing' for longer than its timeout
+type StaleJob struct {
+	Type     WorkType
+	JobID    string
+	Status   string
+	Attempts int
+	Sandbox  bool
+	ImageIDs []string // ids clients know the job's uploads by, in the order they were passed
+}
+
 type ClientReport struct {
 	ClientID          string `csv:"client_id"`
 	Name              string `csv:"name"`
@@ -85,3 +191,73 @@ type ClientReportMonthly struct {
 	TotalAPIUsageCost string `csv:"api_usage_cost_usd"`
 	TotalStorageCost  string `csv:"storage_cost_usd"`
 }
+
+type JobCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	Type      string    `json:"type"`
+	ID        int       `json:"id"`
+}
+
+type JobFilter struct {
+	ClientID    int
+	Type        string
+	Status      string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	Cursor      *JobCursor
+	Limit       int
+}
+
+type UploadCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        int       `json:"id"`
+}
+
+type UploadFilter struct {
+	ClientID int
+	Type     string
+	Cursor   *UploadCursor
+	Limit    int
+}
+
+type Webhook struct {
+	ID        int    `json:"id"`
+	ClientID  int    `json:"client_id"`
+	URL       string `json:"url"`
+	CreatedAt string `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int    `json:"id"`
+	WebhookID      int    `json:"webhook_id"`
+	ClientID       int    `json:"client_id"`
+	URL            string `json:"url"`
+	Secret         string `json:"-"`
+	JobType        string `json:"job_type"`
+	JobID          string `json:"job_id"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	LastStatusCode int    `json:"last_status_code"`
+	LastError      string `json:"last_error"`
+	NextAttemptAt  string `json:"next_attempt_at"`
+	CreatedAt      string `json:"created_at"`
+	DeliveredAt    string `json:"delivered_at"`
+}
+
+// PlanLimit is the rate limit and quotas of a plan on an endpoint, zero values are unlimited
+type PlanLimit struct {
+	PlanID        int    `json:"plan_id"`
+	Endpoint      string `json:"endpoint"`
+	RatePerMinute int    `json:"rate_per_minute"`
+	Burst         int    `json:"burst"`
+	DailyQuota    int    `json:"daily_quota"`
+	MonthlyQuota  int    `json:"monthly_quota"`
+}
+
+// RateLimitResult is the state of a token bucket after taking a token from it
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // until the next token, when not allowed
+	ResetAfter time.Duration // until the bucket is full again
+}
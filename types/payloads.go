@@ -1,24 +1,31 @@
 package types
 
 type SignupPayload struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
-	Plan  string `json:"plan"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Plan    string `json:"plan"`
+	Sandbox bool   `json:"sandbox"`
+
+	// uploads are stored as sent, with their metadata, instead of re-encoded without it
+	KeepOriginalUploads bool `json:"keep_original_uploads"`
 }
 
 type FaceMatchPayload struct {
-	Image1 string `json:"image1"`
-	Image2 string `json:"image2"`
+	Image1  string `json:"image1"`
+	Image2  string `json:"image2"`
+	Sandbox bool   `json:"-"` // set from the client, not the request body
 }
 
 type OCRPayload struct {
-	Image string `json:"image"`
+	Image   string `json:"image"`
+	Sandbox bool   `json:"-"` // set from the client, not the request body
 }
 
 type FaceMatchInternalPayload struct {
-	JobID  string `json:"job_id"`
-	Image1 string `json:"image1"`
-	Image2 string `json:"image2"`
+	JobID   string `json:"job_id"`
+	Image1  string `json:"image1"`
+	Image2  string `json:"image2"`
+	Sandbox bool   `json:"sandbox,omitempty"`
 }
 
 type FaceMatchQueuePayload struct {
@@ -27,8 +34,9 @@ type FaceMatchQueuePayload struct {
 }
 
 type OCRInternalPayload struct {
-	JobID string `json:"job_id"`
-	Image string `json:"image"`
+	JobID   string `json:"job_id"`
+	Image   string `json:"image"`
+	Sandbox bool   `json:"sandbox,omitempty"`
 }
 
 type OCRQueuePayload struct {
@@ -39,3 +47,54 @@ type OCRQueuePayload struct {
 type ResultPayload struct {
 	ID string `json:"id"`
 }
+
+type JobListQuery struct {
+	Type   string `form:"type"`
+	Status string `form:"status"`
+	From   string `form:"from"`
+	To     string `form:"to"`
+	Cursor string `form:"cursor"`
+	Limit  string `form:"limit"`
+}
+
+type UploadListQuery struct {
+	Type   string `form:"type"`
+	Cursor string `form:"cursor"`
+	Limit  string `form:"limit"`
+}
+
+type AccessKeyPayload struct {
+	Label         string   `json:"label"`
+	ExpiresInDays int      `json:"expires_in_days"`
+	AuthScheme    string   `json:"auth_scheme"`
+	Scopes        []string `json:"scopes"`
+	Sandbox       bool     `json:"-"`
+
+	// scopes of the key creating the new one, it can't be given any others
+	HeldScopes []string `json:"-"`
+}
+
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type UploadSessionPayload struct {
+	Type     string `json:"type"`
+	FileName string `json:"file_name"`
+	FileSize int64  `json:"file_size"`
+}
+
+type PresignedUploadPayload struct {
+	Type     string `json:"type"`
+	FileName string `json:"file_name"`
+}
+
+type WebhookPayload struct {
+	URL string `json:"url"`
+}
+
+type WebhookEvent struct {
+	Event      string     `json:"event"`
+	DeliveryID int        `json:"delivery_id"`
+	Job        *JobRecord `json:"job"`
+}
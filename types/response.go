@@ -22,7 +22,45 @@ type IDResponse struct {
 	Id string `name:"id"`
 }
 
-type FileUploadResponse IDResponse
+type FileUploadResponse struct {
+	Id string `json:"id"`
+
+	// set when the client already uploaded the same file, whose id is returned instead
+	Deduplicated bool `json:"deduplicated"`
+}
+
+// PresignedUploadResponse tells how to put the file of the upload with the given id straight to the file store
+type PresignedUploadResponse struct {
+	Id        string            `json:"id"`
+	URL       string            `json:"url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"` // to be sent along with the file
+	ExpiresIn int               `json:"expires_in"`
+}
+
+type PresignedURLResponse struct {
+	URL       string `json:"url"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// UploadResponse is an upload as clients see it, by the id they know it by
+type UploadResponse struct {
+	Id         string `json:"id"`
+	Type       string `json:"type"`
+	FileName   string `json:"file_name"`
+	FileSizeKB int64  `json:"file_size_kb"`
+	SHA256     string `json:"sha256"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Format     string `json:"format"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type UploadListResponse struct {
+	Uploads    []*UploadResponse `json:"uploads"`
+	NextCursor string            `json:"next_cursor"`
+}
+
 type OCRAsyncResponse IDResponse
 type FaceMatchAsyncResponse IDResponse
 
@@ -37,6 +75,49 @@ type ResultResponse struct {
 	Result       OCRResponse `json:"result"`
 }
 
+type JobListResponse struct {
+	Jobs       []*JobRecord `json:"jobs"`
+	NextCursor string       `json:"next_cursor"`
+}
+
+type AccessKeyResponse struct {
+	ID         int      `json:"id"`
+	AccessKey  string   `json:"accessKey"`
+	SecretKey  string   `json:"secretKey"`
+	Label      string   `json:"label"`
+	AuthScheme string   `json:"auth_scheme"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at"`
+}
+
+type TokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+}
+
+type AccessKeyListResponse struct {
+	Keys []*AccessKey `json:"keys"`
+}
+
+type WebhookResponse struct {
+	ID        int    `json:"id"`
+	URL       string `json:"url"`
+	CreatedAt string `json:"created_at"`
+	Secret    string `json:"secret"`
+}
+
+type WebhookListResponse struct {
+	Webhooks []*Webhook `json:"webhooks"`
+}
+
+type WebhookDeliveryListResponse struct {
+	Deliveries []*WebhookDelivery `json:"deliveries"`
+}
+
 type FaceMatchResponse int
 
 type OCRResponseRaw json.RawMessage
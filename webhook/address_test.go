@@ -0,0 +1,49 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tt := []struct {
+		ip        string
+		expPublic bool
+	}{
+		{ip: "93.184.216.34", expPublic: true},
+		{ip: "2606:2800:220:1:248:1893:25c8:1946", expPublic: true},
+		{ip: "127.0.0.1"},
+		{ip: "::1"},
+		{ip: "10.0.0.1"},
+		{ip: "172.16.5.4"},
+		{ip: "192.168.1.1"},
+		{ip: "169.254.169.254"},
+		{ip: "100.64.0.1"},
+		{ip: "fc00::1"},
+		{ip: "fe80::1"},
+		{ip: "0.0.0.0"},
+		{ip: "::ffff:127.0.0.1"},
+	}
+
+	for _, tc := range tt {
+		if public := IsPublicIP(net.ParseIP(tc.ip)); public != tc.expPublic {
+			t.Errorf("Expected %s to be public %v but got %v", tc.ip, tc.expPublic, public)
+		}
+	}
+}
+
+func TestNewHTTPClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPClient(time.Second).Get(server.URL)
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected error %v but got %v", ErrPrivateAddress, err)
+	}
+}
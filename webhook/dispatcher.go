@@ -0,0 +1,226 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+const (
+	DEFAULT_MAX_ATTEMPTS  = 8
+	DEFAULT_BASE_BACKOFF  = 30 * time.Second
+	DEFAULT_MAX_BACKOFF   = 1 * time.Hour
+	DEFAULT_POLL_INTERVAL = 5 * time.Second
+	DEFAULT_TIMEOUT       = 10 * time.Second
+	DEFAULT_BATCH_SIZE    = 50
+)
+
+type Dispatcher struct {
+	store        store.WebhookDataStore
+	client       *http.Client
+	maxAttempts  int
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	pollInterval time.Duration
+	batchSize    int
+	now          func() time.Time
+}
+
+type DispatcherConfig struct {
+	DataStore    store.WebhookDataStore
+	HTTPClient   *http.Client
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+// instantiate a Dispatcher, zero values in config fall back to the defaults
+func New(config *DispatcherConfig) *Dispatcher {
+	d := &Dispatcher{
+		store:        config.DataStore,
+		client:       config.HTTPClient,
+		maxAttempts:  config.MaxAttempts,
+		baseBackoff:  config.BaseBackoff,
+		maxBackoff:   config.MaxBackoff,
+		pollInterval: config.PollInterval,
+		batchSize:    config.BatchSize,
+		now:          time.Now,
+	}
+
+	if d.client == nil {
+		d.client = &http.Client{Timeout: DEFAULT_TIMEOUT}
+	}
+	if d.maxAttempts <= 0 {
+		d.maxAttempts = DEFAULT_MAX_ATTEMPTS
+	}
+	if d.baseBackoff <= 0 {
+		d.baseBackoff = DEFAULT_BASE_BACKOFF
+	}
+	if d.maxBackoff <= 0 {
+		d.maxBackoff = DEFAULT_MAX_BACKOFF
+	}
+	if d.pollInterval <= 0 {
+		d.pollInterval = DEFAULT_POLL_INTERVAL
+	}
+	if d.batchSize <= 0 {
+		d.batchSize = DEFAULT_BATCH_SIZE
+	}
+
+	return d
+}
+
+// Run polls for due deliveries until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	log.Println("Webhook dispatcher started")
+	for {
+		d.DispatchDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Println("Webhook dispatcher stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchDue makes one attempt for every due delivery and returns how many were attempted
+func (d *Dispatcher) DispatchDue(ctx context.Context) int {
+	// lease must outlive an attempt, so a slow receiver doesn't get the same delivery twice
+	lease := 2 * d.client.Timeout
+	if lease <= 0 {
+		lease = 2 * DEFAULT_TIMEOUT
+	}
+
+	deliveries, err := d.store.ClaimDueWebhookDeliveries(d.batchSize, lease)
+	if err != nil {
+		log.Printf("Error while claiming due webhook deliveries: %s\n", err.Error())
+		return 0
+	}
+
+	for _, delivery := range deliveries {
+		d.deliver(ctx, delivery)
+	}
+
+	return len(deliveries)
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *types.WebhookDelivery) {
+	statusCode, err := d.post(ctx, delivery)
+	if err == nil {
+		err = d.store.MarkWebhookDeliverySucceeded(delivery.ID, statusCode)
+		if err != nil {
+			log.Printf("Error while marking webhook delivery (%d) as succeeded: %s\n", delivery.ID, err.Error())
+		}
+		return
+	}
+
+	// give up once the attempts are exhausted, otherwise try again later
+	if delivery.Attempts >= d.maxAttempts {
+		log.Printf("Webhook delivery (%d) failed after %d attempts: %s\n", delivery.ID, delivery.Attempts, err.Error())
+		err = d.store.MarkWebhookDeliveryFailed(delivery.ID, statusCode, err.Error())
+		if err != nil {
+			log.Printf("Error while marking webhook delivery (%d) as failed: %s\n", delivery.ID, err.Error())
+		}
+		return
+	}
+
+	delay := Backoff(delivery.Attempts, d.baseBackoff, d.maxBackoff)
+	log.Printf("Webhook delivery (%d) attempt %d failed, retrying in %s: %s\n", delivery.ID, delivery.Attempts, delay, err.Error())
+	err = d.store.ScheduleWebhookDeliveryRetry(delivery.ID, statusCode, err.Error(), delay)
+	if err != nil {
+		log.Printf("Error while scheduling retry of webhook delivery (%d): %s\n", delivery.ID, err.Error())
+	}
+}
+
+// post sends the signed job result to the webhook url, returning the response status code
+func (d *Dispatcher) post(ctx context.Context, delivery *types.WebhookDelivery) (int, error) {
+	body, event, err := d.buildPayload(delivery)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-ekyc-webhook")
+	req.Header.Set(EVENT_HEADER, event)
+	req.Header.Set(DELIVERY_HEADER, strconv.Itoa(delivery.ID))
+	req.Header.Set(SIGNATURE_HEADER, Sign(delivery.Secret, d.now().Unix(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// drain the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) buildPayload(delivery *types.WebhookDelivery) ([]byte, string, error) {
+	var job *types.JobRecord
+	var err error
+	switch delivery.JobType {
+	case types.FACE_MATCH_WORK_TYPE:
+		job, err = d.store.GetFaceMatchByJobID(delivery.JobID)
+	case types.OCR_WORK_TYPE:
+		job, err = d.store.GetOCRByJobID(delivery.JobID)
+	default:
+		err = fmt.Errorf("invalid job type %q", delivery.JobType)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error while fetching job %s: %w", delivery.JobID, err)
+	}
+
+	event := types.WEBHOOK_EVENT_JOB_COMPLETED
+	if job.Status == types.JOB_STATUS_FAILED {
+		event = types.WEBHOOK_EVENT_JOB_FAILED
+	}
+
+	body, err := json.Marshal(types.WebhookEvent{
+		Event:      event,
+		DeliveryID: delivery.ID,
+		Job:        job,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return body, event, nil
+}
+
+// Backoff returns the delay before the next attempt, doubling from base for every attempt made and capped at max
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+
+	return delay
+}
@@ -0,0 +1,231 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+type mockWebhookStore struct {
+	deliveries []*types.WebhookDelivery
+	succeeded  map[int]int
+	retried    map[int]time.Duration
+	failed     map[int]string
+}
+
+func newMockWebhookStore(deliveries ...*types.WebhookDelivery) *mockWebhookStore {
+	return &mockWebhookStore{
+		deliveries: deliveries,
+		succeeded:  map[int]int{},
+		retried:    map[int]time.Duration{},
+		failed:     map[int]string{},
+	}
+}
+
+func (m *mockWebhookStore) ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]*types.WebhookDelivery, error) {
+	deliveries := m.deliveries
+	m.deliveries = nil
+	return deliveries, nil
+}
+
+func (m *mockWebhookStore) GetFaceMatchByJobID(jobID string) (*types.JobRecord, error) {
+	return &types.JobRecord{
+		Type:       types.FACE_MATCH_WORK_TYPE,
+		ClientID:   1,
+		JobID:      jobID,
+		Status:     types.JOB_STATUS_COMPLETED,
+		MatchScore: 87,
+	}, nil
+}
+
+func (m *mockWebhookStore) GetOCRByJobID(jobID string) (*types.JobRecord, error) {
+	if jobID == "missing" {
+		return nil, errors.New("no rows in result set")
+	}
+
+	return &types.JobRecord{
+		Type:         types.OCR_WORK_TYPE,
+		ClientID:     1,
+		JobID:        jobID,
+		Status:       types.JOB_STATUS_FAILED,
+		FailedReason: "reason",
+	}, nil
+}
+
+func (m *mockWebhookStore) MarkWebhookDeliverySucceeded(deliveryID, statusCode int) error {
+	m.succeeded[deliveryID] = statusCode
+	return nil
+}
+
+func (m *mockWebhookStore) ScheduleWebhookDeliveryRetry(deliveryID, statusCode int, reason string, delay time.Duration) error {
+	m.retried[deliveryID] = delay
+	return nil
+}
+
+func (m *mockWebhookStore) MarkWebhookDeliveryFailed(deliveryID, statusCode int, reason string) error {
+	m.failed[deliveryID] = reason
+	return nil
+}
+
+func TestDispatchDueSignsPayload(t *testing.T) {
+	secret := "whsec_test"
+	var gotEvent types.WebhookEvent
+	var verifyErr error
+	var gotEventHeader string
+
+	// receiver checks the signature like a client would
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verifyErr = Verify(secret, r.Header.Get(SIGNATURE_HEADER), body, 5*time.Minute, time.Now())
+		gotEventHeader = r.Header.Get(EVENT_HEADER)
+		json.Unmarshal(body, &gotEvent)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	store := newMockWebhookStore(&types.WebhookDelivery{
+		ID:       1,
+		URL:      receiver.URL,
+		Secret:   secret,
+		JobType:  types.FACE_MATCH_WORK_TYPE,
+		JobID:    "job1",
+		Attempts: 1,
+	})
+	d := New(&DispatcherConfig{DataStore: store})
+
+	if count := d.DispatchDue(context.Background()); count != 1 {
+		t.Fatalf("Expected 1 delivery to be attempted but got %d", count)
+	}
+
+	if verifyErr != nil {
+		t.Errorf("Expected valid signature but got error: %v", verifyErr)
+	}
+	if gotEventHeader != types.WEBHOOK_EVENT_JOB_COMPLETED {
+		t.Errorf("Expected event header %q but got %q", types.WEBHOOK_EVENT_JOB_COMPLETED, gotEventHeader)
+	}
+	if gotEvent.Job == nil || gotEvent.Job.JobID != "job1" || gotEvent.Job.MatchScore != 87 {
+		t.Errorf("Unexpected job in payload: %+v", gotEvent.Job)
+	}
+	if store.succeeded[1] != http.StatusNoContent {
+		t.Errorf("Expected delivery to be marked succeeded with %d but got %v", http.StatusNoContent, store.succeeded)
+	}
+}
+
+func TestDispatchDueRetries(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	tt := []struct {
+		name       string
+		delivery   *types.WebhookDelivery
+		expRetryIn time.Duration
+		expFailed  bool
+	}{
+		{
+			name:       "first failed attempt is retried after base backoff",
+			delivery:   &types.WebhookDelivery{ID: 1, URL: receiver.URL, JobType: types.OCR_WORK_TYPE, JobID: "job1", Attempts: 1},
+			expRetryIn: time.Second,
+		},
+		{
+			name:       "third failed attempt backs off exponentially",
+			delivery:   &types.WebhookDelivery{ID: 1, URL: receiver.URL, JobType: types.OCR_WORK_TYPE, JobID: "job1", Attempts: 3},
+			expRetryIn: 4 * time.Second,
+		},
+		{
+			name:      "last failed attempt marks the delivery failed",
+			delivery:  &types.WebhookDelivery{ID: 1, URL: receiver.URL, JobType: types.OCR_WORK_TYPE, JobID: "job1", Attempts: 5},
+			expFailed: true,
+		},
+		{
+			name:       "missing job is retried",
+			delivery:   &types.WebhookDelivery{ID: 1, URL: receiver.URL, JobType: types.OCR_WORK_TYPE, JobID: "missing", Attempts: 1},
+			expRetryIn: time.Second,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newMockWebhookStore(tc.delivery)
+			d := New(&DispatcherConfig{
+				DataStore:   store,
+				MaxAttempts: 5,
+				BaseBackoff: time.Second,
+				MaxBackoff:  time.Minute,
+			})
+			d.DispatchDue(context.Background())
+
+			if tc.expFailed {
+				if _, ok := store.failed[1]; !ok {
+					t.Errorf("Expected delivery to be marked failed")
+				}
+				return
+			}
+
+			delay, ok := store.retried[1]
+			if !ok {
+				t.Fatalf("Expected delivery to be retried")
+			}
+			if delay != tc.expRetryIn {
+				t.Errorf("Expected retry in %s but got %s", tc.expRetryIn, delay)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tt := []struct {
+		attempt  int
+		expDelay time.Duration
+	}{
+		{attempt: 1, expDelay: 30 * time.Second},
+		{attempt: 2, expDelay: 60 * time.Second},
+		{attempt: 4, expDelay: 4 * time.Minute},
+		{attempt: 20, expDelay: time.Hour},
+	}
+
+	for _, tc := range tt {
+		delay := Backoff(tc.attempt, 30*time.Second, time.Hour)
+		if delay != tc.expDelay {
+			t.Errorf("Expected delay for attempt %d to be %s but got %s", tc.attempt, tc.expDelay, delay)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"event":"job.completed"}`)
+	header := Sign("secret", now.Unix(), body)
+
+	tt := []struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		now    time.Time
+		expErr error
+	}{
+		{name: "valid signature", secret: "secret", header: header, body: body, now: now},
+		{name: "wrong secret", secret: "other", header: header, body: body, now: now, expErr: ErrSignatureMismatch},
+		{name: "tampered body", secret: "secret", header: header, body: []byte(`{}`), now: now, expErr: ErrSignatureMismatch},
+		{name: "old signature", secret: "secret", header: header, body: body, now: now.Add(10 * time.Minute), expErr: ErrSignatureExpired},
+		{name: "malformed header", secret: "secret", header: "garbage", body: body, now: now, expErr: ErrInvalidSignatureHeader},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Verify(tc.secret, tc.header, tc.body, 5*time.Minute, tc.now)
+			if !errors.Is(err, tc.expErr) {
+				t.Errorf("Expected error %v but got %v", tc.expErr, err)
+			}
+		})
+	}
+}
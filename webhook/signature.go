@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SIGNATURE_HEADER = "X-Ekyc-Signature"
+	EVENT_HEADER     = "X-Ekyc-Event"
+	DELIVERY_HEADER  = "X-Ekyc-Delivery"
+)
+
+var (
+	ErrInvalidSignatureHeader = errors.New("invalid signature header")
+	ErrSignatureMismatch      = errors.New("signature does not match payload")
+	ErrSignatureExpired       = errors.New("signature timestamp outside of tolerance")
+)
+
+// Sign returns the value of the signature header for a payload, formatted as t=<unix timestamp>,v1=<hex hmac>.
+// The hmac is calculated over "<timestamp>.<body>" so a captured payload can't be replayed with a new timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, computeSignature(secret, timestamp, body))
+}
+
+// Verify checks the signature header sent along with a payload, receivers can use it to authenticate deliveries
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return ErrInvalidSignatureHeader
+		}
+
+		switch key {
+		case "t":
+			ts, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return ErrInvalidSignatureHeader
+			}
+			timestamp = ts
+		case "v1":
+			signature = val
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return ErrInvalidSignatureHeader
+	}
+
+	// reject old or future dated signatures
+	signedAt := time.Unix(timestamp, 0)
+	if now.Sub(signedAt) > tolerance || signedAt.Sub(now) > tolerance {
+		return ErrSignatureExpired
+	}
+
+	expected := computeSignature(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrSignatureMismatch
+	}
+
+	return nil
+}
+
+func computeSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"fmt"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+)
+
+const (
+	ENGINE_DUMMY = "dummy"
+	ENGINE_HTTP  = "http"
+)
+
+// NewFaceMatcher returns the face match engine by name, the http one posts to config.URL
+func NewFaceMatcher(engine string, config *HTTPEngineConfig) (service.FaceMatcher, error) {
+	switch engine {
+	case ENGINE_DUMMY:
+		return NewFaceMatchService(), nil
+	case ENGINE_HTTP:
+		if config.URL == "" {
+			return nil, fmt.Errorf("url of the %s face match engine is missing", engine)
+		}
+		return NewHTTPFaceMatchService(config), nil
+	default:
+		return nil, fmt.Errorf("unknown face match engine %q", engine)
+	}
+}
+
+// NewOCRPerformer returns the ocr engine by name, the http one posts to config.URL
+func NewOCRPerformer(engine string, config *HTTPEngineConfig) (service.OCRPerformer, error) {
+	switch engine {
+	case ENGINE_DUMMY:
+		return NewOCRService(), nil
+	case ENGINE_HTTP:
+		if config.URL == "" {
+			return nil, fmt.Errorf("url of the %s ocr engine is missing", engine)
+		}
+		return NewHTTPOCRService(config), nil
+	default:
+		return nil, fmt.Errorf("unknown ocr engine %q", engine)
+	}
+}
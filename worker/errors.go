@@ -0,0 +1,39 @@
+package worker
+
+import "errors"
+
+// retryableError marks failures which may go away when the job is tried again, like a lost database connection
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e retryableError) Unwrap() error {
+	return e.err
+}
+
+func retryable(err error) error {
+	return retryableError{err: err}
+}
+
+func isRetryable(err error) bool {
+	var r retryableError
+	return errors.As(err, &r)
+}
+
+var (
+	ErrImageNotFound = errors.New("image not found")
+	ErrCorruptImage  = errors.New("image is corrupt or not a png/jpeg")
+)
+
+var (
+	ErrEngineUnavailable     = errors.New("engine unavailable")
+	ErrCircuitOpen           = errors.New("engine circuit breaker is open")
+	ErrEngineRejected        = errors.New("engine rejected the request")
+	ErrInvalidEngineResponse = errors.New("invalid engine response")
+)
+
+var ErrSandboxForcedFailure = errors.New("sandbox forced failure")
@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"context"
 	"math/rand"
 	"time"
 
@@ -13,7 +14,11 @@ func NewFaceMatchService() *FaceMatchService {
 	return &FaceMatchService{}
 }
 
-func (d *FaceMatchService) PerformFaceMatch(payload types.FaceMatchPayload) (int, error) {
-	time.Sleep(5 * time.Second)
+func (d *FaceMatchService) PerformFaceMatch(ctx context.Context, image1, image2 *types.ImageData) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-time.After(5 * time.Second):
+	}
 	return rand.Intn(100) + 1, nil
 }
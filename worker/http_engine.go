@@ -0,0 +1,242 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+const (
+	DEFAULT_ENGINE_TIMEOUT           = 10 * time.Second
+	DEFAULT_ENGINE_MAX_RETRIES       = 2
+	DEFAULT_ENGINE_RETRY_DELAY       = 500 * time.Millisecond
+	DEFAULT_ENGINE_BREAKER_THRESHOLD = 5
+	DEFAULT_ENGINE_BREAKER_COOLDOWN  = 30 * time.Second
+)
+
+type HTTPEngineConfig struct {
+	// endpoint the images are posted to
+	URL string
+
+	// timeout of a single request
+	Timeout time.Duration
+
+	// retries of a request failing with a network error, a 5xx or a 429, waiting RetryDelay before the first and twice as long for every next one.
+	// nil uses the default, 0 disables retries
+	MaxRetries *int
+	RetryDelay time.Duration
+
+	// consecutive failed requests which open the circuit breaker, and how long it stays open
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// optional, a client with Timeout is used otherwise
+	Client *http.Client
+}
+
+// httpEngine posts images to a model server as multipart/form-data and decodes its json response
+type httpEngine struct {
+	url        string
+	client     *http.Client
+	maxRetries int
+	retryDelay time.Duration
+	breaker    *circuitBreaker
+}
+
+// zero values in config, other than MaxRetries, fall back to the defaults
+func newHTTPEngine(config *HTTPEngineConfig) *httpEngine {
+	e := &httpEngine{
+		url:        config.URL,
+		client:     config.Client,
+		maxRetries: DEFAULT_ENGINE_MAX_RETRIES,
+		retryDelay: config.RetryDelay,
+	}
+
+	if e.client == nil {
+		timeout := config.Timeout
+		if timeout <= 0 {
+			timeout = DEFAULT_ENGINE_TIMEOUT
+		}
+		e.client = &http.Client{Timeout: timeout}
+	}
+	if config.MaxRetries != nil && *config.MaxRetries >= 0 {
+		e.maxRetries = *config.MaxRetries
+	}
+	if e.retryDelay <= 0 {
+		e.retryDelay = DEFAULT_ENGINE_RETRY_DELAY
+	}
+
+	threshold := config.BreakerThreshold
+	if threshold <= 0 {
+		threshold = DEFAULT_ENGINE_BREAKER_THRESHOLD
+	}
+	cooldown := config.BreakerCooldown
+	if cooldown <= 0 {
+		cooldown = DEFAULT_ENGINE_BREAKER_COOLDOWN
+	}
+	e.breaker = newCircuitBreaker(threshold, cooldown)
+
+	return e
+}
+
+// post sends the images under the given form field names and decodes the response into out.
+// Engine outages return a retryable ErrEngineUnavailable or ErrCircuitOpen, so the job is retried through the queue,
+// while a rejected request returns ErrEngineRejected.
+func (e *httpEngine) post(ctx context.Context, images map[string]*types.ImageData, out any) error {
+	body, contentType, err := multipartBody(images)
+	if err != nil {
+		return err
+	}
+
+	delay := e.retryDelay
+	for attempt := 0; ; attempt++ {
+		if !e.breaker.allow() {
+			return retryable(ErrCircuitOpen)
+		}
+
+		retry, err := e.do(ctx, body, contentType, out)
+		if err == nil {
+			e.breaker.success()
+			return nil
+		}
+		if ctx.Err() != nil {
+			e.breaker.abort()
+			return ctx.Err()
+		}
+		if !retry {
+			// the engine is up, it just didn't like the request
+			e.breaker.success()
+			return err
+		}
+
+		e.breaker.failure()
+		log.Printf("Error while calling engine %s (attempt %d): %s\n", e.url, attempt+1, err.Error())
+		if attempt >= e.maxRetries {
+			return retryable(fmt.Errorf("%w after %d attempts", ErrEngineUnavailable, attempt+1))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// do makes a single request, reporting whether a failure is worth retrying
+func (e *httpEngine) do(ctx context.Context, body []byte, contentType string, out any) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		io.Copy(io.Discard, resp.Body)
+		return true, fmt.Errorf("engine responded with status %d", resp.StatusCode)
+	case resp.StatusCode >= 300:
+		io.Copy(io.Discard, resp.Body)
+		return false, fmt.Errorf("%w with status %d", ErrEngineRejected, resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrInvalidEngineResponse, err.Error())
+	}
+
+	return false, nil
+}
+
+func multipartBody(images map[string]*types.ImageData) ([]byte, string, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for field, image := range images {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s.%s"`, field, image.ID, image.Format))
+		header.Set("Content-Type", "image/"+image.Format)
+
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		_, err = part.Write(image.Content)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), mw.FormDataContentType(), nil
+}
+
+// HTTPFaceMatchService gets the match score from a model server.
+// It posts the images as the image1 and image2 fields and expects {"score": <0-100>} back.
+type HTTPFaceMatchService struct {
+	engine *httpEngine
+}
+
+func NewHTTPFaceMatchService(config *HTTPEngineConfig) *HTTPFaceMatchService {
+	return &HTTPFaceMatchService{
+		engine: newHTTPEngine(config),
+	}
+}
+
+func (s *HTTPFaceMatchService) PerformFaceMatch(ctx context.Context, image1, image2 *types.ImageData) (int, error) {
+	var resp struct {
+		Score *int `json:"score"`
+	}
+	err := s.engine.post(ctx, map[string]*types.ImageData{"image1": image1, "image2": image2}, &resp)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Score == nil || *resp.Score < 0 || *resp.Score > 100 {
+		return 0, fmt.Errorf("%w: score missing or out of range", ErrInvalidEngineResponse)
+	}
+
+	return *resp.Score, nil
+}
+
+// HTTPOCRService gets the id card details from a model server.
+// It posts the image as the image field and expects the fields of types.OCRResponse back.
+type HTTPOCRService struct {
+	engine *httpEngine
+}
+
+func NewHTTPOCRService(config *HTTPEngineConfig) *HTTPOCRService {
+	return &HTTPOCRService{
+		engine: newHTTPEngine(config),
+	}
+}
+
+func (s *HTTPOCRService) PerformOCR(ctx context.Context, image *types.ImageData) (*types.OCRResponse, error) {
+	var resp types.OCRResponse
+	err := s.engine.post(ctx, map[string]*types.ImageData{"image": image}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
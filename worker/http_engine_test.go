@@ -0,0 +1,229 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+var testImage = &types.ImageData{ID: "img1", Format: "png", Content: []byte("png bytes")}
+
+func TestHTTPFaceMatchService(t *testing.T) {
+	tt := []struct {
+		name         string
+		statuses     []int // status of every call in turn, 200 after the last one
+		body         string
+		expScore     int
+		expErr       error
+		expRetryable bool
+		maxRetries   int
+		expCalls     int32
+	}{
+		{
+			name:       "score is read from the response",
+			body:       `{"score": 87}`,
+			expScore:   87,
+			maxRetries: 2,
+			expCalls:   1,
+		},
+		{
+			name:       "server errors are retried",
+			statuses:   []int{http.StatusInternalServerError, http.StatusTooManyRequests},
+			body:       `{"score": 42}`,
+			expScore:   42,
+			maxRetries: 2,
+			expCalls:   3,
+		},
+		{
+			name:         "server errors out of retries leave the engine unavailable",
+			statuses:     []int{500, 500, 500},
+			expErr:       ErrEngineUnavailable,
+			expRetryable: true,
+			maxRetries:   2,
+			expCalls:     3,
+		},
+		{
+			name:         "server errors aren't retried with retries disabled",
+			statuses:     []int{500},
+			expErr:       ErrEngineUnavailable,
+			expRetryable: true,
+			maxRetries:   0,
+			expCalls:     1,
+		},
+		{
+			name:       "rejected request isn't retried",
+			statuses:   []int{http.StatusUnprocessableEntity},
+			expErr:     ErrEngineRejected,
+			maxRetries: 2,
+			expCalls:   1,
+		},
+		{
+			name:       "score out of range is invalid",
+			body:       `{"score": 101}`,
+			expErr:     ErrInvalidEngineResponse,
+			maxRetries: 2,
+			expCalls:   1,
+		},
+		{
+			name:       "malformed response is invalid",
+			body:       `not json`,
+			expErr:     ErrInvalidEngineResponse,
+			maxRetries: 2,
+			expCalls:   1,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := atomic.AddInt32(&calls, 1)
+
+				// both images are posted
+				if err := r.ParseMultipartForm(1 << 20); err != nil || len(r.MultipartForm.File["image1"]) != 1 || len(r.MultipartForm.File["image2"]) != 1 {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+
+				if int(call) <= len(tc.statuses) {
+					w.WriteHeader(tc.statuses[call-1])
+					return
+				}
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			s := NewHTTPFaceMatchService(&HTTPEngineConfig{
+				URL:        server.URL,
+				MaxRetries: &tc.maxRetries,
+				RetryDelay: time.Millisecond,
+			})
+			score, err := s.PerformFaceMatch(context.Background(), testImage, testImage)
+
+			if !errors.Is(err, tc.expErr) {
+				t.Fatalf("Expected error %v but got %v", tc.expErr, err)
+			}
+			if isRetryable(err) != tc.expRetryable {
+				t.Errorf("Expected error retryable to be %v but got %v", tc.expRetryable, isRetryable(err))
+			}
+			if score != tc.expScore {
+				t.Errorf("Expected score %d but got %d", tc.expScore, score)
+			}
+			if calls != tc.expCalls {
+				t.Errorf("Expected %d calls but got %d", tc.expCalls, calls)
+			}
+		})
+	}
+}
+
+func TestHTTPOCRService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("image")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		file.Close()
+		if header.Header.Get("Content-Type") != "image/png" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Write([]byte(`{"name": "John Adams", "dateOfBirth": "1990-01-24", "idNumber": "1234-1234-1234"}`))
+	}))
+	defer server.Close()
+
+	s := NewHTTPOCRService(&HTTPEngineConfig{URL: server.URL})
+	resp, err := s.PerformOCR(context.Background(), testImage)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	exp := types.OCRResponse{Name: "John Adams", DOB: "1990-01-24", IdNumber: "1234-1234-1234"}
+	if *resp != exp {
+		t.Errorf("Expected response %+v but got %+v", exp, *resp)
+	}
+}
+
+func TestHTTPEngineCircuitBreaker(t *testing.T) {
+	var calls int32
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"score": 50}`))
+	}))
+	defer server.Close()
+
+	maxRetries := 1
+	s := NewHTTPFaceMatchService(&HTTPEngineConfig{
+		URL:              server.URL,
+		MaxRetries:       &maxRetries,
+		RetryDelay:       time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Minute,
+	})
+	now := time.Now()
+	s.engine.breaker.now = func() time.Time { return now }
+
+	// two failed calls open the breaker
+	_, err := s.PerformFaceMatch(context.Background(), testImage, testImage)
+	if !errors.Is(err, ErrEngineUnavailable) {
+		t.Fatalf("Expected error %v but got %v", ErrEngineUnavailable, err)
+	}
+
+	// jobs fail fast while it's open
+	_, err = s.PerformFaceMatch(context.Background(), testImage, testImage)
+	if !errors.Is(err, ErrCircuitOpen) || !isRetryable(err) {
+		t.Fatalf("Expected retryable error %v but got %v", ErrCircuitOpen, err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected no calls while the breaker is open but got %d calls", calls-2)
+	}
+
+	// a trial call which is cancelled lets the next trial through
+	now = now.Add(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.PerformFaceMatch(ctx, testImage, testImage)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected error %v but got %v", context.Canceled, err)
+	}
+
+	// after the cooldown a trial call closes it again
+	healthy.Store(true)
+	score, err := s.PerformFaceMatch(context.Background(), testImage, testImage)
+	if err != nil || score != 50 {
+		t.Fatalf("Expected score 50 but got %d, error: %v", score, err)
+	}
+	if !s.engine.breaker.allow() {
+		t.Errorf("Expected breaker to be closed after a successful trial")
+	}
+}
+
+func TestHTTPEngineCancelled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	s := NewHTTPFaceMatchService(&HTTPEngineConfig{URL: server.URL})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := s.PerformFaceMatch(ctx, testImage, testImage)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected error %v but got %v", context.DeadlineExceeded, err)
+	}
+}
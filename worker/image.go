@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register the decoders of the upload formats
+	_ "image/png"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+// loadImage resolves the upload of the image, fetches it from the file store and decodes it.
+// Missing and corrupt images return ErrImageNotFound and ErrCorruptImage, store errors are retryable.
+func (w *Worker) loadImage(imgUuid string) (*types.ImageData, error) {
+	// resolve the upload metadata
+	metaData, err := w.dStore.GetMetaDataByUUID(imgUuid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, imgUuid)
+	}
+	if err != nil {
+		return nil, retryable(err)
+	}
+
+	// load the bytes
+	content, err := w.fStore.GetFile(metaData.FilePath)
+	if errors.Is(err, store.ErrFileNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, imgUuid)
+	}
+	if err != nil {
+		return nil, retryable(err)
+	}
+
+	// decode it
+	img, format, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptImage, imgUuid)
+	}
+
+	return &types.ImageData{
+		ID:      imgUuid,
+		Name:    metaData.FileName,
+		Format:  format,
+		Content: content,
+		Image:   img,
+	}, nil
+}
@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"context"
 	"time"
 
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
@@ -12,8 +13,12 @@ func NewOCRService() *OCRService {
 	return &OCRService{}
 }
 
-func (d *OCRService) PerformOCR(payload types.OCRPayload) (*types.OCRResponse, error) {
-	time.Sleep(5 * time.Second)
+func (d *OCRService) PerformOCR(ctx context.Context, image *types.ImageData) (*types.OCRResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(5 * time.Second):
+	}
 	return &types.OCRResponse{
 		Name:      "John Adams",
 		Gender:    "Male",
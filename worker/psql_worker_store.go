@@ -89,3 +89,50 @@ func (s PsqlWorkerStore) UpdateOCRJobFailed(jobID, reason string) error {
 
 	return nil
 }
+
+func (s PsqlWorkerStore) InsertWebhookDeliveries(jobType, jobID string) error {
+	// one delivery for every active webhook of the client who owns the job
+	_, err := s.db.Exec(`
+		INSERT INTO webhook_delivery (webhook_id, client_id, job_type, job_id)
+		SELECT w.id, w.client_id, $1::TEXT, $2::TEXT
+		FROM webhook w
+		JOIN (
+			SELECT client_id FROM face_match WHERE job_id = $2::TEXT AND $1::TEXT = 'face_match'
+			UNION ALL
+			SELECT client_id FROM ocr WHERE job_id = $2::TEXT AND $1::TEXT = 'ocr'
+		) job ON job.client_id = w.client_id
+		WHERE w.deleted_at IS NULL`,
+		jobType, jobID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s PsqlWorkerStore) GetMetaDataByUUID(imgUuid string) (*types.UploadMetaData, error) {
+	var uploadData types.UploadMetaData
+	err := s.db.QueryRow(
+		"SELECT id, uuid, type, client_id, file_path, file_size_kb, COALESCE(file_name, ''), COALESCE(sha256, ''), COALESCE(width, 0), COALESCE(height, 0), COALESCE(format, ''), created_at FROM upload WHERE uuid = $1 AND deleted_at IS NULL",
+		imgUuid,
+	).Scan(
+		&uploadData.Id,
+		&uploadData.UUID,
+		&uploadData.Type,
+		&uploadData.ClientID,
+		&uploadData.FilePath,
+		&uploadData.FileSizeKB,
+		&uploadData.FileName,
+		&uploadData.SHA256,
+		&uploadData.Width,
+		&uploadData.Height,
+		&uploadData.Format,
+		&uploadData.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &uploadData, nil
+}
@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+// magic values in the name of an uploaded image, which pick the result of a sandbox job
+const (
+	SANDBOX_FORCE_MATCH    = "force_match"
+	SANDBOX_FORCE_MISMATCH = "force_mismatch"
+	SANDBOX_FORCE_FAILURE  = "force_failure"
+	SANDBOX_FORCE_SLOW     = "force_slow"
+)
+
+const (
+	SANDBOX_MATCH_SCORE    = 95
+	SANDBOX_MISMATCH_SCORE = 5
+	DEFAULT_SANDBOX_DELAY  = 10 * time.Second
+)
+
+// SandboxFaceMatchService returns deterministic scores for the jobs of sandbox clients.
+// The score is forced by a magic value in the name of either image,
+// otherwise it's derived from the hash of the images, so the same images always get the same score.
+type SandboxFaceMatchService struct {
+	slowDelay time.Duration
+}
+
+func NewSandboxFaceMatchService(slowDelay time.Duration) *SandboxFaceMatchService {
+	if slowDelay <= 0 {
+		slowDelay = DEFAULT_SANDBOX_DELAY
+	}
+	return &SandboxFaceMatchService{slowDelay: slowDelay}
+}
+
+func (s *SandboxFaceMatchService) PerformFaceMatch(ctx context.Context, image1, image2 *types.ImageData) (int, error) {
+	// failures and slow jobs go first, so they can be combined with the other scenarios
+	if hasScenario(SANDBOX_FORCE_FAILURE, image1, image2) {
+		return 0, ErrSandboxForcedFailure
+	}
+	if hasScenario(SANDBOX_FORCE_SLOW, image1, image2) {
+		err := sandboxWait(ctx, s.slowDelay)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	switch {
+	case hasScenario(SANDBOX_FORCE_MISMATCH, image1, image2):
+		return SANDBOX_MISMATCH_SCORE, nil
+	case hasScenario(SANDBOX_FORCE_MATCH, image1, image2):
+		return SANDBOX_MATCH_SCORE, nil
+	}
+
+	// score between 1 and 100 from the hash of both images
+	h := sha256.New()
+	h.Write(image1.Content)
+	h.Write(image2.Content)
+	sum := h.Sum(nil)
+	return int(binary.BigEndian.Uint16(sum[:2]))%100 + 1, nil
+}
+
+// SandboxOCRService returns a fixed result for the jobs of sandbox clients,
+// failures and slow jobs are forced by a magic value in the name of the image
+type SandboxOCRService struct {
+	slowDelay time.Duration
+}
+
+func NewSandboxOCRService(slowDelay time.Duration) *SandboxOCRService {
+	if slowDelay <= 0 {
+		slowDelay = DEFAULT_SANDBOX_DELAY
+	}
+	return &SandboxOCRService{slowDelay: slowDelay}
+}
+
+func (s *SandboxOCRService) PerformOCR(ctx context.Context, image *types.ImageData) (*types.OCRResponse, error) {
+	if hasScenario(SANDBOX_FORCE_FAILURE, image) {
+		return nil, ErrSandboxForcedFailure
+	}
+	if hasScenario(SANDBOX_FORCE_SLOW, image) {
+		err := sandboxWait(ctx, s.slowDelay)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &types.OCRResponse{
+		Name:      "John Adams",
+		Gender:    "Male",
+		DOB:       "1990-01-24",
+		IdNumber:  "1234-1234-1234",
+		AddrLine1: "A2, 201, Amar Villa",
+		AddrLine2: "MG Road, Pune",
+		Pincode:   "411004",
+	}, nil
+}
+
+// hasScenario reports whether the name of any of the images has the magic value, ignoring case
+func hasScenario(scenario string, images ...*types.ImageData) bool {
+	for _, image := range images {
+		if image != nil && strings.Contains(strings.ToLower(image.Name), scenario) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sandboxWait(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
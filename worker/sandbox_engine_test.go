@@ -0,0 +1,112 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+func TestSandboxFaceMatchService(t *testing.T) {
+	tt := []struct {
+		name     string
+		image1   *types.ImageData
+		image2   *types.ImageData
+		expScore int
+		expErr   error
+	}{
+		{
+			name:     "force match",
+			image1:   &types.ImageData{Name: "selfie_FORCE_MATCH.png", Content: []byte("a")},
+			image2:   &types.ImageData{Name: "id.png", Content: []byte("b")},
+			expScore: SANDBOX_MATCH_SCORE,
+		},
+		{
+			name:     "force mismatch",
+			image1:   &types.ImageData{Name: "selfie.png", Content: []byte("a")},
+			image2:   &types.ImageData{Name: "id_force_mismatch.png", Content: []byte("b")},
+			expScore: SANDBOX_MISMATCH_SCORE,
+		},
+		{
+			name:   "force failure",
+			image1: &types.ImageData{Name: "force_failure.png", Content: []byte("a")},
+			image2: &types.ImageData{Name: "force_match.png", Content: []byte("b")},
+			expErr: ErrSandboxForcedFailure,
+		},
+		{
+			name:     "force slow",
+			image1:   &types.ImageData{Name: "force_slow_force_match.png", Content: []byte("a")},
+			image2:   &types.ImageData{Name: "id.png", Content: []byte("b")},
+			expScore: SANDBOX_MATCH_SCORE,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewSandboxFaceMatchService(time.Millisecond)
+			score, err := s.PerformFaceMatch(context.Background(), tc.image1, tc.image2)
+			if !errors.Is(err, tc.expErr) {
+				t.Fatalf("Expected error %v but got %v", tc.expErr, err)
+			}
+			if score != tc.expScore {
+				t.Errorf("Expected score %d but got %d", tc.expScore, score)
+			}
+		})
+	}
+}
+
+func TestSandboxFaceMatchServiceHash(t *testing.T) {
+	s := NewSandboxFaceMatchService(time.Millisecond)
+	image1 := &types.ImageData{Name: "selfie.png", Content: []byte("selfie bytes")}
+	image2 := &types.ImageData{Name: "id.png", Content: []byte("id bytes")}
+
+	score, err := s.PerformFaceMatch(context.Background(), image1, image2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if score < 1 || score > 100 {
+		t.Fatalf("Expected score between 1 and 100 but got %d", score)
+	}
+
+	// the same images always get the same score
+	for range 5 {
+		again, err := s.PerformFaceMatch(context.Background(), image1, image2)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if again != score {
+			t.Fatalf("Expected score %d but got %d", score, again)
+		}
+	}
+}
+
+func TestSandboxFaceMatchServiceSlowCancelled(t *testing.T) {
+	s := NewSandboxFaceMatchService(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	image := &types.ImageData{Name: "force_slow.png"}
+	_, err := s.PerformFaceMatch(ctx, image, image)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected error %v but got %v", context.Canceled, err)
+	}
+}
+
+func TestSandboxOCRService(t *testing.T) {
+	s := NewSandboxOCRService(time.Millisecond)
+
+	resp, err := s.PerformOCR(context.Background(), &types.ImageData{Name: "card.png"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Name != "John Adams" {
+		t.Errorf("Expected name %q but got %q", "John Adams", resp.Name)
+	}
+
+	_, err = s.PerformOCR(context.Background(), &types.ImageData{Name: "card_force_failure.png"})
+	if !errors.Is(err, ErrSandboxForcedFailure) {
+		t.Errorf("Expected error %v but got %v", ErrSandboxForcedFailure, err)
+	}
+}
@@ -1,20 +1,37 @@
 package worker
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/store"
 	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
 )
 
+const (
+	DEFAULT_CONCURRENCY      = 1
+	DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
+
+	// how long interrupted jobs get to be requeued after the shutdown timeout, before they're given up on
+	SHUTDOWN_REQUEUE_TIMEOUT = 5 * time.Second
+)
+
 type Worker struct {
-	queue       service.TaskQueue
-	dStore      store.WorkerDataStore
-	fStore      store.FileStore
-	faceMatcher service.FaceMatcher
-	ocr         service.OCRPerformer
+	queue           service.TaskQueue
+	dStore          store.WorkerDataStore
+	fStore          store.FileStore
+	faceMatcher     service.FaceMatcher
+	ocr             service.OCRPerformer
+	sandboxMatcher  service.FaceMatcher
+	sandboxOCR      service.OCRPerformer
+	concurrency     int
+	shutdownTimeout time.Duration
+	requeueTimeout  time.Duration
 }
 
 type WorkerConfig struct {
@@ -23,6 +40,16 @@ type WorkerConfig struct {
 	FileStore   store.FileStore
 	FaceMatcher service.FaceMatcher
 	OCR         service.OCRPerformer
+
+	// engines for the jobs of sandbox clients, deterministic ones by default
+	SandboxFaceMatcher service.FaceMatcher
+	SandboxOCR         service.OCRPerformer
+
+	// number of jobs processed at once, also used as the queue prefetch
+	Concurrency int
+
+	// how long in-flight jobs may take to finish on shutdown, before they're requeued
+	ShutdownTimeout time.Duration
 }
 
 type QueueMessage struct {
@@ -30,83 +57,228 @@ type QueueMessage struct {
 	Msg  json.RawMessage `json:"msg"`
 }
 
+// instantiate a Worker, zero values in config fall back to the defaults
 func New(config *WorkerConfig) *Worker {
-	return &Worker{
-		queue:       config.Queue,
-		dStore:      config.DataStore,
-		fStore:      config.FileStore,
-		faceMatcher: config.FaceMatcher,
-		ocr:         config.OCR,
+	w := &Worker{
+		queue:           config.Queue,
+		dStore:          config.DataStore,
+		fStore:          config.FileStore,
+		faceMatcher:     config.FaceMatcher,
+		ocr:             config.OCR,
+		sandboxMatcher:  config.SandboxFaceMatcher,
+		sandboxOCR:      config.SandboxOCR,
+		concurrency:     config.Concurrency,
+		shutdownTimeout: config.ShutdownTimeout,
+		requeueTimeout:  SHUTDOWN_REQUEUE_TIMEOUT,
 	}
+
+	if w.concurrency <= 0 {
+		w.concurrency = DEFAULT_CONCURRENCY
+	}
+	if w.shutdownTimeout <= 0 {
+		w.shutdownTimeout = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+	if w.sandboxMatcher == nil {
+		w.sandboxMatcher = NewSandboxFaceMatchService(DEFAULT_SANDBOX_DELAY)
+	}
+	if w.sandboxOCR == nil {
+		w.sandboxOCR = NewSandboxOCRService(DEFAULT_SANDBOX_DELAY)
+	}
+
+	return w
 }
 
-func (w *Worker) ProcessMessages() {
-	msgs, err := w.queue.PullJobFromQueue()
+// ProcessMessages runs a pool of goroutines processing jobs from the queue, until the context is cancelled.
+// Then it stops consuming and waits for the in-flight jobs up to the shutdown timeout,
+// the ones still running after that are interrupted and requeued. Jobs ignoring the interruption are given up on
+// after SHUTDOWN_REQUEUE_TIMEOUT, they go back to the queue once the connection of the worker is closed.
+func (w *Worker) ProcessMessages(ctx context.Context) {
+	msgs, err := w.queue.PullJobFromQueue(ctx, w.concurrency)
 	if err != nil {
 		log.Fatalf("Error while pulling jobs from queue: %v", err)
 	}
 
-	for payload := range msgs {
-		// unmarshal the payload
-		q := QueueMessage{}
-		if err := json.Unmarshal(payload.Body, &q); err != nil {
-			log.Println("Error while unmarshaling JSON: ", err.Error())
-			payload.Reject(false) // message rejected, no requeue
-		}
+	// jobs get their own context, so they can finish after consuming has stopped
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
 
-		// call service on the basis of type in payload
-		switch q.Type {
-		case types.FACE_MATCH_WORK_TYPE:
-			var s types.FaceMatchInternalPayload
-			if err := json.Unmarshal(q.Msg, &s); err != nil {
-				log.Println("Error while unmarshaling JSON: ", err.Error())
-				payload.Reject(false) // message rejected, no requeue
+	var wg sync.WaitGroup
+	for range w.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for payload := range msgs {
+				// jobs delivered after shutdown started are handed back untouched
+				if ctx.Err() != nil {
+					w.requeue(payload)
+					continue
+				}
+				w.handleMessage(jobCtx, payload)
 			}
+		}()
+	}
 
-			err := w.ProcessFaceMatchWork(s)
-			if err != nil {
-				log.Printf("Error while processing face match job (%s): %s\n", s.JobID, err.Error())
-				payload.Reject(false) // message rejected, no requeue
-			}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
 
-			log.Printf("Job ID %s processed successfully\n", s.JobID)
-			payload.Ack(false) // acknowledges the single message after successful processing
-		case types.OCR_WORK_TYPE:
-			var s types.OCRInternalPayload
-			if err := json.Unmarshal(q.Msg, &s); err != nil {
-				log.Println(err)
-				payload.Reject(false) // message rejected, no requeue
-			}
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
 
-			err := w.ProcessOCRWork(s)
-			if err != nil {
-				log.Printf("Error while processing ocr job (%s): %s\n", s.JobID, err.Error())
-				payload.Reject(false) // message rejected, no requeue
-			}
+	log.Printf("Shutting down, waiting up to %s for in-flight jobs\n", w.shutdownTimeout)
+	select {
+	case <-done:
+		log.Println("In-flight jobs finished")
+	case <-time.After(w.shutdownTimeout):
+		log.Println("Shutdown timeout reached, requeueing in-flight jobs")
+		cancelJobs()
+
+		select {
+		case <-done:
+		case <-time.After(w.requeueTimeout):
+			log.Println("In-flight jobs didn't stop after being interrupted, giving up on them")
+		}
+	}
+}
+
+func (w *Worker) handleMessage(ctx context.Context, payload *service.Message) {
+	// unmarshal the payload
+	q := QueueMessage{}
+	if err := json.Unmarshal(payload.Body, &q); err != nil {
+		log.Println("Error while unmarshaling JSON: ", err.Error())
+		w.deadLetter(payload, err.Error())
+		return
+	}
 
-			log.Printf("Job ID %s processed successfully\n", s.JobID)
-			payload.Ack(false) // acknowledges the single message after successful processing
+	// call service on the basis of type in payload
+	var jobID string
+	var err error
+	switch q.Type {
+	case types.FACE_MATCH_WORK_TYPE:
+		var s types.FaceMatchInternalPayload
+		if err := json.Unmarshal(q.Msg, &s); err != nil {
+			log.Println("Error while unmarshaling JSON: ", err.Error())
+			w.deadLetter(payload, err.Error())
+			return
 		}
+
+		jobID = s.JobID
+		err = w.ProcessFaceMatchWork(ctx, s)
+		if err != nil {
+			log.Printf("Error while processing face match job (%s): %s\n", s.JobID, err.Error())
+		}
+	case types.OCR_WORK_TYPE:
+		var s types.OCRInternalPayload
+		if err := json.Unmarshal(q.Msg, &s); err != nil {
+			log.Println("Error while unmarshaling JSON: ", err.Error())
+			w.deadLetter(payload, err.Error())
+			return
+		}
+
+		jobID = s.JobID
+		err = w.ProcessOCRWork(ctx, s)
+		if err != nil {
+			log.Printf("Error while processing ocr job (%s): %s\n", s.JobID, err.Error())
+		}
+	default:
+		log.Printf("Invalid job type in message: %q\n", q.Type)
+		w.deadLetter(payload, "invalid job type")
+		return
+	}
+
+	// interrupted jobs are requeued as they are, they didn't fail
+	if err != nil && ctx.Err() != nil {
+		log.Printf("Job ID %s interrupted, requeueing\n", jobID)
+		w.requeue(payload)
+		return
+	}
+
+	if err != nil {
+		w.handleFailure(payload, q.Type, jobID, err)
+		return
+	}
+
+	log.Printf("Job ID %s processed successfully\n", jobID)
+	err = payload.Ack() // acknowledges the single message after successful processing
+	if err != nil {
+		log.Printf("Error while acknowledging job (%s): %s\n", jobID, err.Error())
 	}
 }
 
-func (w *Worker) ProcessFaceMatchWork(payload types.FaceMatchInternalPayload) error {
+// handleFailure requeues the message with a delay when the error is retryable,
+// the job is marked failed and the message dead lettered once retries run out or when it isn't
+func (w *Worker) handleFailure(payload *service.Message, jobType, jobID string, err error) {
+	nErr := payload.Nack(isRetryable(err), err.Error())
+	if errors.Is(nErr, service.ErrRetriesExhausted) {
+		log.Printf("Job ID %s dead lettered after %d attempts\n", jobID, payload.Attempt+1)
+		w.changeStateToFailed(jobType, jobID, err.Error())
+		return
+	}
+	if nErr != nil {
+		log.Printf("Error while settling failed job (%s): %s\n", jobID, nErr.Error())
+		return
+	}
+
+	if isRetryable(err) {
+		log.Printf("Job ID %s requeued for retry\n", jobID)
+	}
+}
+
+func (w *Worker) requeue(payload *service.Message) {
+	err := payload.Requeue()
+	if err != nil {
+		log.Printf("Error while requeueing message: %s\n", err.Error())
+	}
+}
+
+func (w *Worker) deadLetter(payload *service.Message, reason string) {
+	err := payload.Nack(false, reason)
+	if err != nil {
+		log.Printf("Error while dead lettering message: %s\n", err.Error())
+	}
+}
+
+func (w *Worker) ProcessFaceMatchWork(ctx context.Context, payload types.FaceMatchInternalPayload) error {
 	// change state to processing
 	err := w.dStore.UpdateFaceMatchJobProcessed(payload.JobID)
 	if err != nil {
 		log.Printf("Error while updating the face match job (%s) state to 'processing': %s\n", payload.JobID, err.Error())
-		w.changeStateToFailed(types.FACE_MATCH_WORK_TYPE, payload.JobID, err.Error())
+		return retryable(err)
+	}
+
+	// load the images
+	image1, err := w.loadJobImage(types.FACE_MATCH_WORK_TYPE, payload.JobID, payload.Image1)
+	if err != nil {
+		return err
+	}
+	image2, err := w.loadJobImage(types.FACE_MATCH_WORK_TYPE, payload.JobID, payload.Image2)
+	if err != nil {
 		return err
 	}
 
-	// do the work
-	p := types.FaceMatchPayload{
-		Image1: payload.Image1,
-		Image2: payload.Image2,
+	// do the work, sandbox jobs get deterministic results
+	faceMatcher := w.faceMatcher
+	if payload.Sandbox {
+		faceMatcher = w.sandboxMatcher
 	}
-	score, err := w.faceMatcher.PerformFaceMatch(p)
+	score, err := faceMatcher.PerformFaceMatch(ctx, image1, image2)
 	if err != nil {
+		// interrupted jobs are requeued, so they aren't failed
+		if ctx.Err() != nil {
+			return err
+		}
+
 		log.Printf("Error while performing the face match job (%s): %s\n", payload.JobID, err.Error())
+
+		// engine outages are retried through the queue, the job is failed once retries run out
+		if isRetryable(err) {
+			return err
+		}
 		w.changeStateToFailed(types.FACE_MATCH_WORK_TYPE, payload.JobID, err.Error())
 		return err
 	}
@@ -115,29 +287,47 @@ func (w *Worker) ProcessFaceMatchWork(payload types.FaceMatchInternalPayload) er
 	err = w.dStore.UpdateFaceMatchJobCompleted(payload.JobID, score)
 	if err != nil {
 		log.Printf("Error while updating the face match job (%s) state to 'completed': %s\n", payload.JobID, err.Error())
-		w.changeStateToFailed(types.FACE_MATCH_WORK_TYPE, payload.JobID, err.Error())
-		return err
+		return retryable(err)
 	}
 
+	// let the client know through its webhooks
+	w.notifyWebhooks(types.FACE_MATCH_WORK_TYPE, payload.JobID)
+
 	return nil
 }
 
-func (w *Worker) ProcessOCRWork(payload types.OCRInternalPayload) error {
+func (w *Worker) ProcessOCRWork(ctx context.Context, payload types.OCRInternalPayload) error {
 	// change state to processing
 	err := w.dStore.UpdateOCRJobProcessed(payload.JobID)
 	if err != nil {
 		log.Printf("Error while updating the ocr job (%s) state to 'processing': %s\n", payload.JobID, err.Error())
-		w.changeStateToFailed(types.OCR_WORK_TYPE, payload.JobID, err.Error())
+		return retryable(err)
+	}
+
+	// load the image
+	image, err := w.loadJobImage(types.OCR_WORK_TYPE, payload.JobID, payload.Image)
+	if err != nil {
 		return err
 	}
 
-	// do the work
-	p := types.OCRPayload{
-		Image: payload.Image,
+	// do the work, sandbox jobs get deterministic results
+	ocr := w.ocr
+	if payload.Sandbox {
+		ocr = w.sandboxOCR
 	}
-	resp, err := w.ocr.PerformOCR(p)
+	resp, err := ocr.PerformOCR(ctx, image)
 	if err != nil {
+		// interrupted jobs are requeued, so they aren't failed
+		if ctx.Err() != nil {
+			return err
+		}
+
 		log.Printf("Error while performing the ocr job (%s): %s\n", payload.JobID, err.Error())
+
+		// engine outages are retried through the queue, the job is failed once retries run out
+		if isRetryable(err) {
+			return err
+		}
 		w.changeStateToFailed(types.OCR_WORK_TYPE, payload.JobID, err.Error())
 		return err
 	}
@@ -146,25 +336,55 @@ func (w *Worker) ProcessOCRWork(payload types.OCRInternalPayload) error {
 	err = w.dStore.UpdateOCRJobCompleted(payload.JobID, resp)
 	if err != nil {
 		log.Printf("Error while updating the face match job (%s) state to 'completed': %s\n", payload.JobID, err.Error())
-		w.changeStateToFailed(types.OCR_WORK_TYPE, payload.JobID, err.Error())
-		return err
+		return retryable(err)
 	}
 
+	// let the client know through its webhooks
+	w.notifyWebhooks(types.OCR_WORK_TYPE, payload.JobID)
+
 	return nil
 }
 
+// loadJobImage loads an image of the job, failing the job if the image is missing or corrupt
+func (w *Worker) loadJobImage(jobType, jobID, imgUuid string) (*types.ImageData, error) {
+	image, err := w.loadImage(imgUuid)
+	if err != nil {
+		log.Printf("Error while loading image of job (%s): %s\n", jobID, err.Error())
+		if !isRetryable(err) {
+			w.changeStateToFailed(jobType, jobID, err.Error())
+		}
+		return nil, err
+	}
+
+	return image, nil
+}
+
 func (w *Worker) changeStateToFailed(jobType, jobID, errMessage string) {
 	switch jobType {
 	case types.FACE_MATCH_WORK_TYPE:
 		err := w.dStore.UpdateFaceMatchJobFailed(jobID, errMessage)
 		if err != nil {
 			log.Printf("Error while updating the face match job (%s) state to 'failed': %s\n", jobID, errMessage)
+			return
 		}
-		return
 	case types.OCR_WORK_TYPE:
 		err := w.dStore.UpdateOCRJobFailed(jobID, errMessage)
 		if err != nil {
 			log.Printf("Error while updating the ocr job (%s) state to 'failed': %s\n", jobID, errMessage)
+			return
 		}
+	default:
+		return
+	}
+
+	// let the client know through its webhooks
+	w.notifyWebhooks(jobType, jobID)
+}
+
+// queues a delivery of the job result to every webhook of the client, the dispatcher does the actual posting
+func (w *Worker) notifyWebhooks(jobType, jobID string) {
+	err := w.dStore.InsertWebhookDeliveries(jobType, jobID)
+	if err != nil {
+		log.Printf("Error while queueing webhook deliveries for job (%s): %s\n", jobID, err.Error())
 	}
 }
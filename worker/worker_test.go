@@ -0,0 +1,449 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/service"
+	"github.com/justsushant/one2n-go-bootcamp/go-ekyc/types"
+)
+
+type mockWorkerDataStore struct {
+	mu sync.Mutex
+
+	// the first processedErrs calls to mark a job processing fail, like a database blip
+	processedErrs int
+	completed     map[string]int
+	failed        map[string]string
+}
+
+func newMockWorkerDataStore(processedErrs int) *mockWorkerDataStore {
+	return &mockWorkerDataStore{
+		processedErrs: processedErrs,
+		completed:     map[string]int{},
+		failed:        map[string]string{},
+	}
+}
+
+func (m *mockWorkerDataStore) UpdateFaceMatchJobCompleted(jobID string, score int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.completed[jobID] = score
+	return nil
+}
+func (m *mockWorkerDataStore) UpdateOCRJobCompleted(jobID string, result *types.OCRResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.completed[jobID] = 0
+	return nil
+}
+func (m *mockWorkerDataStore) UpdateFaceMatchJobProcessed(jobID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.processedErrs > 0 {
+		m.processedErrs--
+		return errors.New("connection reset")
+	}
+	return nil
+}
+func (m *mockWorkerDataStore) UpdateOCRJobProcessed(jobID string) error { return nil }
+func (m *mockWorkerDataStore) UpdateFaceMatchJobFailed(jobID, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed[jobID] = reason
+	return nil
+}
+func (m *mockWorkerDataStore) UpdateOCRJobFailed(jobID, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed[jobID] = reason
+	return nil
+}
+func (m *mockWorkerDataStore) InsertWebhookDeliveries(jobType, jobID string) error { return nil }
+func (m *mockWorkerDataStore) GetMetaDataByUUID(imgUuid string) (*types.UploadMetaData, error) {
+	if imgUuid == "missing" {
+		return nil, sql.ErrNoRows
+	}
+	return &types.UploadMetaData{Id: 1, ClientID: 1, FilePath: "1/" + imgUuid}, nil
+}
+
+// settled reports whether the job ended up completed or failed
+func (m *mockWorkerDataStore) settled(jobID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, completed := m.completed[jobID]
+	_, failed := m.failed[jobID]
+	return completed || failed
+}
+
+type mockFaceMatcher struct {
+	err error
+
+	// when set, jobs wait for it to be closed or for their context to be cancelled
+	release chan struct{}
+
+	// jobs wait for release even when their context is cancelled
+	ignoreCancel bool
+
+	mu     sync.Mutex
+	active int
+}
+
+func (m *mockFaceMatcher) PerformFaceMatch(ctx context.Context, image1, image2 *types.ImageData) (int, error) {
+	if image1 == nil || image1.Image == nil || image2 == nil || image2.Image == nil {
+		return 0, errors.New("images not decoded")
+	}
+
+	if m.release != nil {
+		m.mu.Lock()
+		m.active++
+		m.mu.Unlock()
+
+		done := ctx.Done()
+		if m.ignoreCancel {
+			done = nil
+		}
+		select {
+		case <-m.release:
+		case <-done:
+			return 0, ctx.Err()
+		}
+	}
+
+	return 50, m.err
+}
+
+func (m *mockFaceMatcher) activeJobs() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.active
+}
+
+// waitFor polls the condition until it holds or the test times out
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// newImageFileStore returns a file store holding a png (img1) and a jpeg (img2) upload,
+// along with a corrupt one (corrupt). The metadata of lost resolves but it has no file.
+func newImageFileStore(t *testing.T) *service.MemoryFileStore {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	var pngBytes, jpegBytes bytes.Buffer
+	if err := png.Encode(&pngBytes, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegBytes, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	fStore := service.NewMemoryFileStore()
+	for name, content := range map[string][]byte{
+		"1/img1":    pngBytes.Bytes(),
+		"1/img2":    jpegBytes.Bytes(),
+		"1/corrupt": []byte("not an image"),
+	} {
+		err := fStore.SaveFile(&types.FileUpload{Name: name, Content: bytes.NewReader(content), Size: int64(len(content))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return fStore
+}
+
+func faceMatchMessage(t *testing.T, jobID string) []byte {
+	return faceMatchMessageWithImages(t, jobID, "img1", "img2")
+}
+
+func faceMatchMessageWithImages(t *testing.T, jobID, image1, image2 string) []byte {
+	return faceMatchMessageFor(t, types.FaceMatchInternalPayload{JobID: jobID, Image1: image1, Image2: image2})
+}
+
+func faceMatchMessageFor(t *testing.T, payload types.FaceMatchInternalPayload) []byte {
+	msg, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(QueueMessage{Type: types.FACE_MATCH_WORK_TYPE, Msg: msg})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+func TestProcessMessages(t *testing.T) {
+	tt := []struct {
+		name           string
+		body           []byte
+		processedErrs  int
+		faceMatchErr   error
+		expCompleted   bool
+		expFailed      bool
+		expReason      string
+		expDeadLetters int
+	}{
+		{
+			name:         "processed job is completed",
+			expCompleted: true,
+		},
+		{
+			name:          "database error is retried until it goes away",
+			processedErrs: 2,
+			expCompleted:  true,
+		},
+		{
+			name:           "database error out of retries marks the job failed",
+			processedErrs:  10,
+			expFailed:      true,
+			expDeadLetters: 1,
+		},
+		{
+			name:           "face match error is dead lettered without retry",
+			faceMatchErr:   errors.New("invalid image"),
+			expFailed:      true,
+			expDeadLetters: 1,
+		},
+		{
+			name:           "engine outage is retried until the job is failed",
+			faceMatchErr:   retryable(ErrEngineUnavailable),
+			expFailed:      true,
+			expReason:      "engine unavailable",
+			expDeadLetters: 1,
+		},
+		{
+			name:           "missing image metadata fails the job",
+			body:           faceMatchMessageWithImages(t, "job1", "img1", "missing"),
+			expFailed:      true,
+			expReason:      "image not found: missing",
+			expDeadLetters: 1,
+		},
+		{
+			name:           "missing image file fails the job",
+			body:           faceMatchMessageWithImages(t, "job1", "lost", "img2"),
+			expFailed:      true,
+			expReason:      "image not found: lost",
+			expDeadLetters: 1,
+		},
+		{
+			name:           "corrupt image fails the job",
+			body:           faceMatchMessageWithImages(t, "job1", "img1", "corrupt"),
+			expFailed:      true,
+			expReason:      "image is corrupt or not a png/jpeg: corrupt",
+			expDeadLetters: 1,
+		},
+		{
+			name:         "sandbox job is done by the sandbox engine",
+			body:         faceMatchMessageFor(t, types.FaceMatchInternalPayload{JobID: "job1", Image1: "img1", Image2: "img2", Sandbox: true}),
+			faceMatchErr: errors.New("regular engine used"),
+			expCompleted: true,
+		},
+		{
+			name:           "malformed message is dead lettered",
+			body:           []byte("not json"),
+			expDeadLetters: 1,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			queue := service.NewMemoryQueue(10, 3, time.Millisecond)
+			dStore := newMockWorkerDataStore(tc.processedErrs)
+			w := New(&WorkerConfig{
+				Queue:       queue,
+				DataStore:   dStore,
+				FileStore:   newImageFileStore(t),
+				FaceMatcher: &mockFaceMatcher{err: tc.faceMatchErr},
+			})
+
+			body := tc.body
+			if body == nil {
+				body = faceMatchMessage(t, "job1")
+			}
+			if err := queue.PushJobOnQueue(body); err != nil {
+				t.Fatalf("Unexpected error while pushing job: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				w.ProcessMessages(ctx)
+				close(done)
+			}()
+
+			// wait for the job to be settled, either in the store or in the dead letters
+			waitFor(t, func() bool {
+				return dStore.settled("job1") || len(queue.DeadLetters()) > 0
+			})
+			cancel()
+			<-done
+
+			if _, ok := dStore.completed["job1"]; ok != tc.expCompleted {
+				t.Errorf("Expected job completed to be %v but got %v", tc.expCompleted, ok)
+			}
+			if _, ok := dStore.failed["job1"]; ok != tc.expFailed {
+				t.Errorf("Expected job failed to be %v but got %v", tc.expFailed, ok)
+			}
+			if reason := dStore.failed["job1"]; tc.expReason != "" && reason != tc.expReason {
+				t.Errorf("Expected failed reason %q but got %q", tc.expReason, reason)
+			}
+			if count := len(queue.DeadLetters()); count != tc.expDeadLetters {
+				t.Errorf("Expected %d dead letters but got %d", tc.expDeadLetters, count)
+			}
+		})
+	}
+}
+
+func TestProcessMessagesConcurrency(t *testing.T) {
+	queue := service.NewMemoryQueue(10, 3, time.Millisecond)
+	dStore := newMockWorkerDataStore(0)
+	faceMatcher := &mockFaceMatcher{release: make(chan struct{})}
+	w := New(&WorkerConfig{
+		Queue:       queue,
+		DataStore:   dStore,
+		FileStore:   newImageFileStore(t),
+		FaceMatcher: faceMatcher,
+		Concurrency: 3,
+	})
+
+	for _, jobID := range []string{"job1", "job2", "job3"} {
+		queue.PushJobOnQueue(faceMatchMessage(t, jobID))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.ProcessMessages(ctx)
+		close(done)
+	}()
+
+	// all three jobs are in flight at once
+	waitFor(t, func() bool { return faceMatcher.activeJobs() == 3 })
+	close(faceMatcher.release)
+	waitFor(t, func() bool {
+		return dStore.settled("job1") && dStore.settled("job2") && dStore.settled("job3")
+	})
+
+	cancel()
+	<-done
+}
+
+func TestProcessMessagesShutdown(t *testing.T) {
+	t.Run("in-flight job is drained", func(t *testing.T) {
+		queue := service.NewMemoryQueue(10, 3, time.Millisecond)
+		dStore := newMockWorkerDataStore(0)
+		faceMatcher := &mockFaceMatcher{release: make(chan struct{})}
+		w := New(&WorkerConfig{
+			Queue:           queue,
+			DataStore:       dStore,
+			FileStore:       newImageFileStore(t),
+			FaceMatcher:     faceMatcher,
+			ShutdownTimeout: time.Minute,
+		})
+		queue.PushJobOnQueue(faceMatchMessage(t, "job1"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			w.ProcessMessages(ctx)
+			close(done)
+		}()
+
+		waitFor(t, func() bool { return faceMatcher.activeJobs() == 1 })
+		cancel()
+		close(faceMatcher.release)
+		<-done
+
+		if _, ok := dStore.completed["job1"]; !ok {
+			t.Errorf("Expected in-flight job to be completed on shutdown")
+		}
+	})
+
+	t.Run("job running past the timeout is requeued", func(t *testing.T) {
+		queue := service.NewMemoryQueue(10, 3, time.Millisecond)
+		defer queue.Close()
+		dStore := newMockWorkerDataStore(0)
+		faceMatcher := &mockFaceMatcher{release: make(chan struct{})}
+		w := New(&WorkerConfig{
+			Queue:           queue,
+			DataStore:       dStore,
+			FileStore:       newImageFileStore(t),
+			FaceMatcher:     faceMatcher,
+			ShutdownTimeout: 10 * time.Millisecond,
+		})
+		queue.PushJobOnQueue(faceMatchMessage(t, "job1"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			w.ProcessMessages(ctx)
+			close(done)
+		}()
+
+		waitFor(t, func() bool { return faceMatcher.activeJobs() == 1 })
+		cancel()
+		<-done
+
+		if dStore.settled("job1") {
+			t.Errorf("Expected interrupted job not to be completed or failed")
+		}
+
+		// the job is back on the queue, without an attempt counted
+		msgs, _ := queue.PullJobFromQueue(context.Background(), 1)
+		select {
+		case msg := <-msgs:
+			if msg.Attempt != 0 {
+				t.Errorf("Expected requeued job at attempt 0 but got %d", msg.Attempt)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected interrupted job to be requeued")
+		}
+	})
+	t.Run("job ignoring the interruption is given up on", func(t *testing.T) {
+		queue := service.NewMemoryQueue(10, 3, time.Millisecond)
+		defer queue.Close()
+		faceMatcher := &mockFaceMatcher{release: make(chan struct{}), ignoreCancel: true}
+		defer close(faceMatcher.release)
+		w := New(&WorkerConfig{
+			Queue:           queue,
+			DataStore:       newMockWorkerDataStore(0),
+			FileStore:       newImageFileStore(t),
+			FaceMatcher:     faceMatcher,
+			ShutdownTimeout: 10 * time.Millisecond,
+		})
+		w.requeueTimeout = 10 * time.Millisecond
+		queue.PushJobOnQueue(faceMatchMessage(t, "job1"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			w.ProcessMessages(ctx)
+			close(done)
+		}()
+
+		waitFor(t, func() bool { return faceMatcher.activeJobs() == 1 })
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("Expected shutdown not to wait on the stuck job")
+		}
+	})
+}